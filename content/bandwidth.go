@@ -0,0 +1,81 @@
+package content
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+)
+
+// BandwidthMonthFormat is the time layout of the months bandwidth is
+// accounted per
+const BandwidthMonthFormat = "2006-01"
+
+// Bandwidth is the number of bytes served for a uri in a month
+type Bandwidth struct {
+	Month string `bson:"month" json:"-"`
+	URI   string `bson:"uri" json:"uri"`
+	Bytes int64  `bson:"bytes" json:"bytes"`
+}
+
+// BandwidthReport is the number of bytes served in a month in total and per
+// uri, most served first
+type BandwidthReport struct {
+	Month string      `json:"month"`
+	Total int64       `json:"total"`
+	URIs  []Bandwidth `json:"uris"`
+}
+
+// AddBandwidth adds the given numbers of bytes per uri to the bytes served in
+// the given month
+func (e *Engine) AddBandwidth(month string, served map[string]int64) error {
+	if len(served) == 0 {
+		return nil
+	}
+	models := make([]mongo.WriteModel, 0, len(served))
+	for uri, n := range served {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": month + " " + uri}).
+			SetUpdate(bson.M{"$set": bson.M{"month": month, "uri": uri}, "$inc": bson.M{"bytes": n}}).
+			SetUpsert(true))
+	}
+	log.Println("Writing bandwidth of", len(served), "files for month:", month)
+	_, err := e.bandwidth.BulkWrite(e.ctx, models, options.BulkWrite().SetOrdered(false))
+	return err
+}
+
+// GetBandwidth returns the bandwidth report of the given month; at most limit
+// uris are listed, all if limit is not positive
+func (e *Engine) GetBandwidth(month string, limit int) (BandwidthReport, error) {
+	report := BandwidthReport{Month: month, URIs: []Bandwidth{}}
+	cursor, err := e.bandwidth.Aggregate(e.ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"month": month}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "total": bson.M{"$sum": "$bytes"}}}},
+	})
+	if err != nil {
+		return BandwidthReport{}, err
+	}
+	var totals []struct {
+		Total int64 `bson:"total"`
+	}
+	err = cursor.All(e.ctx, &totals)
+	if err != nil {
+		return BandwidthReport{}, err
+	}
+	if len(totals) > 0 {
+		report.Total = totals[0].Total
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "bytes", Value: -1}, {Key: "uri", Value: 1}})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	cursor, err = e.bandwidth.Find(e.ctx, bson.M{"month": month}, opts)
+	if err != nil {
+		return BandwidthReport{}, err
+	}
+	err = cursor.All(e.ctx, &report.URIs)
+	if err != nil {
+		return BandwidthReport{}, err
+	}
+	return report, nil
+}
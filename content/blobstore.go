@@ -0,0 +1,178 @@
+package content
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"io"
+	"os"
+	"path"
+	"time"
+)
+
+// BlobStore stores the content of all files; the content is addressed by a
+// path. The store must be shared by
+// all instances, so it is either a GridFSStore, an S3Store or a DiskStore on a
+// volume shared by all instances.
+type BlobStore interface {
+	// Name returns the name of the store used in log messages
+	Name() string
+	// Write creates the content at the given path, which is written by the
+	// given function; the content is discarded if the function returns an
+	// error
+	Write(p string, write func(w io.Writer) error) error
+	// Open returns a reader for the content at the given path
+	Open(p string) (io.ReadCloser, error)
+	// Remove removes the content at the given path; removing missing content
+	// is not an error
+	Remove(p string) error
+}
+
+// PresignStore is a BlobStore which can issue URLs clients upload content to
+// directly, so the content does not pass through the application
+type PresignStore interface {
+	BlobStore
+	// PresignPut returns a URL the content at the given path can be uploaded
+	// to with a PUT request until the URL expires after the given duration
+	PresignPut(p string, expires time.Duration) (string, error)
+}
+
+// DiskStore stores content as files below a directory of the file system
+type DiskStore struct {
+	Dir string
+}
+
+func (s DiskStore) Name() string { return "disk:" + s.Dir }
+
+func (s DiskStore) Write(p string, write func(w io.Writer) error) error {
+	// we must ensure that the file's directory exists
+	target := path.Join(s.Dir, p)
+	err := os.MkdirAll(path.Dir(target), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	err = write(f)
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		_ = os.Remove(target)
+	}
+	return err
+}
+
+func (s DiskStore) Open(p string) (io.ReadCloser, error) {
+	return os.Open(path.Join(s.Dir, p))
+}
+
+func (s DiskStore) Remove(p string) error {
+	err := os.Remove(path.Join(s.Dir, p))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// GridFSStore stores content in a GridFS bucket of the database; the path of
+// the content is used as id and name of the GridFS file
+type GridFSStore struct {
+	Bucket *gridfs.Bucket
+}
+
+// NewGridFSStore returns a store of content in the GridFS bucket with the
+// given name of the given database
+func NewGridFSStore(db *mongo.Database, bucket string) (GridFSStore, error) {
+	b, err := gridfs.NewBucket(db, options.GridFSBucket().SetName(bucket))
+	if err != nil {
+		return GridFSStore{}, err
+	}
+	return GridFSStore{Bucket: b}, nil
+}
+
+func (s GridFSStore) Name() string { return "gridfs:" + s.Bucket.GetFilesCollection().Name() }
+
+func (s GridFSStore) Write(p string, write func(w io.Writer) error) error {
+	us, err := s.Bucket.OpenUploadStreamWithID(p, p)
+	if err != nil {
+		return err
+	}
+	err = write(us)
+	if err != nil {
+		_ = us.Abort()
+		return err
+	}
+	return us.Close()
+}
+
+func (s GridFSStore) Open(p string) (io.ReadCloser, error) {
+	ds, err := s.Bucket.OpenDownloadStream(p)
+	if errors.Is(err, gridfs.ErrFileNotFound) {
+		return nil, fmt.Errorf("%w: %s", os.ErrNotExist, p)
+	}
+	return ds, err
+}
+
+func (s GridFSStore) Remove(p string) error {
+	err := s.Bucket.Delete(p)
+	if errors.Is(err, gridfs.ErrFileNotFound) {
+		return nil
+	}
+	return err
+}
+
+// Size returns the total size of the content stored in the bucket; the query
+// fails once the given context is done
+func (s GridFSStore) Size(ctx context.Context) (int64, error) {
+	files := s.Bucket.GetFilesCollection()
+	cursor, err := files.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{"_id": nil, "size": bson.M{"$sum": "$length"}}}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	var totals []struct {
+		Size int64 `bson:"size"`
+	}
+	err = cursor.All(ctx, &totals)
+	if err != nil || len(totals) == 0 {
+		return 0, err
+	}
+	return totals[0].Size, nil
+}
+
+// CheckBlobStore checks whether the blob store is available by writing,
+// reading and removing a probe.
+func (e *Engine) CheckBlobStore() error {
+	p := fmt.Sprintf(".health/%d", time.Now().UnixNano())
+	probe := []byte(p)
+	err := e.blobs.Write(p, func(w io.Writer) error {
+		_, err := w.Write(probe)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = e.blobs.Remove(p) }()
+	rc, err := e.blobs.Open(p)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rc.Close() }()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(data, probe) {
+		return errors.New("blob store returned different content than written")
+	}
+	return nil
+}
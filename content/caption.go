@@ -0,0 +1,118 @@
+package content
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"path"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// cameraNameRegexp matches the words of file names generated by cameras and
+// phones, like 'IMG_1234' or 'DSC01234', which do not describe the image
+var cameraNameRegexp = regexp.MustCompile(`(?i)^(img|dsc|dscn|dscf|pxl|\d+|[a-z]*\d{3,}[a-z\d]*)$`)
+
+// SetCaption sets the alt text and the caption of the file with the given uri;
+// empty values remove them. Returns ErrNotFound if there is no such file.
+func (e *Engine) SetCaption(uri string, alt string, caption string) error {
+	log.Println("Setting caption of file:", uri)
+	set, unset := bson.M{}, bson.M{}
+	for k, v := range map[string]string{"alt": alt, "caption": caption} {
+		if v == "" {
+			unset[k] = ""
+		} else {
+			set[k] = v
+		}
+	}
+	update := bson.M{}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	var res *mongo.UpdateResult
+	err := e.retry("setting caption", uri, func() (err error) {
+		res, err = e.files.UpdateOne(e.ctx, bson.M{"uri": uri}, update)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// AltText returns the alt text of the image, which is its Alt field, else its
+// caption, else derived from its file name, see AltFromName
+func (p *MongoFile) AltText() string {
+	if p.Alt != "" {
+		return p.Alt
+	}
+	if p.Caption != "" {
+		return p.Caption
+	}
+	return AltFromName(p.URI)
+}
+
+// AltFromName derives an alt text from the file name of the given path, like
+// 'Sunset at the beach' from 'sunset-at_the_beach.jpg'; words generated by
+// cameras like 'IMG_1234' are dropped, so an empty string is returned for
+// names without description
+func AltFromName(p string) string {
+	base := path.Base(p)
+	base = strings.TrimSuffix(base, path.Ext(base))
+	words := strings.FieldsFunc(base, func(r rune) bool {
+		return r == '-' || r == '_' || r == '.' || unicode.IsSpace(r)
+	})
+	kept := words[:0]
+	for _, w := range words {
+		if !cameraNameRegexp.MatchString(w) {
+			kept = append(kept, w)
+		}
+	}
+	alt := strings.Join(kept, " ")
+	if r, n := utf8.DecodeRuneInString(alt); n > 0 {
+		alt = string(unicode.ToUpper(r)) + alt[n:]
+	}
+	return alt
+}
+
+// ListImages lists the public images stored below the given directory sorted
+// by their uri except for MongoFile.Content; converted variants of images are
+// not listed
+func (e *Engine) ListImages(dir string) ([]MongoFile, error) {
+	dir = strings.TrimSuffix(path.Clean("/"+dir), "/") + "/"
+	opts := options.Find().SetProjection(metaProjection).SetSort(bson.M{"uri": 1})
+	filter := public(bson.M{"uri": bson.M{"$regex": "^" + regexp.QuoteMeta(dir)}, "mimetype": bson.M{"$regex": "^image/"}})
+	var files []MongoFile
+	err := e.retry("listing images", dir, func() error {
+		cursor, err := e.files.Find(e.ctx, filter, opts)
+		if err != nil {
+			return err
+		}
+		return e.decodeFiles(e.ctx, cursor, &files)
+	})
+	if err != nil {
+		return nil, err
+	}
+	uris := map[string]bool{}
+	for _, f := range files {
+		uris[f.URI] = true
+	}
+	images := make([]MongoFile, 0, len(files))
+	for _, f := range files {
+		// variants are stored at the uri of their image with another extension
+		if uris[strings.TrimSuffix(f.URI, path.Ext(f.URI))] {
+			continue
+		}
+		images = append(images, f)
+	}
+	return images, nil
+}
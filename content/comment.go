@@ -0,0 +1,158 @@
+package content
+
+import (
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"time"
+)
+
+// statuses of comments; comments are pending until a moderator approves them
+// or marks them as spam
+const (
+	CommentPending  = "pending"
+	CommentApproved = "approved"
+	CommentSpam     = "spam"
+)
+
+// ErrInvalidCommentStatus is returned if a comment status is not one of
+// 'pending', 'approved' and 'spam'
+var ErrInvalidCommentStatus = errors.New("invalid comment status; must be one of 'pending', 'approved' and 'spam'")
+
+// Comment is a comment of a visitor on a page; only approved comments are
+// shown on the page. The email address is optional and only shown to
+// moderators.
+type Comment struct {
+	ID      primitive.ObjectID `bson:"_id" json:"id"`
+	URI     string             `bson:"uri" json:"uri"`
+	Author  string             `bson:"author" json:"author"`
+	Email   string             `bson:"email,omitempty" json:"email,omitempty"`
+	Text    string             `bson:"text" json:"text"`
+	Status  string             `bson:"status" json:"status"`
+	Created time.Time          `bson:"created" json:"created"`
+}
+
+// ValidCommentStatus returns whether the given status is a comment status
+func ValidCommentStatus(s string) bool {
+	return s == CommentPending || s == CommentApproved || s == CommentSpam
+}
+
+// WithCommentCollection sets the name of the collection comments are stored
+// in; an empty name keeps the default 'comments'
+func WithCommentCollection(name string) Option {
+	return func(e *Engine) error {
+		if name != "" {
+			e.comments = e.db.Collection(name)
+		}
+		return nil
+	}
+}
+
+// AddComment stores the given comment with a new id and the current time;
+// the comment is pending unless its status is set
+func (e *Engine) AddComment(c *Comment) error {
+	if c.Status == "" {
+		c.Status = CommentPending
+	}
+	if !ValidCommentStatus(c.Status) {
+		return ErrInvalidCommentStatus
+	}
+	c.ID = primitive.NewObjectID()
+	c.Created = time.Now()
+	log.Println("Adding comment to page:", c.URI, c.Status)
+	return e.retry("adding comment", c.URI, func() error {
+		_, err := e.comments.InsertOne(e.ctx, c)
+		if mongo.IsDuplicateKeyError(err) {
+			// the comment was inserted by a failed attempt
+			return nil
+		}
+		return err
+	})
+}
+
+// ListComments lists the approved comments of the page with the given uri,
+// oldest first
+func (e *Engine) ListComments(uri string) ([]Comment, error) {
+	return e.findComments(bson.M{"uri": uri, "status": CommentApproved}, 1)
+}
+
+// ListCommentsByStatus lists the comments of all pages with the given status,
+// newest first
+func (e *Engine) ListCommentsByStatus(status string) ([]Comment, error) {
+	if !ValidCommentStatus(status) {
+		return nil, ErrInvalidCommentStatus
+	}
+	return e.findComments(bson.M{"status": status}, -1)
+}
+
+// CountComments returns the number of comments with the given status
+func (e *Engine) CountComments(status string) (int64, error) {
+	var n int64
+	err := e.retry("counting comments", status, func() (err error) {
+		n, err = e.comments.CountDocuments(e.ctx, bson.M{"status": status})
+		return err
+	})
+	return n, err
+}
+
+// findComments lists the comments matching the given filter sorted by their
+// creation time in the given order
+func (e *Engine) findComments(filter bson.M, order int) ([]Comment, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created", Value: order}})
+	comments := []Comment{}
+	err := e.retry("listing comments", "", func() error {
+		cursor, err := e.comments.Find(e.ctx, filter, opts)
+		if err != nil {
+			return err
+		}
+		return cursor.All(e.ctx, &comments)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return comments, nil
+}
+
+// SetCommentStatus sets the status of the comment with the given id and
+// returns the comment as it was before. Returns ErrNotFound if there is no
+// such comment.
+func (e *Engine) SetCommentStatus(id string, status string) (Comment, error) {
+	if !ValidCommentStatus(status) {
+		return Comment{}, ErrInvalidCommentStatus
+	}
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return Comment{}, ErrNotFound
+	}
+	log.Println("Setting status of comment:", id, status)
+	var c Comment
+	err = e.retry("setting comment status", id, func() error {
+		return e.comments.FindOneAndUpdate(e.ctx, bson.M{"_id": oid},
+			bson.M{"$set": bson.M{"status": status}}).Decode(&c)
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Comment{}, ErrNotFound
+	}
+	return c, err
+}
+
+// DeleteComment deletes the comment with the given id and returns it.
+// Returns ErrNotFound if there is no such comment.
+func (e *Engine) DeleteComment(id string) (Comment, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return Comment{}, ErrNotFound
+	}
+	log.Println("Deleting comment:", id)
+	var c Comment
+	err = e.retry("deleting comment", id, func() error {
+		return e.comments.FindOneAndDelete(e.ctx, bson.M{"_id": oid}).Decode(&c)
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Comment{}, ErrNotFound
+	}
+	return c, err
+}
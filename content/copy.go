@@ -0,0 +1,70 @@
+package content
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"log"
+	"path"
+	"time"
+)
+
+// CopyFile copies the public file with the given uri to the given uri and
+// returns the copy except for MongoFile.Content; the copy has the content of
+// the file and its visibility, variants, alt text and caption, but neither its
+// menu entry, its schedule nor its review comments. The copy has the given
+// status or, if empty, the status of the file. The content is stored once
+// more, so the copy is changed independently, and the slug of a copied page
+// is derived anew and made unique. The variants of the file are not copied
+// along. Returns ErrNotFound if there is no such file, ErrInvalidStatus if the
+// status is invalid and ErrExists if a file is stored at the target uri.
+func (e *Engine) CopyFile(uri string, dest string, status string) (MongoFile, error) {
+	if !ValidStatus(status) {
+		return MongoFile{}, ErrInvalidStatus
+	}
+	dest = path.Clean("/" + dest)
+	log.Println("Copying file:", uri, dest)
+	f, err := e.GetFromDB(uri)
+	if err == nil && !f.Public() {
+		err = ErrNotFound
+	}
+	if err != nil {
+		return MongoFile{}, err
+	}
+	var n int64
+	err = e.retry("checking copy target", dest, func() (err error) {
+		n, err = e.files.CountDocuments(e.ctx, bson.M{"$or": bson.A{bson.M{"uri": dest}, bson.M{"name": dest}}})
+		return err
+	})
+	if err != nil {
+		return MongoFile{}, err
+	}
+	if n > 0 {
+		return MongoFile{}, ErrExists
+	}
+	if status == "" {
+		status = f.Status
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return MongoFile{}, err
+	}
+	defer func() { _ = rc.Close() }()
+	c := MongoFile{
+		URI:        dest,
+		Filesize:   f.Filesize,
+		LastMod:    time.Now(),
+		Mime:       f.Mime,
+		IsMD:       f.IsMD,
+		Visibility: f.Visibility,
+		Status:     status,
+		Variants:   f.Variants,
+		Alt:        f.Alt,
+		Caption:    f.Caption,
+		engine:     e,
+	}
+	err = c.Store(rc)
+	if err != nil {
+		return MongoFile{}, err
+	}
+	c.Content.Data = nil
+	return c, nil
+}
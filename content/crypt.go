@@ -0,0 +1,168 @@
+package content
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// encChunkSize is the size of the plaintext chunks of encrypted local files
+const encChunkSize = 64 << 10 // 64 KiB
+
+var (
+	// ErrNoEncryptionKey is returned when reading an encrypted file while no
+	// encryption key is set
+	ErrNoEncryptionKey = errors.New("file is encrypted but no encryption key is set")
+	// ErrDecrypt is returned if an encrypted file cannot be decrypted, either
+	// because the key is wrong or because the file was modified
+	ErrDecrypt = errors.New("decrypting file failed")
+)
+
+// seal encrypts the given data; the random nonce is prepended to the result
+func (e *Engine) seal(data []byte) ([]byte, error) {
+	nonce := make([]byte, e.aead.NonceSize(), e.aead.NonceSize()+len(data)+e.aead.Overhead())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return e.aead.Seal(nonce, nonce, data, nil), nil
+}
+
+// open decrypts data encrypted by seal
+func (e *Engine) open(data []byte) ([]byte, error) {
+	if e.aead == nil {
+		return nil, ErrNoEncryptionKey
+	}
+	if len(data) < e.aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	plain, err := e.aead.Open(nil, data[:e.aead.NonceSize()], data[e.aead.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plain, nil
+}
+
+// chunkNonce returns the nonce of the chunk with the given index, which is the
+// base nonce with the index added to its last eight bytes
+func chunkNonce(base []byte, i uint64) []byte {
+	nonce := bytes.Clone(base)
+	n := len(nonce) - 8
+	binary.BigEndian.PutUint64(nonce[n:], binary.BigEndian.Uint64(nonce[n:])+i)
+	return nonce
+}
+
+// chunkAD returns the additional data of a chunk, which marks the last chunk,
+// so a truncated file is detected
+func chunkAD(last bool) []byte {
+	if last {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// encryptStream copies the given reader to the given writer encrypting it in
+// chunks of encChunkSize, so large local files do not have to be kept in
+// memory; the stream starts with the random base nonce
+func (e *Engine) encryptStream(w io.Writer, r io.Reader) error {
+	base := make([]byte, e.aead.NonceSize())
+	_, err := rand.Read(base)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(base)
+	if err != nil {
+		return err
+	}
+	br := bufio.NewReaderSize(r, encChunkSize)
+	buf := make([]byte, encChunkSize)
+	for i := uint64(0); ; i++ {
+		n, err := io.ReadFull(br, buf)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return err
+		}
+		last, err := atEOF(br, err)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(e.aead.Seal(nil, chunkNonce(base, i), buf[:n], chunkAD(last)))
+		if err != nil || last {
+			return err
+		}
+	}
+}
+
+// atEOF returns whether the given reader is exhausted after a chunk was read
+// from it with the given error
+func atEOF(br *bufio.Reader, err error) (bool, error) {
+	if err != nil {
+		return true, nil
+	}
+	_, err = br.Peek(1)
+	if errors.Is(err, io.EOF) {
+		return true, nil
+	}
+	return false, err
+}
+
+// decryptReader decrypts a stream written by encryptStream chunk by chunk
+type decryptReader struct {
+	c     io.Closer
+	r     *bufio.Reader
+	aead  cipher.AEAD
+	base  []byte
+	i     uint64
+	buf   []byte
+	plain []byte
+	done  bool
+}
+
+// newDecryptReader returns a reader decrypting the given stream written by
+// encryptStream; closing it closes the given stream
+func (e *Engine) newDecryptReader(rc io.ReadCloser) (io.ReadCloser, error) {
+	if e.aead == nil {
+		return nil, ErrNoEncryptionKey
+	}
+	r := bufio.NewReaderSize(rc, encChunkSize+e.aead.Overhead())
+	base := make([]byte, e.aead.NonceSize())
+	_, err := io.ReadFull(r, base)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return &decryptReader{c: rc, r: r, aead: e.aead, base: base, buf: make([]byte, encChunkSize+e.aead.Overhead())}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(d.r, d.buf)
+		if errors.Is(err, io.EOF) {
+			// the last chunk is always written, even if empty
+			return 0, ErrDecrypt
+		}
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return 0, err
+		}
+		last, err := atEOF(d.r, err)
+		if err != nil {
+			return 0, err
+		}
+		d.plain, err = d.aead.Open(d.buf[:0], chunkNonce(d.base, d.i), d.buf[:n], chunkAD(last))
+		if err != nil {
+			return 0, ErrDecrypt
+		}
+		d.i++
+		d.done = last
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+func (d *decryptReader) Close() error { return d.c.Close() }
@@ -0,0 +1,312 @@
+package content
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// AddBandwidth calls Engine.AddBandwidth of the default engine
+func AddBandwidth(month string, served map[string]int64) error {
+	return engine.AddBandwidth(month, served)
+}
+
+// GetBandwidth calls Engine.GetBandwidth of the default engine
+func GetBandwidth(month string, limit int) (BandwidthReport, error) {
+	return engine.GetBandwidth(month, limit)
+}
+
+// CheckBlobStore calls Engine.CheckBlobStore of the default engine
+func CheckBlobStore() error {
+	return engine.CheckBlobStore()
+}
+
+// SetCaption calls Engine.SetCaption of the default engine
+func SetCaption(uri string, alt string, caption string) error {
+	return engine.SetCaption(uri, alt, caption)
+}
+
+// ListImages calls Engine.ListImages of the default engine
+func ListImages(dir string) ([]MongoFile, error) {
+	return engine.ListImages(dir)
+}
+
+// AddComment calls Engine.AddComment of the default engine
+func AddComment(c *Comment) error {
+	return engine.AddComment(c)
+}
+
+// ListComments calls Engine.ListComments of the default engine
+func ListComments(uri string) ([]Comment, error) {
+	return engine.ListComments(uri)
+}
+
+// ListCommentsByStatus calls Engine.ListCommentsByStatus of the default engine
+func ListCommentsByStatus(status string) ([]Comment, error) {
+	return engine.ListCommentsByStatus(status)
+}
+
+// CountComments calls Engine.CountComments of the default engine
+func CountComments(status string) (int64, error) {
+	return engine.CountComments(status)
+}
+
+// SetCommentStatus calls Engine.SetCommentStatus of the default engine
+func SetCommentStatus(id string, status string) (Comment, error) {
+	return engine.SetCommentStatus(id, status)
+}
+
+// DeleteComment calls Engine.DeleteComment of the default engine
+func DeleteComment(id string) (Comment, error) {
+	return engine.DeleteComment(id)
+}
+
+// CopyFile calls Engine.CopyFile of the default engine
+func CopyFile(uri string, dest string, status string) (MongoFile, error) {
+	return engine.CopyFile(uri, dest, status)
+}
+
+// DefaultLanguage calls Engine.DefaultLanguage of the default engine
+func DefaultLanguage() string {
+	return engine.DefaultLanguage()
+}
+
+// Languages calls Engine.Languages of the default engine
+func Languages() []string {
+	return engine.Languages()
+}
+
+// ValidLanguage calls Engine.ValidLanguage of the default engine
+func ValidLanguage(l string) bool {
+	return engine.ValidLanguage(l)
+}
+
+// SplitLanguage calls Engine.SplitLanguage of the default engine
+func SplitLanguage(uri string) (string, string) {
+	return engine.SplitLanguage(uri)
+}
+
+// GetInLanguage calls Engine.GetInLanguage of the default engine
+func GetInLanguage(uri string, lang string) (MongoFile, error) {
+	return engine.GetInLanguage(uri, lang)
+}
+
+// LoadMenu calls Engine.LoadMenu of the default engine
+func LoadMenu(lang string) ([]MenuItem, error) {
+	return engine.LoadMenu(lang)
+}
+
+// ListMenu calls Engine.ListMenu of the default engine
+func ListMenu() ([]MenuItem, error) {
+	return engine.ListMenu()
+}
+
+// SetMenu calls Engine.SetMenu of the default engine
+func SetMenu(uri string, entry MenuEntry) error {
+	return engine.SetMenu(uri, entry)
+}
+
+// ListInline calls Engine.ListInline of the default engine
+func ListInline() ([]string, error) {
+	return engine.ListInline()
+}
+
+// MoveInline calls Engine.MoveInline of the default engine
+func MoveInline() ([]string, error) {
+	return engine.MoveInline()
+}
+
+// CopyBlobs calls Engine.CopyBlobs of the default engine
+func CopyBlobs(from BlobStore) ([]string, error) {
+	return engine.CopyBlobs(from)
+}
+
+// NewMarkdownFile calls Engine.NewMarkdownFile of the default engine
+func NewMarkdownFile(uri string, size int64, lastMod time.Time) MongoFile {
+	return engine.NewMarkdownFile(uri, size, lastMod)
+}
+
+// NewAsset calls Engine.NewAsset of the default engine
+func NewAsset(uri string, mime string, size int64, lastMod time.Time) MongoFile {
+	return engine.NewAsset(uri, mime, size, lastMod)
+}
+
+// PreviewPage calls Engine.PreviewPage of the default engine
+func PreviewPage(uri string, md []byte) (Page, error) {
+	return engine.PreviewPage(uri, md)
+}
+
+// GetFromDB calls Engine.GetFromDB of the default engine
+func GetFromDB(uri string) (MongoFile, error) {
+	return engine.GetFromDB(uri)
+}
+
+// ListAll calls Engine.ListAll of the default engine
+func ListAll() ([]MongoFile, error) {
+	return engine.ListAll()
+}
+
+// ListStale calls Engine.ListStale of the default engine
+func ListStale(before time.Time) ([]MongoFile, error) {
+	return engine.ListStale(before)
+}
+
+// MoveAsset calls Engine.MoveAsset of the default engine
+func MoveAsset(uri string, dest string) (MongoFile, error) {
+	return engine.MoveAsset(uri, dest)
+}
+
+// ListPosts calls Engine.ListPosts of the default engine
+func ListPosts() ([]MongoFile, error) {
+	return engine.ListPosts()
+}
+
+// Rollback calls Engine.Rollback of the default engine
+func Rollback() ([]string, error) {
+	return engine.Rollback()
+}
+
+// ListQuarantined calls Engine.ListQuarantined of the default engine
+func ListQuarantined() ([]MongoFile, error) {
+	return engine.ListQuarantined()
+}
+
+// SetRedirect calls Engine.SetRedirect of the default engine
+func SetRedirect(r Redirect) (Redirect, error) {
+	return engine.SetRedirect(r)
+}
+
+// GetRedirect calls Engine.GetRedirect of the default engine
+func GetRedirect(from string) (Redirect, error) {
+	return engine.GetRedirect(from)
+}
+
+// ListRedirects calls Engine.ListRedirects of the default engine
+func ListRedirects() ([]Redirect, error) {
+	return engine.ListRedirects()
+}
+
+// DeleteRedirect calls Engine.DeleteRedirect of the default engine
+func DeleteRedirect(id string) (Redirect, error) {
+	return engine.DeleteRedirect(id)
+}
+
+// ListInReview calls Engine.ListInReview of the default engine
+func ListInReview() ([]MongoFile, error) {
+	return engine.ListInReview()
+}
+
+// AddReviewComments calls Engine.AddReviewComments of the default engine
+func AddReviewComments(uri string, comments []ReviewComment) error {
+	return engine.AddReviewComments(uri, comments)
+}
+
+// ClearReviewComments calls Engine.ClearReviewComments of the default engine
+func ClearReviewComments(uri string) error {
+	return engine.ClearReviewComments(uri)
+}
+
+// ListRevisions calls Engine.ListRevisions of the default engine
+func ListRevisions(uri string) ([]Revision, error) {
+	return engine.ListRevisions(uri)
+}
+
+// GetAt calls Engine.GetAt of the default engine
+func GetAt(uri string, at time.Time) (MongoFile, error) {
+	return engine.GetAt(uri, at)
+}
+
+// SetSchedule calls Engine.SetSchedule of the default engine
+func SetSchedule(uri string, publishAt, unpublishAt time.Time) error {
+	return engine.SetSchedule(uri, publishAt, unpublishAt)
+}
+
+// ApplySchedule calls Engine.ApplySchedule of the default engine
+func ApplySchedule(now time.Time) ([]string, error) {
+	return engine.ApplySchedule(now)
+}
+
+// LoadSettings calls Engine.LoadSettings of the default engine
+func LoadSettings() (Settings, error) {
+	return engine.LoadSettings()
+}
+
+// SaveSettings calls Engine.SaveSettings of the default engine
+func SaveSettings(s Settings) error {
+	return engine.SaveSettings(s)
+}
+
+// InvalidateSettings calls Engine.InvalidateSettings of the default engine
+func InvalidateSettings() {
+	engine.InvalidateSettings()
+}
+
+// WatchSettings calls Engine.WatchSettings of the default engine
+func WatchSettings(ctx context.Context) error {
+	return engine.WatchSettings(ctx)
+}
+
+// SlowCounts calls Engine.SlowCounts of the default engine
+func SlowCounts() (queries int64, renders int64) {
+	return engine.SlowCounts()
+}
+
+// GetBySlug calls Engine.GetBySlug of the default engine
+func GetBySlug(slug string, lang string) (MongoFile, bool, error) {
+	return engine.GetBySlug(slug, lang)
+}
+
+// ListStaging calls Engine.ListStaging of the default engine
+func ListStaging(name string) ([]MongoFile, error) {
+	return engine.ListStaging(name)
+}
+
+// ListStagingSets calls Engine.ListStagingSets of the default engine
+func ListStagingSets() ([]string, error) {
+	return engine.ListStagingSets()
+}
+
+// PromoteStaging calls Engine.PromoteStaging of the default engine
+func PromoteStaging(name string) ([]MongoFile, error) {
+	return engine.PromoteStaging(name)
+}
+
+// SetStatus calls Engine.SetStatus of the default engine
+func SetStatus(uri string, s string) error {
+	return engine.SetStatus(uri, s)
+}
+
+// ListTagged calls Engine.ListTagged of the default engine
+func ListTagged() ([]MongoFile, error) {
+	return engine.ListTagged()
+}
+
+// PlainText calls Engine.PlainText of the default engine
+func PlainText(md []byte) string {
+	return engine.PlainText(md)
+}
+
+// ListMarkdown calls Engine.ListMarkdown of the default engine
+func ListMarkdown() ([]MongoFile, error) {
+	return engine.ListMarkdown()
+}
+
+// PresignUpload calls Engine.PresignUpload of the default engine
+func PresignUpload(uri string, expires time.Duration) (string, string, error) {
+	return engine.PresignUpload(uri, expires)
+}
+
+// OpenUpload calls Engine.OpenUpload of the default engine
+func OpenUpload(p string) (io.ReadCloser, error) {
+	return engine.OpenUpload(p)
+}
+
+// RemoveUpload calls Engine.RemoveUpload of the default engine
+func RemoveUpload(p string) {
+	engine.RemoveUpload(p)
+}
+
+// SetVisibility calls Engine.SetVisibility of the default engine
+func SetVisibility(uri string, v string) error {
+	return engine.SetVisibility(uri, v)
+}
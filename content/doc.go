@@ -0,0 +1,27 @@
+// Package content is the content engine of the portfolio: it stores files and
+// their metadata in a MongoDB collection, keeps their contents in a BlobStore
+// and renders markdown files to pages.
+//
+// An engine is created with New, which takes the database and options; all
+// settings have defaults. The operations are methods of the engine, so several
+// engines with their own databases can be used at once:
+//
+//	engine, err := content.New(ctx, db,
+//		content.WithCollections("content", "settings", "bandwidth"),
+//		content.WithEncryptionKey(secret))
+//	f, err := engine.GetFromDB("/about.md")
+//
+// The package-level functions call the methods of the default engine, which
+// an application sets once at startup with SetDefault.
+//
+// Files are described by MongoFile; NewMarkdownFile and NewAsset return files
+// ready to be stored with MongoFile.Store, GetFromDB and the List functions
+// read them back. Files belong to the engine they were read or created by. MongoFile.Open reads the content of a file and
+// MongoFile.ToPage renders a markdown file to a Page, which is written with a
+// template by Page.CreateHTML.
+//
+// Errors are reported by the sentinel errors of this package, like
+// ErrNotFound, ErrHashMismatch and ErrInvalidStatus, which are matched with
+// errors.Is; errors of the database are returned as is and can be classified
+// by IsTransient.
+package content
@@ -0,0 +1,60 @@
+package content
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// maxDocumentSize is the maximum size of a document the database accepts
+const maxDocumentSize = 16 << 20 // 16 MiB
+
+// safeDocumentSize is the maximum size of a document with a cached rendering;
+// the headroom below maxDocumentSize covers fields added after the rendering
+// was stored, like the sha256 hash and the visibility
+const safeDocumentSize = maxDocumentSize - 256<<10
+
+// documentSize returns the estimated size of the file's encoded document
+// including its cached rendering and the inline content of files stored before
+// all content was kept in the blob store
+func (p *MongoFile) documentSize() int {
+	meta := *p
+	meta.Content, meta.Rendered = primitive.Binary{}, primitive.Binary{}
+	data, err := bson.Marshal(meta)
+	if err != nil {
+		return maxDocumentSize
+	}
+	// the binary fields add their names, lengths and subtypes, and the filter
+	// of the upsert adds the name field
+	overhead := 2*(len("rendered")+6) + len("name") + len(p.URI) + 6
+	size := len(data) + len(p.Rendered.Data) + overhead
+	if !p.IsLocal {
+		size += len(p.Content.Data)
+	}
+	return size
+}
+
+// unsetFields returns the fields to remove when writing the file; inline
+// content and cached renderings of the previous file would count towards the
+// size of the document otherwise
+func (p *MongoFile) unsetFields() bson.M {
+	unset := bson.M{}
+	if p.Content.Data == nil {
+		unset["content"] = ""
+	}
+	if p.Rendered.Data == nil {
+		unset["rendered"] = ""
+	}
+	if p.RenderKey == "" {
+		unset["render_key"] = ""
+	}
+	if p.Meta == nil {
+		unset["meta"] = ""
+	}
+	if p.Slug == "" {
+		unset["slug"] = ""
+	}
+	if p.Lang == "" {
+		unset["lang"] = ""
+	}
+	return unset
+}
@@ -0,0 +1,277 @@
+package content
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Engine is a content engine created by New; it holds the database
+// collections, the blob store and the configuration its operations use.
+// Engines are independent of each other, so several engines may be used at
+// once, each with its own database.
+type Engine struct {
+	ctx       context.Context
+	db        *mongo.Database
+	files     *mongo.Collection
+	settings  *mongo.Collection
+	bandwidth *mongo.Collection
+	comments  *mongo.Collection
+	redirects *mongo.Collection
+	// revisions is the collection of the revision history; nil if the history
+	// is disabled
+	revisions *mongo.Collection
+	blobs     BlobStore
+	// aead encrypts file contents at rest; nil if encryption is disabled
+	aead cipher.AEAD
+	// retryAttempts is the number of attempts of idempotent operations failing
+	// with transient errors; retryBackoff is the base delay between attempts,
+	// which doubles with every attempt
+	retryAttempts int
+	retryBackoff  time.Duration
+	// slowQuery and slowRender are the durations from which on database
+	// operations and markdown renderings are logged as slow; zero disables
+	// logging
+	slowQuery  time.Duration
+	slowRender time.Duration
+	// defaultPageStatus is the status of markdown files uploaded for the
+	// first time; empty means published
+	defaultPageStatus string
+	// renderer converts markdown pages to HTML, which is sanitized by policy
+	renderer Renderer
+	policy   *Policy
+	// languages are the languages pages are written in; the first one is the
+	// default language
+	languages []string
+	// defaultLanguage is the default language set by the settings, which
+	// replaces the first configured language; nil if not set
+	defaultLanguage atomic.Pointer[string]
+	// cachedSettings caches the settings document, so it is not read from the
+	// database for every rendered page
+	cachedSettings *Settings
+	settingsMu     sync.RWMutex
+	// slowQueries and slowRenders count the slow operations since the start
+	slowQueries atomic.Int64
+	slowRenders atomic.Int64
+}
+
+// Option configures an Engine created by New
+type Option func(e *Engine) error
+
+// engine is the default engine the package-level functions of this package and
+// files not belonging to an engine use; replaced by SetDefault
+var engine = newEngine(context.Background(), nil)
+
+// newEngine returns an engine using the given database with the default
+// configuration
+func newEngine(ctx context.Context, db *mongo.Database) *Engine {
+	e := &Engine{
+		ctx:           ctx,
+		db:            db,
+		blobs:         DiskStore{Dir: URIRoot},
+		retryAttempts: 3,
+		retryBackoff:  100 * time.Millisecond,
+		slowQuery:     200 * time.Millisecond,
+		slowRender:    100 * time.Millisecond,
+		renderer:      blackfridayRenderer{},
+		policy:        RelaxedPolicy,
+		languages:     []string{"de"},
+	}
+	if db != nil {
+		e.files = db.Collection(URIRoot)
+		e.settings = db.Collection("settings")
+		e.bandwidth = db.Collection("bandwidth")
+		e.comments = db.Collection("comments")
+		e.redirects = db.Collection("redirects")
+		e.blobs = nil
+	}
+	return e
+}
+
+// New creates an engine storing files in the given database, configured by
+// the given options; all database operations use the given context and fail
+// once it is done. Unless set by WithBlobStore, file contents are stored in
+// the GridFS bucket 'blobs' of the database.
+//
+// Files read or created by the engine's methods belong to the engine, so their
+// methods use it as well. The package-level functions use the default engine,
+// see SetDefault.
+func New(ctx context.Context, db *mongo.Database, opts ...Option) (*Engine, error) {
+	if db == nil {
+		return nil, errors.New("content engine requires a database")
+	}
+	e := newEngine(ctx, db)
+	for _, opt := range opts {
+		err := opt(e)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if e.blobs == nil {
+		store, err := NewGridFSStore(db, "blobs")
+		if err != nil {
+			return nil, err
+		}
+		e.blobs = store
+	}
+	index := mongo.IndexModel{Keys: bson.D{{Key: "month", Value: 1}, {Key: "bytes", Value: -1}}}
+	_, err := e.bandwidth.Indexes().CreateOne(ctx, index)
+	if err != nil {
+		log.Println("[Err] Creating bandwidth index:", err)
+	}
+	index = mongo.IndexModel{Keys: bson.D{{Key: "uri", Value: 1}, {Key: "status", Value: 1}, {Key: "created", Value: 1}}}
+	_, err = e.comments.Indexes().CreateOne(ctx, index)
+	if err != nil {
+		log.Println("[Err] Creating comment index:", err)
+	}
+	index = mongo.IndexModel{Keys: bson.D{{Key: "from", Value: 1}}, Options: options.Index().SetUnique(true)}
+	_, err = e.redirects.Indexes().CreateOne(ctx, index)
+	if err != nil {
+		log.Println("[Err] Creating redirect index:", err)
+	}
+	if e.revisions != nil {
+		for _, keys := range []bson.D{{{Key: "uri", Value: 1}, {Key: "saved", Value: -1}}, {{Key: "file.path", Value: 1}},
+			{{Key: "file.slug", Value: 1}, {Key: "saved", Value: -1}}} {
+			_, err = e.revisions.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: keys})
+			if err != nil {
+				log.Println("[Err] Creating revision index:", err)
+			}
+		}
+	}
+	log.Println("Storing file contents in:", e.blobs.Name())
+	return e, nil
+}
+
+// SetDefault sets the default engine, which the package-level functions of
+// this package and files not read or created by an engine use; it is set
+// once at startup, before the package is used concurrently. Until set,
+// the default engine has no database.
+func SetDefault(e *Engine) {
+	engine = e
+}
+
+// Default returns the default engine, see SetDefault
+func Default() *Engine {
+	return engine
+}
+
+// Context returns the context of the engine's database operations
+func (e *Engine) Context() context.Context { return e.ctx }
+
+// Database returns the database the engine stores files in
+func (e *Engine) Database() *mongo.Database { return e.db }
+
+// WithCollections sets the names of the collections files, settings and
+// served bytes are stored in; empty names keep the defaults 'content',
+// 'settings' and 'bandwidth'
+func WithCollections(files string, settings string, bandwidth string) Option {
+	return func(e *Engine) error {
+		if files != "" {
+			e.files = e.db.Collection(files)
+		}
+		if settings != "" {
+			e.settings = e.db.Collection(settings)
+		}
+		if bandwidth != "" {
+			e.bandwidth = e.db.Collection(bandwidth)
+		}
+		return nil
+	}
+}
+
+// WithBlobStore sets the store file contents are kept in; content stored in
+// another store before is not moved, see CopyBlobs.
+func WithBlobStore(s BlobStore) Option {
+	return func(e *Engine) error {
+		e.blobs = s
+		return nil
+	}
+}
+
+// WithEncryptionKey enables AES-256-GCM encryption of file contents using a
+// key derived from the given secret; an empty secret disables encryption of
+// new files. Files stored before are still read as they were written.
+func WithEncryptionKey(secret []byte) Option {
+	return func(e *Engine) error {
+		if len(secret) == 0 {
+			e.aead = nil
+			return nil
+		}
+		key := sha256.Sum256(secret)
+		block, err := aes.NewCipher(key[:])
+		if err != nil {
+			return err
+		}
+		e.aead, err = cipher.NewGCM(block)
+		return err
+	}
+}
+
+// WithRetryPolicy sets the number of attempts of idempotent operations
+// failing with transient errors and the base delay between attempts. The
+// driver retries an operation once by itself, which does not cover elections
+// taking longer than a single attempt.
+func WithRetryPolicy(attempts int, backoff time.Duration) Option {
+	return func(e *Engine) error {
+		e.retryAttempts = max(attempts, 1)
+		e.retryBackoff = backoff
+		return nil
+	}
+}
+
+// WithSlowThresholds sets the durations from which on database operations and
+// markdown renderings are logged and counted as slow; zero disables logging.
+func WithSlowThresholds(query time.Duration, render time.Duration) Option {
+	return func(e *Engine) error {
+		e.slowQuery = query
+		e.slowRender = render
+		return nil
+	}
+}
+
+// WithDefaultPageStatus sets the status of markdown files uploaded for the
+// first time unless a status is given; the status of files replacing existing
+// files is kept. Staged and quarantined files are published once promoted or
+// approved, so they are not affected.
+func WithDefaultPageStatus(s string) Option {
+	return func(e *Engine) error {
+		if !ValidStatus(s) {
+			return ErrInvalidStatus
+		}
+		e.defaultPageStatus = s
+		return nil
+	}
+}
+
+// WithSanitizePolicy sets the policy rendered markdown is sanitized with by
+// its name, which is either 'strict' or 'relaxed'
+func WithSanitizePolicy(name string) Option {
+	return func(e *Engine) error {
+		switch name {
+		case StrictPolicy.Name:
+			e.policy = StrictPolicy
+		case RelaxedPolicy.Name:
+			e.policy = RelaxedPolicy
+		default:
+			return errors.New("unknown sanitize policy: " + name)
+		}
+		return nil
+	}
+}
+
+// WithRenderer sets the renderer converting markdown pages to HTML
+func WithRenderer(r Renderer) Option {
+	return func(e *Engine) error {
+		e.renderer = r
+		return nil
+	}
+}
@@ -0,0 +1,104 @@
+package content
+
+import (
+	"bytes"
+	"gopkg.in/yaml.v3"
+	"log"
+	"time"
+)
+
+// maxFrontMatter is the maximum size of the front matter of a markdown file;
+// larger front matter is not recognized
+const maxFrontMatter = 64 << 10 // 64 KiB
+
+// FrontMatter is the YAML front matter of a markdown file, which is enclosed
+// by lines of three dashes at the start of the file:
+//
+//	---
+//	title: About me
+//	tags: [go, web]
+//	---
+type FrontMatter struct {
+	Title       string   `yaml:"title" bson:"title,omitempty" json:"title,omitempty"`
+	Description string   `yaml:"description" bson:"description,omitempty" json:"description,omitempty"`
+	Tags        []string `yaml:"tags" bson:"tags,omitempty" json:"tags,omitempty"`
+	// Slug is the custom slug of the page replacing the slug of its title, see
+	// Slugify
+	Slug string `yaml:"slug" bson:"slug,omitempty" json:"slug,omitempty"`
+	// URL is the canonical URL of the page
+	URL string `yaml:"url" bson:"url,omitempty" json:"url,omitempty"`
+	// Template is the name of the template the page is rendered with instead
+	// of 'page'
+	Template string    `yaml:"template" bson:"template,omitempty" json:"template,omitempty"`
+	Date     time.Time `yaml:"date" bson:"date,omitempty" json:"date,omitempty"`
+	// Type is PostType for blog posts and empty for static pages
+	Type string `yaml:"type" bson:"type,omitempty" json:"type,omitempty"`
+	// Summary is the summary of blog posts shown on the blog pages and in the
+	// feeds
+	Summary string `yaml:"summary" bson:"summary,omitempty" json:"summary,omitempty"`
+}
+
+// SplitFrontMatter splits the given markdown into its front matter and its
+// body; the front matter is nil if the markdown has none. Returns an error if
+// the front matter is not valid YAML.
+func SplitFrontMatter(md []byte) (*FrontMatter, []byte, error) {
+	md = NormalizeEOL(md)
+	yml, body, found := splitFrontMatter(md)
+	if !found {
+		return nil, md, nil
+	}
+	var fm FrontMatter
+	err := yaml.Unmarshal(yml, &fm)
+	if err != nil {
+		return nil, md, err
+	}
+	return &fm, body, nil
+}
+
+// splitFrontMatter splits the given markdown with normalized EOLs into the
+// YAML of its front matter and its body; returns false if the markdown has no
+// front matter
+func splitFrontMatter(md []byte) ([]byte, []byte, bool) {
+	if !bytes.HasPrefix(md, []byte("---\n")) {
+		return nil, md, false
+	}
+	rest := md[len("---\n"):]
+	for off := 0; off < len(rest) && off <= maxFrontMatter; {
+		end := bytes.IndexByte(rest[off:], '\n')
+		if end == -1 {
+			end = len(rest) - off
+		}
+		line := string(rest[off : off+end])
+		if line == "---" || line == "..." {
+			return rest[:off], rest[min(off+end+1, len(rest)):], true
+		}
+		off += end + 1
+	}
+	// a thematic break at the start of the file is not front matter
+	return nil, md, false
+}
+
+// frontMatterBuffer keeps the start of the content written to it, which is
+// large enough to contain the front matter
+type frontMatterBuffer struct {
+	bytes.Buffer
+}
+
+func (b *frontMatterBuffer) Write(data []byte) (int, error) {
+	if n := maxFrontMatter + 8 - b.Len(); n > 0 {
+		b.Buffer.Write(data[:min(n, len(data))])
+	}
+	return len(data), nil
+}
+
+// parseFrontMatter returns the front matter of the given start of a markdown
+// file; invalid front matter is logged and ignored, so the file is stored
+// nonetheless and rendered without it
+func (p *MongoFile) parseFrontMatter(head []byte) *FrontMatter {
+	fm, _, err := SplitFrontMatter(head)
+	if err != nil {
+		log.Println("[Err] Invalid front matter:", p.URI, err)
+		return nil
+	}
+	return fm
+}
@@ -0,0 +1,195 @@
+package content
+
+import (
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// langRegexp matches language codes like 'de' or 'en-us'
+var langRegexp = regexp.MustCompile(`^[a-z]{2}(-[a-z]{2})?$`)
+
+// Translation is a language variant of a page shown in the language switcher
+// rendered by the templates
+type Translation struct {
+	Lang  string
+	Title string
+	// Link is the path of the variant prefixed by its language, so the variant
+	// is served without language negotiation
+	Link string
+	// Current is set for the variant of the page the switcher is shown on
+	Current bool
+	// DefaultLink is the path of the variant in the default language without
+	// language prefix, which is served in the language preferred by the
+	// client; only set for the variant in the default language
+	DefaultLink string
+}
+
+// WithLanguages sets the languages pages are written in; the first language
+// is the default language, which pages without language suffix are written in
+// and which is served without language prefix. Markdown files are stored in
+// another language by suffixing their name with the language, like
+// 'about.en.md'. Language codes are lower case like 'de' or 'en-us'.
+func WithLanguages(langs ...string) Option {
+	return func(e *Engine) error {
+		if len(langs) == 0 {
+			return errors.New("at least one language is required")
+		}
+		for _, l := range langs {
+			if !langRegexp.MatchString(l) {
+				return errors.New("invalid language: " + l)
+			}
+		}
+		e.languages = slices.Clone(langs)
+		return nil
+	}
+}
+
+// DefaultLanguage returns the language of pages without language suffix,
+// which is the default language of the settings or else the first configured
+// language
+func (e *Engine) DefaultLanguage() string {
+	if l := e.defaultLanguage.Load(); l != nil && e.ValidLanguage(*l) {
+		return *l
+	}
+	return e.languages[0]
+}
+
+// setDefaultLanguage sets the default language of the settings; an empty
+// language resets it to the first configured language
+func (e *Engine) setDefaultLanguage(l string) {
+	if l == "" {
+		e.defaultLanguage.Store(nil)
+		return
+	}
+	e.defaultLanguage.Store(&l)
+}
+
+// Languages returns the languages pages are written in, the default language
+// first
+func (e *Engine) Languages() []string {
+	def := e.DefaultLanguage()
+	langs := []string{def}
+	for _, l := range e.languages {
+		if l != def {
+			langs = append(langs, l)
+		}
+	}
+	return langs
+}
+
+// ValidLanguage returns whether the given language is one of the languages
+// pages are written in
+func (e *Engine) ValidLanguage(l string) bool {
+	return slices.Contains(e.languages, l)
+}
+
+// SplitLanguage splits the language suffix from the name of the given uri;
+// '/about.en.md' is split into '/about.md' and 'en'. Returns the uri as is
+// and an empty language if the name has no suffix of a valid language.
+func (e *Engine) SplitLanguage(uri string) (string, string) {
+	ext := path.Ext(uri)
+	base := strings.TrimSuffix(uri, ext)
+	l := strings.TrimPrefix(path.Ext(base), ".")
+	if l == "" || !e.ValidLanguage(l) {
+		return uri, ""
+	}
+	return strings.TrimSuffix(base, "."+l) + ext, l
+}
+
+// languageURIs returns the uris the variant of the given uri without language
+// suffix is stored at in the given language; variants in the default language
+// may be stored with or without suffix
+func (e *Engine) languageURIs(uri string, lang string) []string {
+	ext := path.Ext(uri)
+	uris := []string{strings.TrimSuffix(uri, ext) + "." + lang + ext}
+	if lang == e.DefaultLanguage() {
+		uris = append(uris, uri)
+	}
+	return uris
+}
+
+// langFilter adds the given language to the given filter; files stored before
+// languages were supported have no language and are in the default language
+func (e *Engine) langFilter(filter bson.M, lang string) bson.M {
+	if lang == e.DefaultLanguage() {
+		filter["lang"] = bson.M{"$in": bson.A{nil, "", lang}}
+	} else {
+		filter["lang"] = lang
+	}
+	return filter
+}
+
+// Language returns the language the file is written in
+func (p *MongoFile) Language() string {
+	if p.Lang != "" {
+		return p.Lang
+	}
+	return p.eng().DefaultLanguage()
+}
+
+// GetInLanguage returns the public markdown file which is the variant of the
+// page with the given uri in the given language except for
+// MongoFile.Content; the uri is without language suffix and has either the
+// extension '.md' or '.html'. Returns ErrNotFound if the page has no such
+// variant.
+func (e *Engine) GetInLanguage(uri string, lang string) (MongoFile, error) {
+	log.Println("Getting file in language:", uri, lang)
+	uri = strings.TrimSuffix(uri, path.Ext(uri)) + ".md"
+	opts := options.FindOne().SetProjection(metaProjection)
+	var file MongoFile
+	err := e.retry("getting file in language", uri, func() error {
+		filter := public(bson.M{"uri": bson.M{"$in": e.languageURIs(uri, lang)}, "is_md": true})
+		return e.decodeFile(e.files.FindOne(e.ctx, e.langFilter(filter, lang), opts), &file)
+	})
+	if err != nil {
+		return MongoFile{}, err
+	}
+	return file, nil
+}
+
+// Translations returns the published variants of the markdown file in all
+// languages including the file itself, ordered like the languages; returns
+// nil if the file has no variant in another language
+func (p *MongoFile) Translations() ([]Translation, error) {
+	e := p.eng()
+	base, _ := e.SplitLanguage(p.URI)
+	var uris []string
+	for _, l := range e.languages {
+		uris = append(uris, e.languageURIs(base, l)...)
+	}
+	opts := options.Find().SetProjection(metaProjection)
+	var files []MongoFile
+	err := e.retry("listing translations", base, func() error {
+		cursor, err := e.files.Find(e.ctx, public(bson.M{"uri": bson.M{"$in": uris}, "is_md": true}), opts)
+		if err != nil {
+			return err
+		}
+		return cursor.All(e.ctx, &files)
+	})
+	if err != nil {
+		return nil, err
+	}
+	var translations []Translation
+	for _, l := range e.languages {
+		i := slices.IndexFunc(files, func(f MongoFile) bool { return f.Language() == l && f.Published() })
+		if i < 0 {
+			continue
+		}
+		f := files[i]
+		t := Translation{Lang: l, Title: f.Title(), Link: f.LanguageLink(), Current: f.URI == p.URI}
+		if l == e.DefaultLanguage() {
+			t.DefaultLink = f.Link()
+		}
+		translations = append(translations, t)
+	}
+	if len(translations) < 2 {
+		return nil, nil
+	}
+	return translations, nil
+}
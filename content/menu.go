@@ -0,0 +1,185 @@
+package content
+
+import (
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"sort"
+	"strings"
+)
+
+// ErrInvalidMenu is returned if the menu entry of a page would refer to a
+// missing parent or nest the menu deeper than one level
+var ErrInvalidMenu = errors.New("menu parent must be another top-level page")
+
+// MenuEntry configures how a page is shown in the navigation menu; pages
+// without entry are shown at the top level with weight zero
+type MenuEntry struct {
+	// Weight orders the entries of a menu level ascending; entries with the
+	// same weight are ordered by title
+	Weight int `bson:"weight,omitempty" json:"weight"`
+	// Hidden pages are not shown in the menu; their children are not shown
+	// either
+	Hidden bool `bson:"hidden,omitempty" json:"hidden"`
+	// Parent is the uri of the top-level page the page is shown below
+	Parent string `bson:"parent,omitempty" json:"parent,omitempty"`
+}
+
+// MenuItem is a page shown in the navigation menu rendered by the templates
+type MenuItem struct {
+	Title    string     `json:"title"`
+	Link     string     `json:"link"`
+	URI      string     `json:"uri"`
+	Weight   int        `json:"weight"`
+	Hidden   bool       `json:"hidden,omitempty"`
+	Children []MenuItem `json:"children,omitempty"`
+}
+
+// menuProjection are the fields of the files read to build the menu
+var menuProjection = bson.M{"uri": 1, "is_md": 1, "meta.title": 1, "slug": 1, "lang": 1, "menu": 1, "visibility": 1,
+	"status": 1, "publish_at": 1, "unpublish_at": 1}
+
+// listMenuPages lists the public markdown files shown in the menu, which
+// excludes the start page; if the given language is not empty, only files in
+// the language are listed
+func (e *Engine) listMenuPages(lang string) ([]MongoFile, error) {
+	opts := options.Find().SetProjection(menuProjection)
+	var start []string
+	for _, l := range e.languages {
+		start = append(start, e.languageURIs("/index.md", l)...)
+	}
+	var files []MongoFile
+	err := e.retry("listing menu pages", "", func() error {
+		filter := public(bson.M{"is_md": true, "uri": bson.M{"$nin": start}})
+		if lang != "" {
+			filter = e.langFilter(filter, lang)
+		}
+		cursor, err := e.files.Find(e.ctx, filter, opts)
+		if err != nil {
+			return err
+		}
+		return e.decodeFiles(e.ctx, cursor, &files)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// menuItem returns the menu item of the file
+func (p *MongoFile) menuItem() MenuItem {
+	item := MenuItem{Title: p.Title(), Link: p.Link(), URI: p.URI}
+	if p.Menu != nil {
+		item.Weight = p.Menu.Weight
+		item.Hidden = p.Menu.Hidden
+	}
+	return item
+}
+
+// sortMenu sorts the given items and their children by weight and title
+func sortMenu(items []MenuItem) {
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].Weight != items[j].Weight {
+			return items[i].Weight < items[j].Weight
+		}
+		return strings.ToLower(items[i].Title) < strings.ToLower(items[j].Title)
+	})
+	for _, item := range items {
+		sortMenu(item.Children)
+	}
+}
+
+// buildMenu returns the menu of the given files with one level of children;
+// if all is false, only listed and published pages which are not hidden are
+// included. Pages whose parent is missing are shown at the top level.
+func buildMenu(files []MongoFile, all bool) []MenuItem {
+	top := map[string]bool{}
+	for _, f := range files {
+		if f.Menu == nil || f.Menu.Parent == "" {
+			top[f.URI] = true
+		}
+	}
+	children := map[string][]MenuItem{}
+	var items []MenuItem
+	for _, f := range files {
+		if !all && (!f.Listed() || !f.Published() || (f.Menu != nil && f.Menu.Hidden)) {
+			continue
+		}
+		if f.Menu != nil && top[f.Menu.Parent] {
+			children[f.Menu.Parent] = append(children[f.Menu.Parent], f.menuItem())
+		} else {
+			items = append(items, f.menuItem())
+		}
+	}
+	for i := range items {
+		items[i].Children = children[items[i].URI]
+	}
+	sortMenu(items)
+	return items
+}
+
+// LoadMenu returns the navigation menu of the listed and published pages in
+// the given language except for the start page; pages are ordered and nested
+// by their menu entries and hidden pages are left out
+func (e *Engine) LoadMenu(lang string) ([]MenuItem, error) {
+	files, err := e.listMenuPages(lang)
+	if err != nil {
+		return nil, err
+	}
+	return buildMenu(files, false), nil
+}
+
+// ListMenu returns the menu of all public pages like LoadMenu, including
+// hidden, unlisted and unpublished pages in all languages, so it can be
+// managed
+func (e *Engine) ListMenu() ([]MenuItem, error) {
+	files, err := e.listMenuPages("")
+	if err != nil {
+		return nil, err
+	}
+	return buildMenu(files, true), nil
+}
+
+// SetMenu sets the menu entry of the markdown file with the given uri. The
+// parent must be another public markdown file without parent, and files with
+// children cannot get a parent, so the menu is nested one level at most.
+// Returns ErrNotFound if there is no such file and ErrInvalidMenu if the
+// parent is not valid.
+func (e *Engine) SetMenu(uri string, entry MenuEntry) error {
+	log.Println("Setting menu entry of file:", uri, entry)
+	if entry.Parent != "" {
+		if entry.Parent == uri {
+			return ErrInvalidMenu
+		}
+		var parents, children int64
+		err := e.retry("checking menu parent", entry.Parent, func() (err error) {
+			parents, err = e.files.CountDocuments(e.ctx, public(bson.M{"uri": entry.Parent, "is_md": true,
+				"menu.parent": bson.M{"$exists": false}}))
+			if err != nil {
+				return err
+			}
+			children, err = e.files.CountDocuments(e.ctx, bson.M{"menu.parent": uri})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if parents == 0 || children > 0 {
+			return ErrInvalidMenu
+		}
+	}
+	var res *mongo.UpdateResult
+	err := e.retry("setting menu entry", uri, func() (err error) {
+		res, err = e.files.UpdateOne(e.ctx, bson.M{"uri": uri, "is_md": true}, bson.M{"$set": bson.M{"menu": entry}})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package content
+
+import (
+	"bytes"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"io"
+	"log"
+	"os"
+)
+
+// ListInline lists the uris of files whose content is stored inline in the
+// database, as they were stored before all content was kept in the blob store.
+func (e *Engine) ListInline() ([]string, error) {
+	return e.listURIs("listing inline files", bson.M{"is_local": bson.M{"$ne": true}})
+}
+
+// MoveInline moves the content of files stored inline in the database to the
+// blob store and removes their cached renderings. Returns the uris of the
+// moved files; files changed during the move are skipped.
+func (e *Engine) MoveInline() ([]string, error) {
+	uris, err := e.ListInline()
+	if err != nil {
+		return nil, err
+	}
+	moved := []string{}
+	for _, uri := range uris {
+		ok, err := e.moveToBlob(uri)
+		if err != nil {
+			return moved, err
+		}
+		if ok {
+			moved = append(moved, uri)
+		}
+	}
+	return moved, nil
+}
+
+// CopyBlobs copies the content of all files missing in the blob store from the
+// given store, like the directory content was stored in before GridFS was
+// used. The content is copied as is, so encrypted content stays encrypted, and
+// is not removed from the given store. Returns the uris of the files whose
+// content was copied; content missing in both stores is logged and skipped.
+func (e *Engine) CopyBlobs(from BlobStore) ([]string, error) {
+	if from.Name() == e.blobs.Name() {
+		return nil, errors.New("cannot copy content from the blob store to itself: " + from.Name())
+	}
+	var files []MongoFile
+	opts := options.Find().SetProjection(bson.M{"uri": 1, "path": 1, "is_local": 1})
+	err := e.retry("listing stored files", "", func() error {
+		cursor, err := e.files.Find(e.ctx, bson.M{"is_local": true}, opts)
+		if err != nil {
+			return err
+		}
+		return e.decodeFiles(e.ctx, cursor, &files)
+	})
+	if err != nil {
+		return nil, err
+	}
+	copied := []string{}
+	// promoted and previous files may refer to the same content
+	seen := map[string]bool{}
+	for _, f := range files {
+		p := f.localPath()
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+		ok, err := e.copyBlob(from, p)
+		if err != nil {
+			return copied, err
+		}
+		if ok {
+			copied = append(copied, f.URI)
+		}
+	}
+	return copied, nil
+}
+
+// copyBlob copies the content at the given path from the given store to the
+// blob store unless it already exists; returns false if nothing was copied
+func (e *Engine) copyBlob(from BlobStore, p string) (bool, error) {
+	rc, err := e.blobs.Open(p)
+	if err == nil {
+		_ = rc.Close()
+		return false, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+	src, err := from.Open(p)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Println("[Err] Content missing in", from.Name()+":", p)
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = src.Close() }()
+	log.Println("Copying content from", from.Name(), "to", e.blobs.Name()+":", p)
+	err = e.blobs.Write(p, func(w io.Writer) error {
+		_, err := io.Copy(w, src)
+		return err
+	})
+	return err == nil, err
+}
+
+// listURIs lists the uris of the files matching the given filter
+func (e *Engine) listURIs(name string, filter bson.M) ([]string, error) {
+	var files []MongoFile
+	opts := options.Find().SetProjection(bson.M{"uri": 1})
+	err := e.retry(name, "", func() error {
+		cursor, err := e.files.Find(e.ctx, filter, opts)
+		if err != nil {
+			return err
+		}
+		return e.decodeFiles(e.ctx, cursor, &files)
+	})
+	if err != nil {
+		return nil, err
+	}
+	uris := []string{}
+	for _, f := range files {
+		uris = append(uris, f.URI)
+	}
+	return uris, nil
+}
+
+// moveToBlob moves the inline content of the file with the given uri to the
+// blob store; returns false if the file was changed or removed in the meantime
+func (e *Engine) moveToBlob(uri string) (bool, error) {
+	var p MongoFile
+	err := e.decodeFile(e.files.FindOne(e.ctx, bson.M{"uri": uri, "is_local": bson.M{"$ne": true}}), &p)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	data := p.Content.Data
+	if p.Encrypted {
+		data, err = e.open(data)
+		if err != nil {
+			return false, err
+		}
+	}
+	log.Println("Moving inline content to blob store:", uri)
+	err = p.storeBlob(bytes.NewReader(data))
+	if err != nil {
+		return false, err
+	}
+	// the content is only replaced if the file was not changed meanwhile
+	filter := bson.M{"uri": uri, "last_mod": p.LastMod, "is_local": bson.M{"$ne": true}}
+	res, err := e.files.UpdateOne(e.ctx, filter, bson.M{
+		"$set":   bson.M{"is_local": true, "path": p.Path, "sha256": p.Hash, "encrypted": e.aead != nil},
+		"$unset": bson.M{"content": "", "rendered": "", "render_key": ""},
+	})
+	if err == nil && res.MatchedCount == 0 {
+		log.Println("File changed during move; discarding moved content:", uri)
+	}
+	if err != nil || res.MatchedCount == 0 {
+		if rErr := e.blobs.Remove(p.localPath()); rErr != nil {
+			log.Println("[Err] Removing moved content:", rErr)
+		}
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,629 @@
+package content
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"html/template"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// URIRoot is the uri root for files
+const URIRoot = "content"
+
+// ErrNotFound is returned if a file does not exist; it matches
+// mongo.ErrNoDocuments as well
+var ErrNotFound = errors.Join(mongo.ErrNoDocuments, errors.New("file not found"))
+
+// ErrHashMismatch is returned if a file's content does not have the expected
+// hash
+var ErrHashMismatch = errors.New("file content hash does not match")
+
+// MongoFile is the representation of a file that is stored in the database
+//
+//goland:noinspection GoVetStructTag
+type MongoFile struct {
+	URI      string           `bson:"uri,omitempty" json:"uri,omitempty"`
+	Filesize int64            `bson:"size,omitempty" json:"size,omitempty"`
+	LastMod  time.Time        `bson:"last_mod,omitempty" json:"last_mod,omitempty"`
+	Content  primitive.Binary `bson:"content,omitempty" json:"-"`
+	IsMD     bool             `bson:"is_md,omitempty" json:"-"`
+	IsLocal  bool             `bson:"is_local,omitempty" json:"-"`
+	Mime     string           `bson:"mimetype,omitempty" json:"mimetype,omitempty"`
+	// Hash is the hex encoded SHA-256 hash of the file's content
+	Hash string `bson:"sha256,omitempty" json:"sha256,omitempty"`
+	// Variants are the mime types of the converted variants stored next to
+	// the file; always written, so a re-upload resets stale variants
+	Variants []string `bson:"variants" json:"variants,omitempty"`
+	// Quarantined files await approval and are not served publicly; they are
+	// stored below QuarantineRoot
+	Quarantined bool   `bson:"quarantined,omitempty" json:"quarantined,omitempty"`
+	UploadedBy  string `bson:"uploaded_by,omitempty" json:"uploaded_by,omitempty"`
+	// Visibility is either VisibilityPublic, VisibilityUnlisted or
+	// VisibilityPrivate; files without visibility are public
+	Visibility string `bson:"visibility,omitempty" json:"visibility,omitempty"`
+	// Status is either StatusDraft, StatusReview, StatusPublished or
+	// StatusArchived; files without status are published
+	Status string `bson:"status,omitempty" json:"status,omitempty"`
+	// PublishAt and UnpublishAt are the times the file is published and
+	// archived at, see ApplySchedule; kept when the file is replaced
+	PublishAt   time.Time `bson:"publish_at,omitempty" json:"publish_at,omitempty"`
+	UnpublishAt time.Time `bson:"unpublish_at,omitempty" json:"unpublish_at,omitempty"`
+	// Meta is the front matter of markdown files parsed when the file is
+	// stored
+	Meta *FrontMatter `bson:"meta,omitempty" json:"meta,omitempty"`
+	// Slug is the path without extension public markdown files are served at,
+	// see Link; OldSlugs are the slugs the file had before, which redirect to
+	// the current slug and are kept when the file is replaced
+	Slug     string   `bson:"slug,omitempty" json:"slug,omitempty"`
+	OldSlugs []string `bson:"old_slugs,omitempty" json:"old_slugs,omitempty"`
+	// Menu is the menu entry of markdown files set by SetMenu; kept when the
+	// file is replaced
+	Menu *MenuEntry `bson:"menu,omitempty" json:"menu,omitempty"`
+	// Review are the comments of reviewers on files submitted for review, see
+	// AddReviewComments; kept when the file is replaced
+	Review []ReviewComment `bson:"review,omitempty" json:"review,omitempty"`
+	// Alt and Caption describe images in galleries and figures, see
+	// expandShortcodes; they are read from sidecar files of uploaded zip files
+	// or set by SetCaption and kept when the file is replaced
+	Alt     string `bson:"alt,omitempty" json:"alt,omitempty"`
+	Caption string `bson:"caption,omitempty" json:"caption,omitempty"`
+	// Lang is the language of markdown files suffixed with a language, like
+	// 'about.en.md'; empty for files in the default language without suffix
+	Lang string `bson:"lang,omitempty" json:"lang,omitempty"`
+	// Staging is the name of the staging content set the file is part of;
+	// staged files are stored below StagingRoot and only served as preview
+	Staging string `bson:"staging,omitempty" json:"staging,omitempty"`
+	// Previous is set for files replaced by the last promotion, which are kept
+	// below PreviousRoot for a rollback
+	Previous string `bson:"previous,omitempty" json:"-"`
+	// Path is the path in the blob store locally stored content is read from
+	// if it differs from the file's URI; always written, so a re-upload resets
+	// it
+	Path string `bson:"path" json:"-"`
+	// Encrypted is set if the file's content is encrypted at rest; always
+	// written, so a re-upload without encryption resets it
+	Encrypted bool `bson:"encrypted" json:"-"`
+	// RenderKey and Rendered cache the HTML rendering of markdown files
+	RenderKey string           `bson:"render_key,omitempty" json:"-"`
+	Rendered  primitive.Binary `bson:"rendered,omitempty" json:"-"`
+	// engine is the engine the file was read or created by; nil for files
+	// created otherwise, which belong to the default engine
+	engine *Engine
+}
+
+// eng returns the engine the file belongs to
+func (p *MongoFile) eng() *Engine {
+	if p.engine != nil {
+		return p.engine
+	}
+	return engine
+}
+
+// decodeFile decodes the file of the given result, which then belongs to the
+// engine
+func (e *Engine) decodeFile(res *mongo.SingleResult, file *MongoFile) error {
+	err := res.Decode(file)
+	file.engine = e
+	return err
+}
+
+// decodeFiles decodes all files of the given cursor, which then belong to the
+// engine
+func (e *Engine) decodeFiles(ctx context.Context, cursor *mongo.Cursor, files *[]MongoFile) error {
+	err := cursor.All(ctx, files)
+	for i := range *files {
+		(*files)[i].engine = e
+	}
+	return err
+}
+
+// MarkdownMime is the mime type markdown files are stored with
+const MarkdownMime = "text/markdown; charset=utf-8"
+
+// NewMarkdownFile returns a markdown file with the given uri, size and
+// modification time, ready to be stored with Store; the file is rendered to a
+// page when requested.
+func (e *Engine) NewMarkdownFile(uri string, size int64, lastMod time.Time) MongoFile {
+	return MongoFile{URI: uri, Filesize: size, LastMod: lastMod, Mime: MarkdownMime, IsMD: true, engine: e}
+}
+
+// NewAsset returns a file with the given uri, mime type, size and modification
+// time, ready to be stored with Store; the file is served as is.
+func (e *Engine) NewAsset(uri string, mime string, size int64, lastMod time.Time) MongoFile {
+	return MongoFile{URI: uri, Filesize: size, LastMod: lastMod, Mime: mime, engine: e}
+}
+
+// Store reads the file's content from the given reader, writes it to the blob
+// store and writes the file's metadata to the database; the file's IsLocal
+// field is set to true. If an encryption key is set, the file's content is
+// encrypted. The front matter of markdown files is parsed into the file's Meta
+// field and the slug of public markdown files is derived from it; a replaced
+// slug is kept to redirect to the new one.
+//
+// Files stored before all content was kept in the blob store may still have
+// their content inline in the database; they are read as before until they
+// are moved by MoveInline.
+//
+// If the file already exists in the database, the previous file is overwritten.
+//
+// Assumes that the file's URI and Filesize fields are set and returns an error
+// otherwise.
+func (p *MongoFile) Store(reader io.Reader) error {
+	e := p.eng()
+	// check fields
+	if p.URI == "" || p.Filesize < 0 {
+		return errors.New("file's Filesize, URI or LastMod field is not set")
+	}
+	log.Println("Storing file content in blob store:", p.URI)
+	p.Content = primitive.Binary{}
+	var head *frontMatterBuffer
+	if p.IsMD {
+		head = &frontMatterBuffer{}
+		reader = io.TeeReader(reader, head)
+	}
+	err := p.storeBlob(reader)
+	if err != nil {
+		return err
+	}
+	p.Meta = nil
+	if head != nil {
+		p.Meta = p.parseFrontMatter(head.Bytes())
+	}
+	p.Encrypted = e.aead != nil
+	return p.write()
+}
+
+// write writes the file's metadata to the database after its content was
+// stored in the blob store; the content the previous file referred to is
+// removed from the blob store
+func (p *MongoFile) write() error {
+	e := p.eng()
+	var err error
+	p.Lang = ""
+	if p.IsMD {
+		_, p.Lang = e.SplitLanguage(p.URI)
+	}
+	p.Slug, p.OldSlugs = "", nil
+	if p.Public() {
+		p.Slug, err = p.uniqueSlug(p.slugPath())
+		if err != nil {
+			return err
+		}
+	}
+	log.Println("Writing file to database:", p.URI)
+	// set options to either insert or update the file; the previous file is
+	// returned to remove its replaced content from the blob store
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before).
+		SetProjection(bson.M{"is_local": 1, "uri": 1, "path": 1, "slug": 1})
+	// update the file in the database
+	update := bson.M{"$set": p}
+	if unset := p.unsetFields(); len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	if p.Status == "" && p.IsMD && e.defaultPageStatus != "" && !p.Quarantined && p.Staging == "" {
+		update["$setOnInsert"] = bson.M{"status": e.defaultPageStatus}
+	}
+	if p.Slug != "" {
+		update["$pull"] = bson.M{"old_slugs": p.Slug}
+	}
+	var old MongoFile
+	err = e.retry("writing file", p.URI, func() error {
+		return e.files.FindOneAndUpdate(e.ctx, bson.M{"name": p.URI}, update, opts).Decode(&old)
+	})
+	// check result
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		log.Println("Inserted file:", p.URI)
+		e.recordRevision(*p, false)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	log.Println("Updated file:", p.URI)
+	e.recordRevision(*p, false)
+	if old.Slug != "" && old.Slug != p.Slug {
+		err = e.keepSlug(p.URI, old.Slug)
+		if err != nil {
+			return err
+		}
+	}
+	if old.IsLocal && old.localPath() != p.localPath() {
+		e.removeLocal(old.localPath())
+	}
+	return nil
+}
+
+// storeBlob writes the content read from the given reader to the blob store
+// and sets the file's Path, Hash and IsLocal fields; the content is encrypted
+// if an encryption key is set
+func (p *MongoFile) storeBlob(reader io.Reader) error {
+	e := p.eng()
+	// contents are stored at a unique path, as promoted and previous files
+	// keep referring to the content they were stored with
+	p.Path = fmt.Sprintf("%s.%d", p.URI, time.Now().UnixNano())
+	// write the file's content
+	h := sha256.New()
+	err := e.blobs.Write(p.localPath(), func(w io.Writer) error {
+		if e.aead != nil {
+			return e.encryptStream(w, io.TeeReader(reader, h))
+		}
+		_, err := io.Copy(w, io.TeeReader(reader, h))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	p.Hash = hex.EncodeToString(h.Sum(nil))
+	p.IsLocal = true
+	return nil
+}
+
+// Open returns a reader for the file's content. If the file is stored locally,
+// the file's content is read from the blob store. Otherwise, the file was
+// stored inline before all content was kept in the blob store; its content is
+// read from the database and a bytes.Reader is returned. Encrypted content is
+// decrypted.
+func (p *MongoFile) Open() (io.ReadCloser, error) {
+	e := p.eng()
+	if p.IsLocal {
+		log.Println("Opening file from blob store:", p.URI)
+		f, err := e.blobs.Open(p.localPath())
+		if err != nil || !p.Encrypted {
+			return f, err
+		}
+		rc, err := e.newDecryptReader(f)
+		if err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+		return rc, nil
+	}
+	log.Println("Opening file from database:", p.URI)
+	opts := options.FindOne().SetProjection(bson.M{"content": 1, "encrypted": 1})
+	err := e.retry("reading file", p.URI, func() error {
+		return e.files.FindOne(e.ctx, bson.M{"uri": p.URI}, opts).Decode(p)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if p.Encrypted {
+		p.Content.Data, err = e.open(p.Content.Data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return io.NopCloser(bytes.NewReader(p.Content.Data)), nil
+}
+
+// ToPage parses the file's content as markdown and returns a Page. Returns an
+// error if the file was not flagged to be markdown. The file is fully read from
+// the database. If the file is stored locally, the file's content is read from
+// the blob store.
+func (p *MongoFile) ToPage() (Page, error) {
+	e := p.eng()
+	log.Println("Parsing file:", p.URI)
+	if !p.IsMD {
+		return Page{}, errors.New("file is not a markdown file")
+	}
+	err := e.retry("reading file", p.URI, func() error {
+		return e.files.FindOne(e.ctx, bson.M{"uri": p.URI}).Decode(p)
+	})
+	if err != nil {
+		return Page{}, err
+	}
+	if p.IsLocal {
+		log.Println("Reading file content from blob store:", p.URI)
+		f, err := p.Open()
+		if err != nil {
+			return Page{}, err
+		}
+		defer func() { _ = f.Close() }()
+		buf := bytes.Buffer{}
+		_, err = io.Copy(&buf, f)
+		if err != nil {
+			return Page{}, err
+		}
+		p.Content = primitive.Binary{Data: buf.Bytes()}
+	} else if p.Encrypted {
+		p.Content.Data, err = e.open(p.Content.Data)
+		if err != nil {
+			return Page{}, err
+		}
+	}
+	// due to a bug from the blackfriday package
+	// we need to convert Windows (CRLF) and Mac (CR) EOLs to UNIX (LF)
+	p.Content.Data = NormalizeEOL(p.Content.Data)
+	fm, body, err := SplitFrontMatter(p.Content.Data)
+	if err != nil {
+		log.Println("[Err] Invalid front matter:", p.URI, err)
+	}
+	// files stored before front matter was parsed have no metadata yet
+	if p.Meta == nil {
+		p.Meta = fm
+	}
+	return p.page(p.render(e.expandShortcodes(body)))
+}
+
+// PreviewPage renders the given markdown like ToPage renders the content of
+// the markdown file with the given uri; nothing is read from the database
+// except for the settings, and the rendering is not cached, so unsaved content
+// can be previewed. Invalid front matter is reported as error.
+func (e *Engine) PreviewPage(uri string, md []byte) (Page, error) {
+	p := e.NewMarkdownFile(uri, int64(len(md)), time.Now())
+	fm, body, err := SplitFrontMatter(md)
+	if err != nil {
+		return Page{}, err
+	}
+	p.Meta = fm
+	return p.page(e.renderTimed(uri, e.expandShortcodes(body)))
+}
+
+// page returns the page of the markdown file with the given rendered content
+func (p *MongoFile) page(html []byte) (Page, error) {
+	e := p.eng()
+	var base string
+	isIndex, err := path.Match("index.*", path.Base(p.Name()))
+	if err != nil {
+		return Page{}, err
+	}
+	if isIndex && p.languagePrefix() == "" {
+		base = path.Base(p.Name())
+	} else {
+		base = strings.TrimPrefix(p.Link(), "/")
+	}
+	settings, err := e.LoadSettings()
+	if err != nil {
+		return Page{}, err
+	}
+	menu, err := e.LoadMenu(p.Language())
+	if err != nil {
+		return Page{}, err
+	}
+	translations, err := p.Translations()
+	if err != nil {
+		return Page{}, err
+	}
+	page := Page{
+		Title:    p.Title(),
+		Content:  template.HTML(html),
+		LastMod:  p.LastMod,
+		Year:     time.Now().Year(),
+		Base:     base,
+		Root:     URIRoot,
+		Settings: settings,
+		Menu:     menu,
+	}
+	page.Lang, page.Translations = p.Language(), translations
+	if p.Meta != nil {
+		page.Description = p.Meta.Description
+		page.Tags = p.Meta.Tags
+		page.URL = p.Meta.URL
+		page.Template = p.Meta.Template
+		page.Date = p.Meta.Date
+	}
+	return page, nil
+}
+
+// Delete deletes the file from the database and blob store if it exists
+func (p *MongoFile) Delete() error {
+	err := p.delete(bson.M{"uri": p.URI})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	return err
+}
+
+// DeleteIfHash deletes the file from the database and blob store if its
+// content's hash is the given hash. Returns ErrHashMismatch if the file does
+// not exist or its content has a different hash.
+func (p *MongoFile) DeleteIfHash(hash string) error {
+	err := p.delete(bson.M{"uri": p.URI, "sha256": hash})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrHashMismatch
+	}
+	return err
+}
+
+// delete deletes the file matching the given filter from the database and file
+// system; returns mongo.ErrNoDocuments if no file matches
+func (p *MongoFile) delete(filter bson.M) error {
+	e := p.eng()
+	log.Println("Deleting file from database:", p.URI)
+	// we only need to know whether the file is local and whether its deletion
+	// is recorded as revision
+	opts := options.FindOneAndDelete().SetProjection(bson.M{"is_local": 1, "uri": 1, "path": 1, "is_md": 1,
+		"quarantined": 1, "staging": 1, "previous": 1})
+	start := time.Now()
+	err := e.files.FindOneAndDelete(e.ctx, filter, opts).Decode(p)
+	e.observeQuery("deleting file", p.URI, time.Since(start))
+	if err != nil {
+		return err
+	}
+	e.recordRevision(*p, true)
+	// delete file from blob store if it exists and is not kept for a rollback
+	// or a revision
+	if p.IsLocal {
+		e.removeLocal(p.localPath())
+	}
+	return nil
+}
+
+// ContentHash returns the hex encoded SHA-256 hash of the file's content; the
+// hash of files stored before hashes were recorded is computed from the
+// content and written to the database
+func (p *MongoFile) ContentHash() (string, error) {
+	e := p.eng()
+	if p.Hash != "" {
+		return p.Hash, nil
+	}
+	rc, err := p.Open()
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = rc.Close() }()
+	h := sha256.New()
+	_, err = io.Copy(h, rc)
+	if err != nil {
+		return "", err
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+	log.Println("Recording content hash:", p.URI)
+	// the filter ensures that the hash of a concurrently stored file is kept
+	filter := bson.M{"uri": p.URI, "last_mod": p.LastMod, "sha256": bson.M{"$exists": false}}
+	err = e.retry("recording content hash", p.URI, func() error {
+		_, err := e.files.UpdateOne(e.ctx, filter, bson.M{"$set": bson.M{"sha256": hash}})
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	p.Hash = hash
+	return hash, nil
+}
+
+// Public returns whether the file is served publicly, which is not the case
+// for quarantined, staged and previous files
+func (p *MongoFile) Public() bool {
+	return !p.Quarantined && p.Staging == "" && p.Previous == ""
+}
+
+// localPath returns the path in the blob store the file's content is stored at
+// if it is stored locally
+func (p *MongoFile) localPath() string {
+	if p.Path != "" {
+		return p.Path
+	}
+	return p.URI
+}
+
+/* Methods for implementing the os.FileInfo interface */
+
+// Name returns the file's uri or, if the file is a markdown file, the file's
+// uri with the extension changed to ".html"
+func (p *MongoFile) Name() string {
+	if p.IsMD {
+		return p.URI[:len(p.URI)-len(path.Ext(p.URI))] + ".html"
+	}
+	return p.URI
+}
+func (p *MongoFile) Size() int64        { return p.Filesize }
+func (p *MongoFile) Mode() os.FileMode  { return os.ModePerm }
+func (p *MongoFile) ModTime() time.Time { return p.LastMod }
+func (p *MongoFile) IsDir() bool        { return false }
+func (p *MongoFile) Sys() interface{}   { return nil }
+
+// GetFromDB returns the file with the given uri from the database. The file's
+// content is not read.
+func (e *Engine) GetFromDB(uri string) (MongoFile, error) {
+	log.Println("Getting file from database:", uri)
+	var file MongoFile
+	opts := options.FindOne().SetProjection(metaProjection)
+	err := e.retry("getting file", uri, func() error {
+		return e.decodeFile(e.files.FindOne(e.ctx, bson.M{"uri": uri}, opts), &file)
+	})
+	// if the file is not found and the file is a html file, we search for the file
+	// as a markdown file
+	if errors.Is(ErrNotFound, err) && path.Ext(uri) == ".html" {
+		uri = uri[:len(uri)-len(path.Ext(uri))] + ".md"
+		err = e.retry("getting file", uri, func() error {
+			return e.decodeFile(e.files.FindOne(e.ctx, bson.M{"uri": uri}, opts), &file)
+		})
+		if err != nil {
+			return MongoFile{}, err
+		}
+		file.URI = uri
+		file.IsMD = true
+	}
+	if err != nil {
+		return MongoFile{}, err
+	}
+	return file, nil
+}
+
+// ListAll lists all files in the database except for MongoFile.Content and
+// files which are not public
+func (e *Engine) ListAll() ([]MongoFile, error) {
+	opts := options.Find().SetProjection(metaProjection)
+	var files []MongoFile
+	err := e.retry("listing files", "", func() error {
+		cursor, err := e.files.Find(e.ctx, public(bson.M{}), opts)
+		if err != nil {
+			return err
+		}
+		return e.decodeFiles(e.ctx, cursor, &files)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// ListStale lists all markdown files in the database which were last modified
+// before the given time, oldest first, except for MongoFile.Content
+func (e *Engine) ListStale(before time.Time) ([]MongoFile, error) {
+	opts := options.Find().SetProjection(metaProjection).SetSort(bson.M{"last_mod": 1})
+	var files []MongoFile
+	err := e.retry("listing stale files", "", func() error {
+		cursor, err := e.files.Find(e.ctx, public(bson.M{"is_md": true, "last_mod": bson.M{"$lt": before}}), opts)
+		if err != nil {
+			return err
+		}
+		return e.decodeFiles(e.ctx, cursor, &files)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// NormalizeEOL will convert Windows (CRLF) and Mac (CR) EOLs to UNIX (LF)
+//
+// Taken from
+// https://github.com/go-gitea/gitea/blob/dc8036dcc680abab52b342d18181a5ee42f40318/modules/util/util.go#L68-L102
+func NormalizeEOL(input []byte) []byte {
+	var right, left, pos int
+	if right = bytes.IndexByte(input, '\r'); right == -1 {
+		return input
+	}
+	length := len(input)
+	tmp := make([]byte, length)
+
+	// We know that left < length because otherwise right would be -1 from IndexByte.
+	copy(tmp[pos:pos+right], input[left:left+right])
+	pos += right
+	tmp[pos] = '\n'
+	left += right + 1
+	pos++
+
+	for left < length {
+		if input[left] == '\n' {
+			left++
+		}
+
+		right = bytes.IndexByte(input[left:], '\r')
+		if right == -1 {
+			copy(tmp[pos:], input[left:])
+			pos += length - left
+			break
+		}
+		copy(tmp[pos:pos+right], input[left:left+right])
+		pos += right
+		tmp[pos] = '\n'
+		left += right + 1
+		pos++
+	}
+	return tmp[:pos]
+}
@@ -0,0 +1,68 @@
+package content
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"log"
+	"path"
+)
+
+var (
+	// ErrExists is returned if a file is moved to a uri another file is stored
+	// at
+	ErrExists = errors.New("a file is already stored at the target uri")
+	// ErrNotAnAsset is returned if a markdown file or a file which is not public
+	// is moved
+	ErrNotAnAsset = errors.New("only public assets can be moved")
+)
+
+// MoveAsset moves the public asset with the given uri to the given uri and
+// returns the moved file; the content stays where it is, the moved file refers
+// to it. Returns ErrNotFound if there is no such file, ErrNotAnAsset if the
+// file is a markdown file or not public and ErrExists if a file is stored at
+// the target uri.
+//
+// The file is moved in a single transaction if MongoDB runs as a replica set,
+// see transact.
+func (e *Engine) MoveAsset(uri string, dest string) (MongoFile, error) {
+	dest = path.Clean("/" + dest)
+	log.Println("Moving file:", uri, dest)
+	var f MongoFile
+	err := e.transact(func(ctx context.Context) error {
+		f = MongoFile{}
+		err := e.decodeFile(e.files.FindOne(ctx, bson.M{"uri": uri}), &f)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if f.IsMD || !f.Public() {
+			return ErrNotAnAsset
+		}
+		n, err := e.files.CountDocuments(ctx, bson.M{"$or": bson.A{bson.M{"uri": dest}, bson.M{"name": dest}}})
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			return ErrExists
+		}
+		if f.IsLocal {
+			f.Path = f.localPath()
+		}
+		f.URI = dest
+		err = e.replace(ctx, f)
+		if err != nil {
+			return err
+		}
+		_, err = e.files.DeleteOne(ctx, bson.M{"uri": uri})
+		return err
+	})
+	if err != nil {
+		return MongoFile{}, err
+	}
+	f.Content.Data = nil
+	return f, nil
+}
@@ -0,0 +1,54 @@
+package content
+
+import (
+	"html/template"
+	"io"
+	"log"
+	"time"
+)
+
+// Page is the representation of a page that is served to the client; the
+// description, tags, url, template and date are set by the front matter
+type Page struct {
+	Title       string
+	Content     template.HTML
+	LastMod     time.Time
+	Year        int
+	Base        string
+	Root        string
+	Settings    Settings
+	Menu        []MenuItem
+	Description string
+	Tags        []string
+	URL         string
+	Template    string
+	Date        time.Time
+	// Image is the URL of the page's social preview image; set by the server
+	// rendering the page
+	Image string
+	// Lang is the language of the page; Translations are the variants of the
+	// page in all languages, nil if there are no variants in other languages
+	Lang         string
+	Translations []Translation
+	// Comments are the approved comments of the page, oldest first; visitors
+	// may post comments if CommentsOpen is set
+	Comments     []Comment
+	CommentsOpen bool
+}
+
+// CreateHTML creates the HTML representation of the page using the given
+// template set and writes it to the given writer; the page is executed with
+// the template named by its front matter if the set defines it, else with the
+// template 'page'
+func (p *Page) CreateHTML(tmpl *template.Template, w io.Writer) error {
+	log.Println("Creating HTML for page:", p.Title)
+	name := "page"
+	if p.Template != "" {
+		if tmpl.Lookup(p.Template) != nil {
+			name = p.Template
+		} else {
+			log.Println("[Err] Unknown template of page:", p.Title, p.Template)
+		}
+	}
+	return tmpl.ExecuteTemplate(w, name, p)
+}
@@ -0,0 +1,39 @@
+package content
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// PostType is the type of markdown files which are blog posts rather than
+// static pages, set by the front matter:
+//
+//	---
+//	title: Hello world
+//	type: post
+//	date: 2024-03-01
+//	summary: The first post
+//	---
+const PostType = "post"
+
+// IsPost returns whether the file is a blog post
+func (p *MongoFile) IsPost() bool {
+	return p.IsMD && p.Meta != nil && p.Meta.Type == PostType
+}
+
+// ListPosts lists all public blog posts except for MongoFile.Content
+func (e *Engine) ListPosts() ([]MongoFile, error) {
+	opts := options.Find().SetProjection(metaProjection)
+	var files []MongoFile
+	err := e.retry("listing posts", "", func() error {
+		cursor, err := e.files.Find(e.ctx, public(bson.M{"is_md": true, "meta.type": PostType}), opts)
+		if err != nil {
+			return err
+		}
+		return e.decodeFiles(e.ctx, cursor, &files)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
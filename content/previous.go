@@ -0,0 +1,203 @@
+package content
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"path"
+)
+
+// PreviousRoot is the uri prefix the files replaced by the last promotion are
+// kept below
+const PreviousRoot = "/.previous"
+
+// kinds of previous files
+const (
+	// previousFile is a kept file
+	previousFile = "file"
+	// previousAbsent records that no file existed at the uri
+	previousAbsent = "absent"
+)
+
+// Rollback swaps the files replaced by the last promotion back in; files which
+// did not exist before the promotion are removed. The swapped out files are
+// kept in turn, so a rollback can be undone by another rollback. Returns the
+// uris of the swapped files or ErrNotFound if there is nothing to roll back.
+//
+// The files are swapped in a single transaction if MongoDB runs as a replica
+// set, see transact.
+func (e *Engine) Rollback() ([]string, error) {
+	log.Println("Rolling back the last promotion")
+	var uris []string
+	err := e.transact(func(ctx context.Context) error {
+		opts := options.Find().SetProjection(bson.M{"uri": 1})
+		cursor, err := e.files.Find(ctx, bson.M{"previous": bson.M{"$exists": true}}, opts)
+		if err != nil {
+			return err
+		}
+		var files []MongoFile
+		err = e.decodeFiles(ctx, cursor, &files)
+		if err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			return ErrNotFound
+		}
+		uris = nil
+		for _, f := range files {
+			uri := f.URI[len(PreviousRoot):]
+			err = e.swapPrevious(ctx, uri)
+			if err != nil {
+				return err
+			}
+			uris = append(uris, uri)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return uris, nil
+}
+
+// keepPrevious keeps the file with the given uri below PreviousRoot and
+// returns it; if no such file exists, its absence is recorded
+func (e *Engine) keepPrevious(ctx context.Context, uri string) (MongoFile, error) {
+	var cur MongoFile
+	err := e.decodeFile(e.files.FindOne(ctx, bson.M{"uri": uri}), &cur)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		kept := MongoFile{URI: path.Join(PreviousRoot, uri), Previous: previousAbsent, engine: e}
+		return kept, e.replace(ctx, kept)
+	}
+	if err != nil {
+		return MongoFile{}, err
+	}
+	// the content stays where it is, the kept file refers to it
+	if cur.IsLocal {
+		cur.Path = cur.localPath()
+	}
+	cur.URI = path.Join(PreviousRoot, uri)
+	cur.Previous = previousFile
+	return cur, e.replace(ctx, cur)
+}
+
+// swapPrevious swaps the file with the given uri with the file kept for it
+// below PreviousRoot
+func (e *Engine) swapPrevious(ctx context.Context, uri string) error {
+	var prev MongoFile
+	err := e.decodeFile(e.files.FindOne(ctx, bson.M{"uri": path.Join(PreviousRoot, uri)}), &prev)
+	if err != nil {
+		return err
+	}
+	_, err = e.keepPrevious(ctx, uri)
+	if err != nil {
+		return err
+	}
+	if prev.Previous == previousAbsent {
+		_, err = e.files.DeleteOne(ctx, bson.M{"uri": uri})
+		return err
+	}
+	prev.URI = uri
+	prev.Previous = ""
+	return e.replace(ctx, prev)
+}
+
+// discardPrevious deletes all files kept below PreviousRoot; returns the local
+// paths of their contents, which must be removed using removeLocal once the
+// surrounding transaction succeeded
+func (e *Engine) discardPrevious(ctx context.Context) ([]string, error) {
+	filter := bson.M{"previous": bson.M{"$exists": true}}
+	opts := options.Find().SetProjection(bson.M{"is_local": 1, "uri": 1, "path": 1})
+	cursor, err := e.files.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var files []MongoFile
+	err = e.decodeFiles(ctx, cursor, &files)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, f := range files {
+		if f.IsLocal {
+			paths = append(paths, f.localPath())
+		}
+	}
+	_, err = e.files.DeleteMany(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+// replace replaces the document of the file with the file's uri by the given
+// file or inserts it
+func (e *Engine) replace(ctx context.Context, f MongoFile) error {
+	data, err := bson.Marshal(f)
+	if err != nil {
+		return err
+	}
+	var doc bson.M
+	err = bson.Unmarshal(data, &doc)
+	if err != nil {
+		return err
+	}
+	// files are identified by their name when stored
+	doc["name"] = f.URI
+	_, err = e.files.ReplaceOne(ctx, bson.M{"name": f.URI}, doc, options.Replace().SetUpsert(true))
+	return err
+}
+
+// removeLocal removes the locally stored content at the given path from the
+// blob store unless a file or a revision still refers to it
+func (e *Engine) removeLocal(p string) {
+	n, err := e.blobReferences(p)
+	if err != nil {
+		log.Println("[Err] Checking references of file:", p, err)
+		return
+	}
+	if n > 0 {
+		return
+	}
+	log.Println("Deleting file from blob store:", p)
+	err = e.blobs.Remove(p)
+	if err != nil {
+		log.Println("[Err] Deleting file:", p, err)
+	}
+}
+
+// blobReferences returns the number of files and revisions referring to the
+// content at the given path of the blob store
+func (e *Engine) blobReferences(p string) (int64, error) {
+	n, err := e.files.CountDocuments(e.ctx, bson.M{"is_local": true, "$or": bson.A{
+		bson.M{"path": p},
+		bson.M{"uri": p, "path": bson.M{"$in": bson.A{"", nil}}},
+	}})
+	if err == nil && n == 0 {
+		n, err = e.revisionReferences(p)
+	}
+	return n, err
+}
+
+// transact calls the given function in a transaction; transactions require
+// MongoDB to run as a replica set, on a standalone server the function is
+// called without a transaction instead
+func (e *Engine) transact(fn func(ctx context.Context) error) error {
+	session, err := e.files.Database().Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(e.ctx)
+	_, err = session.WithTransaction(e.ctx, func(ctx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(ctx)
+	})
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Code == 20 { // IllegalOperation
+		log.Println("Transactions are not supported; running without transaction")
+		return fn(e.ctx)
+	}
+	return err
+}
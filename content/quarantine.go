@@ -0,0 +1,50 @@
+package content
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"path"
+	"strings"
+)
+
+// QuarantineRoot is the uri prefix quarantined files are stored below
+const QuarantineRoot = "/.quarantine"
+
+// public returns the given filter restricted to public files, see
+// MongoFile.Public
+func public(filter bson.M) bson.M {
+	filter["quarantined"] = bson.M{"$ne": true}
+	filter["staging"] = bson.M{"$exists": false}
+	filter["previous"] = bson.M{"$exists": false}
+	return filter
+}
+
+// QuarantineURI returns the uri a file with the given uri is stored at while
+// it is quarantined
+func QuarantineURI(uri string) string {
+	return path.Join(QuarantineRoot, uri)
+}
+
+// QuarantineTarget returns the uri the quarantined file with the given uri is
+// published at when it is approved
+func QuarantineTarget(uri string) string {
+	return strings.TrimPrefix(uri, QuarantineRoot)
+}
+
+// ListQuarantined lists all quarantined files, oldest first, except for
+// MongoFile.Content
+func (e *Engine) ListQuarantined() ([]MongoFile, error) {
+	opts := options.Find().SetProjection(metaProjection).SetSort(bson.M{"last_mod": 1})
+	files := []MongoFile{}
+	err := e.retry("listing quarantined files", "", func() error {
+		cursor, err := e.files.Find(e.ctx, bson.M{"quarantined": true}, opts)
+		if err != nil {
+			return err
+		}
+		return e.decodeFiles(e.ctx, cursor, &files)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
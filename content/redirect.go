@@ -0,0 +1,139 @@
+package content
+
+import (
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// ErrInvalidRedirect is returned if a redirect does not redirect an absolute
+// path to another path or to an http(s) URL, or if its status is not a
+// redirect status
+var ErrInvalidRedirect = errors.New("invalid redirect; must redirect an absolute path to another path or URL " +
+	"with status 301, 302, 307 or 308")
+
+// Redirect redirects requests for an old path, which is not found otherwise,
+// to a new path or URL
+type Redirect struct {
+	ID      primitive.ObjectID `bson:"_id" json:"id"`
+	From    string             `bson:"from" json:"from"`
+	To      string             `bson:"to" json:"to"`
+	Status  int                `bson:"status" json:"status"`
+	Created time.Time          `bson:"created" json:"created"`
+}
+
+// WithRedirectCollection sets the name of the collection redirects are stored
+// in; an empty name keeps the default 'redirects'
+func WithRedirectCollection(name string) Option {
+	return func(e *Engine) error {
+		if name != "" {
+			e.redirects = e.db.Collection(name)
+		}
+		return nil
+	}
+}
+
+// validRedirectStatus returns whether the given status is a redirect status
+func validRedirectStatus(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// validRedirectTarget returns whether the given target is an absolute path or
+// an http(s) URL
+func validRedirectTarget(to string) bool {
+	if strings.HasPrefix(to, "/") {
+		return !strings.HasPrefix(to, "//")
+	}
+	u, err := url.Parse(to)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// SetRedirect stores the given redirect, replacing the redirect of the same
+// path; the path is cleaned and the status defaults to 301. Returns the
+// stored redirect or ErrInvalidRedirect if the redirect is invalid.
+func (e *Engine) SetRedirect(r Redirect) (Redirect, error) {
+	if r.Status == 0 {
+		r.Status = http.StatusMovedPermanently
+	}
+	if !strings.HasPrefix(r.From, "/") || strings.ContainsAny(r.From, "?#") {
+		return Redirect{}, ErrInvalidRedirect
+	}
+	r.From = path.Clean(r.From)
+	if r.From == r.To || !validRedirectTarget(r.To) || !validRedirectStatus(r.Status) {
+		return Redirect{}, ErrInvalidRedirect
+	}
+	log.Println("Setting redirect:", r.From, r.To, r.Status)
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	update := bson.M{
+		"$set":         bson.M{"to": r.To, "status": r.Status},
+		"$setOnInsert": bson.M{"_id": primitive.NewObjectID(), "created": time.Now()},
+	}
+	var stored Redirect
+	err := e.retry("setting redirect", r.From, func() error {
+		return e.redirects.FindOneAndUpdate(e.ctx, bson.M{"from": r.From}, update, opts).Decode(&stored)
+	})
+	if err != nil {
+		return Redirect{}, err
+	}
+	return stored, nil
+}
+
+// GetRedirect returns the redirect of the given path. Returns ErrNotFound if
+// there is no such redirect.
+func (e *Engine) GetRedirect(from string) (Redirect, error) {
+	var r Redirect
+	err := e.retry("getting redirect", from, func() error {
+		return e.redirects.FindOne(e.ctx, bson.M{"from": from}).Decode(&r)
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Redirect{}, ErrNotFound
+	}
+	return r, err
+}
+
+// ListRedirects lists all redirects sorted by their path
+func (e *Engine) ListRedirects() ([]Redirect, error) {
+	opts := options.Find().SetSort(bson.M{"from": 1})
+	redirects := []Redirect{}
+	err := e.retry("listing redirects", "", func() error {
+		cursor, err := e.redirects.Find(e.ctx, bson.M{}, opts)
+		if err != nil {
+			return err
+		}
+		return cursor.All(e.ctx, &redirects)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return redirects, nil
+}
+
+// DeleteRedirect deletes the redirect with the given id and returns it.
+// Returns ErrNotFound if there is no such redirect.
+func (e *Engine) DeleteRedirect(id string) (Redirect, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return Redirect{}, ErrNotFound
+	}
+	log.Println("Deleting redirect:", id)
+	var r Redirect
+	err = e.retry("deleting redirect", id, func() error {
+		return e.redirects.FindOneAndDelete(e.ctx, bson.M{"_id": oid}).Decode(&r)
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Redirect{}, ErrNotFound
+	}
+	return r, err
+}
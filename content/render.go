@@ -0,0 +1,95 @@
+package content
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/russross/blackfriday/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log"
+)
+
+// Renderer converts markdown to HTML. The name identifies the renderer and its
+// configuration; it is part of the key of cached renderings, so a different
+// renderer never reuses HTML rendered by another.
+type Renderer interface {
+	Name() string
+	Render(md []byte) []byte
+}
+
+// blackfridayRenderer renders markdown using blackfriday's default options
+type blackfridayRenderer struct{}
+
+func (blackfridayRenderer) Name() string            { return "blackfriday/v2" }
+func (blackfridayRenderer) Render(md []byte) []byte { return blackfriday.Run(md) }
+
+// metaProjection excludes the file's content and cached rendering, so only the
+// file's metadata is read from the database
+var metaProjection = bson.M{"content": 0, "rendered": 0}
+
+// renderKey returns the cache key for the rendering of the given markdown by
+// the engine's renderer and sanitize policy; renderings cached with and
+// without encryption have different keys
+func (e *Engine) renderKey(md []byte) string {
+	h := sha256.New()
+	h.Write([]byte(e.renderer.Name()))
+	h.Write([]byte{0})
+	h.Write([]byte(e.policy.Name + policyRevision))
+	h.Write([]byte{0})
+	if e.aead != nil {
+		h.Write([]byte("encrypted"))
+		h.Write([]byte{0})
+	}
+	h.Write(md)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// render returns the sanitized HTML for the given markdown of the file; if the
+// file's cached rendering was created from the same markdown by the same
+// renderer and policy, it is reused, else the markdown is rendered and the
+// cache is updated lazily. The cached rendering is encrypted like the file's
+// content.
+func (p *MongoFile) render(md []byte) []byte {
+	e := p.eng()
+	key := e.renderKey(md)
+	if p.RenderKey == key && p.Rendered.Data != nil {
+		html := p.Rendered.Data
+		var err error
+		if e.aead != nil {
+			html, err = e.open(html)
+		}
+		if err == nil {
+			log.Println("Using cached rendering:", p.URI)
+			return html
+		}
+		log.Println("[Err] Decrypting cached rendering failed:", p.URI, err)
+	}
+	log.Println("Rendering markdown:", p.URI)
+	html := e.renderTimed(p.URI, md)
+	cached := html
+	if e.aead != nil {
+		var err error
+		cached, err = e.seal(html)
+		if err != nil {
+			log.Println("[Err] Encrypting rendering failed:", p.URI, err)
+			return html
+		}
+	}
+	p.RenderKey = key
+	p.Rendered = primitive.Binary{Data: cached}
+	// renderings pushing the document over the safe size are not cached
+	if p.documentSize() > safeDocumentSize {
+		log.Println("Rendering is too large to be cached:", p.URI)
+		return html
+	}
+	update := bson.M{"$set": bson.M{"render_key": p.RenderKey, "rendered": p.Rendered}}
+	err := e.retry("caching rendering", p.URI, func() error {
+		_, err := e.files.UpdateOne(e.ctx, bson.M{"uri": p.URI}, update)
+		return err
+	})
+	if err != nil {
+		// the rendering is still valid, only caching failed
+		log.Println("[Err] Caching rendering failed:", p.URI, err)
+	}
+	return html
+}
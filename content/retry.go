@@ -0,0 +1,80 @@
+package content
+
+import (
+	"errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"log"
+	"math/rand"
+	"time"
+)
+
+// maxRetryBackoff is the maximum delay between two attempts of an operation
+const maxRetryBackoff = 5 * time.Second
+
+// transientCodes are the codes of server errors caused by elections and
+// shutdowns of replica set members, which are resolved once a new primary is
+// elected
+var transientCodes = []int{
+	6,     // HostUnreachable
+	7,     // HostNotFound
+	89,    // NetworkTimeout
+	91,    // ShutdownInProgress
+	189,   // PrimarySteppedDown
+	9001,  // SocketException
+	10107, // NotWritablePrimary
+	11600, // InterruptedAtShutdown
+	11602, // InterruptedDueToReplStateChange
+	13435, // NotPrimaryNoSecondaryOk
+	13436, // NotPrimaryOrSecondary
+}
+
+// IsTransient returns whether the given error is transient, like network
+// errors and errors caused by a stepdown of the primary, so retrying the
+// operation may succeed
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	if mongo.IsNetworkError(err) {
+		return true
+	}
+	var se mongo.ServerError
+	if !errors.As(err, &se) {
+		return false
+	}
+	if se.HasErrorLabel("RetryableWriteError") || se.HasErrorLabel("TransientTransactionError") {
+		return true
+	}
+	for _, code := range transientCodes {
+		if se.HasErrorCode(code) {
+			return true
+		}
+	}
+	return false
+}
+
+// retry calls the given idempotent operation on the given subject, like the
+// uri of a file, until it succeeds, fails with an error which is not transient
+// or all attempts failed; attempts are delayed by an exponential backoff with
+// full jitter, so instances do not retry in lockstep. Slow attempts are
+// recorded.
+func (e *Engine) retry(name string, subject string, op func() error) error {
+	attempt := func() error {
+		start := time.Now()
+		err := op()
+		e.observeQuery(name, subject, time.Since(start))
+		return err
+	}
+	err := attempt()
+	for i := 1; i < e.retryAttempts && IsTransient(err); i++ {
+		delay := time.Duration(rand.Int63n(int64(min(e.retryBackoff<<i, maxRetryBackoff)) + 1))
+		log.Println("[Err] Transient database error; retrying", name, subject, "in", delay.Round(time.Millisecond), ":", err)
+		select {
+		case <-time.After(delay):
+		case <-e.ctx.Done():
+			return err
+		}
+		err = attempt()
+	}
+	return err
+}
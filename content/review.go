@@ -0,0 +1,73 @@
+package content
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"time"
+)
+
+// ReviewComment is a comment of a reviewer on a file submitted for review;
+// comments with a line refer to the line of the file's markdown
+type ReviewComment struct {
+	Author  string    `bson:"author" json:"author"`
+	Line    int       `bson:"line,omitempty" json:"line,omitempty"`
+	Text    string    `bson:"text" json:"text"`
+	Created time.Time `bson:"created" json:"created"`
+}
+
+// ListInReview lists all public files submitted for review, oldest first,
+// except for MongoFile.Content
+func (e *Engine) ListInReview() ([]MongoFile, error) {
+	opts := options.Find().SetProjection(metaProjection).SetSort(bson.M{"last_mod": 1})
+	files := []MongoFile{}
+	err := e.retry("listing files in review", "", func() error {
+		cursor, err := e.files.Find(e.ctx, public(bson.M{"status": StatusReview}), opts)
+		if err != nil {
+			return err
+		}
+		return e.decodeFiles(e.ctx, cursor, &files)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// AddReviewComments appends the given comments to the review comments of the
+// file with the given uri. Returns ErrNotFound if there is no such file.
+func (e *Engine) AddReviewComments(uri string, comments []ReviewComment) error {
+	log.Println("Adding review comments to file:", uri, len(comments))
+	var res *mongo.UpdateResult
+	err := e.retry("adding review comments", uri, func() (err error) {
+		res, err = e.files.UpdateOne(e.ctx, bson.M{"uri": uri},
+			bson.M{"$push": bson.M{"review": bson.M{"$each": comments}}})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ClearReviewComments removes the review comments of the file with the given
+// uri once its review is done. Returns ErrNotFound if there is no such file.
+func (e *Engine) ClearReviewComments(uri string) error {
+	log.Println("Clearing review comments of file:", uri)
+	var res *mongo.UpdateResult
+	err := e.retry("clearing review comments", uri, func() (err error) {
+		res, err = e.files.UpdateOne(e.ctx, bson.M{"uri": uri}, bson.M{"$unset": bson.M{"review": ""}})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package content
+
+import (
+	"bytes"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"io"
+	"log"
+	"path"
+	"strings"
+	"time"
+)
+
+// Revision is a version of a markdown file recorded when the file is stored;
+// the deletion of the file is recorded as revision without file. The content
+// of a revision is kept in the blob store as long as the revision exists.
+type Revision struct {
+	ID    primitive.ObjectID `bson:"_id" json:"id"`
+	URI   string             `bson:"uri" json:"uri"`
+	Saved time.Time          `bson:"saved" json:"saved"`
+	// Deleted is set for revisions recording the deletion of the file
+	Deleted bool       `bson:"deleted,omitempty" json:"deleted,omitempty"`
+	File    *MongoFile `bson:"file,omitempty" json:"file,omitempty"`
+}
+
+// WithRevisions enables the revision history of markdown files, which is
+// stored in the collection with the given name; an empty name disables the
+// history. Contents replaced or deleted are kept for the revisions, so the
+// history grows with every upload.
+func WithRevisions(name string) Option {
+	return func(e *Engine) error {
+		e.revisions = nil
+		if name != "" {
+			e.revisions = e.db.Collection(name)
+		}
+		return nil
+	}
+}
+
+// recordRevision records the given markdown file as revision or, if deleted
+// is set, the deletion of the file with the given uri; files which are not
+// public and other files are not recorded. Failures are logged, as the file
+// itself was stored.
+func (e *Engine) recordRevision(p MongoFile, deleted bool) {
+	if e.revisions == nil || !p.IsMD || !p.Public() {
+		return
+	}
+	r := Revision{ID: primitive.NewObjectID(), URI: p.URI, Saved: time.Now(), Deleted: deleted}
+	if !deleted {
+		p.RenderKey, p.Rendered = "", primitive.Binary{}
+		r.File = &p
+	}
+	log.Println("Recording revision of file:", p.URI, deleted)
+	err := e.retry("recording revision", p.URI, func() error {
+		_, err := e.revisions.InsertOne(e.ctx, r)
+		if mongo.IsDuplicateKeyError(err) {
+			// the revision was inserted by a failed attempt
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		log.Println("[Err] Recording revision failed:", p.URI, err)
+	}
+}
+
+// revisionReferences returns the number of revisions whose content is stored
+// at the given path of the blob store
+func (e *Engine) revisionReferences(p string) (int64, error) {
+	if e.revisions == nil {
+		return 0, nil
+	}
+	return e.revisions.CountDocuments(e.ctx, bson.M{"file.path": p})
+}
+
+// ListRevisions lists the revisions of the file with the given uri, newest
+// first
+func (e *Engine) ListRevisions(uri string) ([]Revision, error) {
+	revisions := []Revision{}
+	if e.revisions == nil {
+		return revisions, nil
+	}
+	opts := options.Find().SetSort(bson.M{"saved": -1})
+	err := e.retry("listing revisions", uri, func() error {
+		cursor, err := e.revisions.Find(e.ctx, bson.M{"uri": uri}, opts)
+		if err != nil {
+			return err
+		}
+		return cursor.All(e.ctx, &revisions)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+// revisionAt returns the last revision of the file with the given uri
+// recorded until the given time
+func (e *Engine) revisionAt(uri string, at time.Time) (Revision, error) {
+	opts := options.FindOne().SetSort(bson.M{"saved": -1})
+	var r Revision
+	err := e.retry("getting revision", uri, func() error {
+		return e.revisions.FindOne(e.ctx, bson.M{"uri": uri, "saved": bson.M{"$lte": at}}, opts).Decode(&r)
+	})
+	return r, err
+}
+
+// GetAt returns the public markdown file with the given uri as it was at the
+// given time; the uri has either the extension '.md' or '.html' or is the
+// slug of the file at the time. Files without revisions until the time are
+// returned as they are if they were not modified since. Returns ErrNotFound
+// if the file did not exist at the time or its version is unknown.
+func (e *Engine) GetAt(uri string, at time.Time) (MongoFile, error) {
+	uri = strings.TrimSuffix(uri, path.Ext(uri)) + ".md"
+	if e.revisions != nil {
+		r, err := e.revisionAt(uri, at)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			r, err = e.revisionBySlugAt(strings.TrimSuffix(uri, ".md"), at)
+		}
+		if err == nil {
+			if r.Deleted || r.File == nil {
+				return MongoFile{}, ErrNotFound
+			}
+			f := *r.File
+			f.engine = e
+			return f, nil
+		}
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			return MongoFile{}, err
+		}
+	}
+	f, err := e.GetFromDB(uri)
+	if err == nil && (!f.IsMD || !f.Public() || f.LastMod.After(at)) {
+		err = ErrNotFound
+	}
+	if err != nil {
+		return MongoFile{}, err
+	}
+	return f, nil
+}
+
+// revisionBySlugAt returns the last revision of the file which had the given
+// slug at the given time; the revision must be the last revision of its file
+// until the time, so slugs taken over by another file are not followed
+func (e *Engine) revisionBySlugAt(slug string, at time.Time) (Revision, error) {
+	opts := options.FindOne().SetSort(bson.M{"saved": -1})
+	var r Revision
+	err := e.retry("getting revision by slug", slug, func() error {
+		filter := bson.M{"file.slug": strings.TrimPrefix(slug, "/"), "saved": bson.M{"$lte": at}}
+		return e.revisions.FindOne(e.ctx, filter, opts).Decode(&r)
+	})
+	if err != nil {
+		return Revision{}, err
+	}
+	last, err := e.revisionAt(r.URI, at)
+	if err != nil {
+		return Revision{}, err
+	}
+	if last.ID != r.ID {
+		return Revision{}, mongo.ErrNoDocuments
+	}
+	return last, nil
+}
+
+// RevisionPage renders the file returned by GetAt like ToPage; the content is
+// read from the blob store, so the version of the file is rendered rather
+// than the current file, and the rendering is not cached
+func (p *MongoFile) RevisionPage() (Page, error) {
+	e := p.eng()
+	if !p.IsLocal {
+		return p.ToPage()
+	}
+	log.Println("Rendering revision of file:", p.URI)
+	rc, err := p.Open()
+	if err != nil {
+		return Page{}, err
+	}
+	defer func() { _ = rc.Close() }()
+	buf := bytes.Buffer{}
+	_, err = io.Copy(&buf, rc)
+	if err != nil {
+		return Page{}, err
+	}
+	fm, body, err := SplitFrontMatter(NormalizeEOL(buf.Bytes()))
+	if err != nil {
+		log.Println("[Err] Invalid front matter:", p.URI, err)
+	}
+	if p.Meta == nil {
+		p.Meta = fm
+	}
+	return p.page(e.renderTimed(p.URI, e.expandShortcodes(body)))
+}
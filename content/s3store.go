@@ -0,0 +1,250 @@
+package content
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// amzDateFormat is the format of the timestamps of signed requests
+	amzDateFormat = "20060102T150405Z"
+	// unsignedPayload replaces the hash of the payload of signed requests, so
+	// the content does not have to be read twice
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// S3Store stores content as objects of a bucket of an S3 compatible object
+// storage; the path of the content is used as key of the object. Requests are
+// signed with AWS Signature Version 4 and use path-style URLs, so any S3
+// compatible service may be used. Objects are limited to 5 GiB, the maximum
+// size of a single upload.
+type S3Store struct {
+	// Endpoint is the URL of the service, e.g. https://s3.eu-central-1.amazonaws.com
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// Client sends the requests; http.DefaultClient is used if nil
+	Client *http.Client
+}
+
+func (s S3Store) Name() string { return "s3:" + s.Bucket }
+
+func (s S3Store) Write(p string, write func(w io.Writer) error) error {
+	// the size of an object must be known before it is uploaded, so the
+	// content is buffered in a temporary file
+	tmp, err := os.CreateTemp("", "s3-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
+	err = write(tmp)
+	if err != nil {
+		return err
+	}
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	res, err := s.do(http.MethodPut, p, io.NopCloser(tmp), size)
+	if err != nil {
+		return err
+	}
+	return s.check(res, p)
+}
+
+func (s S3Store) Open(p string) (io.ReadCloser, error) {
+	res, err := s.do(http.MethodGet, p, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	switch res.StatusCode {
+	case http.StatusOK:
+		return res.Body, nil
+	case http.StatusNotFound:
+		_ = res.Body.Close()
+		return nil, fmt.Errorf("%w: %s", os.ErrNotExist, p)
+	}
+	return nil, s.check(res, p)
+}
+
+func (s S3Store) Remove(p string) error {
+	res, err := s.do(http.MethodDelete, p, nil, 0)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode == http.StatusNotFound {
+		_ = res.Body.Close()
+		return nil
+	}
+	return s.check(res, p)
+}
+
+// PresignPut returns a URL the content at the given path can be uploaded to
+// with a PUT request without credentials until the URL expires after the given
+// duration, which must not exceed seven days
+func (s S3Store) PresignPut(p string, expires time.Duration) (string, error) {
+	if expires < time.Second || expires > 7*24*time.Hour {
+		return "", errors.New("expiry of pre-signed URL must be between one second and seven days")
+	}
+	u, err := s.objectURL(p)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now().UTC()
+	u.RawQuery = canonicalQuery(url.Values{
+		"X-Amz-Algorithm":     {"AWS4-HMAC-SHA256"},
+		"X-Amz-Credential":    {s.AccessKey + "/" + s.scope(now)},
+		"X-Amz-Date":          {now.Format(amzDateFormat)},
+		"X-Amz-Expires":       {strconv.Itoa(int(expires.Seconds()))},
+		"X-Amz-SignedHeaders": {"host"},
+	})
+	_, sig := s.signature(now, http.MethodPut, u, map[string]string{"host": u.Host})
+	u.RawQuery += "&X-Amz-Signature=" + sig
+	return u.String(), nil
+}
+
+// do sends a signed request with the given method and body of the given size
+// for the object at the given path
+func (s S3Store) do(method string, p string, body io.Reader, size int64) (*http.Response, error) {
+	u, err := s.objectURL(p)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	now := time.Now().UTC()
+	header := map[string]string{
+		"host":                 u.Host,
+		"x-amz-content-sha256": unsignedPayload,
+		"x-amz-date":           now.Format(amzDateFormat),
+	}
+	req.Header.Set("X-Amz-Content-Sha256", header["x-amz-content-sha256"])
+	req.Header.Set("X-Amz-Date", header["x-amz-date"])
+	signed, sig := s.signature(now, method, u, header)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, s.scope(now), signed, sig))
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req)
+}
+
+// check closes the body of the given response and returns an error containing
+// the message of the service if the request for the object at the given path
+// failed
+func (s S3Store) check(res *http.Response, p string) error {
+	defer func() { _ = res.Body.Close() }()
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+	return fmt.Errorf("s3 request for %s failed: %s: %s", p, res.Status, strings.TrimSpace(string(msg)))
+}
+
+// objectURL returns the path-style URL of the object at the given path
+func (s S3Store) objectURL(p string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSuffix(s.Endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, errors.New("invalid s3 endpoint: " + s.Endpoint)
+	}
+	u.Path += "/" + s.Bucket + "/" + strings.TrimPrefix(p, "/")
+	u.RawPath = s3Escape(u.Path, true)
+	return u, nil
+}
+
+// scope returns the credential scope of requests signed at the given time
+func (s S3Store) scope(t time.Time) string {
+	return t.Format("20060102") + "/" + s.Region + "/s3/aws4_request"
+}
+
+// signature returns the signed headers and the signature of a request signed
+// at the given time with the given method and URL, whose query must be in
+// canonical form, and the given headers with lower case names
+func (s S3Store) signature(t time.Time, method string, u *url.URL, header map[string]string) (string, string) {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonical strings.Builder
+	fmt.Fprintf(&canonical, "%s\n%s\n%s\n", method, u.EscapedPath(), u.RawQuery)
+	for _, name := range names {
+		fmt.Fprintf(&canonical, "%s:%s\n", name, strings.TrimSpace(header[name]))
+	}
+	signed := strings.Join(names, ";")
+	fmt.Fprintf(&canonical, "\n%s\n%s", signed, unsignedPayload)
+	hash := sha256.Sum256([]byte(canonical.String()))
+	toSign := "AWS4-HMAC-SHA256\n" + t.Format(amzDateFormat) + "\n" + s.scope(t) + "\n" + hex.EncodeToString(hash[:])
+	key := []byte("AWS4" + s.SecretKey)
+	for _, part := range []string{t.Format("20060102"), s.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	return signed, hex.EncodeToString(hmacSHA256(key, toSign))
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of the given data with the given key
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalQuery returns the given query in the canonical form of signed
+// requests, sorted by keys and escaped by s3Escape
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		for _, v := range q[k] {
+			parts = append(parts, s3Escape(k, false)+"="+s3Escape(v, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Escape escapes all but the unreserved characters of the given string as
+// required for signed requests; slashes are kept if the string is a path
+func s3Escape(s string, isPath bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', isPath && c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,211 @@
+package content
+
+import (
+	"bytes"
+	"golang.org/x/net/html"
+	"net/url"
+	"strings"
+)
+
+// Policy is a sanitization policy for rendered markdown; it lists the allowed
+// elements with their allowed attributes. Elements that are not allowed are
+// removed while their content is kept, except for the elements in
+// droppedElements, which are removed including their content.
+type Policy struct {
+	Name string
+	// elements maps the allowed elements to their allowed attributes
+	elements map[string][]string
+	// global are the attributes allowed on all allowed elements
+	global []string
+}
+
+// droppedElements are removed including their content if they are not allowed
+var droppedElements = map[string]bool{
+	"script": true, "style": true, "iframe": true, "object": true, "embed": true,
+	"noscript": true, "template": true, "textarea": true, "select": true,
+	"title": true, "svg": true, "math": true, "frameset": true, "applet": true,
+}
+
+// urlAttributes are the attributes whose values are URLs, which must be
+// relative or use a safe scheme
+var urlAttributes = map[string]bool{"href": true, "src": true, "cite": true, "poster": true}
+
+// basicElements are the elements blackfriday produces
+var basicElements = map[string][]string{
+	"p": nil, "br": nil, "hr": nil, "h1": nil, "h2": nil, "h3": nil, "h4": nil, "h5": nil, "h6": nil,
+	"blockquote": {"cite"}, "pre": nil, "code": nil, "em": nil, "strong": nil, "del": nil,
+	"ul": nil, "ol": {"start"}, "li": nil, "a": {"href"}, "img": {"src", "alt"},
+	"table": nil, "thead": nil, "tbody": nil, "tr": nil, "th": {"align"}, "td": {"align"},
+}
+
+// policyRevision is part of the cache key of renderings; it is changed
+// whenever the policies change, so renderings sanitized by an older policy
+// are not served anymore
+const policyRevision = "2"
+
+var (
+	// StrictPolicy only allows the elements produced by markdown and titles
+	StrictPolicy = &Policy{Name: "strict", elements: basicElements, global: []string{"title"}}
+	// RelaxedPolicy additionally allows common inline HTML of hand-written
+	// pages like figures, media and HTTPS iframes, and class and id attributes
+	RelaxedPolicy = &Policy{Name: "relaxed", elements: merge(basicElements, map[string][]string{
+		"a":   {"href", "target"},
+		"img": {"src", "alt", "width", "height", "loading"},
+		"div": nil, "span": nil, "section": nil, "figure": nil, "figcaption": nil,
+		"details": {"open"}, "summary": nil, "sup": nil, "sub": nil, "kbd": nil, "mark": nil,
+		"abbr": nil, "dl": nil, "dt": nil, "dd": nil, "caption": nil, "colgroup": nil, "col": {"span"},
+		"video":  {"src", "controls", "width", "height", "poster", "loop", "muted", "playsinline"},
+		"audio":  {"src", "controls", "loop", "muted"},
+		"source": {"src", "type"},
+		"iframe": {"src", "width", "height", "allow", "allowfullscreen", "loading"},
+	}), global: []string{"title", "id", "class", "lang", "dir"}}
+)
+
+// merge returns the union of the given element maps; attributes of elements in
+// b replace those in a
+func merge(a map[string][]string, b map[string][]string) map[string][]string {
+	m := make(map[string][]string, len(a)+len(b))
+	for k, v := range a {
+		m[k] = v
+	}
+	for k, v := range b {
+		m[k] = v
+	}
+	return m
+}
+
+// Sanitize removes all elements and attributes from the given HTML that are
+// not allowed by the policy; comments and doctypes are removed as well
+func (p *Policy) Sanitize(data []byte) []byte {
+	z := html.NewTokenizer(bytes.NewReader(data))
+	buf := bytes.Buffer{}
+	// skip is the name and depth of the currently dropped element
+	skip, depth := "", 0
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return buf.Bytes()
+		}
+		t := z.Token()
+		if skip != "" {
+			switch {
+			case tt == html.StartTagToken && t.Data == skip:
+				depth++
+			case tt == html.EndTagToken && t.Data == skip:
+				depth--
+			}
+			if depth == 0 {
+				skip = ""
+			}
+			continue
+		}
+		switch tt {
+		case html.TextToken:
+			buf.WriteString(html.EscapeString(t.Data))
+		case html.StartTagToken, html.SelfClosingTagToken:
+			attrs, ok := p.allowed(t)
+			if !ok {
+				if droppedElements[t.Data] && tt == html.StartTagToken {
+					skip, depth = t.Data, 1
+				}
+				continue
+			}
+			t.Attr = attrs
+			buf.WriteString(t.String())
+		case html.EndTagToken:
+			if _, ok := p.elements[t.Data]; ok {
+				buf.WriteString(t.String())
+			}
+		}
+	}
+}
+
+// allowed returns the allowed attributes of the given tag; returns false if
+// the element is not allowed
+func (p *Policy) allowed(t html.Token) ([]html.Attribute, bool) {
+	names, ok := p.elements[t.Data]
+	if !ok {
+		return nil, false
+	}
+	var attrs []html.Attribute
+	blank := false
+	for _, a := range t.Attr {
+		if a.Namespace != "" || !(contains(names, a.Key) || contains(p.global, a.Key)) {
+			continue
+		}
+		if urlAttributes[a.Key] && !safeURL(t.Data, a.Val) {
+			continue
+		}
+		if a.Key == "target" {
+			blank = a.Val == "_blank"
+		}
+		attrs = append(attrs, a)
+	}
+	// iframes are only kept with a safe source and are always sandboxed; the
+	// framed document gets an opaque origin, as scripts of a document of this
+	// site's origin could remove the sandbox otherwise
+	if t.Data == "iframe" {
+		if !hasAttr(attrs, "src") {
+			return nil, false
+		}
+		attrs = append(attrs, html.Attribute{Key: "sandbox", Val: "allow-scripts allow-popups"})
+	}
+	if blank {
+		attrs = append(attrs, html.Attribute{Key: "rel", Val: "noopener noreferrer"})
+	}
+	return attrs, true
+}
+
+// safeURL returns whether the given URL of an attribute of the given element is
+// relative or uses a safe scheme; iframes must use absolute HTTPS URLs and only
+// raster images may be embedded as data URLs
+func safeURL(element string, v string) bool {
+	u, err := url.Parse(strings.TrimSpace(v))
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "":
+		return element != "iframe"
+	case "https":
+		return true
+	case "http":
+		return element != "iframe"
+	case "mailto":
+		return element == "a"
+	case "data":
+		return element == "img" && rasterData(u.Opaque)
+	}
+	return false
+}
+
+// rasterData returns whether the given opaque part of a data URL is a raster
+// image
+func rasterData(opaque string) bool {
+	for _, prefix := range []string{"image/png", "image/jpeg", "image/gif", "image/webp"} {
+		if strings.HasPrefix(strings.ToLower(opaque), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// contains returns whether the given list contains the given string
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// hasAttr returns whether the given attributes contain one with the given key
+func hasAttr(attrs []html.Attribute, key string) bool {
+	for _, a := range attrs {
+		if a.Key == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package content
+
+import (
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"log"
+	"time"
+)
+
+// ErrInvalidSchedule is returned if a file would be unpublished before it is
+// published
+var ErrInvalidSchedule = errors.New("unpublish_at must be after publish_at")
+
+// SetSchedule sets the times the file with the given uri is published and
+// unpublished at; a zero time removes the schedule. A file scheduled to be
+// published in the future is set to draft until then. Returns ErrNotFound if
+// there is no such file.
+func (e *Engine) SetSchedule(uri string, publishAt, unpublishAt time.Time) error {
+	if !publishAt.IsZero() && !unpublishAt.IsZero() && !unpublishAt.After(publishAt) {
+		return ErrInvalidSchedule
+	}
+	log.Println("Setting schedule of file:", uri, publishAt, unpublishAt)
+	set, unset := bson.M{}, bson.M{}
+	if publishAt.IsZero() {
+		unset["publish_at"] = ""
+	} else {
+		set["publish_at"] = publishAt
+		if publishAt.After(time.Now()) {
+			set["status"] = StatusDraft
+		}
+	}
+	if unpublishAt.IsZero() {
+		unset["unpublish_at"] = ""
+	} else {
+		set["unpublish_at"] = unpublishAt
+	}
+	update := bson.M{}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	var res *mongo.UpdateResult
+	err := e.retry("setting schedule", uri, func() (err error) {
+		res, err = e.files.UpdateOne(e.ctx, bson.M{"uri": uri}, update)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ApplySchedule publishes the files whose publication time passed and
+// archives the files whose unpublication time passed; the applied time is
+// removed from the file. Returns the uris of the changed files.
+func (e *Engine) ApplySchedule(now time.Time) ([]string, error) {
+	published, err := e.applySchedule("publish_at", StatusPublished, now)
+	if err != nil {
+		return published, err
+	}
+	archived, err := e.applySchedule("unpublish_at", StatusArchived, now)
+	return append(published, archived...), err
+}
+
+// applySchedule sets the given status for the files whose time in the given
+// field passed and removes the field
+func (e *Engine) applySchedule(field string, status string, now time.Time) ([]string, error) {
+	due := bson.M{field: bson.M{"$lte": now}}
+	uris, err := e.listURIs("listing scheduled files", due)
+	if err != nil {
+		return nil, err
+	}
+	changed := []string{}
+	for _, uri := range uris {
+		log.Println("Applying schedule of file:", uri, status)
+		var res *mongo.UpdateResult
+		// the time is checked again, as the schedule may have changed meanwhile
+		err = e.retry("applying schedule", uri, func() (err error) {
+			res, err = e.files.UpdateOne(e.ctx, bson.M{"uri": uri, field: bson.M{"$lte": now}},
+				bson.M{"$set": bson.M{"status": status}, "$unset": bson.M{field: ""}})
+			return err
+		})
+		if err != nil {
+			return changed, err
+		}
+		if res.ModifiedCount > 0 {
+			changed = append(changed, uri)
+		}
+	}
+	return changed, nil
+}
@@ -0,0 +1,170 @@
+package content
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"net/url"
+	"regexp"
+	"slices"
+)
+
+// ErrWatchUnsupported is returned by WatchSettings if the database does not
+// support change streams, which require a replica set
+var ErrWatchUnsupported = errors.New("change streams are not supported by the database")
+
+// settingsID is the id of the single settings document
+const settingsID = "site"
+
+// analyticsRegexp matches measurement ids of the analytics service like
+// 'G-XXXXXXXXXX'
+var analyticsRegexp = regexp.MustCompile(`^[A-Z]{1,3}-[A-Z0-9-]{4,32}$`)
+
+// Link is a labeled link rendered by the templates
+type Link struct {
+	Label string `bson:"label" json:"label" binding:"required"`
+	URL   string `bson:"url" json:"url" binding:"required"`
+}
+
+// FooterColumn is a titled column of links rendered in the footer
+type FooterColumn struct {
+	Title string `bson:"title" json:"title"`
+	Links []Link `bson:"links" json:"links" binding:"dive"`
+}
+
+// Settings are the site-wide settings that are stored in the database and
+// rendered by the templates; empty values fall back to the configuration of
+// the server. The analytics script is only rendered if AnalyticsID is set,
+// and DefaultLanguage replaces the first configured language as the language
+// of pages without language suffix.
+type Settings struct {
+	SiteTitle       string         `bson:"site_title,omitempty" json:"site_title" binding:"max=256"`
+	Author          string         `bson:"author,omitempty" json:"author" binding:"max=256"`
+	FooterText      string         `bson:"footer_text,omitempty" json:"footer_text" binding:"max=1024"`
+	FooterColumns   []FooterColumn `bson:"footer_columns" json:"footer_columns" binding:"dive"`
+	SocialLinks     []Link         `bson:"social_links" json:"social_links" binding:"dive"`
+	AnalyticsID     string         `bson:"analytics_id,omitempty" json:"analytics_id"`
+	DefaultLanguage string         `bson:"default_language,omitempty" json:"default_language"`
+}
+
+// Validate checks whether all links of the settings have a label and a valid
+// URL, whether the analytics id is a valid measurement id and whether the
+// default language is one of the languages pages are written in, which are
+// those of the default engine
+func (s *Settings) Validate() error {
+	return s.validate(engine.languages)
+}
+
+// validate checks the settings like Validate against the given languages
+func (s *Settings) validate(languages []string) error {
+	if s.AnalyticsID != "" && !analyticsRegexp.MatchString(s.AnalyticsID) {
+		return errors.New("invalid analytics id: " + s.AnalyticsID)
+	}
+	if s.DefaultLanguage != "" && !slices.Contains(languages, s.DefaultLanguage) {
+		return errors.New("invalid default language: " + s.DefaultLanguage)
+	}
+	links := append([]Link{}, s.SocialLinks...)
+	for _, c := range s.FooterColumns {
+		links = append(links, c.Links...)
+	}
+	for _, l := range links {
+		if l.Label == "" {
+			return errors.New("link label must not be empty")
+		}
+		u, err := url.Parse(l.URL)
+		if err != nil || l.URL == "" {
+			return errors.New("invalid link url: " + l.URL)
+		}
+		switch u.Scheme {
+		case "", "http", "https", "mailto":
+		default:
+			return errors.New("unsupported link url scheme: " + u.Scheme)
+		}
+	}
+	return nil
+}
+
+// LoadSettings returns the site settings; the settings are read from the
+// database on first access and cached afterward. If no settings are stored,
+// empty settings are returned.
+func (e *Engine) LoadSettings() (Settings, error) {
+	e.settingsMu.RLock()
+	s := e.cachedSettings
+	e.settingsMu.RUnlock()
+	if s != nil {
+		return *s, nil
+	}
+	log.Println("Loading settings from database")
+	var loaded Settings
+	err := e.retry("loading settings", "", func() error {
+		return e.settings.FindOne(e.ctx, bson.M{"_id": settingsID}).Decode(&loaded)
+	})
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return Settings{}, err
+	}
+	e.settingsMu.Lock()
+	e.cachedSettings = &loaded
+	e.settingsMu.Unlock()
+	e.setDefaultLanguage(loaded.DefaultLanguage)
+	return loaded, nil
+}
+
+// SaveSettings validates the given settings and writes them to the database,
+// replacing the previous settings
+func (e *Engine) SaveSettings(s Settings) error {
+	if err := s.validate(e.languages); err != nil {
+		return err
+	}
+	log.Println("Writing settings to database")
+	opts := options.Replace().SetUpsert(true)
+	err := e.retry("writing settings", "", func() error {
+		_, err := e.settings.ReplaceOne(e.ctx, bson.M{"_id": settingsID}, s, opts)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	e.settingsMu.Lock()
+	e.cachedSettings = &s
+	e.settingsMu.Unlock()
+	e.setDefaultLanguage(s.DefaultLanguage)
+	return nil
+}
+
+// InvalidateSettings drops the cached settings, so they are read from the
+// database on the next access.
+func (e *Engine) InvalidateSettings() {
+	e.settingsMu.Lock()
+	e.cachedSettings = nil
+	e.settingsMu.Unlock()
+}
+
+// WatchSettings watches the settings collection using a change stream and
+// drops the cached settings whenever they are changed, which includes changes
+// made by other instances. The cached settings are also dropped once the
+// stream is opened, as changes made before may have been missed. Blocks until
+// the context is done or the stream fails; returns ErrWatchUnsupported if the
+// database does not support change streams.
+func (e *Engine) WatchSettings(ctx context.Context) error {
+	stream, err := e.settings.Watch(ctx, mongo.Pipeline{})
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Code == 40573 { // Location40573
+		return ErrWatchUnsupported
+	}
+	if err != nil {
+		return err
+	}
+	defer func() { _ = stream.Close(e.ctx) }()
+	e.InvalidateSettings()
+	for stream.Next(ctx) {
+		log.Println("Settings changed, dropping cached settings")
+		e.InvalidateSettings()
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return stream.Err()
+}
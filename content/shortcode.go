@@ -0,0 +1,139 @@
+package content
+
+import (
+	"errors"
+	"html"
+	"log"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var (
+	// shortcodeRegexp matches shortcodes on lines of their own, like
+	//
+	//	{{< figure src="img/photo.jpg" >}}
+	shortcodeRegexp = regexp.MustCompile(`(?m)^\{\{<\s*(figure|gallery)((?:\s+[a-z]+="[^"]*")*)\s*>}}[ \t]*$`)
+	// shortcodeArgRegexp matches the arguments of a shortcode
+	shortcodeArgRegexp = regexp.MustCompile(`([a-z]+)="([^"]*)"`)
+)
+
+// expandShortcodes replaces the shortcodes of the given markdown by HTML; the
+// figure shortcode shows the image 'src' with its caption, the gallery
+// shortcode shows all images below the directory 'dir' with their captions.
+// The alt texts and captions are those of the images unless given by the
+// arguments 'alt' and 'caption' of a figure. Shortcodes which cannot be
+// expanded are kept as they are.
+//
+// Shortcodes are expanded before the markdown is rendered, so the cached
+// rendering is replaced once a caption changes.
+func (e *Engine) expandShortcodes(md []byte) []byte {
+	if !strings.Contains(string(md), "{{<") {
+		return md
+	}
+	return shortcodeRegexp.ReplaceAllFunc(md, func(sc []byte) []byte {
+		m := shortcodeRegexp.FindSubmatch(sc)
+		args := map[string]string{}
+		for _, a := range shortcodeArgRegexp.FindAllSubmatch(m[2], -1) {
+			args[string(a[1])] = string(a[2])
+		}
+		var out string
+		var err error
+		switch string(m[1]) {
+		case "figure":
+			out, err = e.figureShortcode(args)
+		case "gallery":
+			out, err = e.galleryShortcode(args)
+		}
+		if err != nil {
+			log.Println("[Err] Expanding shortcode failed:", string(sc), err)
+			return sc
+		}
+		// the HTML is a block of its own
+		return []byte("\n" + out + "\n")
+	})
+}
+
+// figureShortcode returns the HTML of the figure shortcode with the given
+// arguments; images which are not found are shown with the given arguments
+func (e *Engine) figureShortcode(args map[string]string) (string, error) {
+	src := args["src"]
+	if src == "" {
+		return "", errors.New("figure requires src")
+	}
+	f := MongoFile{URI: src, engine: e}
+	if uri, ok := imageURI(src); ok {
+		var err error
+		f, err = e.GetFromDB(uri)
+		if errors.Is(err, ErrNotFound) || (err == nil && !f.Public()) {
+			f = MongoFile{URI: uri, engine: e}
+		} else if err != nil {
+			return "", err
+		}
+	}
+	alt, ok := args["alt"]
+	if !ok {
+		alt = f.AltText()
+	}
+	caption, ok := args["caption"]
+	if !ok {
+		caption = f.Caption
+	}
+	return figureHTML(src, alt, caption), nil
+}
+
+// galleryShortcode returns the HTML of the gallery shortcode with the given
+// arguments
+func (e *Engine) galleryShortcode(args map[string]string) (string, error) {
+	dir := args["dir"]
+	if dir == "" {
+		return "", errors.New("gallery requires dir")
+	}
+	uri, ok := imageURI(dir)
+	if !ok {
+		return "", errors.New("gallery dir must be a path of the content")
+	}
+	images, err := e.ListImages(uri)
+	if err != nil {
+		return "", err
+	}
+	b := strings.Builder{}
+	b.WriteString(`<div class="gallery">`)
+	for _, f := range images {
+		b.WriteString(figureHTML(strings.TrimPrefix(f.URI, "/"), f.AltText(), f.Caption))
+	}
+	b.WriteString("</div>")
+	return b.String(), nil
+}
+
+// imageURI returns the uri of the file the given link of a page refers to;
+// links are relative to the content root. Returns false for URLs of other
+// sites and links to other routes.
+func imageURI(link string) (string, bool) {
+	u, err := url.Parse(link)
+	if err != nil || u.Scheme != "" || u.Host != "" {
+		return "", false
+	}
+	p := u.Path
+	if strings.HasPrefix(p, "/") {
+		var ok bool
+		p, ok = strings.CutPrefix(p, "/"+URIRoot+"/")
+		if !ok {
+			return "", false
+		}
+	}
+	return path.Join("/", p), true
+}
+
+// figureHTML returns the figure showing the image at the given source with
+// the given alt text and caption
+func figureHTML(src string, alt string, caption string) string {
+	b := strings.Builder{}
+	b.WriteString(`<figure><img src="` + html.EscapeString(src) + `" alt="` + html.EscapeString(alt) + `" loading="lazy">`)
+	if caption != "" {
+		b.WriteString("<figcaption>" + html.EscapeString(caption) + "</figcaption>")
+	}
+	b.WriteString("</figure>")
+	return b.String()
+}
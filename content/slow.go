@@ -0,0 +1,33 @@
+package content
+
+import (
+	"log"
+	"time"
+)
+
+// SlowCounts returns the number of slow database operations and markdown
+// renderings since the start.
+func (e *Engine) SlowCounts() (queries int64, renders int64) {
+	return e.slowQueries.Load(), e.slowRenders.Load()
+}
+
+// observeQuery records the duration of the given database operation on the
+// given subject
+func (e *Engine) observeQuery(name string, subject string, d time.Duration) {
+	if e.slowQuery > 0 && d >= e.slowQuery {
+		e.slowQueries.Add(1)
+		log.Println("[Slow] Database operation", name, subject, "took", d.Round(time.Millisecond))
+	}
+}
+
+// renderTimed renders the given markdown of the file with the given uri using
+// the engine's renderer and sanitize policy; slow renderings are recorded
+func (e *Engine) renderTimed(uri string, md []byte) []byte {
+	start := time.Now()
+	html := e.policy.Sanitize(e.renderer.Render(md))
+	if d := time.Since(start); e.slowRender > 0 && d >= e.slowRender {
+		e.slowRenders.Add(1)
+		log.Println("[Slow] Rendering", uri, "of", len(md), "bytes took", d.Round(time.Millisecond))
+	}
+	return html
+}
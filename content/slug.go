@@ -0,0 +1,176 @@
+package content
+
+import (
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"path"
+	"slices"
+	"strings"
+)
+
+// slugReplacer transcribes letters with diacritics to ASCII; German umlauts
+// are transcribed with two letters
+var slugReplacer = strings.NewReplacer(
+	"ä", "ae", "ö", "oe", "ü", "ue", "ß", "ss", "æ", "ae", "œ", "oe", "ø", "o", "å", "a",
+	"à", "a", "á", "a", "â", "a", "ã", "a", "ç", "c", "è", "e", "é", "e", "ê", "e", "ë", "e",
+	"ì", "i", "í", "i", "î", "i", "ï", "i", "ñ", "n", "ò", "o", "ó", "o", "ô", "o", "õ", "o",
+	"ù", "u", "ú", "u", "û", "u", "ý", "y", "ÿ", "y",
+)
+
+// Slugify returns the URL-safe slug of the given text, which consists of
+// lower case ASCII letters and digits separated by single dashes; for example,
+// 'Über Go & Web' becomes 'ueber-go-web'
+func Slugify(s string) string {
+	s = slugReplacer.Replace(strings.ToLower(s))
+	b := strings.Builder{}
+	dash := false
+	for _, r := range s {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') {
+			dash = true
+			continue
+		}
+		if dash && b.Len() > 0 {
+			b.WriteByte('-')
+		}
+		b.WriteRune(r)
+		dash = false
+	}
+	return b.String()
+}
+
+// slugPath returns the path the markdown file is served at without extension;
+// it is the directory of the file's uri joined with the slug of the file's
+// front matter or else the slug of its title. Variants of a page in other
+// languages may have the same slug. A slug starting with '/' is
+// relative to the content root instead. Returns an empty string for index
+// pages and files which are not markdown.
+func (p *MongoFile) slugPath() string {
+	if !p.IsMD || strings.HasPrefix(path.Base(p.URI), "index.") {
+		return ""
+	}
+	dir, name := path.Dir(p.URI), p.Title()
+	if p.Meta != nil && p.Meta.Slug != "" {
+		name = p.Meta.Slug
+		if strings.HasPrefix(name, "/") {
+			dir = "/"
+		}
+	}
+	segments := []string{dir}
+	for _, s := range strings.Split(name, "/") {
+		if s = Slugify(s); s != "" {
+			segments = append(segments, s)
+		}
+	}
+	if len(segments) == 1 {
+		return ""
+	}
+	return path.Join(segments...)
+}
+
+// uniqueSlug returns the given slug path, suffixed by a number if another
+// public file in the file's language already has the slug or is a page served
+// at it
+func (p *MongoFile) uniqueSlug(slug string) (string, error) {
+	e := p.eng()
+	if slug == "" {
+		return "", nil
+	}
+	for i := 1; ; i++ {
+		s := slug
+		if i > 1 {
+			s = fmt.Sprintf("%s-%d", slug, i)
+		}
+		var n int64
+		err := e.retry("checking slug", s, func() (err error) {
+			filter := public(bson.M{"name": bson.M{"$ne": p.URI},
+				"$or": bson.A{bson.M{"slug": s}, bson.M{"uri": bson.M{"$in": e.languageURIs(s+".md", p.Language())}}}})
+			n, err = e.files.CountDocuments(e.ctx, e.langFilter(filter, p.Language()))
+			return err
+		})
+		if err != nil || n == 0 {
+			return s, err
+		}
+	}
+}
+
+// replaceSlug sets the slug of the file replacing the given file; the slugs of
+// the replaced file are kept as previous slugs. The slug is not checked for
+// uniqueness, as the replaced file still has it.
+func (p *MongoFile) replaceSlug(replaced MongoFile) {
+	p.Slug, p.OldSlugs = p.slugPath(), nil
+	for _, s := range append(replaced.OldSlugs, replaced.Slug) {
+		if s != "" && s != p.Slug && !slices.Contains(p.OldSlugs, s) {
+			p.OldSlugs = append(p.OldSlugs, s)
+		}
+	}
+}
+
+// keepSlug keeps the given previous slug of the file with the given uri, so
+// requests for it are redirected to the file's current slug
+func (e *Engine) keepSlug(uri string, slug string) error {
+	log.Println("Keeping previous slug of file:", uri, slug)
+	return e.retry("keeping slug", uri, func() error {
+		_, err := e.files.UpdateOne(e.ctx, bson.M{"name": uri}, bson.M{"$addToSet": bson.M{"old_slugs": slug}})
+		return err
+	})
+}
+
+// Link returns the path the file is served at; markdown files with a slug are
+// served at their slug. Markdown files in another language than the default
+// language are served below the language without language suffix, like
+// '/en/content/about.html'.
+func (p *MongoFile) Link() string {
+	if p.Slug != "" {
+		return p.languagePrefix() + path.Join("/", URIRoot, p.Slug+".html")
+	}
+	if p.IsMD && p.Lang != "" {
+		name, _ := p.eng().SplitLanguage(p.Name())
+		return p.languagePrefix() + path.Join("/", URIRoot, name)
+	}
+	return path.Join("/", URIRoot, p.Name())
+}
+
+// LanguageLink returns the link of the markdown file prefixed by its language
+// even for the default language, so the file is served without language
+// negotiation
+func (p *MongoFile) LanguageLink() string {
+	if p.Language() == p.eng().DefaultLanguage() {
+		return "/" + p.Language() + p.Link()
+	}
+	return p.Link()
+}
+
+// languagePrefix returns the path prefix of markdown files in another language
+// than the default language, or else an empty string
+func (p *MongoFile) languagePrefix() string {
+	if !p.IsMD || p.Language() == p.eng().DefaultLanguage() {
+		return ""
+	}
+	return "/" + p.Lang
+}
+
+// GetBySlug returns the public markdown file in the given language with the
+// given slug path except for MongoFile.Content; if no file has the slug, the
+// file which had the slug before is returned and the second return value is
+// true, so the request can be redirected to the file's current link
+func (e *Engine) GetBySlug(slug string, lang string) (MongoFile, bool, error) {
+	log.Println("Getting file by slug:", slug, lang)
+	opts := options.FindOne().SetProjection(metaProjection)
+	var file MongoFile
+	err := e.retry("getting file by slug", slug, func() error {
+		return e.decodeFile(e.files.FindOne(e.ctx, e.langFilter(public(bson.M{"slug": slug}), lang), opts), &file)
+	})
+	if !errors.Is(ErrNotFound, err) {
+		return file, false, err
+	}
+	err = e.retry("getting file by previous slug", slug, func() error {
+		return e.decodeFile(e.files.FindOne(e.ctx, e.langFilter(public(bson.M{"old_slugs": slug}), lang), opts), &file)
+	})
+	if errors.Is(ErrNotFound, err) {
+		return MongoFile{}, false, ErrNotFound
+	}
+	return file, true, err
+}
@@ -0,0 +1,161 @@
+package content
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// StagingRoot is the uri prefix the files of staging content sets are stored
+// below
+const StagingRoot = "/.staging"
+
+// ErrInvalidStagingName is returned if the name of a staging content set is
+// not valid
+var ErrInvalidStagingName = errors.New("invalid staging name")
+
+// stagingNameRegexp matches valid names of staging content sets
+var stagingNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// ValidStagingName returns whether the given name is a valid name of a staging
+// content set, which consists of lowercase letters, digits, dashes and
+// underscores
+func ValidStagingName(name string) bool {
+	return stagingNameRegexp.MatchString(name)
+}
+
+// StagingURI returns the uri a file with the given uri is stored at in the
+// staging content set with the given name
+func StagingURI(name string, uri string) string {
+	return path.Join(StagingRoot, name, uri)
+}
+
+// StagingTarget returns the uri the file with the given uri of the staging
+// content set with the given name is published at when the set is promoted
+func StagingTarget(name string, uri string) string {
+	return strings.TrimPrefix(uri, path.Join(StagingRoot, name))
+}
+
+// ListStaging lists all files of the staging content set with the given name
+// except for MongoFile.Content
+func (e *Engine) ListStaging(name string) ([]MongoFile, error) {
+	opts := options.Find().SetProjection(metaProjection).SetSort(bson.M{"uri": 1})
+	files := []MongoFile{}
+	err := e.retry("listing staged files", name, func() error {
+		cursor, err := e.files.Find(e.ctx, bson.M{"staging": name}, opts)
+		if err != nil {
+			return err
+		}
+		return e.decodeFiles(e.ctx, cursor, &files)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// ListStagingSets lists the names of all staging content sets
+func (e *Engine) ListStagingSets() ([]string, error) {
+	var values []interface{}
+	err := e.retry("listing staging sets", "", func() (err error) {
+		values, err = e.files.Distinct(e.ctx, "staging", bson.M{"staging": bson.M{"$exists": true}})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, v := range values {
+		if name, ok := v.(string); ok {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// PromoteStaging publishes all files of the staging content set with the given
+// name at their target uris, replacing the files stored there, and removes the
+// set. Returns the published files or ErrNotFound if the set is empty.
+//
+// The replaced files are kept below PreviousRoot until the next promotion, so
+// the promotion can be rolled back using Rollback; the files kept by the
+// previous promotion are discarded.
+//
+// The files are swapped in a single transaction if MongoDB runs as a replica
+// set, see transact. Locally stored contents are not moved, the published
+// files refer to them.
+func (e *Engine) PromoteStaging(name string) ([]MongoFile, error) {
+	if !ValidStagingName(name) {
+		return nil, ErrInvalidStagingName
+	}
+	log.Println("Promoting staging content set:", name)
+	var promoted []MongoFile
+	var discarded []string
+	err := e.transact(func(ctx context.Context) error {
+		var err error
+		promoted, discarded, err = e.promote(ctx, name)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	// the contents of discarded files are only removed once the swap succeeded
+	for _, p := range discarded {
+		e.removeLocal(p)
+	}
+	return promoted, nil
+}
+
+// promote replaces the target files of the staging content set with the given
+// name by the set's files, keeps the replaced files below PreviousRoot and
+// deletes the set's files; returns the published files and the local paths of
+// the discarded previous files
+func (e *Engine) promote(ctx context.Context, name string) ([]MongoFile, []string, error) {
+	cursor, err := e.files.Find(ctx, bson.M{"staging": name})
+	if err != nil {
+		return nil, nil, err
+	}
+	var files []MongoFile
+	err = e.decodeFiles(ctx, cursor, &files)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(files) == 0 {
+		return nil, nil, ErrNotFound
+	}
+	discarded, err := e.discardPrevious(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, f := range files {
+		staged := f.URI
+		target := StagingTarget(name, staged)
+		kept, err := e.keepPrevious(ctx, target)
+		if err != nil {
+			return nil, nil, err
+		}
+		// the content stays where it is, the published file refers to it
+		if f.IsLocal {
+			f.Path = f.localPath()
+		}
+		f.URI = target
+		f.Staging = ""
+		f.replaceSlug(kept)
+		err = e.replace(ctx, f)
+		if err != nil {
+			return nil, nil, err
+		}
+		_, err = e.files.DeleteOne(ctx, bson.M{"uri": staged})
+		if err != nil {
+			return nil, nil, err
+		}
+		f.Content.Data, f.Rendered.Data = nil, nil
+		files[i] = f
+	}
+	return files, discarded, nil
+}
@@ -0,0 +1,75 @@
+package content
+
+import (
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"log"
+	"time"
+)
+
+// publication statuses of files
+const (
+	// StatusDraft files are neither served nor listed, except for previews
+	StatusDraft = "draft"
+	// StatusPublished files are served and listed depending on their
+	// visibility; files without status are published
+	StatusPublished = "published"
+	// StatusArchived files were published before, but are neither served nor
+	// listed anymore
+	StatusArchived = "archived"
+	// StatusReview files were submitted for review; like drafts they are
+	// neither served nor listed until they are approved
+	StatusReview = "review"
+)
+
+// ErrInvalidStatus is returned if a status is not valid
+var ErrInvalidStatus = errors.New("status must be 'draft', 'review', 'published' or 'archived'")
+
+// ValidStatus returns whether the given status is valid; the empty status is
+// valid and means published
+func ValidStatus(s string) bool {
+	switch s {
+	case "", StatusDraft, StatusReview, StatusPublished, StatusArchived:
+		return true
+	}
+	return false
+}
+
+// Published returns whether the file is published, so it may be served; a
+// scheduled publication or unpublication takes effect once its time passed,
+// even if it was not applied by ApplySchedule yet
+func (p *MongoFile) Published() bool {
+	now := time.Now()
+	if !p.UnpublishAt.IsZero() && !now.Before(p.UnpublishAt) {
+		return false
+	}
+	if !p.PublishAt.IsZero() && !now.Before(p.PublishAt) {
+		return true
+	}
+	return p.Status == "" || p.Status == StatusPublished
+}
+
+// SetStatus sets the status of the file with the given uri. Returns
+// ErrNotFound if there is no such file.
+func (e *Engine) SetStatus(uri string, s string) error {
+	if !ValidStatus(s) {
+		return ErrInvalidStatus
+	}
+	if s == "" {
+		s = StatusPublished
+	}
+	log.Println("Setting status of file:", uri, s)
+	var res *mongo.UpdateResult
+	err := e.retry("setting status", uri, func() (err error) {
+		res, err = e.files.UpdateOne(e.ctx, bson.M{"uri": uri}, bson.M{"$set": bson.M{"status": s}})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package content
+
+import (
+	"bytes"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gopkg.in/yaml.v3"
+)
+
+// Tags returns the tags of the file's front matter
+func (p *MongoFile) Tags() []string {
+	if p.Meta == nil {
+		return nil
+	}
+	return p.Meta.Tags
+}
+
+// ListTagged lists all public markdown files with tags in their front matter
+// except for MongoFile.Content
+func (e *Engine) ListTagged() ([]MongoFile, error) {
+	opts := options.Find().SetProjection(metaProjection)
+	var files []MongoFile
+	err := e.retry("listing tagged files", "", func() error {
+		cursor, err := e.files.Find(e.ctx, public(bson.M{"is_md": true, "meta.tags.0": bson.M{"$exists": true}}), opts)
+		if err != nil {
+			return err
+		}
+		return e.decodeFiles(e.ctx, cursor, &files)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// SetTags returns the given markdown with the tags of its front matter
+// replaced by the given tags; the other fields of the front matter are kept,
+// and front matter is added to markdown without. Empty tags remove the field.
+// Returns an error if the front matter is not valid YAML.
+func SetTags(md []byte, tags []string) ([]byte, error) {
+	md = NormalizeEOL(md)
+	yml, body, ok := splitFrontMatter(md)
+	if !ok && len(tags) == 0 {
+		return md, nil
+	}
+	var doc yaml.Node
+	err := yaml.Unmarshal(yml, &doc)
+	if err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	m := doc.Content[0]
+	if m.Kind != yaml.MappingNode {
+		return nil, errors.New("front matter is not a mapping")
+	}
+	value := &yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle}
+	for _, t := range tags {
+		value.Content = append(value.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: t})
+	}
+	found := false
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value != "tags" {
+			continue
+		}
+		found = true
+		if len(tags) == 0 {
+			m.Content = append(m.Content[:i], m.Content[i+2:]...)
+		} else {
+			m.Content[i+1] = value
+		}
+		break
+	}
+	if !found && len(tags) > 0 {
+		m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "tags"}, value)
+	}
+	buf := bytes.Buffer{}
+	buf.WriteString("---\n")
+	if len(m.Content) > 0 {
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		err = enc.Encode(&doc)
+		if err != nil {
+			return nil, err
+		}
+	}
+	buf.WriteString("---\n")
+	buf.Write(body)
+	return buf.Bytes(), nil
+}
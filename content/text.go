@@ -0,0 +1,85 @@
+package content
+
+import (
+	"bytes"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"html"
+	"io"
+	"log"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var (
+	tagRegexp   = regexp.MustCompile(`<[^>]*>`)
+	spaceRegexp = regexp.MustCompile(`\s+`)
+)
+
+// Title returns the title of the file, which is the title of its front matter
+// or else the file's uri stripped from directory, language and extension
+func (p *MongoFile) Title() string {
+	if p.Meta != nil && p.Meta.Title != "" {
+		return p.Meta.Title
+	}
+	uri, _ := p.eng().SplitLanguage(p.URI)
+	return path.Base(uri[:len(uri)-len(path.Ext(uri))])
+}
+
+// Markdown returns the file's markdown content with normalized EOLs and
+// without front matter. If the file's content was not loaded yet, it is read
+// from the database or, if the file is stored locally, from the blob store;
+// encrypted content is decrypted.
+func (p *MongoFile) Markdown() ([]byte, error) {
+	data := p.Content.Data
+	if data == nil {
+		rc, err := p.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = rc.Close() }()
+		buf := bytes.Buffer{}
+		_, err = io.Copy(&buf, rc)
+		if err != nil {
+			return nil, err
+		}
+		data = buf.Bytes()
+	} else if p.Encrypted {
+		var err error
+		data, err = p.eng().open(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	_, body, _ := SplitFrontMatter(data)
+	return body, nil
+}
+
+// PlainText converts the given markdown to plain text by rendering it to HTML
+// and stripping all tags; consecutive whitespace is collapsed
+func (e *Engine) PlainText(md []byte) string {
+	s := string(e.renderer.Render(NormalizeEOL(md)))
+	s = tagRegexp.ReplaceAllString(s, " ")
+	s = html.UnescapeString(s)
+	return strings.TrimSpace(spaceRegexp.ReplaceAllString(s, " "))
+}
+
+// ListMarkdown lists all markdown files in the database including their
+// content; the content of locally stored files is not read
+func (e *Engine) ListMarkdown() ([]MongoFile, error) {
+	log.Println("Listing markdown files")
+	opts := options.Find().SetProjection(bson.M{"rendered": 0})
+	var files []MongoFile
+	err := e.retry("listing markdown files", "", func() error {
+		cursor, err := e.files.Find(e.ctx, public(bson.M{"is_md": true}), opts)
+		if err != nil {
+			return err
+		}
+		return e.decodeFiles(e.ctx, cursor, &files)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
@@ -0,0 +1,130 @@
+package content
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrDirectUploadUnsupported is returned for direct uploads if the blob
+	// store cannot issue pre-signed upload URLs or contents are encrypted, as
+	// encrypted contents must pass through the engine
+	ErrDirectUploadUnsupported = errors.New("direct uploads are not supported by the blob store")
+	// ErrUploadPath is returned when storing a direct upload if the path was
+	// not issued for the file's uri or its content is already stored
+	ErrUploadPath = errors.New("invalid path of direct upload")
+)
+
+// PresignUpload returns the path content of the file with the given uri is
+// uploaded to directly and a URL the content can be uploaded to with a PUT
+// request until the URL expires after the given duration; the file is stored
+// by MongoFile.StoreUploaded once the content is uploaded. Returns
+// ErrDirectUploadUnsupported if the blob store is not a PresignStore or
+// contents are encrypted.
+func (e *Engine) PresignUpload(uri string, expires time.Duration) (string, string, error) {
+	store, ok := e.blobs.(PresignStore)
+	if !ok || e.aead != nil {
+		return "", "", ErrDirectUploadUnsupported
+	}
+	// like all contents, uploaded contents are stored at a unique path
+	p := fmt.Sprintf("%s.%d", uri, time.Now().UnixNano())
+	u, err := store.PresignPut(p, expires)
+	if err != nil {
+		return "", "", err
+	}
+	log.Println("Issued upload URL:", p)
+	return p, u, nil
+}
+
+// OpenUpload returns a reader for the content uploaded directly to the given
+// path, so it can be checked before the file is stored
+func (e *Engine) OpenUpload(p string) (io.ReadCloser, error) {
+	return e.blobs.Open(p)
+}
+
+// RemoveUpload removes the content uploaded directly to the given path unless
+// a file or a revision refers to it
+func (e *Engine) RemoveUpload(p string) {
+	e.removeLocal(p)
+}
+
+// ValidUploadPath returns whether the given path may have been issued by
+// PresignUpload for content of the file with the given uri
+func ValidUploadPath(uri string, p string) bool {
+	stamp, ok := strings.CutPrefix(p, uri+".")
+	_, err := strconv.ParseUint(stamp, 10, 64)
+	return ok && err == nil
+}
+
+// StoreUploaded writes the file with the content uploaded directly to the
+// given path, issued by PresignUpload for the file's uri, to the database like
+// Store. The content is read once to set the file's Hash and Filesize fields;
+// it is passed to the given inspect function as well, which rejects the file
+// by returning an error, and read to the end afterwards. Returns ErrUploadPath
+// if the path was not issued for the file's uri or its content is already
+// stored. Markdown files cannot be uploaded directly, as their front matter is
+// parsed when they are stored.
+func (p *MongoFile) StoreUploaded(path string, inspect func(r io.Reader) error) error {
+	e := p.eng()
+	if _, ok := e.blobs.(PresignStore); !ok || e.aead != nil {
+		return ErrDirectUploadUnsupported
+	}
+	if p.URI == "" || p.IsMD {
+		return errors.New("file's URI field is not set or the file is a markdown file")
+	}
+	if !ValidUploadPath(p.URI, path) {
+		return ErrUploadPath
+	}
+	n, err := e.blobReferences(path)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrUploadPath
+	}
+	log.Println("Storing uploaded file content:", path)
+	rc, err := e.blobs.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rc.Close() }()
+	h := sha256.New()
+	r := io.TeeReader(rc, h)
+	size := int64(0)
+	err = inspect(readCounter{r, &size})
+	if err != nil {
+		return err
+	}
+	n, err = io.Copy(io.Discard, r)
+	if err != nil {
+		return err
+	}
+	p.Content = primitive.Binary{}
+	p.Path = path
+	p.Hash = hex.EncodeToString(h.Sum(nil))
+	p.Filesize = size + n
+	p.IsLocal = true
+	p.Encrypted = false
+	p.Meta = nil
+	return p.write()
+}
+
+// readCounter adds the number of bytes read from its reader to its count
+type readCounter struct {
+	r     io.Reader
+	count *int64
+}
+
+func (c readCounter) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	*c.count += int64(n)
+	return n, err
+}
@@ -0,0 +1,67 @@
+package content
+
+import (
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"log"
+)
+
+// visibilities of files
+const (
+	// VisibilityPublic files are served to everyone and listed in the sitemap,
+	// the feeds and the search; files without visibility are public
+	VisibilityPublic = "public"
+	// VisibilityUnlisted files are served to everyone, but not listed
+	VisibilityUnlisted = "unlisted"
+	// VisibilityPrivate files are only served to authenticated users
+	VisibilityPrivate = "private"
+)
+
+// ErrInvalidVisibility is returned if a visibility is not valid
+var ErrInvalidVisibility = errors.New("visibility must be 'public', 'unlisted' or 'private'")
+
+// ValidVisibility returns whether the given visibility is valid; the empty
+// visibility is valid and means public
+func ValidVisibility(v string) bool {
+	switch v {
+	case "", VisibilityPublic, VisibilityUnlisted, VisibilityPrivate:
+		return true
+	}
+	return false
+}
+
+// Listed returns whether the file is listed in the sitemap, the feeds and the
+// search, which is only the case for public files
+func (p *MongoFile) Listed() bool {
+	return p.Visibility == "" || p.Visibility == VisibilityPublic
+}
+
+// Private returns whether the file is only served to authenticated users
+func (p *MongoFile) Private() bool {
+	return p.Visibility == VisibilityPrivate
+}
+
+// SetVisibility sets the visibility of the file with the given uri. Returns
+// ErrNotFound if there is no such file.
+func (e *Engine) SetVisibility(uri string, v string) error {
+	if !ValidVisibility(v) {
+		return ErrInvalidVisibility
+	}
+	if v == "" {
+		v = VisibilityPublic
+	}
+	log.Println("Setting visibility of file:", uri, v)
+	var res *mongo.UpdateResult
+	err := e.retry("setting visibility", uri, func() (err error) {
+		res, err = e.files.UpdateOne(e.ctx, bson.M{"uri": uri}, bson.M{"$set": bson.M{"visibility": v}})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+	"log"
+	"path"
+	"strings"
+	"time"
+)
+
+// ErrManagedUser is returned when changing a user that is managed by the
+// accounts file
+var ErrManagedUser = errors.New("user is managed by the accounts file")
+
+// Action is a content action the permissions of an account can be restricted
+// to
+type Action string
+
+const (
+	// ActionUpload allows uploading content
+	ActionUpload Action = "upload"
+	// ActionDelete allows deleting content
+	ActionDelete Action = "delete"
+	// ActionDownload allows downloading the portfolio
+	ActionDownload Action = "download"
+)
+
+// Valid returns whether the action is a known action
+func (a Action) Valid() bool {
+	return a == ActionUpload || a == ActionDelete || a == ActionDownload
+}
+
+// Allows returns whether the user may perform the given action; users without
+// restricted actions may perform all actions their role permits
+func (u User) Allows(a Action) bool {
+	if len(u.Actions) == 0 {
+		return true
+	}
+	for _, allowed := range u.Actions {
+		if allowed == a {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsURI returns whether the user may change the content at the given uri;
+// users without prefixes may change all content. A prefix ending with '/**'
+// matches the directory and everything below it, other prefixes are matched
+// like path.Match, so '/blog/*' only matches the files directly in '/blog'.
+func (u User) AllowsURI(uri string) bool {
+	if len(u.Prefixes) == 0 {
+		return true
+	}
+	uri = path.Join("/", uri)
+	for _, p := range u.Prefixes {
+		if dir, ok := strings.CutSuffix(p, "/**"); ok {
+			if uri == path.Join("/", dir) || strings.HasPrefix(uri, path.Join("/", dir, "/")+"/") {
+				return true
+			}
+			continue
+		}
+		if ok, _ := path.Match(p, uri); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidPrefix returns whether the given uri prefix is valid; prefixes are
+// absolute paths and valid patterns of path.Match
+func ValidPrefix(p string) bool {
+	if !strings.HasPrefix(p, "/") {
+		return false
+	}
+	_, err := path.Match(strings.TrimSuffix(p, "/**"), "")
+	return err == nil
+}
+
+// Account is a user account configured in the accounts file; the password is
+// given either in plain text or as bcrypt hash. If permissions are given, the
+// account may only perform the listed actions; if prefixes are given, it may
+// only change content matching them.
+type Account struct {
+	Name         string   `json:"name" yaml:"name"`
+	Email        string   `json:"email,omitempty" yaml:"email,omitempty"`
+	Password     string   `json:"password,omitempty" yaml:"password,omitempty"`
+	PasswordHash string   `json:"password_hash,omitempty" yaml:"password_hash,omitempty"`
+	Role         Role     `json:"role" yaml:"role"`
+	Permissions  []Action `json:"permissions,omitempty" yaml:"permissions,omitempty"`
+	Prefixes     []string `json:"prefixes,omitempty" yaml:"prefixes,omitempty"`
+}
+
+// SyncAccounts creates or updates a user for each of the given accounts and
+// marks them as managed, so they cannot be changed otherwise; managed users
+// whose account was removed are deleted. The sessions of users whose password
+// changed are deleted. All accounts are validated before any user is changed.
+func SyncAccounts(accounts []Account) error {
+	hashes := make([][]byte, len(accounts))
+	names := bson.A{}
+	seen := map[string]bool{}
+	for i, a := range accounts {
+		if !userNameRegexp.MatchString(a.Name) || seen[a.Name] {
+			return fmt.Errorf("%w: invalid or duplicate name %q", ErrInvalidUser, a.Name)
+		}
+		seen[a.Name] = true
+		names = append(names, a.Name)
+		if !a.Role.Valid() {
+			return fmt.Errorf("%w: invalid role %q of account %s", ErrInvalidUser, a.Role, a.Name)
+		}
+		if err := validateEmail(a.Email); err != nil {
+			return err
+		}
+		for _, p := range a.Permissions {
+			if !p.Valid() {
+				return fmt.Errorf("%w: invalid permission %q of account %s", ErrInvalidUser, p, a.Name)
+			}
+		}
+		for _, p := range a.Prefixes {
+			if !ValidPrefix(p) {
+				return fmt.Errorf("%w: invalid prefix %q of account %s", ErrInvalidUser, p, a.Name)
+			}
+		}
+		hash, err := accountHash(a)
+		if err != nil {
+			return err
+		}
+		hashes[i] = hash
+	}
+	for i, a := range accounts {
+		old, err := GetUser(a.Name)
+		if err != nil && !errors.Is(err, ErrUserNotFound) {
+			return err
+		}
+		set := bson.M{"role": a.Role, "email": a.Email, "actions": a.Permissions, "prefixes": a.Prefixes,
+			"managed": true}
+		changed := err != nil || bcrypt.CompareHashAndPassword(old.Password, []byte(a.Password)) != nil
+		if a.PasswordHash != "" {
+			changed = string(old.Password) != a.PasswordHash
+		}
+		if changed {
+			set["password"] = hashes[i]
+		}
+		log.Println("Syncing account:", a.Name)
+		opts := options.Update().SetUpsert(true)
+		_, err = userCol.UpdateOne(Context, bson.M{"_id": a.Name},
+			bson.M{"$set": set, "$setOnInsert": bson.M{"created": time.Now()}}, opts)
+		if err != nil {
+			return err
+		}
+		if changed {
+			_, err = sessionCol.DeleteMany(Context, bson.M{"user": a.Name})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	cursor, err := userCol.Find(Context, bson.M{"managed": true, "_id": bson.M{"$nin": names}})
+	if err != nil {
+		return err
+	}
+	var removed []User
+	err = cursor.All(Context, &removed)
+	if err != nil {
+		return err
+	}
+	for _, u := range removed {
+		log.Println("Account was removed from the accounts file:", u.Name)
+		err = deleteUser(u.Name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// accountHash returns the bcrypt hash of the password of the given account;
+// the hash is only computed if no hash is given, in which case the password
+// policy is enforced
+func accountHash(a Account) ([]byte, error) {
+	if a.PasswordHash != "" {
+		if _, err := bcrypt.Cost([]byte(a.PasswordHash)); err != nil {
+			return nil, fmt.Errorf("%w: invalid password hash of account %s: %v", ErrInvalidUser, a.Name, err)
+		}
+		return []byte(a.PasswordHash), nil
+	}
+	if a.Password == "" {
+		return nil, fmt.Errorf("%w: account %s has neither password nor password hash", ErrInvalidUser, a.Name)
+	}
+	return hashPassword(a.Password)
+}
+
+// checkManaged returns ErrManagedUser if the user with the given name is
+// managed by the accounts file
+func checkManaged(name string) error {
+	n, err := userCol.CountDocuments(Context, bson.M{"_id": name, "managed": true})
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrManagedUser
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/crypto/bcrypt"
+	"log"
+	"time"
+)
+
+// SeedUser creates a user with the given name and role if no users exist yet,
+// so there is an account to log in with on the first run. The password may be
+// given in plain text or as bcrypt hash; if a hash is given, the password is
+// ignored. Unlike CreateUser, the password policy is not enforced, but a weak
+// password is logged. Returns whether the user was created.
+func SeedUser(name string, password string, hash string, role Role) (bool, error) {
+	n, err := userCol.CountDocuments(Context, bson.M{})
+	if err != nil || n > 0 {
+		return false, err
+	}
+	var h []byte
+	if hash != "" {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return false, fmt.Errorf("%w: invalid password hash: %v", ErrInvalidUser, err)
+		}
+		h = []byte(hash)
+	} else {
+		if len(password) < minPasswordLength {
+			log.Println("Seeded password is shorter than", minPasswordLength, "characters; change it after logging in")
+		}
+		h, err = bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return false, err
+		}
+	}
+	log.Println("Seeding user:", name)
+	_, err = userCol.InsertOne(Context, User{Name: name, Role: role, Password: h, Created: time.Now()})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Authenticate checks the given credentials and returns the matching user.
+// Returns ErrInvalidCredentials if the user does not exist or the password is
+// wrong.
+func Authenticate(name string, password string) (User, error) {
+	u, err := GetUser(name)
+	if errors.Is(err, ErrUserNotFound) {
+		// compare anyway, so the response time does not reveal existing users
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return User{}, ErrInvalidCredentials
+	}
+	if err != nil {
+		return User{}, err
+	}
+	if bcrypt.CompareHashAndPassword(u.Password, []byte(password)) != nil {
+		return User{}, ErrInvalidCredentials
+	}
+	return u, nil
+}
+
+// dummyHash is compared against for unknown users
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+// hashPassword checks the length of the given password and returns its bcrypt
+// hash
+func hashPassword(password string) ([]byte, error) {
+	if len(password) < minPasswordLength {
+		return nil, fmt.Errorf("%w: password must be at least %d characters long", ErrInvalidUser, minPasswordLength)
+	}
+	if len(password) > 72 {
+		// bcrypt only uses the first 72 bytes
+		return nil, fmt.Errorf("%w: password must be at most 72 bytes long", ErrInvalidUser)
+	}
+	return bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+}
+
+// ChangePassword sets the password of the user with the given name after
+// verifying the user's current password; all of the user's sessions and, if
+// revokeTokens is set, API tokens are deleted. Returns ErrInvalidCredentials
+// if the current password is wrong.
+func ChangePassword(name string, current string, password string, revokeTokens bool) error {
+	_, err := Authenticate(name, current)
+	if err != nil {
+		return err
+	}
+	err = SetPassword(name, password)
+	if err != nil || !revokeTokens {
+		return err
+	}
+	return RevokeTokens(name)
+}
@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"time"
+)
+
+var previewCol *mongo.Collection
+
+// ErrNoPreview is returned if a preview token does not exist, expired, was
+// already used or does not grant access to the requested file
+var ErrNoPreview = errors.New("preview token not found")
+
+// Preview is a token granting access to a single unpublished file without
+// credentials, so reviewers can see a draft. Like API tokens, the database
+// stores the token's hash as its ID. One-time previews are deleted on their
+// first use.
+type Preview struct {
+	ID      string    `bson:"_id" json:"id"`
+	URI     string    `bson:"uri" json:"uri"`
+	User    string    `bson:"user" json:"user"`
+	Once    bool      `bson:"once" json:"once"`
+	Created time.Time `bson:"created" json:"created"`
+	Expires time.Time `bson:"expires" json:"expires"`
+}
+
+// CreatePreview creates a preview token for the file with the given uri on
+// behalf of the given user that expires after the given duration. Returns the
+// preview and the secret the reviewer has to present; the secret cannot be
+// retrieved later.
+func CreatePreview(uri string, user string, ttl time.Duration, once bool) (Preview, string, error) {
+	secret, err := randomToken()
+	if err != nil {
+		return Preview{}, "", err
+	}
+	now := time.Now()
+	p := Preview{ID: hashToken(secret), URI: uri, User: user, Once: once, Created: now, Expires: now.Add(ttl)}
+	log.Println("Creating preview of", uri, "for user:", user)
+	_, err = previewCol.InsertOne(Context, p)
+	if err != nil {
+		return Preview{}, "", err
+	}
+	return p, secret, nil
+}
+
+// UsePreview returns the preview for the given secret if it grants access to
+// the file with the given uri; one-time previews are deleted. Returns
+// ErrNoPreview if there is no such preview.
+func UsePreview(secret string, uri string) (Preview, error) {
+	var p Preview
+	filter := bson.M{"_id": hashToken(secret), "uri": uri, "expires": bson.M{"$gt": time.Now()}}
+	err := previewCol.FindOne(Context, filter).Decode(&p)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Preview{}, ErrNoPreview
+	}
+	if err != nil {
+		return Preview{}, err
+	}
+	if p.Once {
+		// only the request deleting the preview may use it
+		res, err := previewCol.DeleteOne(Context, bson.M{"_id": p.ID})
+		if err != nil {
+			return Preview{}, err
+		}
+		if res.DeletedCount == 0 {
+			return Preview{}, ErrNoPreview
+		}
+	}
+	return p, nil
+}
+
+// ListPreviews lists the unexpired previews created by the given user or, if
+// the user is empty, by all users, newest first
+func ListPreviews(user string) ([]Preview, error) {
+	filter := bson.M{"expires": bson.M{"$gt": time.Now()}}
+	if user != "" {
+		filter["user"] = user
+	}
+	opts := options.Find().SetSort(bson.M{"created": -1})
+	cursor, err := previewCol.Find(Context, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	previews := []Preview{}
+	err = cursor.All(Context, &previews)
+	if err != nil {
+		return nil, err
+	}
+	return previews, nil
+}
+
+// RevokePreview deletes the preview with the given ID; if the user is not
+// empty, only a preview created by the given user is deleted. Returns
+// ErrNoPreview if there is no such preview.
+func RevokePreview(id string, user string) error {
+	filter := bson.M{"_id": id}
+	if user != "" {
+		filter["user"] = user
+	}
+	log.Println("Revoking preview:", id)
+	res, err := previewCol.DeleteOne(Context, filter)
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNoPreview
+	}
+	return nil
+}
+
+// DeleteExpiredPreviews deletes all expired previews and returns their number
+func DeleteExpiredPreviews() (int64, error) {
+	res, err := previewCol.DeleteMany(Context, bson.M{"expires": bson.M{"$lte": time.Now()}})
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
+// SetPreviewCollection sets the collection previews are stored in and creates
+// an index removing expired previews
+func SetPreviewCollection(c *mongo.Collection) {
+	previewCol = c
+	index := mongo.IndexModel{
+		Keys:    bson.M{"expires": 1},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+	_, err := c.Indexes().CreateOne(Context, index)
+	if err != nil {
+		log.Println("[Err] Creating preview expiry index:", err)
+	}
+}
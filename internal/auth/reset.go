@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// resetKey is the key password reset tokens are signed with
+var resetKey []byte
+
+// ErrInvalidResetToken is returned if a password reset token is malformed,
+// expired or was already used
+var ErrInvalidResetToken = errors.New("invalid or expired password reset token")
+
+// ResetToken returns a signed password reset token for the given user that
+// expires after the given duration. The signature covers the user's current
+// password hash, so the token becomes invalid once the password is changed.
+func ResetToken(u User, ttl time.Duration) string {
+	payload := u.Name + "|" + strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	sig := resetSignature(payload, u.Password)
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// VerifyResetToken checks the given password reset token and returns the user
+// it was issued for. Returns ErrInvalidResetToken if the token is invalid.
+func VerifyResetToken(token string) (User, error) {
+	p, s, ok := strings.Cut(token, ".")
+	if !ok {
+		return User{}, ErrInvalidResetToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(p)
+	if err != nil {
+		return User{}, ErrInvalidResetToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return User{}, ErrInvalidResetToken
+	}
+	name, exp, ok := strings.Cut(string(payload), "|")
+	if !ok {
+		return User{}, ErrInvalidResetToken
+	}
+	expires, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return User{}, ErrInvalidResetToken
+	}
+	u, err := GetUser(name)
+	if errors.Is(err, ErrUserNotFound) {
+		return User{}, ErrInvalidResetToken
+	}
+	if err != nil {
+		return User{}, err
+	}
+	if !hmac.Equal(sig, resetSignature(string(payload), u.Password)) {
+		return User{}, ErrInvalidResetToken
+	}
+	return u, nil
+}
+
+// SetResetKey sets the key password reset tokens are signed with
+func SetResetKey(key []byte) { resetKey = key }
+
+// resetSignature returns the signature of the given token payload for a user
+// with the given password hash
+func resetSignature(payload string, passwordHash []byte) []byte {
+	mac := hmac.New(sha256.New, resetKey)
+	mac.Write([]byte(payload))
+	mac.Write([]byte{0})
+	mac.Write(passwordHash)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"time"
+)
+
+var (
+	Context    context.Context
+	sessionCol *mongo.Collection
+)
+
+// ErrNoSession is returned if a session does not exist or is expired
+var ErrNoSession = errors.New("session not found or expired")
+
+// Session is a login session of a user that is stored in the database. The
+// session token itself is only known to the client; the database stores its
+// hash as the session's ID, so a leaked database does not leak usable
+// sessions.
+type Session struct {
+	ID        string    `bson:"_id" json:"id"`
+	User      string    `bson:"user" json:"user"`
+	Created   time.Time `bson:"created" json:"created"`
+	Expires   time.Time `bson:"expires" json:"expires"`
+	LastSeen  time.Time `bson:"last_seen" json:"last_seen"`
+	UserAgent string    `bson:"user_agent,omitempty" json:"user_agent,omitempty"`
+	IP        string    `bson:"ip,omitempty" json:"ip,omitempty"`
+	// Pending sessions await the second factor and do not authenticate
+	Pending bool `bson:"pending,omitempty" json:"pending,omitempty"`
+}
+
+// NewSession creates a session for the given user that expires after the given
+// duration and writes it to the database. Returns the session and the token
+// the client has to present to use the session.
+func NewSession(user string, ttl time.Duration, userAgent string, ip string) (Session, string, error) {
+	return newSession(user, ttl, userAgent, ip, false)
+}
+
+// NewPendingSession creates a pending session for the given user, which has
+// entered the correct password but still has to enter the second factor; the
+// pending session is replaced by a session when the second factor is verified
+func NewPendingSession(user string, ttl time.Duration, userAgent string, ip string) (Session, string, error) {
+	return newSession(user, ttl, userAgent, ip, true)
+}
+
+// newSession creates a session and writes it to the database
+func newSession(user string, ttl time.Duration, userAgent string, ip string, pending bool) (Session, string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return Session{}, "", err
+	}
+	now := time.Now()
+	s := Session{
+		ID:        hashToken(token),
+		User:      user,
+		Created:   now,
+		Expires:   now.Add(ttl),
+		LastSeen:  now,
+		UserAgent: userAgent,
+		IP:        ip,
+		Pending:   pending,
+	}
+	log.Println("Creating session for user:", user)
+	_, err = sessionCol.InsertOne(Context, s)
+	if err != nil {
+		return Session{}, "", err
+	}
+	return s, token, nil
+}
+
+// GetSession returns the unexpired session for the given token and updates its
+// last seen time. Returns ErrNoSession if there is no such session or the
+// session is pending.
+func GetSession(token string) (Session, error) {
+	return getSession(token, false)
+}
+
+// GetPendingSession returns the unexpired pending session for the given token.
+// Returns ErrNoSession if there is no such session.
+func GetPendingSession(token string) (Session, error) {
+	return getSession(token, true)
+}
+
+// getSession returns the unexpired session for the given token with the given
+// pending state and updates its last seen time
+func getSession(token string, pending bool) (Session, error) {
+	var s Session
+	filter := bson.M{"_id": hashToken(token), "expires": bson.M{"$gt": time.Now()}}
+	if pending {
+		filter["pending"] = true
+	} else {
+		filter["pending"] = bson.M{"$ne": true}
+	}
+	update := bson.M{"$set": bson.M{"last_seen": time.Now()}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := sessionCol.FindOneAndUpdate(Context, filter, update, opts).Decode(&s)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Session{}, ErrNoSession
+	}
+	if err != nil {
+		return Session{}, err
+	}
+	return s, nil
+}
+
+// DeleteSession deletes the session for the given token
+func DeleteSession(token string) error {
+	log.Println("Deleting session")
+	_, err := sessionCol.DeleteOne(Context, bson.M{"_id": hashToken(token)})
+	return err
+}
+
+// DeleteExpiredSessions deletes all expired sessions and returns their
+// number; expired sessions are also removed by the database, but only once a
+// minute and not at all if the expiry index is missing
+func DeleteExpiredSessions() (int64, error) {
+	res, err := sessionCol.DeleteMany(Context, bson.M{"expires": bson.M{"$lte": time.Now()}})
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
+// ListSessions lists the unexpired sessions of the given user or, if the user
+// is empty, of all users, most recently seen first
+func ListSessions(user string) ([]Session, error) {
+	filter := bson.M{"expires": bson.M{"$gt": time.Now()}, "pending": bson.M{"$ne": true}}
+	if user != "" {
+		filter["user"] = user
+	}
+	opts := options.Find().SetSort(bson.M{"last_seen": -1})
+	cursor, err := sessionCol.Find(Context, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	sessions := []Session{}
+	err = cursor.All(Context, &sessions)
+	if err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// RevokeSession deletes the session with the given ID; if the user is not
+// empty, only a session of the given user is deleted. Returns ErrNoSession if
+// there is no such session.
+func RevokeSession(id string, user string) error {
+	filter := bson.M{"_id": id}
+	if user != "" {
+		filter["user"] = user
+	}
+	log.Println("Revoking session:", id)
+	res, err := sessionCol.DeleteOne(Context, filter)
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNoSession
+	}
+	return nil
+}
+
+// SetSessionCollection sets the collection sessions are stored in and creates
+// an index removing expired sessions
+func SetSessionCollection(c *mongo.Collection) {
+	sessionCol = c
+	index := mongo.IndexModel{
+		Keys:    bson.M{"expires": 1},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+	_, err := c.Indexes().CreateOne(Context, index)
+	if err != nil {
+		log.Println("[Err] Creating session expiry index:", err)
+	}
+}
+
+// randomToken returns a random hex encoded token
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashToken returns the hex encoded SHA-256 hash of the given token
+func hashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"strings"
+	"time"
+)
+
+var tokenCol *mongo.Collection
+
+// ErrNoToken is returned if an API token does not exist
+var ErrNoToken = errors.New("token not found")
+
+// tokenPrefix is prepended to API tokens, so they are recognizable in logs and
+// secret scanners
+const tokenPrefix = "gp_"
+
+// Token is an API token that authenticates programmatic requests as its user.
+// Like sessions, the token itself is only known to the client and the
+// database stores its hash as the token's ID.
+type Token struct {
+	ID       string    `bson:"_id" json:"id"`
+	Name     string    `bson:"name" json:"name"`
+	User     string    `bson:"user" json:"user"`
+	Hint     string    `bson:"hint" json:"hint"`
+	Created  time.Time `bson:"created" json:"created"`
+	LastUsed time.Time `bson:"last_used,omitempty" json:"last_used,omitempty"`
+}
+
+// CreateToken creates an API token with the given name for the given user and
+// writes it to the database. Returns the token and the secret the client has
+// to present; the secret cannot be retrieved later.
+func CreateToken(name string, user string) (Token, string, error) {
+	if strings.TrimSpace(name) == "" {
+		return Token{}, "", errors.New("token name must not be empty")
+	}
+	secret, err := randomToken()
+	if err != nil {
+		return Token{}, "", err
+	}
+	secret = tokenPrefix + secret
+	t := Token{
+		ID:      hashToken(secret),
+		Name:    name,
+		User:    user,
+		Hint:    secret[:len(tokenPrefix)+6],
+		Created: time.Now(),
+	}
+	log.Println("Creating token for user:", user)
+	_, err = tokenCol.InsertOne(Context, t)
+	if err != nil {
+		return Token{}, "", err
+	}
+	return t, secret, nil
+}
+
+// GetToken returns the token for the given secret and updates its last used
+// time. Returns ErrNoToken if there is no such token.
+func GetToken(secret string) (Token, error) {
+	var t Token
+	update := bson.M{"$set": bson.M{"last_used": time.Now()}}
+	err := tokenCol.FindOneAndUpdate(Context, bson.M{"_id": hashToken(secret)}, update).Decode(&t)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Token{}, ErrNoToken
+	}
+	if err != nil {
+		return Token{}, err
+	}
+	return t, nil
+}
+
+// ListTokens lists the tokens of the given user or, if the user is empty, of
+// all users, newest first
+func ListTokens(user string) ([]Token, error) {
+	filter := bson.M{}
+	if user != "" {
+		filter["user"] = user
+	}
+	opts := options.Find().SetSort(bson.M{"created": -1})
+	cursor, err := tokenCol.Find(Context, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	tokens := []Token{}
+	err = cursor.All(Context, &tokens)
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// RevokeToken deletes the token with the given ID; if the user is not empty,
+// only a token of the given user is deleted. Returns ErrNoToken if there is no
+// such token.
+func RevokeToken(id string, user string) error {
+	filter := bson.M{"_id": id}
+	if user != "" {
+		filter["user"] = user
+	}
+	log.Println("Revoking token:", id)
+	res, err := tokenCol.DeleteOne(Context, filter)
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNoToken
+	}
+	return nil
+}
+
+// RevokeTokens deletes all tokens of the given user
+func RevokeTokens(user string) error {
+	log.Println("Revoking tokens of user:", user)
+	_, err := tokenCol.DeleteMany(Context, bson.M{"user": user})
+	return err
+}
+
+// DeleteIdleTokens deletes all tokens not used since the given time, which
+// expire after being idle; tokens never used expire once they were created
+// before the given time. Returns the number of deleted tokens.
+func DeleteIdleTokens(since time.Time) (int64, error) {
+	filter := bson.M{"$or": bson.A{
+		bson.M{"last_used": bson.M{"$lt": since}},
+		bson.M{"last_used": bson.M{"$exists": false}, "created": bson.M{"$lt": since}},
+	}}
+	res, err := tokenCol.DeleteMany(Context, filter)
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
+func SetTokenCollection(c *mongo.Collection) { tokenCol = c }
@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// totpPeriod is the duration of a TOTP time step
+	totpPeriod = 30
+	// totpDigits is the number of digits of TOTP codes
+	totpDigits = 6
+	// totpSkew is the number of time steps codes are accepted before and after
+	// the current time step to allow for clock drift
+	totpSkew = 1
+	// recoveryCodeCount is the number of recovery codes generated on enrollment
+	recoveryCodeCount = 10
+)
+
+var (
+	ErrInvalidCode    = errors.New("invalid code")
+	ErrTOTPNotEnabled = errors.New("second factor is not enabled")
+	ErrTOTPEnabled    = errors.New("second factor is already enabled")
+)
+
+// totpEncoding is the encoding of TOTP secrets as used by authenticator apps
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// EnrollTOTP starts the enrollment of a second factor for the user with the
+// given name; the enrollment is completed by ConfirmTOTP. Returns the
+// base32 encoded secret and the otpauth URI for authenticator apps, which
+// shows the given issuer.
+func EnrollTOTP(name string, issuer string) (string, string, error) {
+	u, err := GetUser(name)
+	if err != nil {
+		return "", "", err
+	}
+	if u.TOTPEnabled() {
+		return "", "", ErrTOTPEnabled
+	}
+	secret := make([]byte, 20)
+	_, err = rand.Read(secret)
+	if err != nil {
+		return "", "", err
+	}
+	log.Println("Enrolling second factor for user:", name)
+	_, err = userCol.UpdateOne(Context, bson.M{"_id": name}, bson.M{"$set": bson.M{"totp_pending": secret}})
+	if err != nil {
+		return "", "", err
+	}
+	encoded := totpEncoding.EncodeToString(secret)
+	q := url.Values{}
+	q.Set("secret", encoded)
+	q.Set("issuer", issuer)
+	q.Set("period", fmt.Sprint(totpPeriod))
+	q.Set("digits", fmt.Sprint(totpDigits))
+	uri := "otpauth://totp/" + url.PathEscape(issuer+":"+name) + "?" + q.Encode()
+	return encoded, uri, nil
+}
+
+// ConfirmTOTP completes the enrollment of the second factor for the user with
+// the given name if the given code matches the pending secret. Returns the
+// recovery codes, which are only stored as hashes.
+func ConfirmTOTP(name string, code string) ([]string, error) {
+	u, err := GetUser(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(u.TOTPPending) == 0 {
+		return nil, ErrTOTPNotEnabled
+	}
+	step, ok := matchTOTP(u.TOTPPending, strings.TrimSpace(code), 0)
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		_, err = rand.Read(b)
+		if err != nil {
+			return nil, err
+		}
+		c := strings.ToLower(totpEncoding.EncodeToString(b))
+		codes[i] = c[:4] + "-" + c[4:]
+		hashes[i] = hashToken(codes[i])
+	}
+	log.Println("Enabling second factor for user:", name)
+	update := bson.M{
+		"$set":   bson.M{"totp_secret": u.TOTPPending, "totp_last": step, "recovery_codes": hashes},
+		"$unset": bson.M{"totp_pending": ""},
+	}
+	_, err = userCol.UpdateOne(Context, bson.M{"_id": name}, update)
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTOTP disables the second factor of the user with the given name if
+// the given code is a valid TOTP or recovery code
+func DisableTOTP(name string, code string) error {
+	u, err := GetUser(name)
+	if err != nil {
+		return err
+	}
+	err = VerifyTOTP(u, code)
+	if err != nil {
+		return err
+	}
+	log.Println("Disabling second factor for user:", name)
+	update := bson.M{"$unset": bson.M{"totp_secret": "", "totp_pending": "", "totp_last": "", "recovery_codes": ""}}
+	_, err = userCol.UpdateOne(Context, bson.M{"_id": name}, update)
+	return err
+}
+
+// VerifyTOTP checks the given code against the second factor of the given user.
+// The code is either a TOTP code, which may only be used once, or an unused
+// recovery code, which is removed. Returns ErrInvalidCode if the code does not
+// match.
+func VerifyTOTP(u User, code string) error {
+	if !u.TOTPEnabled() {
+		return ErrTOTPNotEnabled
+	}
+	code = strings.ToLower(strings.TrimSpace(code))
+	if step, ok := matchTOTP(u.TOTPSecret, code, u.TOTPLast); ok {
+		// the filter ensures that concurrent logins cannot use the same code
+		filter := bson.M{"_id": u.Name, "totp_last": bson.M{"$lt": step}}
+		res, err := userCol.UpdateOne(Context, filter, bson.M{"$set": bson.M{"totp_last": step}})
+		if err != nil {
+			return err
+		}
+		if res.ModifiedCount == 0 {
+			return ErrInvalidCode
+		}
+		return nil
+	}
+	hash := hashToken(code)
+	for _, h := range u.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+			log.Println("Using recovery code of user:", u.Name)
+			res, err := userCol.UpdateOne(Context, bson.M{"_id": u.Name}, bson.M{"$pull": bson.M{"recovery_codes": h}})
+			if err != nil {
+				return err
+			}
+			if res.ModifiedCount == 0 {
+				return ErrInvalidCode
+			}
+			return nil
+		}
+	}
+	return ErrInvalidCode
+}
+
+// matchTOTP returns the time step the given code is valid for with the given
+// secret; only time steps after the given last used time step are accepted
+func matchTOTP(secret []byte, code string, last int64) (int64, bool) {
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	now := time.Now().Unix() / totpPeriod
+	for step := now - totpSkew; step <= now+totpSkew; step++ {
+		if step <= last {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(secret, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpCode returns the TOTP code of the given secret for the given time step as
+// specified by RFC 6238 and RFC 4226
+func totpCode(secret []byte, step int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
@@ -0,0 +1,276 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"net/mail"
+	"regexp"
+	"time"
+)
+
+var userCol *mongo.Collection
+
+var (
+	ErrUserNotFound       = errors.New("user not found")
+	ErrUserExists         = errors.New("user already exists")
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	// ErrInvalidUser is wrapped by errors about invalid user names, passwords
+	// or roles
+	ErrInvalidUser = errors.New("invalid user")
+)
+
+// userNameRegexp matches valid user names
+var userNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,64}$`)
+
+// minPasswordLength is the minimum length of user passwords
+const minPasswordLength = 8
+
+// Role is the role of a user which determines the user's permissions
+type Role string
+
+const (
+	// RoleAdmin may do everything including managing users
+	RoleAdmin Role = "admin"
+	// RoleEditor may read, upload and delete content
+	RoleEditor Role = "editor"
+	// RoleViewer may only read content and listings
+	RoleViewer Role = "viewer"
+)
+
+// Permission is a permission required for an admin action
+type Permission int
+
+const (
+	// PermRead allows listing and downloading content
+	PermRead Permission = iota
+	// PermWrite allows uploading, changing and deleting content
+	PermWrite
+	// PermAdmin allows managing users and site-wide settings
+	PermAdmin
+)
+
+// Can returns whether the role has the given permission
+func (r Role) Can(p Permission) bool {
+	switch r {
+	case RoleAdmin:
+		return true
+	case RoleEditor:
+		return p <= PermWrite
+	case RoleViewer:
+		return p == PermRead
+	default:
+		return false
+	}
+}
+
+// Valid returns whether the role is a known role
+func (r Role) Valid() bool {
+	return r == RoleAdmin || r == RoleEditor || r == RoleViewer
+}
+
+// User is a user account that is stored in the database; the password is
+// stored as bcrypt hash
+type User struct {
+	Name     string    `bson:"_id" json:"name"`
+	Role     Role      `bson:"role" json:"role"`
+	Email    string    `bson:"email,omitempty" json:"email,omitempty"`
+	Password []byte    `bson:"password" json:"-"`
+	Created  time.Time `bson:"created" json:"created"`
+	// TOTPSecret is the secret of the enabled second factor; TOTPPending is the
+	// secret of an enrollment that was not confirmed yet; TOTPLast is the last
+	// used time step, so codes cannot be replayed
+	TOTPSecret  []byte `bson:"totp_secret,omitempty" json:"-"`
+	TOTPPending []byte `bson:"totp_pending,omitempty" json:"-"`
+	TOTPLast    int64  `bson:"totp_last,omitempty" json:"-"`
+	// RecoveryCodes are the hashes of the unused recovery codes, which may be
+	// used instead of a TOTP code
+	RecoveryCodes []string `bson:"recovery_codes,omitempty" json:"-"`
+	// Actions restrict the content actions the user may perform; all actions
+	// permitted by the role are allowed if empty
+	Actions []Action `bson:"actions,omitempty" json:"actions,omitempty"`
+	// Prefixes restrict the uris of the content the user may change to the
+	// given patterns, see AllowsURI; all uris are allowed if empty
+	Prefixes []string `bson:"prefixes,omitempty" json:"prefixes,omitempty"`
+	// Managed is set for users configured in the accounts file
+	Managed bool `bson:"managed,omitempty" json:"managed,omitempty"`
+}
+
+// TOTPEnabled returns whether the user has a second factor enabled
+func (u User) TOTPEnabled() bool { return len(u.TOTPSecret) > 0 }
+
+// CreateUser creates a user with the given name, email, password and role; the
+// email is optional. Returns ErrUserExists if a user with the given name
+// already exists.
+func CreateUser(name string, email string, password string, role Role) (User, error) {
+	if !userNameRegexp.MatchString(name) {
+		return User{}, fmt.Errorf("%w: invalid name %q", ErrInvalidUser, name)
+	}
+	if err := validateEmail(email); err != nil {
+		return User{}, err
+	}
+	if !role.Valid() {
+		return User{}, fmt.Errorf("%w: invalid role %q", ErrInvalidUser, role)
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return User{}, err
+	}
+	u := User{Name: name, Role: role, Email: email, Password: hash, Created: time.Now()}
+	log.Println("Creating user:", name)
+	_, err = userCol.InsertOne(Context, u)
+	if mongo.IsDuplicateKeyError(err) {
+		return User{}, ErrUserExists
+	}
+	if err != nil {
+		return User{}, err
+	}
+	return u, nil
+}
+
+// GetUser returns the user with the given name. Returns ErrUserNotFound if
+// there is no such user.
+func GetUser(name string) (User, error) {
+	var u User
+	err := userCol.FindOne(Context, bson.M{"_id": name}).Decode(&u)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return User{}, ErrUserNotFound
+	}
+	if err != nil {
+		return User{}, err
+	}
+	return u, nil
+}
+
+// ListUsers lists all users sorted by name
+func ListUsers() ([]User, error) {
+	opts := options.Find().SetSort(bson.M{"_id": 1})
+	cursor, err := userCol.Find(Context, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	users := []User{}
+	err = cursor.All(Context, &users)
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// UpdateUser sets the email, the role and, if not empty, the password of the
+// user with the given name. Returns ErrUserNotFound if there is no such user
+// and ErrManagedUser if the user is managed by the accounts file.
+func UpdateUser(name string, email string, password string, role Role) error {
+	if err := checkManaged(name); err != nil {
+		return err
+	}
+	if !role.Valid() {
+		return fmt.Errorf("%w: invalid role %q", ErrInvalidUser, role)
+	}
+	if err := validateEmail(email); err != nil {
+		return err
+	}
+	set := bson.M{"role": role, "email": email}
+	if password != "" {
+		hash, err := hashPassword(password)
+		if err != nil {
+			return err
+		}
+		set["password"] = hash
+	}
+	log.Println("Updating user:", name)
+	res, err := userCol.UpdateOne(Context, bson.M{"_id": name}, bson.M{"$set": set})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// DeleteUser deletes the user with the given name and all of the user's
+// sessions and tokens. Returns ErrUserNotFound if there is no such user and
+// ErrManagedUser if the user is managed by the accounts file.
+func DeleteUser(name string) error {
+	if err := checkManaged(name); err != nil {
+		return err
+	}
+	return deleteUser(name)
+}
+
+// deleteUser deletes the user with the given name and all of the user's
+// sessions and tokens
+func deleteUser(name string) error {
+	log.Println("Deleting user:", name)
+	res, err := userCol.DeleteOne(Context, bson.M{"_id": name})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrUserNotFound
+	}
+	_, err = sessionCol.DeleteMany(Context, bson.M{"user": name})
+	if err != nil {
+		return err
+	}
+	_, err = tokenCol.DeleteMany(Context, bson.M{"user": name})
+	return err
+}
+
+// GetUserByEmail returns the user with the given email. Returns
+// ErrUserNotFound if there is no such user.
+func GetUserByEmail(email string) (User, error) {
+	var u User
+	if email == "" {
+		return User{}, ErrUserNotFound
+	}
+	err := userCol.FindOne(Context, bson.M{"email": email}).Decode(&u)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return User{}, ErrUserNotFound
+	}
+	if err != nil {
+		return User{}, err
+	}
+	return u, nil
+}
+
+// SetPassword sets the password of the user with the given name and deletes
+// all of the user's sessions. Returns ErrUserNotFound if there is no such
+// user and ErrManagedUser if the user is managed by the accounts file.
+func SetPassword(name string, password string) error {
+	if err := checkManaged(name); err != nil {
+		return err
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	log.Println("Setting password of user:", name)
+	res, err := userCol.UpdateOne(Context, bson.M{"_id": name}, bson.M{"$set": bson.M{"password": hash}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	_, err = sessionCol.DeleteMany(Context, bson.M{"user": name})
+	return err
+}
+
+func SetUserCollection(c *mongo.Collection) { userCol = c }
+
+// validateEmail checks whether the given email is empty or a valid address
+func validateEmail(email string) error {
+	if email == "" {
+		return nil
+	}
+	a, err := mail.ParseAddress(email)
+	if err != nil || a.Address != email {
+		return fmt.Errorf("%w: invalid email %q", ErrInvalidUser, email)
+	}
+	return nil
+}
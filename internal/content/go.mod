@@ -1,3 +0,0 @@
-module content
-
-go 1.21
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"auth"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"gopkg.in/yaml.v3"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// accountsFile is the content of the accounts file
+type accountsFile struct {
+	Accounts []auth.Account `json:"accounts" yaml:"accounts"`
+}
+
+// loadAccounts reads the accounts of the JSON or YAML accounts file at the
+// given path; the format is determined by the file's extension
+func loadAccounts(p string) ([]auth.Account, error) {
+	data, err := os.ReadFile(filepath.Clean(p))
+	if err != nil {
+		return nil, err
+	}
+	var f accountsFile
+	switch strings.ToLower(filepath.Ext(p)) {
+	case ".json":
+		d := json.NewDecoder(bytes.NewReader(data))
+		d.DisallowUnknownFields()
+		err = d.Decode(&f)
+	case ".yaml", ".yml":
+		d := yaml.NewDecoder(bytes.NewReader(data))
+		d.KnownFields(true)
+		err = d.Decode(&f)
+	default:
+		return nil, errors.New("accounts file must be a .json, .yaml or .yml file: " + p)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f.Accounts, nil
+}
+
+// syncAccountsFile syncs the users with the accounts of the accounts file set
+// by ACCOUNTS_FILE, see auth.SyncAccounts; nothing is done if it is not set
+func syncAccountsFile() error {
+	p := getEnvOrElse("ACCOUNTS_FILE", "")
+	if p == "" {
+		return nil
+	}
+	log.Println("Loading accounts from:", p)
+	accounts, err := loadAccounts(p)
+	if err != nil {
+		return err
+	}
+	return auth.SyncAccounts(accounts)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"github.com/duevil/Go_Portfolio/content"
+	"github.com/gin-gonic/gin"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// parseAsOf parses the given time of the time-travel mode, which is either an
+// RFC 3339 timestamp or a date like '2023-06-01' meaning the end of the day in
+// UTC
+func parseAsOf(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, errors.New("invalid time; must be a date like 2023-06-01 or an RFC 3339 timestamp")
+	}
+	return t.Add(24*time.Hour - time.Nanosecond), nil
+}
+
+// handleAsOf handles requests for pages as they were at the given time, like
+// '/admin/asof/2023-06-01/about.html'; pages are rendered from their revision
+// at the time, and links to other pages are rewritten, so the site can be
+// browsed at the time. Menu, settings and assets are the current ones.
+func handleAsOf(c *gin.Context) {
+	date := c.Param("date")
+	uri := c.Param("uri")
+	log.Println("Page as of requested:", date, uri)
+	at, err := parseAsOf(date)
+	if errStatus(c, http.StatusBadRequest, err) {
+		return
+	}
+	if uri == "" || strings.HasSuffix(uri, "/") {
+		uri += "index.md"
+	}
+	f, err := content.GetAt(uri, at)
+	if errNotFound(c, err) || errISE(c, err) {
+		return
+	}
+	page, err := f.RevisionPage()
+	if errISE(c, err) {
+		return
+	}
+	buf := bytes.Buffer{}
+	err = page.CreateHTML(currentTemplates(), &buf)
+	if errISE(c, err) {
+		return
+	}
+	notice := `<main>` + "\n" + `<p class="as-of">` +
+		template.HTMLEscapeString(translate(page.Lang, "as_of", at.Format(translate(page.Lang, "date")))) + "</p>"
+	html := strings.Replace(buf.String(), "<main>", notice, 1)
+	html = strings.ReplaceAll(html, `href="/`+content.URIRoot+`/`, `href="/admin/asof/`+date+`/`)
+	noCDNCache(c)
+	c.Header("X-Robots-Tag", "noindex")
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(html))
+}
+
+// handleRevisions handles requests for the revisions of the page with the
+// given uri, newest first
+func handleRevisions(c *gin.Context) {
+	uri := c.Param("uri")
+	log.Println("Revisions requested:", uri)
+	revisions, err := content.ListRevisions(uri)
+	if errISE(c, err) {
+		return
+	}
+	c.JSON(http.StatusOK, revisions)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"sort"
+)
+
+// embedded contains the default templates, static assets and the admin
+// frontend that are compiled into the binary, so the server can run without any
+// files next to it
+//
+//go:embed templates static ui
+var embedded embed.FS
+
+// assetFS returns the file system for the embedded directory with the given
+// name; if the environment variable with the given key points to an existing
+// directory, its files take precedence over the embedded ones, so single files
+// can be customized without copying the whole directory
+func assetFS(key, name string) fs.FS {
+	lower, err := fs.Sub(embedded, name)
+	checkErr(err)
+	dir := getEnvOrElse(key, "")
+	if dir == "" {
+		return lower
+	}
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		log.Println("Override directory does not exist, using embedded files:", dir)
+		return lower
+	}
+	log.Println("Using override directory for", name+":", dir)
+	return overlayFS{upper: os.DirFS(dir), lower: lower}
+}
+
+// overlayFS is a read-only file system which looks up files in the upper file
+// system first and falls back to the lower file system
+type overlayFS struct {
+	upper fs.FS
+	lower fs.FS
+}
+
+// Open opens the named file from the upper file system if it exists there,
+// else from the lower file system
+func (o overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.upper.Open(name)
+	if err == nil {
+		return f, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return o.lower.Open(name)
+}
+
+// ReadDir returns the merged directory entries of both file systems sorted by
+// filename; entries of the upper file system shadow those of the lower one
+func (o overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	upper, uErr := fs.ReadDir(o.upper, name)
+	lower, lErr := fs.ReadDir(o.lower, name)
+	if uErr != nil && lErr != nil {
+		return nil, lErr
+	}
+	entries := make(map[string]fs.DirEntry, len(upper)+len(lower))
+	for _, e := range lower {
+		entries[e.Name()] = e
+	}
+	for _, e := range upper {
+		entries[e.Name()] = e
+	}
+	list := make([]fs.DirEntry, 0, len(entries))
+	for _, e := range entries {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name() < list[j].Name() })
+	return list, nil
+}
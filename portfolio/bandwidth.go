@@ -0,0 +1,92 @@
+package main
+
+import (
+	"github.com/duevil/Go_Portfolio/content"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	// served counts the bytes served per month and uri since the last flush;
+	// the counts are only written periodically, so a crash loses at most one
+	// interval
+	served   = map[string]map[string]int64{}
+	servedMu sync.Mutex
+)
+
+// countBandwidth is the middleware counting the bytes of successful responses
+// for the requested uri
+func countBandwidth(c *gin.Context) {
+	c.Next()
+	status := c.Writer.Status()
+	if n := c.Writer.Size(); n > 0 && (status == http.StatusOK || status == http.StatusPartialContent) {
+		month := time.Now().UTC().Format(content.BandwidthMonthFormat)
+		servedMu.Lock()
+		if served[month] == nil {
+			served[month] = map[string]int64{}
+		}
+		served[month][c.Param("uri")] += int64(n)
+		servedMu.Unlock()
+	}
+}
+
+// flushBandwidth writes the counted bytes to the database; counts that could
+// not be written are kept for the next flush
+func flushBandwidth() error {
+	servedMu.Lock()
+	pending := served
+	served = map[string]map[string]int64{}
+	servedMu.Unlock()
+	for month, counts := range pending {
+		err := content.AddBandwidth(month, counts)
+		if err != nil {
+			servedMu.Lock()
+			for m, counts := range pending {
+				if served[m] == nil {
+					served[m] = map[string]int64{}
+				}
+				for uri, n := range counts {
+					served[m][uri] += n
+				}
+			}
+			servedMu.Unlock()
+			return err
+		}
+		delete(pending, month)
+	}
+	return nil
+}
+
+// statsQuery are the query parameters of the bandwidth report
+type statsQuery struct {
+	Month string `form:"month" binding:"omitempty,datetime=2006-01"`
+	Limit int    `form:"limit,default=100" binding:"min=1,max=10000"`
+}
+
+// handleStats handles requests for the bandwidth report of a month; the
+// optional query parameter 'month' selects the month as YYYY-MM, defaulting to
+// the current month, and 'limit' the maximum number of listed uris
+func handleStats(c *gin.Context) {
+	log.Println("Stats requested")
+	var q statsQuery
+	err := c.ShouldBindQuery(&q)
+	if errBind(c, err) {
+		return
+	}
+	if q.Month == "" {
+		q.Month = time.Now().UTC().Format(content.BandwidthMonthFormat)
+	}
+	// include the bytes counted since the last flush
+	err = flushBandwidth()
+	if errISE(c, err) {
+		return
+	}
+	report, err := content.GetBandwidth(q.Month, q.Limit)
+	if errISE(c, err) {
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
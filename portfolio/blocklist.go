@@ -0,0 +1,34 @@
+package main
+
+import (
+	"mime"
+	"strings"
+)
+
+// defaultBlockedMimeTypes are the mime types that are never served inline by
+// default; text/html may be added if HTML files are only uploaded as assets
+const defaultBlockedMimeTypes = "application/x-msdownload,application/x-msdos-program," +
+	"application/vnd.microsoft.portable-executable,application/x-executable,application/x-elf," +
+	"application/x-sharedlib,application/x-mach-binary,application/x-sh,application/x-bat," +
+	"application/java-archive,application/x-msi"
+
+// blockedMimeTypes are the mime types set by BLOCKED_MIME_TYPES as comma
+// separated list; files of these types are served as attachments or, if
+// BLOCKED_MIME_MODE is 'reject', rejected at upload
+var blockedMimeTypes = parseList(getEnvOrElse("BLOCKED_MIME_TYPES", defaultBlockedMimeTypes))
+
+// isBlockedMime returns whether the given mime type must not be served inline;
+// parameters like the charset are ignored
+func isBlockedMime(mimeType string) bool {
+	t, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		t = strings.ToLower(strings.TrimSpace(strings.Split(mimeType, ";")[0]))
+	}
+	return blockedMimeTypes[t]
+}
+
+// rejectBlockedMime returns whether uploads of blocked mime types are rejected
+// instead of being served as attachments
+func rejectBlockedMime() bool {
+	return getEnvOrElse("BLOCKED_MIME_MODE", "attachment") == "reject"
+}
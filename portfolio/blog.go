@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"github.com/duevil/Go_Portfolio/content"
+	"github.com/gin-gonic/gin"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// blogPage is a generated blog page listing posts
+type blogPage struct {
+	Title string
+	// Base is the path of the page without leading slash, like 'blog/page/2'
+	Base string
+	HTML string
+}
+
+// blogPageSize returns the number of posts per blog page, configured by
+// BLOG_PAGE_SIZE
+func blogPageSize() int { return max(getEnvIntOrElse("BLOG_PAGE_SIZE", 10), 1) }
+
+// listPosts returns the listed and published posts of the given files, newest
+// first
+func listPosts(files []content.MongoFile) []content.MongoFile {
+	posts := filterFiles(files, func(f *content.MongoFile) bool { return f.IsPost() && f.Listed() && f.Published() })
+	sort.SliceStable(posts, func(i, j int) bool {
+		return pageDate(posts[i]).After(pageDate(posts[j]))
+	})
+	return posts
+}
+
+// loadPosts returns the listed and published posts in the database, newest
+// first
+func loadPosts() ([]content.MongoFile, error) {
+	files, err := content.ListPosts()
+	if err != nil {
+		return nil, err
+	}
+	return listPosts(files), nil
+}
+
+// blogURL returns the path of the blog page with the given number
+func blogURL(n int) string {
+	if n <= 1 {
+		return "/blog"
+	}
+	return "/blog/page/" + strconv.Itoa(n)
+}
+
+// postYears returns the years of the given posts, newest first
+func postYears(posts []content.MongoFile) []int {
+	var years []int
+	for _, p := range posts {
+		if y := pageDate(p).Year(); len(years) == 0 || years[len(years)-1] != y {
+			years = append(years, y)
+		}
+	}
+	return years
+}
+
+// writePosts writes the given posts as list of articles with their date and
+// summary
+func writePosts(b *strings.Builder, posts []content.MongoFile) {
+	lang := content.DefaultLanguage()
+	for _, p := range posts {
+		date := pageDate(p)
+		b.WriteString(`<article class="post">` + "\n" + `<h2><a href="` + template.HTMLEscapeString(p.Link()) + `">` +
+			template.HTMLEscapeString(p.Title()) + "</a></h2>\n")
+		b.WriteString(`<p class="date"><time datetime="` + date.Format("2006-01-02") + `">` +
+			template.HTMLEscapeString(date.Format(translate(lang, "date"))) + "</time></p>\n")
+		summary := ""
+		if p.Meta != nil {
+			summary = p.Meta.Summary
+			if summary == "" {
+				summary = p.Meta.Description
+			}
+		}
+		if summary != "" {
+			b.WriteString("<p>" + template.HTMLEscapeString(summary) + "</p>\n")
+		}
+		b.WriteString("</article>\n")
+	}
+}
+
+// writeArchive writes the links to the archive pages of the given years
+func writeArchive(b *strings.Builder, years []int) {
+	if len(years) == 0 {
+		return
+	}
+	b.WriteString(`<p class="archive">` + template.HTMLEscapeString(translate(content.DefaultLanguage(), "archive")) + ":")
+	for _, y := range years {
+		s := strconv.Itoa(y)
+		b.WriteString(` <a href="/blog/` + s + `">` + s + "</a>")
+	}
+	b.WriteString("</p>\n")
+}
+
+// blogIndexPages returns the blog pages listing the given posts, newest
+// first, with blogPageSize posts per page; the first page is returned even
+// if there are no posts
+func blogIndexPages(posts []content.MongoFile) []blogPage {
+	lang := content.DefaultLanguage()
+	size := blogPageSize()
+	n := max((len(posts)+size-1)/size, 1)
+	years := postYears(posts)
+	pages := make([]blogPage, 0, n)
+	for i := 1; i <= n; i++ {
+		title := translate(lang, "blog")
+		if i > 1 {
+			title += " – " + translate(lang, "page", i)
+		}
+		b := strings.Builder{}
+		b.WriteString("<h1>" + template.HTMLEscapeString(title) + "</h1>\n")
+		writePosts(&b, posts[(i-1)*size:min(i*size, len(posts))])
+		if n > 1 {
+			var links []string
+			if i > 1 {
+				links = append(links, `<a href="`+blogURL(i-1)+`" rel="prev">`+template.HTMLEscapeString(translate(lang, "newer"))+"</a>")
+			}
+			if i < n {
+				links = append(links, `<a href="`+blogURL(i+1)+`" rel="next">`+template.HTMLEscapeString(translate(lang, "older"))+"</a>")
+			}
+			b.WriteString(`<nav class="pagination">` + strings.Join(links, " ") + "</nav>\n")
+		}
+		writeArchive(&b, years)
+		pages = append(pages, blogPage{Title: title, Base: strings.TrimPrefix(blogURL(i), "/"), HTML: b.String()})
+	}
+	return pages
+}
+
+// blogYearPages returns the archive pages listing the given posts by year,
+// newest first
+func blogYearPages(posts []content.MongoFile) []blogPage {
+	lang := content.DefaultLanguage()
+	years := postYears(posts)
+	pages := make([]blogPage, 0, len(years))
+	for _, y := range years {
+		var year []content.MongoFile
+		for _, p := range posts {
+			if pageDate(p).Year() == y {
+				year = append(year, p)
+			}
+		}
+		title := translate(lang, "posts_of", y)
+		b := strings.Builder{}
+		b.WriteString("<h1>" + template.HTMLEscapeString(title) + "</h1>\n")
+		writePosts(&b, year)
+		writeArchive(&b, years)
+		pages = append(pages, blogPage{Title: title, Base: "blog/" + strconv.Itoa(y), HTML: b.String()})
+	}
+	return pages
+}
+
+// handleBlog handles requests for the first blog page
+func handleBlog(c *gin.Context) {
+	log.Println("Blog requested")
+	serveBlogPage(c, 1)
+}
+
+// handleBlogPage handles requests for the blog page with the given number;
+// the first page is redirected to '/blog'
+func handleBlogPage(c *gin.Context) {
+	n, err := strconv.Atoi(c.Param("page"))
+	log.Println("Blog page requested:", c.Param("page"))
+	if err != nil || n < 1 {
+		errNotFound(c, content.ErrNotFound)
+		return
+	}
+	if n == 1 {
+		c.Redirect(http.StatusMovedPermanently, blogURL(1))
+		return
+	}
+	serveBlogPage(c, n)
+}
+
+// serveBlogPage responds with the blog page with the given number; responds
+// with status 404 if there is no such page
+func serveBlogPage(c *gin.Context, n int) {
+	posts, err := loadPosts()
+	if errISE(c, err) {
+		return
+	}
+	pages := blogIndexPages(posts)
+	if n > len(pages) {
+		errNotFound(c, content.ErrNotFound)
+		return
+	}
+	p := pages[n-1]
+	renderGenerated(c, p.Title, p.Base, p.HTML)
+}
+
+// handleBlogYear handles requests for the archive page of the given year;
+// responds with status 404 if there are no posts of the year
+func handleBlogYear(c *gin.Context) {
+	year := c.Param("year")
+	log.Println("Blog archive requested:", year)
+	posts, err := loadPosts()
+	if errISE(c, err) {
+		return
+	}
+	for _, p := range blogYearPages(posts) {
+		if p.Base == "blog/"+year {
+			renderGenerated(c, p.Title, p.Base, p.HTML)
+			return
+		}
+	}
+	errNotFound(c, content.ErrNotFound)
+}
+
+// blogHook is an export hook writing the blog pages and the archive pages
+// into the export; every page is written as 'index.html' into the directory
+// of its path, so it is served at the same path as by the server
+type blogHook struct{}
+
+func (blogHook) Name() string { return "blog" }
+
+func (blogHook) Run(dir string, _ string, files []content.MongoFile) error {
+	posts := listPosts(files)
+	if len(posts) == 0 {
+		return nil
+	}
+	for _, p := range append(blogIndexPages(posts), blogYearPages(posts)...) {
+		page := newPage(p.Title, p.Base)
+		page.Content = template.HTML(p.HTML)
+		buf := bytes.Buffer{}
+		err := page.CreateHTML(currentTemplates(), &buf)
+		if err != nil {
+			return err
+		}
+		name := filepath.Join(dir, filepath.FromSlash(p.Base), "index.html")
+		err = os.MkdirAll(filepath.Dir(name), 0o755)
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(name, buf.Bytes(), 0o644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errCircuitOpen is returned instead of querying the database while the
+// database circuit is open
+var errCircuitOpen = errors.New("database circuit is open")
+
+// circuit breaker states
+const (
+	circuitClosed   = "closed"
+	circuitOpen     = "open"
+	circuitHalfOpen = "half-open"
+)
+
+// circuitBreaker stops calls to a failing dependency; after threshold
+// consecutive failures the circuit opens and calls fail immediately. After the
+// cooldown a single probe call is let through, which closes the circuit on
+// success and reopens it on failure.
+type circuitBreaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+	mu        sync.Mutex
+	state     string
+	failures  int
+	opened    time.Time
+	probing   bool
+}
+
+// dbBreaker guards the database queries of requests for content; set by
+// BREAKER_THRESHOLD and BREAKER_COOLDOWN
+var dbBreaker = &circuitBreaker{
+	name:      "database",
+	threshold: getEnvIntOrElse("BREAKER_THRESHOLD", 5),
+	cooldown:  getEnvDurationOrElse("BREAKER_COOLDOWN", 30*time.Second),
+	state:     circuitClosed,
+}
+
+// do calls the given function unless the circuit is open, in which case
+// errCircuitOpen is returned, and records its result
+func (b *circuitBreaker) do(f func() error) error {
+	if !b.allow() {
+		return errCircuitOpen
+	}
+	err := f()
+	b.record(err)
+	return err
+}
+
+// allow returns whether a call may be made; once the cooldown of an open
+// circuit elapsed, only a single probe call is allowed until its result is
+// recorded
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case circuitOpen:
+		if time.Since(b.opened) < b.cooldown {
+			return false
+		}
+		b.setState(circuitHalfOpen)
+		b.probing = true
+		return true
+	case circuitHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return true
+}
+
+// record records the result of a call; only errors indicating that the
+// dependency is unavailable count as failures
+func (b *circuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if !dbFailure(err) {
+		b.failures = 0
+		if b.state != circuitClosed {
+			b.setState(circuitClosed)
+		}
+		return
+	}
+	b.failures++
+	if b.state == circuitHalfOpen || b.failures >= b.threshold {
+		b.opened = time.Now()
+		if b.state != circuitOpen {
+			b.setState(circuitOpen)
+		}
+	}
+}
+
+// setState sets the state of the circuit; must be called with the lock held
+func (b *circuitBreaker) setState(state string) {
+	log.Println("Circuit of", b.name, "changed from", b.state, "to", state)
+	b.state = state
+}
+
+// status returns the state of the circuit, the number of consecutive failures
+// and the time until a probe call is allowed
+func (b *circuitBreaker) status() (string, int, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var retry time.Duration
+	if b.state == circuitOpen {
+		retry = max(b.cooldown-time.Since(b.opened), 0)
+	}
+	return b.state, b.failures, retry
+}
+
+// dbFailure returns whether the given error indicates that the database is not
+// available, as opposed to errors like missing documents
+func dbFailure(err error) bool {
+	return err != nil && (mongo.IsNetworkError(err) || mongo.IsTimeout(err) ||
+		errors.Is(err, mongo.ErrClientDisconnected) || errors.Is(err, context.DeadlineExceeded))
+}
+
+// staleEntry is a recorded response of the stale cache
+type staleEntry struct {
+	key    string
+	header http.Header
+	body   []byte
+	stored time.Time
+}
+
+// staleCache keeps the most recently used successful responses for content,
+// so they can be served while the database is not available
+type staleCache struct {
+	mu      sync.Mutex
+	max     int
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+var (
+	// stale holds the responses served while the database is not available;
+	// its size in bytes is set by STALE_CACHE_SIZE
+	stale = &staleCache{
+		max:     getEnvIntOrElse("STALE_CACHE_SIZE", 32<<20),
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+	}
+	// maxStaleBody is the maximum size of a response body that is kept in the
+	// stale cache set by STALE_CACHE_MAX_BODY
+	maxStaleBody = getEnvIntOrElse("STALE_CACHE_MAX_BODY", 1<<20)
+)
+
+// staleHeaders are the response headers that are kept in the stale cache
+var staleHeaders = []string{"Content-Type", "Content-Disposition", "X-Content-Type-Options", "X-Robots-Tag", "Vary",
+	"ETag", "Last-Modified"}
+
+// get returns the response stored for the given key
+func (s *staleCache) get(key string) (staleEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok {
+		return staleEntry{}, false
+	}
+	s.order.MoveToFront(e)
+	return e.Value.(staleEntry), true
+}
+
+// put stores the given response, evicting the least recently used responses
+// if the cache exceeds its size
+func (s *staleCache) put(entry staleEntry) {
+	if len(entry.body) > s.max {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[entry.key]; ok {
+		s.size -= len(e.Value.(staleEntry).body)
+		s.order.Remove(e)
+	}
+	s.entries[entry.key] = s.order.PushFront(entry)
+	s.size += len(entry.body)
+	for s.size > s.max {
+		oldest := s.order.Back()
+		old := s.order.Remove(oldest).(staleEntry)
+		delete(s.entries, old.key)
+		s.size -= len(old.body)
+	}
+}
+
+// staleKey returns the key of the response to the given request; responses
+// are negotiated by the Accept header
+func staleKey(c *gin.Context) string {
+	return c.Request.URL.Path + "\n" + c.GetHeader("Accept")
+}
+
+// keepStale records successful public responses in the stale cache; responses
+// that must not be stored, like those of private files and previews, are not
+// recorded
+func keepStale(c *gin.Context) {
+	if c.Request.Method != http.MethodGet || c.Request.URL.RawQuery != "" {
+		c.Next()
+		return
+	}
+	w := &recordingWriter{ResponseWriter: c.Writer, limit: maxStaleBody}
+	c.Writer = w
+	c.Next()
+	c.Writer = w.ResponseWriter
+	cacheControl := w.Header().Get("Cache-Control")
+	if w.overflow || w.Status() != http.StatusOK || w.Header().Get("Set-Cookie") != "" ||
+		strings.Contains(cacheControl, "private") || strings.Contains(cacheControl, "no-store") {
+		return
+	}
+	header := http.Header{}
+	for _, name := range staleHeaders {
+		if v := w.Header().Values(name); len(v) > 0 {
+			header[name] = v
+		}
+	}
+	stale.put(staleEntry{key: staleKey(c), header: header, body: w.body.Bytes(), stored: time.Now()})
+}
+
+// errUnavailable checks whether the given error indicates that the database is
+// not available; if so, the recorded response to the request is served marked
+// as stale or, if there is none, the request is aborted with status 503 and a
+// Retry-After header
+func errUnavailable(c *gin.Context, err error) bool {
+	if !errors.Is(err, errCircuitOpen) && !dbFailure(err) {
+		return false
+	}
+	log.Println("[Err] Database unavailable:", err)
+	noCDNCache(c)
+	if e, ok := stale.get(staleKey(c)); ok && c.Request.URL.RawQuery == "" {
+		log.Println("Serving stale response:", c.Request.URL.Path)
+		for name, values := range e.header {
+			c.Writer.Header()[name] = values
+		}
+		c.Header("Age", strconv.Itoa(int(time.Since(e.stored).Seconds())))
+		c.Header("Warning", `110 - "Response is Stale"`)
+		c.Header("X-Cache", "STALE")
+		c.Status(http.StatusOK)
+		_, _ = c.Writer.Write(e.body)
+		c.Abort()
+		return true
+	}
+	_, _, retry := dbBreaker.status()
+	c.Header("Retry-After", strconv.Itoa(max(int(retry.Seconds()), 1)))
+	_ = c.AbortWithError(http.StatusServiceUnavailable, err)
+	return true
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"github.com/duevil/Go_Portfolio/content"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// bulk actions
+const (
+	bulkDelete     = "delete"
+	bulkRetag      = "retag"
+	bulkSetStatus  = "set-status"
+	bulkMovePrefix = "move-prefix"
+)
+
+var (
+	// errNotAPage is returned by bulk actions on pages for other files
+	errNotAPage = errors.New("file is not a page")
+	// errURIDenied is returned by bulk actions for uris the user may not change
+	errURIDenied = errors.New("permission denied")
+	// errPrefixMismatch is returned by the move-prefix action for uris without
+	// the prefix
+	errPrefixMismatch = errors.New("uri does not start with the prefix")
+)
+
+// bulkRequest is the request body of a bulk operation; the action is performed
+// on every file of the list. Retagging adds the tags AddTags and removes the
+// tags RemoveTags from pages, setting the status sets Status, and moving
+// assets replaces the prefix From of their uris by To.
+type bulkRequest struct {
+	Action     string   `json:"action" binding:"required,oneof=delete retag set-status move-prefix"`
+	URIs       []string `json:"uris" binding:"required,min=1,max=1000,dive,required"`
+	AddTags    []string `json:"add_tags" binding:"max=100,dive,max=128"`
+	RemoveTags []string `json:"remove_tags" binding:"max=100,dive,max=128"`
+	Status     string   `json:"status" binding:"required_if=Action set-status,omitempty,oneof=draft review published archived"`
+	From       string   `json:"from" binding:"required_if=Action move-prefix"`
+	To         string   `json:"to" binding:"required_if=Action move-prefix"`
+}
+
+// bulkResult is the result of a bulk action for a single file; the status is
+// the response status the action would have had as single request, To is the
+// new uri of moved files
+type bulkResult struct {
+	URI    string `json:"uri"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+	To     string `json:"to,omitempty"`
+}
+
+// bulkStatus returns the response status for the given error of a bulk action
+func bulkStatus(err error) int {
+	var ue *uploadError
+	switch {
+	case err == nil:
+		return http.StatusOK
+	case errors.As(err, &ue):
+		return ue.status
+	case errors.Is(content.ErrNotFound, err):
+		return http.StatusNotFound
+	case errors.Is(err, errURIDenied):
+		return http.StatusForbidden
+	case errors.Is(err, content.ErrExists):
+		return http.StatusConflict
+	case errors.Is(err, errNotAPage), errors.Is(err, errPrefixMismatch), errors.Is(err, content.ErrNotAnAsset),
+		errors.Is(err, content.ErrInvalidStatus):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// handleBulk handles requests to perform an action on a list of files; the
+// actions are 'delete', 'retag', 'set-status' and 'move-prefix'. Each file is
+// handled independently, so a failure does not stop the others; the response
+// reports the result for each file in the order of the request.
+func handleBulk(c *gin.Context) {
+	var req bulkRequest
+	err := c.ShouldBindJSON(&req)
+	if errBind(c, err) {
+		return
+	}
+	log.Println("Bulk operation requested:", req.Action, len(req.URIs), "files")
+	results := make([]bulkResult, 0, len(req.URIs))
+	failed := 0
+	for _, uri := range req.URIs {
+		r := bulkResult{URI: uri}
+		r.To, err = bulkAction(c, req, uri)
+		r.Status = bulkStatus(err)
+		if err != nil {
+			log.Println("[Err] Bulk", req.Action, "failed:", uri, err)
+			r.Error = err.Error()
+			if r.Status == http.StatusInternalServerError {
+				// internal errors are not exposed
+				r.Error = http.StatusText(r.Status)
+			}
+			failed++
+		}
+		results = append(results, r)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"action":    req.Action,
+		"results":   results,
+		"succeeded": len(results) - failed,
+		"failed":    failed,
+	})
+}
+
+// bulkAction performs the action of the given request on the file with the
+// given uri; returns the new uri of moved files
+func bulkAction(c *gin.Context, req bulkRequest, uri string) (string, error) {
+	if !uriAllowed(c, uri) {
+		return "", errURIDenied
+	}
+	f, err := content.GetFromDB(uri)
+	if err == nil && !f.Public() {
+		err = content.ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	switch req.Action {
+	case bulkDelete:
+		err = f.Delete()
+		if err == nil {
+			err = deleteVariants(f)
+		}
+	case bulkRetag:
+		err = retagPage(f, req.AddTags, req.RemoveTags)
+	case bulkSetStatus:
+		err = setFileStatus(f, req.Status)
+	case bulkMovePrefix:
+		return bulkMove(c, f, req.From, req.To)
+	}
+	if err != nil {
+		return "", err
+	}
+	contentChanged(f.URI)
+	return "", nil
+}
+
+// bulkMove moves the given asset to the uri with the given prefix replaced,
+// see moveAsset; returns the new uri
+func bulkMove(c *gin.Context, f content.MongoFile, from string, to string) (string, error) {
+	from = strings.TrimSuffix(from, "/") + "/"
+	rest, ok := strings.CutPrefix(f.URI, from)
+	if !ok {
+		return "", errPrefixMismatch
+	}
+	dest, err := sanitizePath(strings.TrimPrefix(strings.TrimSuffix(to, "/")+"/"+rest, "/"))
+	if err != nil {
+		return "", err
+	}
+	if !uriAllowed(c, dest) {
+		return "", errURIDenied
+	}
+	_, err = moveAsset(f, dest, false)
+	if err != nil {
+		return "", err
+	}
+	return dest, nil
+}
+
+// retagPage adds the given tags to the front matter of the given page and
+// removes the given tags from it; tags are matched as described at tagKey, so
+// the labels of kept tags do not change
+func retagPage(f content.MongoFile, add []string, remove []string) error {
+	if !f.IsMD {
+		return errNotAPage
+	}
+	removed := map[string]bool{}
+	for _, t := range remove {
+		removed[tagKey(t)] = true
+	}
+	var tags []string
+	seen := map[string]bool{}
+	for _, t := range append(f.Tags(), add...) {
+		key := tagKey(t)
+		if key == "" || removed[key] || seen[key] {
+			continue
+		}
+		seen[key] = true
+		tags = append(tags, strings.TrimSpace(t))
+	}
+	md, err := readFile(f)
+	if err != nil {
+		return err
+	}
+	md, err = content.SetTags(md, tags)
+	if err != nil {
+		return &uploadError{status: http.StatusUnprocessableEntity, file: f.URI, msg: "invalid front matter: " + err.Error()}
+	}
+	log.Println("Retagging page:", f.URI, tags)
+	page := content.NewMarkdownFile(f.URI, int64(len(md)), time.Now())
+	return page.Store(bytes.NewReader(md))
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/duevil/Go_Portfolio/content"
+	"github.com/gin-gonic/gin"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// bundleManifestName is the name of the manifest within a page bundle
+	bundleManifestName = "bundle.json"
+	// bundleFilesDir is the directory of the files within a page bundle
+	bundleFilesDir = "files"
+	// bundleVersion is the version of the bundle format
+	bundleVersion = 1
+)
+
+// bundleManifest describes a page bundle, which is a zip file containing a
+// markdown page and the assets it references, so the page can be moved to
+// another instance; the files are stored below bundleFilesDir at their uris
+type bundleManifest struct {
+	Version  int          `json:"version"`
+	Page     string       `json:"page"`
+	Exported time.Time    `json:"exported"`
+	Files    []bundleFile `json:"files"`
+}
+
+// bundleFile is a file of a page bundle; the hash is the hex encoded SHA-256
+// hash of the file's content
+type bundleFile struct {
+	URI  string `json:"uri"`
+	Mime string `json:"mimetype"`
+	Size int64  `json:"size"`
+	Hash string `json:"sha256"`
+}
+
+// bundleEntry returns the name of the file with the given uri within a page
+// bundle
+func bundleEntry(uri string) string {
+	return path.Join(bundleFilesDir, uri)
+}
+
+// bundleAssets returns the uris of the assets the given markdown references;
+// links to pages and to other routes are not included, as are assets which
+// are not found
+func bundleAssets(md []byte) ([]string, error) {
+	var uris []string
+	for _, l := range extractLinks(md) {
+		u, err := url.Parse(l)
+		if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" {
+			continue
+		}
+		uri, _, ok := contentURI(u.Path)
+		if !ok || path.Ext(uri) == ".html" || path.Ext(uri) == ".md" {
+			continue
+		}
+		f, err := content.GetFromDB(uri)
+		if errors.Is(content.ErrNotFound, err) || (err == nil && f.IsMD) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		uris = append(uris, uri)
+	}
+	return uris, nil
+}
+
+// readBundleFile reads the content of the given file and returns it along
+// with its bundle description
+func readBundleFile(f content.MongoFile) ([]byte, bundleFile, error) {
+	data, err := readFile(f)
+	if err != nil {
+		return nil, bundleFile{}, err
+	}
+	sum := sha256.Sum256(data)
+	return data, bundleFile{URI: f.URI, Mime: f.Mime, Size: int64(len(data)), Hash: hex.EncodeToString(sum[:])}, nil
+}
+
+// handleBundleExport handles requests for exporting the markdown page with the
+// given uri as page bundle; the uri may have the extension '.html' instead of
+// '.md'. The bundle contains the page with its front matter and all assets it
+// references.
+func handleBundleExport(c *gin.Context) {
+	uri := c.Param("uri")
+	uri = strings.TrimSuffix(uri, path.Ext(uri)) + ".md"
+	log.Println("Bundle export requested:", uri)
+	page, err := content.GetFromDB(uri)
+	if err == nil && !page.IsMD {
+		err = content.ErrNotFound
+	}
+	if errNotFound(c, err) || errISE(c, err) {
+		return
+	}
+	md, err := page.Markdown()
+	if errISE(c, err) {
+		return
+	}
+	assets, err := bundleAssets(md)
+	if errISE(c, err) {
+		return
+	}
+
+	// collect files; the bundle is small, so it is built in memory and errors
+	// can still be reported
+	m := bundleManifest{Version: bundleVersion, Page: uri, Exported: time.Now()}
+	data := map[string][]byte{}
+	for _, a := range append([]string{uri}, assets...) {
+		f := page
+		if a != uri {
+			f, err = content.GetFromDB(a)
+			if errISE(c, err) {
+				return
+			}
+		}
+		d, bf, err := readBundleFile(f)
+		if errISE(c, err) {
+			return
+		}
+		data[a] = d
+		m.Files = append(m.Files, bf)
+	}
+	buf := bytes.Buffer{}
+	w := zip.NewWriter(&buf)
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if errISE(c, err) {
+		return
+	}
+	err = writeZipEntry(w, bundleManifestName, manifest)
+	for _, f := range m.Files {
+		if err == nil {
+			err = writeZipEntry(w, bundleEntry(f.URI), data[f.URI])
+		}
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if errISE(c, err) {
+		return
+	}
+	name := strings.TrimSuffix(path.Base(uri), ".md") + ".zip"
+	c.Header("Content-Disposition", `attachment; filename="`+name+`"`)
+	c.Data(http.StatusOK, "application/zip", buf.Bytes())
+}
+
+// writeZipEntry writes the given data as file with the given name to the given
+// zip writer
+func writeZipEntry(w *zip.Writer, name string, data []byte) error {
+	fw, err := w.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: exportTime()})
+	if err != nil {
+		return err
+	}
+	_, err = fw.Write(data)
+	return err
+}
+
+// handleBundleImport handles requests for importing a page bundle exported by
+// handleBundleExport; the page and its assets are stored at their uris. Files
+// already existing with the same content are skipped; files existing with
+// other content are only replaced if the query parameter 'overwrite' is
+// 'true', else the import is rejected before any file is stored. Like uploads,
+// the files may be staged and are quarantined if required.
+//
+// Like handleUpload, the auth middleware is called manually after the uploaded
+// file has been saved
+func handleBundleImport(c *gin.Context, auth gin.HandlerFunc) {
+	log.Println("Bundle import requested")
+	ff, ok := formFile(c)
+	if !ok {
+		return
+	}
+	err := checkUploadSize(ff.Filename, ff.Size)
+	if errUpload(c, err) {
+		return
+	}
+
+	// create tmp dir and save file
+	dir, err := makeScratchDir("bundle", ff.Size)
+	if errStorage(c, err) || errISE(c, err) {
+		return
+	}
+	defer func(path string) { _ = os.RemoveAll(path) }(dir)
+	fPath := path.Join(dir, "bundle.zip")
+	err = c.SaveUploadedFile(ff, fPath)
+	if errISE(c, err) {
+		return
+	}
+
+	// check credentials
+	auth(c)
+	if c.IsAborted() {
+		return
+	}
+
+	// scan the bundle before anything of it is stored
+	f, err := os.Open(fPath)
+	if errISE(c, err) {
+		return
+	}
+	defer cls(f)
+	err = scanUpload(f, ff.Filename, ff.Size, true)
+	if errUpload(c, err) || errISE(c, err) {
+		return
+	}
+
+	// read and check the bundle
+	m, files, err := readBundle(f, ff.Size)
+	if errUpload(c, err) || errStatus(c, http.StatusBadRequest, err) {
+		return
+	}
+	u := newUploader(c)
+	overwrite := c.Query("overwrite") == "true"
+	var store, skipped []string
+	var conflicts []string
+	for _, bf := range m.Files {
+		existing, err := content.GetFromDB(u.storedURI(bf.URI))
+		if errors.Is(content.ErrNotFound, err) {
+			store = append(store, bf.URI)
+			continue
+		}
+		if errISE(c, err) {
+			return
+		}
+		if existing.Hash == bf.Hash {
+			skipped = append(skipped, bf.URI)
+			continue
+		}
+		conflicts = append(conflicts, bf.URI)
+		store = append(store, bf.URI)
+	}
+	if len(conflicts) > 0 && !overwrite {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "files exist with other content", "files": conflicts})
+		return
+	}
+
+	// store files; the page is stored last, so its assets exist once it is
+	// published
+	types := map[string]string{}
+	for _, bf := range m.Files {
+		types[bf.URI] = bf.Mime
+	}
+	stored := []string{}
+	for i := len(store) - 1; i >= 0; i-- {
+		uri := store[i]
+		mime := types[uri]
+		if ok, t := checkMimeType(path.Ext(uri)); ok {
+			mime = t
+		}
+		p := content.NewAsset(uri, mime, int64(len(files[uri])), time.Now())
+		p.IsMD = uri == m.Page
+		err = u.store(p, bytes.NewReader(files[uri]))
+		if errUpload(c, err) || errISE(c, err) {
+			return
+		}
+		stored = append(stored, uri)
+	}
+	if skipped == nil {
+		skipped = []string{}
+	}
+	c.Header("Location", u.location(strings.TrimSuffix(m.Page, ".md")+".html"))
+	c.JSON(u.status(), gin.H{"page": m.Page, "files": stored, "skipped": skipped})
+}
+
+// readBundle reads the manifest and the files of the page bundle read from the
+// given reader; returns an error if the bundle is invalid, which is the case
+// if a file is missing or does not match the manifest, or if the page is not
+// the first file of the bundle; rejected uris and sizes are reported as
+// uploadError
+func readBundle(r io.ReaderAt, size int64) (bundleManifest, map[string][]byte, error) {
+	var m bundleManifest
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return m, nil, err
+	}
+	if len(zr.File) > maxArchiveEntries+1 {
+		return m, nil, errors.New("bundle contains too many files")
+	}
+	entries := map[string]*zip.File{}
+	for _, zf := range zr.File {
+		entries[zf.Name] = zf
+	}
+	zf, ok := entries[bundleManifestName]
+	if !ok {
+		return m, nil, errors.New("bundle has no manifest")
+	}
+	data, err := readZipFile(zf)
+	if err == nil {
+		err = json.Unmarshal(data, &m)
+	}
+	if err != nil {
+		return m, nil, errors.New("invalid bundle manifest: " + err.Error())
+	}
+	if m.Version != bundleVersion {
+		return m, nil, errors.New("unsupported bundle version")
+	}
+	if len(m.Files) == 0 || m.Files[0].URI != m.Page || path.Ext(m.Page) != ".md" {
+		return m, nil, errors.New("bundle does not start with its page")
+	}
+	files := map[string][]byte{}
+	for _, bf := range m.Files {
+		uri, err := sanitizePath(strings.TrimPrefix(bf.URI, "/"))
+		if err != nil {
+			return m, nil, err
+		}
+		if uri != bf.URI {
+			return m, nil, errors.New("invalid uri in bundle: " + bf.URI)
+		}
+		err = checkUploadSize(uri, bf.Size)
+		if err != nil {
+			return m, nil, err
+		}
+		zf, ok := entries[bundleEntry(uri)]
+		if !ok {
+			return m, nil, errors.New("file missing in bundle: " + uri)
+		}
+		data, err := readZipFile(zf)
+		if err != nil {
+			return m, nil, err
+		}
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != bf.Hash {
+			return m, nil, errors.New("file does not match the manifest: " + uri)
+		}
+		files[uri] = data
+	}
+	return m, files, nil
+}
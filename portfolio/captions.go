@@ -0,0 +1,97 @@
+package main
+
+import (
+	"archive/zip"
+	"github.com/duevil/Go_Portfolio/content"
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+	"log"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+)
+
+// maxSidecarSize is the maximum size of a caption sidecar file; larger files
+// are stored as they are
+const maxSidecarSize = 64 << 10
+
+// imageCaption is the alt text and caption of an image read from a sidecar
+// file
+type imageCaption struct {
+	Alt     string `yaml:"alt" json:"alt" binding:"max=1024"`
+	Caption string `yaml:"caption" json:"caption" binding:"max=4096"`
+}
+
+// sidecarExts are the extensions of caption sidecar files; text files contain
+// the caption, YAML files the fields of imageCaption
+var sidecarExts = []string{".txt", ".yaml", ".yml"}
+
+// zipCaptions reads the captions of the images of the given zip file from
+// their sidecar files, which are named like the image with or without its
+// extension and one of sidecarExts, like 'photo.txt' or 'photo.jpg.yaml';
+// returns the captions by the names of the images, and the names of the
+// sidecar files, which are not stored
+func zipCaptions(files []*zip.File) (map[string]imageCaption, map[string]bool, error) {
+	// images by their names with and without extension
+	images := map[string]string{}
+	for _, zf := range files {
+		ext := path.Ext(zf.Name)
+		if ok, mime := checkMimeType(ext); ok && strings.HasPrefix(mime, "image/") {
+			images[zf.Name] = zf.Name
+			images[strings.TrimSuffix(zf.Name, ext)] = zf.Name
+		}
+	}
+	captions := map[string]imageCaption{}
+	sidecars := map[string]bool{}
+	for _, zf := range files {
+		ext := strings.ToLower(path.Ext(zf.Name))
+		image, ok := images[strings.TrimSuffix(zf.Name, path.Ext(zf.Name))]
+		if !ok || !slices.Contains(sidecarExts, ext) || zf.UncompressedSize64 > maxSidecarSize {
+			continue
+		}
+		data, err := readZipFile(zf)
+		if err != nil {
+			return nil, nil, err
+		}
+		c := captions[image]
+		if ext == ".txt" {
+			c.Caption = strings.TrimSpace(string(content.NormalizeEOL(data)))
+		} else {
+			var fields imageCaption
+			err = yaml.Unmarshal(data, &fields)
+			if err != nil {
+				return nil, nil, &uploadError{status: http.StatusBadRequest, code: "invalid_sidecar", file: zf.Name,
+					msg: "invalid caption file: " + zf.Name}
+			}
+			c.Alt = strings.TrimSpace(fields.Alt)
+			if fields.Caption != "" {
+				c.Caption = strings.TrimSpace(fields.Caption)
+			}
+		}
+		log.Println("Read caption of image from:", image, zf.Name)
+		captions[image] = c
+		sidecars[zf.Name] = true
+	}
+	return captions, sidecars, nil
+}
+
+// handleCaption handles requests to set the alt text and the caption of the
+// image with the given uri; the request body contains both, empty values
+// remove them. Pages showing the image get the new caption once rendered
+// again.
+func handleCaption(c *gin.Context) {
+	uri := c.Param("uri")
+	log.Println("Caption update requested:", uri)
+	var req imageCaption
+	err := c.ShouldBindJSON(&req)
+	if errBind(c, err) || !allowURI(c, uri) {
+		return
+	}
+	req.Alt, req.Caption = strings.TrimSpace(req.Alt), strings.TrimSpace(req.Caption)
+	err = content.SetCaption(uri, req.Alt, req.Caption)
+	if errNotFound(c, err) || errISE(c, err) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"uri": uri, "alt": req.Alt, "caption": req.Caption})
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// surrogate keys shared by many responses
+const (
+	// keyMenu tags responses rendering the navigation menu or the footer
+	// columns of the settings
+	keyMenu = "menu"
+	// keySettings tags responses rendering the other settings
+	keySettings = "settings"
+	// keyPages tags responses listing the pages, like the sitemap and feeds
+	keyPages = "pages"
+	// keyStatic tags static files
+	keyStatic = "static"
+)
+
+// cdnProvider purges cached responses of a CDN fronting the site by their
+// surrogate keys
+type cdnProvider interface {
+	Name() string
+	// PurgeKeys purges all responses tagged with one of the given keys
+	PurgeKeys(keys []string) error
+	// KeyHeader is the response header the CDN reads surrogate keys from
+	KeyHeader() string
+	// KeySeparator separates multiple keys in the key header
+	KeySeparator() string
+}
+
+// cdn is the provider set by CDN_PROVIDER, which is either 'cloudflare',
+// 'fastly' or 'bunny'; nil if no CDN is configured
+var cdn = newCDNProvider(getEnvOrElse("CDN_PROVIDER", ""))
+
+// cdnClient is the client purge requests are sent with
+var cdnClient = &http.Client{Timeout: 30 * time.Second}
+
+var (
+	// purgeMu guards purgeKeys
+	purgeMu sync.Mutex
+	// purgeKeys are the surrogate keys of changed responses awaiting a purge
+	purgeKeys = map[string]bool{}
+)
+
+// newCDNProvider returns the provider with the given name configured by the
+// CDN_* variables; returns nil if the name is empty
+func newCDNProvider(name string) cdnProvider {
+	token := getEnvOrElse("CDN_API_TOKEN", "")
+	switch strings.ToLower(name) {
+	case "":
+		return nil
+	case "cloudflare":
+		return cloudflareCDN{zone: getEnvOrElse("CDN_ZONE_ID", ""), token: token}
+	case "fastly":
+		return fastlyCDN{service: getEnvOrElse("CDN_SERVICE_ID", ""), token: token}
+	case "bunny":
+		return bunnyCDN{zone: getEnvOrElse("CDN_ZONE_ID", ""), token: token}
+	}
+	log.Println("[Err] Unknown CDN_PROVIDER, purging is disabled:", name)
+	return nil
+}
+
+// fileKey returns the surrogate key of the file with the given uri; markdown
+// files have the same key as the HTML page they are served as
+func fileKey(uri string) string {
+	if path.Ext(uri) == ".html" {
+		uri = strings.TrimSuffix(uri, ".html") + ".md"
+	}
+	return "file:" + url.PathEscape(strings.TrimPrefix(uri, "/"))
+}
+
+// purgeCDN queues the surrogate keys of the files with the given uris to be
+// purged by the next run of the 'cdn-purge' job; changed pages also change
+// the responses listing the pages and the menu
+func purgeCDN(uris ...string) {
+	keys := make([]string, 0, len(uris)+2)
+	for _, uri := range uris {
+		keys = append(keys, fileKey(uri))
+		if path.Ext(uri) == ".md" {
+			keys = append(keys, keyPages, keyMenu)
+		}
+	}
+	purgeCDNKeys(keys...)
+}
+
+// purgeCDNKeys queues the given surrogate keys to be purged by the next run of
+// the 'cdn-purge' job
+func purgeCDNKeys(keys ...string) {
+	if cdn == nil {
+		return
+	}
+	purgeMu.Lock()
+	defer purgeMu.Unlock()
+	for _, k := range keys {
+		purgeKeys[k] = true
+	}
+}
+
+// scheduleCDNPurge schedules the job purging the queued keys every
+// CDN_PURGE_INTERVAL, so bulk uploads are purged in few requests
+func scheduleCDNPurge() {
+	if cdn == nil {
+		return
+	}
+	log.Println("Purging changed content from CDN:", cdn.Name())
+	scheduleJob("cdn-purge", getEnvDurationOrElse("CDN_PURGE_INTERVAL", 5*time.Second), flushCDNPurge)
+}
+
+// flushCDNPurge purges the queued keys; on failure, the keys are queued again
+func flushCDNPurge() error {
+	purgeMu.Lock()
+	queued := purgeKeys
+	purgeKeys = map[string]bool{}
+	purgeMu.Unlock()
+	if len(queued) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(queued))
+	for k := range queued {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	log.Println("Purging keys from CDN:", strings.Join(keys, " "))
+	err := cdn.PurgeKeys(keys)
+	if err != nil {
+		purgeCDNKeys(keys...)
+	}
+	return err
+}
+
+// surrogateKeys tags the response with the given surrogate keys in addition
+// to the keys it is already tagged with; does nothing if no CDN is configured
+func surrogateKeys(c *gin.Context, keys ...string) {
+	if cdn == nil {
+		return
+	}
+	if prev := c.Writer.Header().Get(cdn.KeyHeader()); prev != "" {
+		keys = append(strings.Split(prev, cdn.KeySeparator()), keys...)
+	}
+	c.Header(cdn.KeyHeader(), strings.Join(keys, cdn.KeySeparator()))
+}
+
+// cdnCache returns a middleware tagging responses with the given surrogate
+// keys and allowing the CDN to cache them for CDN_MAX_AGE, while clients
+// revalidate; content responses are additionally tagged with the key of the
+// requested file. Does nothing if no CDN is configured.
+func cdnCache(keys ...string) gin.HandlerFunc {
+	maxAge := strconv.Itoa(int(getEnvDurationOrElse("CDN_MAX_AGE", time.Hour).Seconds()))
+	return func(c *gin.Context) {
+		if cdn == nil {
+			return
+		}
+		if uri := c.Param("uri"); uri != "" {
+			surrogateKeys(c, fileKey(uri))
+		}
+		surrogateKeys(c, keys...)
+		c.Header("Surrogate-Control", "max-age="+maxAge)
+		c.Header("CDN-Cache-Control", "max-age="+maxAge)
+	}
+}
+
+// noCDNCache removes the headers allowing the CDN to cache the response and
+// forbids caching it at all
+func noCDNCache(c *gin.Context) {
+	if cdn != nil {
+		c.Writer.Header().Del(cdn.KeyHeader())
+	}
+	c.Writer.Header().Del("Surrogate-Control")
+	c.Writer.Header().Del("CDN-Cache-Control")
+	c.Header("Cache-Control", "private, no-store")
+}
+
+// cdnRequest sends a request with the given method, headers and JSON body to
+// the given URL of a CDN API
+func cdnRequest(method string, u string, headers map[string]string, body any) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, u, r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	res, err := cdnClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer cls(res.Body)
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("request to %s failed: %s", u, res.Status)
+	}
+	return nil
+}
+
+// batches calls the given function with consecutive batches of at most n of
+// the given keys
+func batches(keys []string, n int, fn func(batch []string) error) error {
+	for len(keys) > 0 {
+		b := min(len(keys), n)
+		if err := fn(keys[:b]); err != nil {
+			return err
+		}
+		keys = keys[b:]
+	}
+	return nil
+}
+
+// cloudflareCDN purges using the Cloudflare API
+type cloudflareCDN struct {
+	zone  string
+	token string
+}
+
+func (cloudflareCDN) Name() string         { return "cloudflare" }
+func (cloudflareCDN) KeyHeader() string    { return "Cache-Tag" }
+func (cloudflareCDN) KeySeparator() string { return "," }
+
+func (p cloudflareCDN) PurgeKeys(keys []string) error {
+	// Cloudflare accepts up to 30 tags per request
+	return batches(keys, 30, func(batch []string) error {
+		return cdnRequest(http.MethodPost, "https://api.cloudflare.com/client/v4/zones/"+p.zone+"/purge_cache",
+			map[string]string{"Authorization": "Bearer " + p.token}, map[string]any{"tags": batch})
+	})
+}
+
+// fastlyCDN purges using the Fastly API
+type fastlyCDN struct {
+	service string
+	token   string
+}
+
+func (fastlyCDN) Name() string         { return "fastly" }
+func (fastlyCDN) KeyHeader() string    { return "Surrogate-Key" }
+func (fastlyCDN) KeySeparator() string { return " " }
+
+func (p fastlyCDN) PurgeKeys(keys []string) error {
+	// Fastly accepts up to 256 keys per request
+	return batches(keys, 256, func(batch []string) error {
+		return cdnRequest(http.MethodPost, "https://api.fastly.com/service/"+p.service+"/purge",
+			map[string]string{"Fastly-Key": p.token, "Surrogate-Key": strings.Join(batch, " ")}, nil)
+	})
+}
+
+// bunnyCDN purges using the bunny.net API
+type bunnyCDN struct {
+	zone  string
+	token string
+}
+
+func (bunnyCDN) Name() string         { return "bunny" }
+func (bunnyCDN) KeyHeader() string    { return "CDN-Tag" }
+func (bunnyCDN) KeySeparator() string { return "," }
+
+func (p bunnyCDN) PurgeKeys(keys []string) error {
+	// bunny.net purges one tag per request
+	return batches(keys, 1, func(batch []string) error {
+		return cdnRequest(http.MethodPost, "https://api.bunny.net/pullzone/"+p.zone+"/purgeCache",
+			map[string]string{"AccessKey": p.token}, map[string]any{"CacheTag": batch[0]})
+	})
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+var (
+	// adminAllowedNets are the networks the admin routes may be accessed from;
+	// all networks if empty
+	adminAllowedNets = parseCIDRs(getEnvOrElse("ADMIN_ALLOWED_CIDRS", ""))
+	// adminDeniedNets are the networks the admin routes may not be accessed
+	// from, even if allowed
+	adminDeniedNets = parseCIDRs(getEnvOrElse("ADMIN_DENIED_CIDRS", ""))
+)
+
+// parseCIDRs parses the given comma separated list of networks in CIDR
+// notation; single IPs are accepted as networks of one address
+func parseCIDRs(list string) []*net.IPNet {
+	var nets []*net.IPNet
+	for s := range parseList(list) {
+		if !strings.Contains(s, "/") {
+			if ip := net.ParseIP(s); ip.To4() != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(s)
+		checkErr(err)
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// containsIP returns whether one of the given networks contains the given IP
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// adminAllowed is the middleware aborting requests with status 403 if the
+// client IP is not in one of the networks set by ADMIN_ALLOWED_CIDRS or in one
+// of the networks set by ADMIN_DENIED_CIDRS
+func adminAllowed(c *gin.Context) {
+	if len(adminAllowedNets) == 0 && len(adminDeniedNets) == 0 {
+		return
+	}
+	ip := net.ParseIP(c.ClientIP())
+	if ip != nil && (len(adminAllowedNets) == 0 || containsIP(adminAllowedNets, ip)) && !containsIP(adminDeniedNets, ip) {
+		return
+	}
+	log.Println("[Err] Admin access denied for:", c.ClientIP())
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "access denied"})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"github.com/duevil/Go_Portfolio/content"
+	"golang.org/x/sync/singleflight"
+	"log"
+	"strconv"
+)
+
+// fileGroup coalesces concurrent database lookups of the same file
+var fileGroup singleflight.Group
+
+// pageGroup coalesces concurrent renderings of the same version of a page
+var pageGroup singleflight.Group
+
+// getFile returns the file with the given uri from the database; concurrent
+// lookups of the same uri share a single query and its result. The query is
+// guarded by dbBreaker.
+func getFile(uri string) (content.MongoFile, error) {
+	v, err, shared := fileGroup.Do(uri, func() (any, error) {
+		var f content.MongoFile
+		err := dbBreaker.do(func() (err error) {
+			f, err = content.GetFromDB(uri)
+			return err
+		})
+		return f, err
+	})
+	if shared {
+		log.Println("Coalesced file lookup:", uri)
+	}
+	f, _ := v.(content.MongoFile)
+	return f, err
+}
+
+// renderPage converts the given markdown file to a page, passes it to the
+// given function unless it is nil and renders it using the 'page' template;
+// concurrent renderings of the same version of a file without such a function
+// share a single rendering and its result, which must not be modified. Reading
+// the content is guarded by dbBreaker.
+func renderPage(f content.MongoFile, adjust func(page *content.Page)) ([]byte, error) {
+	render := func() (any, error) {
+		var page content.Page
+		err := dbBreaker.do(func() (err error) {
+			page, err = f.ToPage()
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if !f.Private() {
+			page.Image = ogImageURL(f)
+		}
+		absoluteTranslations(&page, siteURL(nil))
+		loadComments(f, &page)
+		if adjust != nil {
+			adjust(&page)
+		}
+		buf := bytes.Buffer{}
+		err = page.CreateHTML(currentTemplates(), &buf)
+		return buf.Bytes(), err
+	}
+	var v any
+	var err error
+	if adjust != nil {
+		v, err = render()
+	} else {
+		// the key changes with every upload, so a rendering of replaced content
+		// is never shared with requests for the new content
+		key := f.URI + "@" + strconv.FormatInt(f.LastMod.UnixNano(), 10) + "@" + f.Hash
+		var shared bool
+		v, err, shared = pageGroup.Do(key, render)
+		if shared {
+			log.Println("Coalesced page rendering:", f.URI)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return v.([]byte), nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"github.com/duevil/Go_Portfolio/content"
+	"github.com/gin-gonic/gin"
+	"html/template"
+	"log"
+	"net/http"
+)
+
+// commentsEnabled returns whether visitors may comment on pages, which is
+// enabled by COMMENTS; comments are shown once a moderator approved them
+func commentsEnabled() bool {
+	return getEnvOrElse("COMMENTS", "false") == "true"
+}
+
+// commentRequest is the request body for posting a comment, sent by the
+// comment form of a page or as JSON; Website is a field hidden from visitors,
+// so comments filling it in are posted by bots and marked as spam
+type commentRequest struct {
+	Author  string `form:"author" json:"author" binding:"required,max=100"`
+	Email   string `form:"email" json:"email" binding:"omitempty,email,max=254"`
+	Text    string `form:"text" json:"text" binding:"required,max=4096"`
+	Website string `form:"website" json:"website"`
+}
+
+// loadComments sets the approved comments of the given page file on the given
+// page and opens the page for comments if comments are enabled; private pages
+// have no comments
+func loadComments(f content.MongoFile, page *content.Page) {
+	if !commentsEnabled() || f.Private() {
+		return
+	}
+	comments, err := content.ListComments(f.URI)
+	if err != nil {
+		log.Println("[Err] Listing comments:", f.URI, err)
+	}
+	page.Comments = comments
+	page.CommentsOpen = true
+}
+
+// handleCommentPost handles requests to comment on the requested page; the
+// comment is pending until a moderator approves it. Requests of the comment
+// form are answered with a page thanking the visitor, others with status 202.
+func handleCommentPost(c *gin.Context) {
+	uri := c.Param("uri")
+	lang := c.Param("lang")
+	log.Println("Comment post requested:", uri, lang)
+	if !commentsEnabled() || (lang != "" && !content.ValidLanguage(lang)) {
+		errNotFound(c, content.ErrNotFound)
+		return
+	}
+	var req commentRequest
+	err := c.ShouldBind(&req)
+	if errBind(c, err) {
+		return
+	}
+	f, err := getLocalized(uri, lang)
+	if err == nil && (!f.IsMD || !f.Public() || !f.Published() || f.Private()) {
+		err = content.ErrNotFound
+	}
+	if errNotFound(c, err) || errUnavailable(c, err) || errISE(c, err) {
+		return
+	}
+	comment := content.Comment{URI: f.URI, Author: req.Author, Email: req.Email, Text: req.Text}
+	if req.Website != "" {
+		log.Println("[Err] Comment filled in the hidden field; marking as spam:", f.URI)
+		comment.Status = content.CommentSpam
+	}
+	err = content.AddComment(&comment)
+	if errISE(c, err) {
+		return
+	}
+	// spam is answered like any other comment, so bots do not learn about it
+	if c.ContentType() == gin.MIMEJSON {
+		c.JSON(http.StatusAccepted, gin.H{"id": comment.ID, "status": content.CommentPending})
+		return
+	}
+	lang = f.Language()
+	page := newLocalizedPage(translate(lang, "comments"), c.Request.URL.Path[1:], lang)
+	page.Content = template.HTML("<p>" + template.HTMLEscapeString(translate(lang, "comment_pending")) + "</p>\n" +
+		`<p><a href="` + template.HTMLEscapeString(f.Link()) + `">` + template.HTMLEscapeString(translate(lang, "back")) +
+		"</a></p>\n")
+	noCDNCache(c)
+	c.HTML(http.StatusOK, "page", page)
+}
+
+// handleComments handles requests for the comments with the status given by
+// the query parameter 'status', which are the pending comments by default,
+// newest first
+func handleComments(c *gin.Context) {
+	status := c.DefaultQuery("status", content.CommentPending)
+	log.Println("Comments requested:", status)
+	comments, err := content.ListCommentsByStatus(status)
+	if errors.Is(err, content.ErrInvalidCommentStatus) {
+		errStatus(c, http.StatusBadRequest, err)
+		return
+	}
+	if errISE(c, err) {
+		return
+	}
+	c.JSON(http.StatusOK, comments)
+}
+
+// handleCommentApprove handles requests to approve the comment with the given
+// id, which is then shown on its page
+func handleCommentApprove(c *gin.Context) {
+	moderateComment(c, content.CommentApproved)
+}
+
+// handleCommentSpam handles requests to mark the comment with the given id as
+// spam, which hides it if it was approved
+func handleCommentSpam(c *gin.Context) {
+	moderateComment(c, content.CommentSpam)
+}
+
+// moderateComment sets the status of the comment with the given id; the page
+// of the comment is purged from the CDN if the comment appears on or
+// disappears from the page
+func moderateComment(c *gin.Context, status string) {
+	id := c.Param("id")
+	log.Println("Comment moderation requested:", id, status)
+	prev, err := content.SetCommentStatus(id, status)
+	if errNotFound(c, err) || errISE(c, err) {
+		return
+	}
+	if (prev.Status == content.CommentApproved) != (status == content.CommentApproved) {
+		purgeCDNKeys(fileKey(prev.URI))
+	}
+	c.JSON(http.StatusOK, gin.H{"id": id, "uri": prev.URI, "status": status})
+}
+
+// handleCommentDelete handles requests to delete the comment with the given id
+func handleCommentDelete(c *gin.Context) {
+	id := c.Param("id")
+	log.Println("Comment deletion requested:", id)
+	comment, err := content.DeleteComment(id)
+	if errNotFound(c, err) || errISE(c, err) {
+		return
+	}
+	if comment.Status == content.CommentApproved {
+		purgeCDNKeys(fileKey(comment.URI))
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	// cssClassRegexp matches class rules of embedded style sheets as used by
+	// Google Docs exports
+	cssClassRegexp = regexp.MustCompile(`\.([\w-]+)\s*\{([^}]*)}`)
+	blankRegexp    = regexp.MustCompile(`[ \t\r\n]+`)
+	newlinesRegexp = regexp.MustCompile(`\n{3,}`)
+)
+
+// htmlConverter converts exported HTML documents to markdown
+type htmlConverter struct {
+	// classes maps CSS class names to their declarations
+	classes map[string]string
+	// image is called for every image source and returns the source to use in
+	// the markdown
+	image func(src string) (string, error)
+	// depth is the nesting depth of the current list
+	depth int
+	err   error
+}
+
+// convertHTML converts the given HTML document to markdown; image sources are
+// passed to the given function, which returns the source used in the markdown
+func convertHTML(doc string, image func(src string) (string, error)) (string, error) {
+	root, err := html.Parse(strings.NewReader(doc))
+	if err != nil {
+		return "", err
+	}
+	c := htmlConverter{classes: map[string]string{}, image: image}
+	c.collectStyles(root)
+	md := c.convert(root)
+	if c.err != nil {
+		return "", c.err
+	}
+	md = newlinesRegexp.ReplaceAllString(md, "\n\n")
+	return strings.TrimSpace(md) + "\n", nil
+}
+
+// collectStyles collects the class rules of all style elements
+func (c *htmlConverter) collectStyles(n *html.Node) {
+	if n.Type == html.ElementNode && n.DataAtom == atom.Style && n.FirstChild != nil {
+		for _, m := range cssClassRegexp.FindAllStringSubmatch(n.FirstChild.Data, -1) {
+			c.classes[m[1]] += m[2] + ";"
+		}
+	}
+	for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+		c.collectStyles(ch)
+	}
+}
+
+// style returns the inline style of the given element including the
+// declarations of its classes
+func (c *htmlConverter) style(n *html.Node) string {
+	s := strings.ToLower(attr(n, "style"))
+	for _, class := range strings.Fields(attr(n, "class")) {
+		s += ";" + strings.ToLower(c.classes[class])
+	}
+	return strings.ReplaceAll(s, " ", "")
+}
+
+// children converts the children of the given node
+func (c *htmlConverter) children(n *html.Node) string {
+	b := strings.Builder{}
+	for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+		b.WriteString(c.convert(ch))
+	}
+	return b.String()
+}
+
+// convert converts the given node and its children to markdown
+func (c *htmlConverter) convert(n *html.Node) string {
+	switch n.Type {
+	case html.TextNode:
+		return escapeMarkdown(blankRegexp.ReplaceAllString(n.Data, " "))
+	case html.ElementNode:
+	default:
+		return c.children(n)
+	}
+	switch n.DataAtom {
+	case atom.Head, atom.Script, atom.Style, atom.Title, atom.Meta, atom.Link:
+		return ""
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		level := int(n.Data[1] - '0')
+		return block(strings.Repeat("#", level) + " " + strings.TrimSpace(c.children(n)))
+	case atom.P, atom.Div, atom.Section, atom.Article, atom.Header, atom.Footer, atom.Figure:
+		return block(strings.TrimSpace(c.children(n)))
+	case atom.Br:
+		return "  \n"
+	case atom.Hr:
+		return block("---")
+	case atom.Strong, atom.B:
+		return wrapInline(c.children(n), "**")
+	case atom.Em, atom.I:
+		return wrapInline(c.children(n), "*")
+	case atom.S, atom.Del:
+		return wrapInline(c.children(n), "~~")
+	case atom.Span:
+		s, style := c.children(n), c.style(n)
+		if strings.Contains(style, "font-weight:700") || strings.Contains(style, "font-weight:bold") {
+			s = wrapInline(s, "**")
+		}
+		if strings.Contains(style, "font-style:italic") {
+			s = wrapInline(s, "*")
+		}
+		return s
+	case atom.Code:
+		return "`" + textContent(n) + "`"
+	case atom.Pre:
+		return block("```\n" + strings.TrimRight(textContent(n), "\n") + "\n```")
+	case atom.A:
+		text := strings.TrimSpace(c.children(n))
+		href := unwrapLink(attr(n, "href"))
+		if href == "" || text == "" {
+			return text
+		}
+		return "[" + text + "](" + href + ")"
+	case atom.Img:
+		src := attr(n, "src")
+		if src == "" {
+			return ""
+		}
+		src, err := c.image(src)
+		if err != nil && c.err == nil {
+			c.err = err
+		}
+		return "![" + escapeMarkdown(attr(n, "alt")) + "](" + src + ")"
+	case atom.Ul, atom.Ol:
+		return c.list(n)
+	case atom.Blockquote:
+		lines := strings.Split(strings.TrimSpace(c.children(n)), "\n")
+		for i, l := range lines {
+			lines[i] = strings.TrimRight("> "+l, " ")
+		}
+		return block(strings.Join(lines, "\n"))
+	case atom.Table:
+		return c.table(n)
+	default:
+		return c.children(n)
+	}
+}
+
+// list converts the given list element; nested lists are indented
+func (c *htmlConverter) list(n *html.Node) string {
+	indent := strings.Repeat("   ", c.depth)
+	c.depth++
+	defer func() { c.depth-- }()
+	b := strings.Builder{}
+	i := 1
+	for li := n.FirstChild; li != nil; li = li.NextSibling {
+		if li.Type != html.ElementNode || li.DataAtom != atom.Li {
+			continue
+		}
+		marker := "- "
+		if n.DataAtom == atom.Ol {
+			marker = fmt.Sprintf("%d. ", i)
+		}
+		item := newlinesRegexp.ReplaceAllString(strings.TrimSpace(c.children(li)), "\n\n")
+		item = strings.ReplaceAll(item, "\n\n", "\n")
+		b.WriteString(indent + marker + item + "\n")
+		i++
+	}
+	if c.depth > 1 {
+		return "\n" + b.String()
+	}
+	return block(strings.TrimRight(b.String(), "\n"))
+}
+
+// table converts the given table element to a markdown table using the first
+// row as header
+func (c *htmlConverter) table(n *html.Node) string {
+	var rows [][]string
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.DataAtom == atom.Tr {
+			var row []string
+			for cell := n.FirstChild; cell != nil; cell = cell.NextSibling {
+				if cell.Type == html.ElementNode && (cell.DataAtom == atom.Td || cell.DataAtom == atom.Th) {
+					text := blankRegexp.ReplaceAllString(strings.TrimSpace(c.children(cell)), " ")
+					row = append(row, strings.ReplaceAll(text, "|", `\|`))
+				}
+			}
+			rows = append(rows, row)
+			return
+		}
+		for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+			walk(ch)
+		}
+	}
+	walk(n)
+	if len(rows) == 0 {
+		return ""
+	}
+	b := strings.Builder{}
+	b.WriteString("| " + strings.Join(rows[0], " | ") + " |\n|")
+	b.WriteString(strings.Repeat(" --- |", len(rows[0])))
+	for _, row := range rows[1:] {
+		b.WriteString("\n| " + strings.Join(row, " | ") + " |")
+	}
+	return block(b.String())
+}
+
+// block surrounds the given markdown with blank lines
+func block(s string) string {
+	if s == "" {
+		return ""
+	}
+	return "\n\n" + s + "\n\n"
+}
+
+// wrapInline wraps the given inline markdown with the given marker; leading and
+// trailing spaces are kept outside the marker, as markdown requires
+func wrapInline(s string, marker string) string {
+	trimmed := strings.TrimSpace(s)
+	if trimmed == "" {
+		return s
+	}
+	lead := s[:strings.Index(s, trimmed)]
+	trail := s[len(lead)+len(trimmed):]
+	return lead + marker + trimmed + marker + trail
+}
+
+// escapeMarkdown escapes characters that would be interpreted as markdown
+func escapeMarkdown(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "*", `\*`, "_", `\_`, "`", "\\`", "[", `\[`, "]", `\]`).Replace(s)
+}
+
+// textContent returns the unescaped text of the given node and its children
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	b := strings.Builder{}
+	for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+		b.WriteString(textContent(ch))
+	}
+	return b.String()
+}
+
+// attr returns the value of the attribute with the given key or an empty string
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// unwrapLink removes the redirect Google Docs wraps around external links
+func unwrapLink(href string) string {
+	u, err := url.Parse(href)
+	if err == nil && strings.HasSuffix(u.Host, "google.com") && u.Path == "/url" && u.Query().Get("q") != "" {
+		return u.Query().Get("q")
+	}
+	return href
+}
+
+// decodeDataURI decodes the given data URI; returns the data and the file
+// extension matching the data's mime type
+func decodeDataURI(uri string) ([]byte, string, error) {
+	meta, data, ok := strings.Cut(strings.TrimPrefix(uri, "data:"), ",")
+	if !ok || !strings.HasSuffix(meta, ";base64") {
+		return nil, "", fmt.Errorf("unsupported data uri: %.32s", uri)
+	}
+	b, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, "", err
+	}
+	return b, extensionByType(strings.TrimSuffix(meta, ";base64")), nil
+}
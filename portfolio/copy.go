@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"github.com/duevil/Go_Portfolio/content"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// errExtensionMismatch is returned if a file is copied to a uri with another
+// extension, which would change how the copy is served
+var errExtensionMismatch = errors.New("the copy must have the extension of the file")
+
+// handleCopy handles requests to copy the file with the given uri to the uri
+// given by the query parameter 'dest', see content.CopyFile; the variants of
+// images are copied along. Copying a page is a quick way to start a new page
+// from the layout of an existing one. Copies of published pages made by users
+// who may not publish are drafts, so they are reviewed like any other page.
+func handleCopy(c *gin.Context) {
+	uri := c.Param("uri")
+	dest, err := sanitizePath(strings.TrimPrefix(c.Query("dest"), "/"))
+	if errUpload(c, err) {
+		return
+	}
+	log.Println("Copy requested:", uri, dest)
+	f, err := content.GetFromDB(uri)
+	if err == nil && !f.Public() {
+		err = content.ErrNotFound
+	}
+	if errNotFound(c, err) || errISE(c, err) || !allowURI(c, f.URI) || !allowURI(c, dest) {
+		return
+	}
+	if path.Ext(dest) != path.Ext(f.URI) {
+		errStatus(c, http.StatusBadRequest, errExtensionMismatch)
+		return
+	}
+	status := ""
+	if f.IsMD && (f.Status == "" || f.Status == content.StatusPublished) && !mayPublish(c) {
+		status = content.StatusDraft
+	}
+
+	// the variants are copied after the file, so a failed copy of the file
+	// does not leave copies of its variants behind
+	cp, err := content.CopyFile(f.URI, dest, status)
+	if errCopy(c, err) {
+		return
+	}
+	files := []string{cp.URI}
+	for _, m := range f.Variants {
+		v, err := content.CopyFile(f.URI+extensionByType(m), dest+extensionByType(m), status)
+		if errors.Is(content.ErrNotFound, err) {
+			continue
+		}
+		if errCopy(c, err) {
+			contentChanged(files...)
+			return
+		}
+		files = append(files, v.URI)
+	}
+	contentChanged(files...)
+	c.Header("Location", cp.Link())
+	c.JSON(http.StatusCreated, gin.H{"from": f.URI, "uri": cp.URI, "link": cp.Link(), "files": files})
+}
+
+// errCopy checks whether the given error of copying a file is not nil and
+// aborts the request with the matching status if so
+func errCopy(c *gin.Context, err error) bool {
+	if errors.Is(err, content.ErrExists) {
+		return errStatus(c, http.StatusConflict, err)
+	}
+	return errNotFound(c, err) || errISE(c, err)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/duevil/Go_Portfolio/content"
+	"github.com/gin-gonic/gin"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// diffClient is the client manifests of remote instances are requested with
+var diffClient = &http.Client{Timeout: 30 * time.Second}
+
+// diffRemotes are the base URLs of the instances content may be compared with
+// set by DIFF_REMOTES as comma separated list; remotes are restricted, so the
+// server cannot be used to send requests to arbitrary hosts
+var diffRemotes = parseList(getEnvOrElse("DIFF_REMOTES", ""))
+
+// changedFile is a file whose content differs between two instances
+type changedFile struct {
+	URI    string `json:"uri"`
+	Local  string `json:"local"`
+	Remote string `json:"remote"`
+}
+
+// contentDiff is the result of comparing the content of this instance with a
+// remote instance
+type contentDiff struct {
+	Remote     string        `json:"remote"`
+	OnlyLocal  []string      `json:"only_local"`
+	OnlyRemote []string      `json:"only_remote"`
+	Changed    []changedFile `json:"changed"`
+	Equal      int           `json:"equal"`
+}
+
+// diffQuery are the query parameters of the content comparison
+type diffQuery struct {
+	Remote string `form:"remote" binding:"required,http_url"`
+}
+
+// handleDiff handles requests to compare the content hashes of this instance
+// with those of the remote instance given by the query parameter 'remote',
+// which must be listed in DIFF_REMOTES; the remote's manifest is requested
+// from its '/admin/list' endpoint using the API token set by
+// DIFF_REMOTE_TOKEN
+func handleDiff(c *gin.Context) {
+	var q diffQuery
+	err := c.ShouldBindQuery(&q)
+	if errBind(c, err) {
+		return
+	}
+	remote := strings.TrimRight(strings.TrimSpace(q.Remote), "/")
+	log.Println("Diff requested:", remote)
+	if !diffRemotes[strings.ToLower(remote)] {
+		errStatus(c, http.StatusBadRequest, errors.New("remote is not listed in DIFF_REMOTES: "+remote))
+		return
+	}
+	local, err := content.ListAll()
+	if errISE(c, err) {
+		return
+	}
+	localHashes := map[string]string{}
+	for i := range local {
+		hash, err := local[i].ContentHash()
+		if errISE(c, err) {
+			return
+		}
+		localHashes[local[i].URI] = hash
+	}
+	remoteFiles, err := fetchManifest(remote)
+	if errStatus(c, http.StatusBadGateway, err) {
+		return
+	}
+	c.JSON(http.StatusOK, compareManifests(remote, localHashes, remoteFiles))
+}
+
+// fetchManifest requests the list of files of the remote instance with the
+// given base URL
+func fetchManifest(remote string) ([]content.MongoFile, error) {
+	req, err := http.NewRequest(http.MethodGet, remote+"/admin/list", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if token := getEnvOrElse("DIFF_REMOTE_TOKEN", ""); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := diffClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer cls(res.Body)
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("request to %s failed: %s", req.URL, res.Status)
+	}
+	var files []content.MongoFile
+	err = json.NewDecoder(io.LimitReader(res.Body, 64<<20)).Decode(&files)
+	return files, err
+}
+
+// compareManifests compares the given local content hashes by uri with the
+// hashes of the given remote files
+func compareManifests(remote string, local map[string]string, remoteFiles []content.MongoFile) contentDiff {
+	d := contentDiff{Remote: remote, OnlyLocal: []string{}, OnlyRemote: []string{}, Changed: []changedFile{}}
+	seen := map[string]bool{}
+	for _, f := range remoteFiles {
+		seen[f.URI] = true
+		hash, ok := local[f.URI]
+		switch {
+		case !ok:
+			d.OnlyRemote = append(d.OnlyRemote, f.URI)
+		case hash != f.Hash:
+			d.Changed = append(d.Changed, changedFile{URI: f.URI, Local: hash, Remote: f.Hash})
+		default:
+			d.Equal++
+		}
+	}
+	for uri := range local {
+		if !seen[uri] {
+			d.OnlyLocal = append(d.OnlyLocal, uri)
+		}
+	}
+	sort.Strings(d.OnlyLocal)
+	sort.Strings(d.OnlyRemote)
+	sort.Slice(d.Changed, func(i, j int) bool { return d.Changed[i].URI < d.Changed[j].URI })
+	return d
+}
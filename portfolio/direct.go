@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"github.com/duevil/Go_Portfolio/content"
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/gin-gonic/gin"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// directUploadRequest is the request body of direct uploads; the uri is the
+// relative path of the file like the name of an uploaded file and the size is
+// its expected size. The path is the one returned with the upload URL, which
+// the content was uploaded to.
+type directUploadRequest struct {
+	URI  string `json:"uri" binding:"required"`
+	Size int64  `json:"size"`
+	Path string `json:"path"`
+}
+
+// handleDirectUpload handles requests for a URL the content of the large asset
+// with the uri of the request body is uploaded to directly with a PUT request,
+// so it goes straight to the blob store; responds with status 501 unless file
+// contents are stored in S3 without encryption. Once the content is uploaded,
+// the file is stored by handleDirectUploadRegister. Like for regular uploads,
+// the query may set the staging set, the visibility and the status of the
+// file; the registration must be requested with the same query.
+func handleDirectUpload(c *gin.Context) {
+	log.Println("Direct upload requested")
+	var req directUploadRequest
+	err := c.ShouldBindJSON(&req)
+	if errBind(c, err) {
+		return
+	}
+	f, err := directUploadFile(req.URI)
+	if err == nil {
+		err = checkDirectUploadSize(f.URI, req.Size)
+	}
+	if err == nil {
+		f, err = newUploader(c).prepare(f)
+	}
+	if errUpload(c, err) {
+		return
+	}
+	p, u, err := content.PresignUpload(f.URI, directUploadExpiry)
+	if errors.Is(err, content.ErrDirectUploadUnsupported) {
+		errStatus(c, http.StatusNotImplemented, err)
+		return
+	}
+	if errISE(c, err) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"uri": f.URI, "path": p, "url": u, "expires": time.Now().Add(directUploadExpiry)})
+}
+
+// handleDirectUploadRegister handles requests to store the file with the uri
+// and the path of the request body once its content is uploaded to the URL
+// issued by handleDirectUpload; the content is checked and scanned like a
+// regular upload and removed from the blob store if it is rejected
+func handleDirectUploadRegister(c *gin.Context) {
+	log.Println("Direct upload registration requested")
+	var req directUploadRequest
+	err := c.ShouldBindJSON(&req)
+	if errBind(c, err) {
+		return
+	}
+	f, err := directUploadFile(req.URI)
+	uri := f.URI
+	u := newUploader(c)
+	if err == nil {
+		f, err = u.prepare(f)
+	}
+	if err == nil && !content.ValidUploadPath(f.URI, req.Path) {
+		err = &uploadError{status: http.StatusBadRequest, code: "invalid_path", file: f.URI,
+			msg: "path was not issued for the upload: " + req.Path}
+	}
+	if errUpload(c, err) {
+		return
+	}
+	f.LastMod = time.Now()
+	err = f.StoreUploaded(req.Path, func(r io.Reader) error { return inspectDirectUpload(&f, r) })
+	var ue *uploadError
+	if errors.As(err, &ue) {
+		content.RemoveUpload(req.Path)
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		err = &uploadError{status: http.StatusBadRequest, code: "not_uploaded", file: f.URI,
+			msg: "content was not uploaded: " + f.URI}
+	}
+	if errors.Is(err, content.ErrUploadPath) {
+		err = &uploadError{status: http.StatusConflict, code: "already_stored", file: f.URI,
+			msg: "content of the upload is already stored: " + req.Path}
+	}
+	if errors.Is(err, content.ErrDirectUploadUnsupported) {
+		errStatus(c, http.StatusNotImplemented, err)
+		return
+	}
+	if errUpload(c, err) || errISE(c, err) {
+		return
+	}
+	if f.Public() {
+		contentChanged(f.URI)
+	}
+	location := u.location(uri)
+	if u.quarantine && u.staging == "" {
+		location = "/admin/quarantine"
+	}
+	c.Status(u.status())
+	c.Header("Location", location)
+}
+
+// directUploadFile returns the file with the uri for the given relative path
+// uploaded directly; returns an uploadError with status 415 if the type of the
+// file is not allowed or the file must be uploaded regularly, as pages and
+// images are processed when stored
+func directUploadFile(p string) (content.MongoFile, error) {
+	uri, err := sanitizePath(p)
+	if err != nil {
+		return content.MongoFile{}, err
+	}
+	f := content.MongoFile{URI: uri, IsMD: path.Ext(uri) == ".md"}
+	_, f.Mime = checkMimeType(path.Ext(uri))
+	err = checkDirectUpload(f)
+	if err == nil && f.Mime != "" {
+		err = checkUploadMime(f)
+	}
+	return f, err
+}
+
+// checkDirectUpload returns an uploadError with status 415 if the given file
+// must not be uploaded directly, as it is processed when stored
+func checkDirectUpload(f content.MongoFile) error {
+	if f.IsMD || strings.HasPrefix(f.Mime, "image/svg+xml") || isRasterImage(f.Mime) ||
+		(stripMetadataEnabled() && hasStrippableMetadata(f.Mime)) {
+		return &uploadError{status: http.StatusUnsupportedMediaType, code: "direct_upload_unsupported", file: f.URI,
+			msg: "file must be uploaded regularly: " + f.URI}
+	}
+	return nil
+}
+
+// inspectDirectUpload sets the mime type of the given file uploaded directly
+// from its extension or its content read from the given reader and checks the
+// file like storeUpload; the content is scanned and read to the end to check
+// its size
+func inspectDirectUpload(f *content.MongoFile, r io.Reader) error {
+	head := make([]byte, 3072)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+	head = head[:n]
+	ok, mime := checkMimeType(path.Ext(f.URI))
+	if !ok {
+		mime = mimetype.Detect(head).String()
+	}
+	f.Mime = mime
+	err = checkDirectUpload(*f)
+	if err == nil {
+		err = checkUploadMime(*f)
+	}
+	if err != nil {
+		return err
+	}
+	var size int64
+	r = io.TeeReader(io.MultiReader(bytes.NewReader(head), r), writeCounter{&size})
+	err = scanStream(f.URI, r)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(io.Discard, io.LimitReader(r, maxDirectUploadSize+1-size))
+	if err != nil {
+		return err
+	}
+	return checkDirectUploadSize(f.URI, size)
+}
+
+// writeCounter adds the number of bytes written to it to its count
+type writeCounter struct {
+	count *int64
+}
+
+func (w writeCounter) Write(b []byte) (int, error) {
+	*w.count += int64(len(b))
+	return len(b), nil
+}
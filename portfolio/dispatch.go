@@ -0,0 +1,48 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"strings"
+)
+
+// deleteRoute is a delete route below /admin whose last path segment is
+// passed as parameter with the given name
+type deleteRoute struct {
+	prefix   string
+	param    string
+	handlers []gin.HandlerFunc
+}
+
+// adminDeleteHandler returns the handler for delete requests below /admin;
+// gin does not allow other routes next to the catch-all route for deleting
+// files, so the given routes are dispatched here by their prefix, all other
+// requests are passed to the fallback handlers
+func adminDeleteHandler(routes []deleteRoute, fallback ...gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uri := c.Param("uri")
+		handlers := fallback
+		for _, r := range routes {
+			rest, ok := strings.CutPrefix(uri, r.prefix)
+			if ok && rest != "" && !strings.Contains(rest, "/") {
+				c.Params = append(c.Params, gin.Param{Key: r.param, Value: rest})
+				handlers = r.handlers
+				break
+			}
+		}
+		chain(handlers...)(c)
+	}
+}
+
+// chain returns a handler calling the given handlers in order until one of
+// them aborts the request; the raw upload routes cannot use middleware, so
+// their middleware is chained inside the handler
+func chain(handlers ...gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, h := range handlers {
+			h(c)
+			if c.IsAborted() {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/duevil/Go_Portfolio/content"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// finding levels of the doctor
+const (
+	findingOK    = "ok"
+	findingWarn  = "warn"
+	findingError = "error"
+)
+
+// finding is the result of a doctor check
+type finding struct {
+	Check   string `json:"check"`
+	Level   string `json:"level"`
+	Message string `json:"message"`
+}
+
+// doctorReport is the result of all doctor checks
+type doctorReport struct {
+	OK       bool      `json:"ok"`
+	Findings []finding `json:"findings"`
+}
+
+// doctor collects findings
+type doctor struct {
+	findings []finding
+}
+
+// add adds a finding of the given check and level with a formatted message
+func (d *doctor) add(check string, level string, format string, args ...any) {
+	d.findings = append(d.findings, finding{Check: check, Level: level, Message: fmt.Sprintf(format, args...)})
+}
+
+// runDoctor checks the configuration, the database, the templates, the
+// directories and the settings; the database checks are skipped if no
+// database connection is established
+func runDoctor() doctorReport {
+	d := &doctor{}
+	d.checkConfig()
+	d.checkTemplates()
+	d.checkDirs()
+	if dbClient != nil {
+		d.checkDB()
+	} else {
+		d.add("database", findingError, "no database connection")
+	}
+	report := doctorReport{OK: true, Findings: d.findings}
+	for _, f := range d.findings {
+		if f.Level == findingError {
+			report.OK = false
+		}
+	}
+	return report
+}
+
+// checkConfig checks the environment configuration
+func (d *doctor) checkConfig() {
+	if u := getEnvOrElse("SITE_URL", ""); u == "" {
+		d.add("config", findingWarn, "SITE_URL is not set; password resets and OAuth login are disabled and feeds use the request host")
+	} else if p, err := url.Parse(u); err != nil || (p.Scheme != "http" && p.Scheme != "https") || p.Host == "" {
+		d.add("config", findingError, "SITE_URL is not an absolute http(s) URL: %s", u)
+	}
+	for _, key := range []string{"PASSWORD_RESET_KEY", "CSRF_KEY", "DOWNLOAD_URL_KEY"} {
+		if getEnvOrElse(key, "") == "" {
+			d.add("config", findingWarn, "%s is not set; a random key is used, which invalidates its tokens on restart", key)
+		}
+	}
+	if getEnvOrElse("ADMIN_PASSWORD", "") == "" && getEnvOrElse("ADMIN_PASSWORD_HASH", "") == "" {
+		d.add("config", findingWarn, "neither ADMIN_PASSWORD nor ADMIN_PASSWORD_HASH is set; a new database is seeded with the default password")
+	}
+	if getEnvOrElse("OAUTH_CLIENT_ID", "") != "" {
+		if _, ok := oauthConfig(); !ok {
+			d.add("config", findingError, "OAUTH_CLIENT_ID is set, but OAUTH_PROVIDER, OIDC_ISSUER or SITE_URL is missing or invalid")
+		} else if len(parseList(getEnvOrElse("OAUTH_ADMIN_EMAILS", ""))) == 0 {
+			d.add("config", findingWarn, "OAUTH_ADMIN_EMAILS is empty; nobody can log in using OAuth")
+		}
+	}
+	if p := getEnvOrElse("ACCOUNTS_FILE", ""); p != "" {
+		if _, err := loadAccounts(p); err != nil {
+			d.add("config", findingError, "ACCOUNTS_FILE cannot be loaded: %v", err)
+		}
+	}
+	if !mailEnabled() {
+		d.add("config", findingWarn, "SMTP_HOST is not set; password resets and notifications are disabled")
+	}
+	if !variantsAvailable() {
+		d.add("config", findingWarn, "no image converter found; WebP and AVIF variants are not created")
+	}
+	if scanningEnabled() {
+		if err := pingClamd(); err != nil {
+			d.add("config", findingError, "clamd at CLAMD_ADDR is not reachable; all uploads are rejected: %v", err)
+		}
+	}
+	switch mode := getEnvOrElse("TLS_MODE", "off"); mode {
+	case "off":
+		if getEnvOrElse("SESSION_SECURE", "false") != "true" {
+			d.add("config", findingWarn, "TLS_MODE is off and SESSION_SECURE is not set; session cookies are sent over plain HTTP unless behind a TLS proxy")
+		}
+	case "file":
+		for _, key := range []string{"TLS_CERT_FILE", "TLS_KEY_FILE"} {
+			if _, err := os.Stat(getEnvOrElse(key, "")); err != nil {
+				d.add("config", findingError, "%s is not readable: %v", key, err)
+			}
+		}
+	case "autocert":
+		if len(parseList(getEnvOrElse("TLS_DOMAINS", ""))) == 0 {
+			d.add("config", findingError, "TLS_DOMAINS must be set for TLS_MODE 'autocert'")
+		}
+	default:
+		d.add("config", findingError, "unknown TLS_MODE: %s", mode)
+	}
+	d.add("config", findingOK, "configuration checked")
+}
+
+// checkTemplates parses and validates the templates including overrides
+func (d *doctor) checkTemplates() {
+	_, err := loadTemplates()
+	if err != nil {
+		d.add("templates", findingError, "invalid templates: %v", err)
+		return
+	}
+	d.add("templates", findingOK, "all templates parsed and executed with sample data")
+}
+
+// checkDirs checks that the directories files are written to are writable
+func (d *doctor) checkDirs() {
+	dirs := []string{scratchDir}
+	if getEnvOrElse("TLS_MODE", "off") == "autocert" {
+		dirs = append(dirs, getEnvOrElse("TLS_CACHE_DIR", "certs"))
+	}
+	for _, dir := range dirs {
+		if err := checkWritable(dir); err != nil {
+			d.add("directories", findingError, "%s is not writable: %v", dir, err)
+		} else {
+			d.add("directories", findingOK, "%s is writable", dir)
+		}
+	}
+	if err := checkScratchSpace(0); err != nil {
+		d.add("directories", findingWarn, "%v", err)
+	}
+}
+
+// checkWritable creates the given directory if necessary and writes and
+// removes a file in it
+func checkWritable(dir string) error {
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".doctor")
+	if err != nil {
+		return err
+	}
+	cls(f)
+	return os.Remove(filepath.Clean(f.Name()))
+}
+
+// checkDB checks the database connection, the indexes and the stored content
+// and settings
+func (d *doctor) checkDB() {
+	err := dbClient.Ping(dbCtx, readpref.Primary())
+	if err != nil {
+		d.add("database", findingError, "database is not reachable: %v", err)
+		return
+	}
+	d.add("database", findingOK, "database is reachable")
+	if err := contentEngine.CheckBlobStore(); err != nil {
+		d.add("storage", findingError, "blob store is not available: %v", err)
+	} else {
+		d.add("storage", findingOK, "blob store is available")
+	}
+	db := dbClient.Database(getEnvOrElse("DB_NAME", "portfolio"))
+	indexes := map[string]string{
+		getEnvOrElse("DB_SESSION_COL", "sessions"):    "expires_1",
+		getEnvOrElse("DB_BANDWIDTH_COL", "bandwidth"): "month_1_bytes_-1",
+	}
+	for col, name := range indexes {
+		found, err := indexNames(db.Collection(col))
+		if err == nil && !found[name] {
+			err = errors.New("index " + name + " is missing; it is created on startup")
+		}
+		if err != nil {
+			d.add("indexes", findingError, "%s: %v", col, err)
+		} else {
+			d.add("indexes", findingOK, "%s: index %s exists", col, name)
+		}
+	}
+	_, err = content.GetFromDB("/index.md")
+	if errors.Is(content.ErrNotFound, err) {
+		d.add("content", findingWarn, "no /index.md uploaded; the start page is not found")
+	} else if err != nil {
+		d.add("content", findingError, "reading the start page failed: %v", err)
+	}
+	inline, err := content.ListInline()
+	if err != nil {
+		d.add("content", findingWarn, "checking for inline content failed: %v", err)
+	} else if len(inline) > 0 {
+		d.add("content", findingWarn, "%d files have their content stored inline in the database; run 'portfolio migrate inline' to move it to the blob store", len(inline))
+	}
+	s, err := content.LoadSettings()
+	if err != nil {
+		d.add("settings", findingError, "loading settings failed: %v", err)
+	} else if len(s.FooterColumns) == 0 && len(s.SocialLinks) == 0 {
+		d.add("settings", findingWarn, "no footer columns or social links configured")
+	}
+}
+
+// indexNames returns the names of the indexes of the given collection
+func indexNames(col *mongo.Collection) (map[string]bool, error) {
+	cursor, err := col.Indexes().List(dbCtx)
+	if err != nil {
+		return nil, err
+	}
+	var list []bson.M
+	err = cursor.All(dbCtx, &list)
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for _, i := range list {
+		if name, ok := i["name"].(string); ok {
+			names[name] = true
+		}
+	}
+	return names, nil
+}
+
+// runDoctorCommand runs the doctor from the command line and prints the
+// findings; returns the exit code, which is 1 if there are errors
+func runDoctorCommand() int {
+	client, err := connectDB()
+	if err != nil {
+		log.Println("[Err] Connecting to database:", err)
+	} else {
+		defer func() { _ = client.Disconnect(dbCtx) }()
+		initDB(client)
+	}
+	report := runDoctor()
+	for _, f := range report.Findings {
+		fmt.Printf("[%s] %s: %s\n", f.Level, f.Check, f.Message)
+	}
+	if !report.OK {
+		return 1
+	}
+	return 0
+}
+
+// handleDoctor handles requests to run the doctor
+func handleDoctor(c *gin.Context) {
+	log.Println("Doctor requested")
+	c.JSON(http.StatusOK, runDoctor())
+}
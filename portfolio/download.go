@@ -2,27 +2,64 @@ package main
 
 import (
 	"archive/zip"
-	"content"
+	"bytes"
+	"compress/flate"
+	"github.com/duevil/Go_Portfolio/content"
 	"github.com/gin-gonic/gin"
+	"hash/crc32"
 	"io"
+	"io/fs"
 	"log"
 	"os"
 	"path"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
+// exportEntry is a compressed file of the export
+type exportEntry struct {
+	header *zip.FileHeader
+	data   []byte
+	err    error
+}
+
 // handleDownload handles requests for downloading the portfolio; collects all
-// files from the database and writes them to a zip file, which is then served to
-// the client
+// files from the database and renders them into a staging directory, on which
+// the export hooks are run; the staging directory is then written to a zip
+// file, which is served to the client; files are sorted by name and all
+// metadata is normalized, so the same content always results in a
+// byte-identical zip file
 func handleDownload(c *gin.Context) {
 	log.Println("Download requested")
-
-	// create tmp dir and zip file
-	dir, err := os.MkdirTemp("", "tmp")
+	fs, err := content.ListAll()
 	if errISE(c, err) {
 		return
 	}
+	// the export is published as static site, which cannot protect private files
+	// and must not contain unpublished files
+	fs = filterFiles(fs, func(f *content.MongoFile) bool { return !f.Private() && f.Published() })
+
+	// create tmp dir and zip file; the rendered files and the zip file need at
+	// most about twice the size of all files
+	var size int64
+	for _, f := range fs {
+		size += f.Filesize
+	}
+	dir, err := makeScratchDir("export", 2*size)
+	if errStorage(c, err) || errISE(c, err) {
+		return
+	}
 	defer func(path string) { _ = os.RemoveAll(path) }(dir)
+	site := filepath.Join(dir, "site")
+	err = os.Mkdir(site, os.ModePerm)
+	if errISE(c, err) {
+		return
+	}
 	fPath := path.Join(dir, "portfolio.zip")
 	f, err := os.Create(fPath)
 	if errISE(c, err) {
@@ -32,18 +69,27 @@ func handleDownload(c *gin.Context) {
 	w := zip.NewWriter(f)
 	defer cls(w)
 
+	// render files into the staging directory
+	log.Println("Rendering files to:", site)
+	fs = exportFiles(fs)
+	err = handleDownloadRenderFiles(site, fs)
+	if errISE(c, err) {
+		return
+	}
+
+	// post-process the staging directory; the URLs of the export refer to
+	// SITE_URL or else to the host the export was requested from
+	err = runExportHooks(site, siteURL(c), fs)
+	if errISE(c, err) {
+		return
+	}
+
 	// add files
 	log.Println("Collecting files to zip:", fPath)
-	fs, err := content.ListAll()
+	err = handleDownloadAddFiles(w, site)
 	if errISE(c, err) {
 		return
 	}
-	for _, f := range fs {
-		err = handleDownloadAddFile(w, f)
-		if errISE(c, err) {
-			return
-		}
-	}
 
 	// finish
 	err = w.Close()
@@ -54,46 +100,202 @@ func handleDownload(c *gin.Context) {
 	c.FileAttachment(fPath, "portfolio.zip")
 }
 
-// handleDownloadAddFile adds the given file to the given zip writer; if the file
-// is a markdown file, it is converted to HTML and written to the zip writer,
-// else the file is written as-is
-func handleDownloadAddFile(w *zip.Writer, f content.MongoFile) error {
-	log.Println("Adding file to zip:", f.URI)
-	// create header
-	h, err := zip.FileInfoHeader(&f)
+// exportName returns the name of the given file within the export; pages are
+// exported at their slugs
+func exportName(f content.MongoFile) string {
+	if path.Base(f.Name()) == "index.html" {
+		return "index.html"
+	}
+	return strings.TrimPrefix(f.Link(), "/")
+}
+
+// exportFiles sorts the given files by their name within the export; if
+// multiple files have the same name, only the one with the lowest uri is kept
+func exportFiles(files []content.MongoFile) []content.MongoFile {
+	sort.SliceStable(files, func(i, j int) bool {
+		ni, nj := exportName(files[i]), exportName(files[j])
+		if ni != nj {
+			return ni < nj
+		}
+		return files[i].URI < files[j].URI
+	})
+	var unique []content.MongoFile
+	for i, f := range files {
+		if i > 0 && exportName(files[i-1]) == exportName(f) {
+			log.Println("Skipping file with duplicate export name:", f.URI)
+			continue
+		}
+		unique = append(unique, f)
+	}
+	return unique
+}
+
+// exportTime returns the modification time used for all files of the export;
+// it is taken from SOURCE_DATE_EPOCH if set, else the earliest time a zip file
+// can represent is used
+func exportTime() time.Time {
+	if epoch, err := strconv.ParseInt(getEnvOrElse("SOURCE_DATE_EPOCH", ""), 10, 64); err == nil {
+		return time.Unix(epoch, 0).UTC()
+	}
+	return time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// exportWorkers returns the number of workers used to render and compress the
+// export, configured by EXPORT_WORKERS
+func exportWorkers() int {
+	workers := getEnvIntOrElse("EXPORT_WORKERS", runtime.NumCPU())
+	if workers < 1 {
+		return 1
+	}
+	return workers
+}
+
+// handleDownloadRenderFiles renders the given files concurrently into the given
+// directory using a pool of export workers
+func handleDownloadRenderFiles(dir string, files []content.MongoFile) error {
+	indices := make(chan int)
+	errs := make(chan error, len(files))
+	wg := sync.WaitGroup{}
+	for n := 0; n < exportWorkers(); n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				errs <- handleDownloadRenderFile(dir, files[i])
+			}
+		}()
+	}
+	for i := range files {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleDownloadRenderFile writes the given file into the given directory; if
+// the file is a markdown file, it is converted to HTML, else the file is
+// written as-is
+func handleDownloadRenderFile(dir string, f content.MongoFile) error {
+	log.Println("Rendering file:", f.URI)
+	target := filepath.Join(dir, filepath.FromSlash(exportName(f)))
+	err := os.MkdirAll(filepath.Dir(target), os.ModePerm)
 	if err != nil {
 		return err
 	}
-	if path.Base(f.Name()) == "index.html" {
-		h.Name = "index.html"
-	} else {
-		h.Name = filepath.ToSlash(path.Join(content.URIRoot, f.Name()))
-	}
-	h.Method = zip.Deflate
-	zf, err := w.CreateHeader(h)
+	out, err := os.Create(target)
 	if err != nil {
 		return err
 	}
-	// write file
+	defer cls(out)
 	if f.IsMD {
 		page, err := f.ToPage()
 		if err != nil {
 			return err
 		}
-		err = page.CreateHTML(templates, zf)
-		if err != nil {
-			return err
-		}
-		return nil
+		exportTranslations(&page)
+		return page.CreateHTML(currentTemplates(), out)
 	}
 	rc, err := f.Open()
 	if err != nil {
 		return err
 	}
 	defer cls(rc)
-	_, err = io.Copy(zf, rc)
+	_, err = io.Copy(out, rc)
+	return err
+}
+
+// handleDownloadAddFiles compresses the files of the given directory
+// concurrently using a pool of export workers and writes them to the given zip
+// writer sorted by name; at most as many files as there are workers are held
+// in memory at once
+func handleDownloadAddFiles(w *zip.Writer, dir string) error {
+	var names []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		name, err := filepath.Rel(dir, p)
+		names = append(names, filepath.ToSlash(name))
+		return err
+	})
 	if err != nil {
 		return err
 	}
+	sort.Strings(names)
+	results := make([]chan exportEntry, len(names))
+	for i := range results {
+		results[i] = make(chan exportEntry, 1)
+	}
+	slots := make(chan struct{}, exportWorkers())
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for i := range names {
+			select {
+			case slots <- struct{}{}:
+			case <-done:
+				return
+			}
+			go func(i int) { results[i] <- handleDownloadCompressFile(dir, names[i]) }(i)
+		}
+	}()
+	for i := range names {
+		e := <-results[i]
+		if e.err != nil {
+			return e.err
+		}
+		zf, err := w.CreateRaw(e.header)
+		if err != nil {
+			return err
+		}
+		_, err = zf.Write(e.data)
+		if err != nil {
+			return err
+		}
+		<-slots
+	}
 	return nil
 }
+
+// handleDownloadCompressFile creates the zip entry for the file with the given
+// name within the given directory; the content is compressed, so it can be
+// written to the zip as raw data
+func handleDownloadCompressFile(dir string, name string) exportEntry {
+	log.Println("Adding file to zip:", name)
+	data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+	if err != nil {
+		return exportEntry{err: err}
+	}
+	// create header; it only contains normalized metadata, so identical content
+	// results in identical archives
+	h := &zip.FileHeader{
+		Name:     name,
+		Modified: exportTime(),
+	}
+	h.SetMode(0o644)
+	// compress file
+	compressed := bytes.Buffer{}
+	fw, err := flate.NewWriter(&compressed, flate.DefaultCompression)
+	if err != nil {
+		return exportEntry{err: err}
+	}
+	_, err = fw.Write(data)
+	if err == nil {
+		err = fw.Close()
+	}
+	if err != nil {
+		return exportEntry{err: err}
+	}
+	h.Method = zip.Deflate
+	h.CRC32 = crc32.ChecksumIEEE(data)
+	h.UncompressedSize64 = uint64(len(data))
+	h.CompressedSize64 = uint64(compressed.Len())
+	return exportEntry{header: h, data: compressed.Bytes()}
+}
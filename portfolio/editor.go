@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"github.com/duevil/Go_Portfolio/content"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+)
+
+// handleMarkdownPreview handles requests to preview markdown edited in the
+// admin frontend; the request body is a page like for the pages API. The
+// markdown is rendered like a stored page using the 'page' template, so the
+// editor shows the page as it will be served; nothing is stored.
+func handleMarkdownPreview(c *gin.Context) {
+	log.Println("Markdown preview requested")
+	req, ok := bindPage(c)
+	if !ok {
+		return
+	}
+	if req.Content == nil {
+		abortFields(c, map[string]string{"content": "is required"})
+		return
+	}
+	uri := "/preview.md"
+	if req.URI != "" {
+		var err error
+		uri, err = pageURI(req.URI)
+		if errUpload(c, err) {
+			return
+		}
+	}
+	page, err := content.PreviewPage(uri, []byte(*req.Content))
+	if errISE(c, err) {
+		return
+	}
+	buf := bytes.Buffer{}
+	err = page.CreateHTML(currentTemplates(), &buf)
+	if errISE(c, err) {
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Header("X-Robots-Tag", "noindex")
+	c.Data(http.StatusOK, "text/html; charset=utf-8", buf.Bytes())
+}
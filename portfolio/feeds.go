@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/xml"
+	"github.com/duevil/Go_Portfolio/content"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxFeedEntries is the maximum number of pages contained in the feeds
+const maxFeedEntries = 20
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type sitemap struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title   string   `xml:"title"`
+	ID      string   `xml:"id"`
+	Link    atomLink `xml:"link"`
+	Updated string   `xml:"updated"`
+	Summary string   `xml:"summary,omitempty"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Author  string      `xml:"author>name,omitempty"`
+	Links   []atomLink  `xml:"link"`
+	Updated string      `xml:"updated"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type rssItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	GUID        string `xml:"guid"`
+	PubDate     string `xml:"pubDate"`
+	Description string `xml:"description,omitempty"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name  `xml:"rss"`
+	Version string    `xml:"version,attr"`
+	Title   string    `xml:"channel>title"`
+	Link    string    `xml:"channel>link"`
+	Desc    string    `xml:"channel>description"`
+	Items   []rssItem `xml:"channel>item"`
+}
+
+// siteURL returns the base URL of the site without trailing slash; it is taken
+// from SITE_URL or, if not set and a request is given, derived from the request
+func siteURL(c *gin.Context) string {
+	if u := getEnvOrElse("SITE_URL", ""); u != "" || c == nil {
+		return strings.TrimSuffix(u, "/")
+	}
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host
+}
+
+// feedPages returns the markdown pages of the given files, most recent first;
+// pages are dated by the date of their front matter, so blog posts are
+// ordered by their publication date
+func feedPages(files []content.MongoFile) []content.MongoFile {
+	var pages []content.MongoFile
+	for _, f := range files {
+		if f.IsMD {
+			pages = append(pages, f)
+		}
+	}
+	sort.SliceStable(pages, func(i, j int) bool { return pageDate(pages[i]).After(pageDate(pages[j])) })
+	return pages
+}
+
+// buildSitemap creates the sitemap of the markdown pages of the given files
+// using the given base URL
+func buildSitemap(base string, files []content.MongoFile) ([]byte, error) {
+	s := sitemap{URLs: []sitemapURL{}}
+	for _, p := range feedPages(files) {
+		u := sitemapURL{Loc: base + "/" + exportName(p)}
+		if !p.LastMod.IsZero() {
+			u.LastMod = p.LastMod.UTC().Format(time.DateOnly)
+		}
+		s.URLs = append(s.URLs, u)
+	}
+	sort.Slice(s.URLs, func(i, j int) bool { return s.URLs[i].Loc < s.URLs[j].Loc })
+	return marshalXML(s)
+}
+
+// buildAtom creates the Atom feed of the most recently modified markdown pages
+// of the given files using the given base URL
+func buildAtom(base string, files []content.MongoFile) ([]byte, error) {
+	feed := atomFeed{
+		Title:  siteTitle(),
+		ID:     base + "/",
+		Author: siteAuthor(),
+		Links:  []atomLink{{Href: base + "/"}, {Href: base + "/feed.xml", Rel: "self"}},
+	}
+	for i, p := range feedPages(files) {
+		if i == maxFeedEntries {
+			break
+		}
+		summary, err := feedSummary(p)
+		if err != nil {
+			return nil, err
+		}
+		u := base + "/" + exportName(p)
+		updated := p.LastMod.UTC().Format(time.RFC3339)
+		if updated > feed.Updated {
+			feed.Updated = updated
+		}
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title:   p.Title(),
+			ID:      u,
+			Link:    atomLink{Href: u},
+			Updated: updated,
+			Summary: summary,
+		})
+	}
+	if feed.Updated == "" {
+		feed.Updated = exportTime().Format(time.RFC3339)
+	}
+	return marshalXML(feed)
+}
+
+// buildRSS creates the RSS feed of the most recently modified markdown pages of
+// the given files using the given base URL
+func buildRSS(base string, files []content.MongoFile) ([]byte, error) {
+	feed := rssFeed{Version: "2.0", Title: siteTitle(), Link: base + "/", Desc: siteTitle()}
+	for i, p := range feedPages(files) {
+		if i == maxFeedEntries {
+			break
+		}
+		summary, err := feedSummary(p)
+		if err != nil {
+			return nil, err
+		}
+		u := base + "/" + exportName(p)
+		feed.Items = append(feed.Items, rssItem{
+			Title:       p.Title(),
+			Link:        u,
+			GUID:        u,
+			PubDate:     pageDate(p).UTC().Format(time.RFC1123Z),
+			Description: summary,
+		})
+	}
+	return marshalXML(feed)
+}
+
+// siteTitle returns the title of the site used in feeds, set by the settings
+// or else configured by SITE_TITLE
+func siteTitle() string {
+	s, err := content.LoadSettings()
+	if err == nil && s.SiteTitle != "" {
+		return s.SiteTitle
+	}
+	return getEnvOrElse("SITE_TITLE", "Portfolio")
+}
+
+// siteAuthor returns the author of the site set by the settings; empty if not
+// set
+func siteAuthor() string {
+	s, err := content.LoadSettings()
+	if err != nil {
+		return ""
+	}
+	return s.Author
+}
+
+// feedSummary returns the summary of the given page's front matter or else
+// the excerpt of the page used as feed summary
+func feedSummary(p content.MongoFile) (string, error) {
+	if p.Meta != nil && p.Meta.Summary != "" {
+		return p.Meta.Summary, nil
+	}
+	md, err := p.Markdown()
+	if err != nil {
+		return "", err
+	}
+	return excerpt(content.PlainText(md)), nil
+}
+
+// marshalXML marshals the given value to indented XML including the XML header
+func marshalXML(v any) ([]byte, error) {
+	data, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// feedHandler returns a handler serving the document created by the given
+// build function for all files in the database with the given mime type
+func feedHandler(mime string, build func(string, []content.MongoFile) ([]byte, error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		log.Println("Feed requested:", c.Request.URL.Path)
+		files, err := content.ListAll()
+		if errISE(c, err) {
+			return
+		}
+		files = filterFiles(files, func(f *content.MongoFile) bool { return f.Listed() && f.Published() })
+		data, err := build(siteURL(c), files)
+		if errISE(c, err) {
+			return
+		}
+		c.Data(http.StatusOK, mime, data)
+	}
+}
+
+// feedsHook is an export hook writing the sitemap and the feeds of the
+// listed pages into the export
+type feedsHook struct{}
+
+func (feedsHook) Name() string { return "feeds and sitemap" }
+
+func (feedsHook) Run(dir string, base string, files []content.MongoFile) error {
+	files = filterFiles(files, (*content.MongoFile).Listed)
+	for name, build := range map[string]func(string, []content.MongoFile) ([]byte, error){
+		"sitemap.xml": buildSitemap,
+		"feed.xml":    buildAtom,
+		"rss.xml":     buildRSS,
+	} {
+		data, err := build(base, files)
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(filepath.Join(dir, name), data, 0o644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,79 +1,235 @@
 package main
 
 import (
-	"content"
+	"auth"
+	"errors"
+	"github.com/duevil/Go_Portfolio/content"
 	"github.com/gin-gonic/gin"
+	"io"
 	"log"
+	"mime"
 	"net/http"
+	"path"
+	"strings"
 	"time"
 )
 
+// pageVariants are the templates pages are rendered with instead of their own
+// template if requested by the query parameter of the same name, like
+// '?print=1'; 'print' leaves out navigation and footer for printing and
+// 'minimal' is a plain rendering without scripts for slow connections and
+// reader modes, which pages may also select by their front matter
+var pageVariants = []string{"print", "minimal"}
+
+// newPage returns a page with the given title and base which is not backed by
+// a file; if the settings or the menu cannot be loaded, the page is rendered
+// without them
+func newPage(title string, base string) content.Page {
+	return newLocalizedPage(title, base, content.DefaultLanguage())
+}
+
+// newLocalizedPage returns a page like newPage in the given language, which
+// has the menu of the pages in the language
+func newLocalizedPage(title string, base string, lang string) content.Page {
+	settings, err := content.LoadSettings()
+	if err != nil {
+		log.Println("[Err] Loading settings:", err)
+	}
+	menu, err := content.LoadMenu(lang)
+	if err != nil {
+		log.Println("[Err] Loading menu:", err)
+	}
+	return content.Page{
+		Title:    title,
+		Base:     base,
+		Root:     content.URIRoot,
+		Year:     time.Now().Year(),
+		Settings: settings,
+		Menu:     menu,
+		Lang:     lang,
+	}
+}
+
 // handleNotFound handles requests for non-existing routes; servers a 404
-// response with the parsed '404' template as content
+// response with the parsed '404' template as content unless a redirect of the
+// requested path is set
 func handleNotFound(c *gin.Context) {
 	log.Println("Route not found")
-	c.HTML(http.StatusNotFound, "404", content.Page{
-		Title: "404",
-		Base:  c.Request.URL.Path[1:], // remove leading '/'
-		Root:  content.URIRoot,
-		Year:  time.Now().Year(),
-	})
+	if applyRedirect(c) {
+		return
+	}
+	surrogateKeys(c, keyMenu, keySettings)
+	// pages requested below a language prefix are not found in the language
+	lang := c.GetString("lang")
+	if lang == "" {
+		lang = content.DefaultLanguage()
+	}
+	c.HTML(http.StatusNotFound, "404", newLocalizedPage("404", c.Request.URL.Path[1:], lang)) // remove leading '/'
 }
 
 // handleFile handles requests for pages, templates and static files; if the
 // requested file is a markdown file, it is converted to HTML and served, else
-// the file is served as-is
+// the file is served as-is. Pages requested without language prefix are
+// redirected to the translation preferred by the client.
 func handleFile(c *gin.Context) {
 	file := c.Param("uri")
-	log.Println("File requested:", file)
-	// get file from database
-	f, err := content.GetFromDB(file)
-	if errNotFound(c, err) || errISE(c, err) {
+	lang := c.GetString("lang")
+	log.Println("File requested:", file, lang)
+	// get file from database; concurrent requests for the same file share the
+	// lookup, pages are served at their slugs
+	f, err := getLocalized(file, lang)
+	if errNotFound(c, err) || errUnavailable(c, err) || errISE(c, err) {
+		return
+	}
+	// drafts are served to reviewers presenting a preview token
+	if secret := c.Query("preview"); secret != "" {
+		servePreview(c, f, secret)
+		return
+	}
+	// private and unpublished files are served at signed download URLs
+	if signature := c.Query("signature"); signature != "" {
+		serveSigned(c, f, signature, c.Query("expires"))
 		return
 	}
+	// quarantined, staged and previous files as well as drafts and archived
+	// files are not served
+	if !f.Public() || !f.Published() {
+		errNotFound(c, content.ErrNotFound)
+		return
+	}
+	// private files are only served to authenticated users, unlisted files
+	// must not be indexed
+	if f.Private() {
+		noCDNCache(c)
+		chain(sessionAuth, requirePermission(auth.PermRead))(c)
+		if c.IsAborted() {
+			return
+		}
+	} else if !f.Listed() {
+		c.Header("X-Robots-Tag", "noindex")
+	}
+	if lang == "" && f.IsMD && negotiateLanguage(c, f) {
+		return
+	}
+	// pages requested at their uri or a previous slug are redirected to their
+	// current slug, pages in other languages to their language prefix
+	requested := path.Join("/", content.URIRoot, file)
+	if lang != "" {
+		requested = "/" + lang + requested
+	}
+	if (f.Slug != "" || f.Lang != "") && requested != f.Link() && requested != f.LanguageLink() {
+		redirectLink(c, f)
+		return
+	}
+	if fileKey(f.URI) != fileKey(file) {
+		surrogateKeys(c, fileKey(f.URI))
+	}
+	var adjust func(page *content.Page)
+	for _, v := range pageVariants {
+		if c.Query(v) == "1" {
+			c.Header("X-Robots-Tag", "noindex")
+			adjust = func(page *content.Page) { page.Template = v }
+			break
+		}
+	}
+	serveFile(c, f, adjust)
+}
+
+// redirectLink permanently redirects the request to the link of the given
+// file keeping the query
+func redirectLink(c *gin.Context, f content.MongoFile) {
+	link := f.Link()
+	if q := c.Request.URL.RawQuery; q != "" {
+		link += "?" + q
+	}
+	log.Println("Redirecting to link:", link)
+	c.Redirect(http.StatusMovedPermanently, link)
+}
+
+// serveFile serves the given file; if the file is a markdown file, it is
+// rendered using renderPage with the given function, else the file is served
+// as-is
+func serveFile(c *gin.Context, f content.MongoFile, adjust func(page *content.Page)) {
+	file := f.URI
 	// serve page if file is markdown
 	if f.IsMD {
 		log.Println("Serving markdown page:", file)
-		page, err := f.ToPage()
-		if errISE(c, err) {
+		html, err := renderPage(f, adjust)
+		if errUnavailable(c, err) || errISE(c, err) {
 			return
 		}
-		c.HTML(http.StatusOK, "page", page)
+		surrogateKeys(c, keyMenu, keySettings)
+		c.Data(http.StatusOK, "text/html; charset=utf-8", html)
 		return
 	}
+	// serve the preferred image variant if the client accepts one
+	if len(f.Variants) > 0 {
+		c.Header("Vary", "Accept")
+		if uri := negotiateVariant(f, c.GetHeader("Accept")); uri != "" {
+			v, err := getFile(uri)
+			if err == nil {
+				log.Println("Serving image variant:", uri)
+				f = v
+			} else if !errors.Is(content.ErrNotFound, err) && (errUnavailable(c, err) || errISE(c, err)) {
+				return
+			}
+		}
+	}
 	// serve file as-is
 	log.Println("Serving file:", file)
-	rc, err := f.Open()
-	if errISE(c, err) {
+	var rc io.ReadCloser
+	err := dbBreaker.do(func() (err error) {
+		rc, err = f.Open()
+		return err
+	})
+	if errUnavailable(c, err) || errISE(c, err) {
 		return
 	}
 	defer cls(rc)
-	c.DataFromReader(http.StatusOK, f.Filesize, f.Mime, rc, nil)
+	var headers map[string]string
+	if isBlockedMime(f.Mime) {
+		log.Println("Serving blocked mime type as attachment:", f.Mime)
+		headers = map[string]string{
+			"Content-Disposition":    mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(f.URI)}),
+			"X-Content-Type-Options": "nosniff",
+		}
+	}
+	c.DataFromReader(http.StatusOK, f.Filesize, f.Mime, rc, headers)
 }
 
 // handleAdmin handles requests for the admin page; serves the parsed 'admin'
 // template as page
 func handleAdmin(c *gin.Context) {
 	log.Println("Admin requested")
-	c.HTML(http.StatusOK, "admin", content.Page{
-		Title: "Admin",
-		Base:  "admin/",
-		Root:  content.URIRoot,
-		Year:  time.Now().Year(),
-	})
+	setCSRFCookie(c)
+	c.HTML(http.StatusOK, "admin", newPage("Admin", "admin/"))
 }
 
-// handleList handles requests to list all files in the database
+// handleList handles requests to list all files in the database including
+// their content hashes; missing hashes of files stored before hashes were
+// recorded are computed once
 func handleList(c *gin.Context) {
 	log.Println("List requested")
 	list, err := content.ListAll()
 	if errISE(c, err) {
 		return
 	}
+	// private files are only listed for admins
+	role, _ := c.Get("role")
+	if r, _ := role.(auth.Role); !r.Can(auth.PermAdmin) {
+		list = filterFiles(list, func(f *content.MongoFile) bool { return !f.Private() })
+	}
+	for i := range list {
+		if _, err := list[i].ContentHash(); err != nil {
+			log.Println("[Err] Computing content hash:", list[i].URI, err)
+		}
+	}
 	c.JSON(http.StatusOK, list)
 }
 
-// handleDelete handles requests to delete files from the database
+// handleDelete handles requests to delete files from the database; if the
+// request has an If-Match header, the file is only deleted if its content hash
+// matches, else the request is answered with status 412
 func handleDelete(c *gin.Context) {
 	name := c.Param("uri")
 	log.Println("Delete requested:", name)
@@ -81,9 +237,66 @@ func handleDelete(c *gin.Context) {
 	if errNotFound(c, err) || errISE(c, err) {
 		return
 	}
-	err = f.Delete()
+	deleteFile(c, f)
+}
+
+// deleteFile deletes the given file and its variants and responds with status
+// 204; the request's If-Match header is checked as described at handleDelete
+func deleteFile(c *gin.Context, f content.MongoFile) {
+	if !allowURI(c, f.URI) {
+		return
+	}
+	var err error
+	if ifMatch := c.GetHeader("If-Match"); ifMatch != "" {
+		var hash string
+		hash, err = f.ContentHash()
+		if errISE(c, err) {
+			return
+		}
+		if !matchETag(ifMatch, hash) {
+			err = content.ErrHashMismatch
+		} else {
+			err = f.DeleteIfHash(hash)
+		}
+		if errors.Is(err, content.ErrHashMismatch) {
+			log.Println("[Err] Precondition failed for delete:", f.URI)
+			c.AbortWithStatusJSON(http.StatusPreconditionFailed, gin.H{"error": err.Error(), "sha256": hash})
+			return
+		}
+	} else {
+		err = f.Delete()
+	}
+	if err == nil {
+		err = deleteVariants(f)
+	}
 	if errISE(c, err) {
 		return
 	}
+	contentChanged(f.URI)
 	c.Status(http.StatusNoContent)
 }
+
+// deleteVariants deletes the variants of the given file
+func deleteVariants(f content.MongoFile) error {
+	for _, m := range f.Variants {
+		v := content.MongoFile{URI: f.URI + extensionByType(m)}
+		err := v.Delete()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchETag returns whether the given If-Match header matches the given hash;
+// the header is a comma separated list of quoted or unquoted hashes or '*';
+// weak tags never match
+func matchETag(header string, hash string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.Trim(strings.TrimSpace(tag), `"`)
+		if tag == "*" || strings.EqualFold(tag, hash) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"log"
+	"net/http"
+	"time"
+)
+
+// handleHealth handles health checks of load balancers and orchestrators;
+// responds with status 503 if the database or the blob store is not available,
+// so an instance that lost access to shared storage stops receiving requests.
+// The state of the database circuit, the number of slow database operations
+// and renderings and the number of storage warnings are reported as well.
+func handleHealth(c *gin.Context) {
+	checks := map[string]func() error{
+		"database": func() error { return dbClient.Ping(dbCtx, readpref.Primary()) },
+		"storage":  contentEngine.CheckBlobStore,
+	}
+	state, failures, retry := dbBreaker.status()
+	circuit := gin.H{"state": state, "failures": failures}
+	if state == circuitOpen {
+		circuit["retry_in"] = retry.Round(time.Second).String()
+	}
+	queries, renders := contentEngine.SlowCounts()
+	slow := gin.H{"queries": queries, "renders": renders}
+	status := gin.H{"status": "ok", "circuit": circuit, "slow": slow}
+	// warnings of the last usage check; storage warnings do not make the
+	// instance unavailable
+	usageMu.Lock()
+	if lastUsageReport != nil {
+		status["storage_warnings"] = len(lastUsageReport.Warnings)
+	}
+	usageMu.Unlock()
+	for name, check := range checks {
+		if err := check(); err != nil {
+			log.Println("[Err] Health check", name, "failed:", err)
+			status["status"] = "unavailable"
+			status[name] = "unavailable"
+		}
+	}
+	if status["status"] != "ok" {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, status)
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
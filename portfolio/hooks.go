@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"github.com/duevil/Go_Portfolio/content"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// exportHook is a post-processing step of the export; it is run with the
+// staging directory containing the rendered site, the base URL of the site
+// without trailing slash and the exported files before the directory is
+// archived, so it may add, change or remove files
+type exportHook interface {
+	Name() string
+	Run(dir string, base string, files []content.MongoFile) error
+}
+
+// exportHooks are the hooks run on every export in the given order; hooks are
+// added by registerExportHook
+var exportHooks []exportHook
+
+// registerExportHook adds the given hook to the hooks run on every export
+func registerExportHook(h exportHook) {
+	log.Println("Registering export hook:", h.Name())
+	exportHooks = append(exportHooks, h)
+}
+
+// runExportHooks runs all registered export hooks on the given staging
+// directory of the site with the given base URL; stops at the first failing
+// hook
+func runExportHooks(dir string, base string, files []content.MongoFile) error {
+	for _, h := range exportHooks {
+		log.Println("Running export hook:", h.Name())
+		err := h.Run(dir, base, files)
+		if err != nil {
+			return fmt.Errorf("export hook %s: %w", h.Name(), err)
+		}
+	}
+	return nil
+}
+
+// commandHook is an export hook running a shell command inside the staging
+// directory; the directory and the base URL of the site are also passed as
+// EXPORT_DIR and EXPORT_BASE_URL environment variables
+type commandHook struct {
+	command string
+}
+
+func (h commandHook) Name() string { return "command: " + h.command }
+
+func (h commandHook) Run(dir string, base string, _ []content.MongoFile) error {
+	cmd := exec.Command("sh", "-c", h.command)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "EXPORT_DIR="+dir, "EXPORT_BASE_URL="+base)
+	out, err := cmd.CombinedOutput()
+	if len(out) > 0 {
+		log.Println("Export hook output:", strings.TrimSpace(string(out)))
+	}
+	return err
+}
+
+// registerCommandHooks registers a commandHook for each line of EXPORT_HOOKS
+func registerCommandHooks() {
+	for _, line := range strings.Split(getEnvOrElse("EXPORT_HOOKS", ""), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			registerExportHook(commandHook{command: line})
+		}
+	}
+}
+
+// contentChanged notifies the mirror instance and the CDN that the published
+// files with the given uris changed
+func contentChanged(uris ...string) {
+	replicate(uris...)
+	purgeCDN(uris...)
+}
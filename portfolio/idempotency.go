@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"net/http"
+	"time"
+)
+
+// idempotencyHeader is the header clients send a unique key for an operation
+// with, so retries of the operation return the original result
+const idempotencyHeader = "Idempotency-Key"
+
+// maxIdempotentBody is the maximum size of a response body that is recorded;
+// operations with larger responses are not recorded
+const maxIdempotentBody = 64 << 10
+
+// idempotencyHeaders are the response headers that are recorded and replayed
+var idempotencyHeaders = []string{"Location", "Content-Type", "ETag", "Last-Modified"}
+
+var (
+	// idempotencyCol is the collection the results of operations are recorded
+	// in; shared by all instances, so retries may reach any instance
+	idempotencyCol *mongo.Collection
+	// idempotencyTTL is the time results are kept set by IDEMPOTENCY_TTL
+	idempotencyTTL = getEnvDurationOrElse("IDEMPOTENCY_TTL", 24*time.Hour)
+)
+
+// idempotencyRecord is the recorded result of an operation
+type idempotencyRecord struct {
+	ID      string              `bson:"_id"`
+	Method  string              `bson:"method"`
+	Path    string              `bson:"path"`
+	Pending bool                `bson:"pending"`
+	Status  int                 `bson:"status,omitempty"`
+	Header  map[string][]string `bson:"header,omitempty"`
+	Body    []byte              `bson:"body,omitempty"`
+	Expires time.Time           `bson:"expires"`
+}
+
+// recordingWriter records the body written to the response up to the limit
+type recordingWriter struct {
+	gin.ResponseWriter
+	limit    int
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.record(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.record([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+// record appends the given data to the recorded body
+func (w *recordingWriter) record(data []byte) {
+	if w.overflow || w.body.Len()+len(data) > w.limit {
+		w.overflow = true
+		return
+	}
+	w.body.Write(data)
+}
+
+// setIdempotencyCollection sets the collection the results of operations are
+// recorded in and creates an index expiring them
+func setIdempotencyCollection(c *mongo.Collection) {
+	idempotencyCol = c
+	index := mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+	_, err := c.Indexes().CreateOne(dbCtx, index)
+	if err != nil {
+		log.Println("[Err] Creating idempotency index:", err)
+	}
+}
+
+// idempotencyID returns the id of the record of the given key; the key is
+// scoped to the credentials of the request, so clients cannot replay the
+// results of other clients
+func idempotencyID(c *gin.Context, key string) string {
+	session, _ := c.Cookie(sessionCookie)
+	sum := sha256.Sum256([]byte(c.GetHeader("Authorization") + "\n" + session + "\n" + key))
+	return hex.EncodeToString(sum[:])
+}
+
+// recordable returns whether a response with the given status is recorded;
+// server errors and rejected credentials or rate limits are not, so retries
+// are executed again
+func recordable(status int) bool {
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests:
+		return false
+	}
+	return status < http.StatusInternalServerError
+}
+
+// idempotent wraps the given handler, so requests with an Idempotency-Key
+// header are executed at most once per key; retries with the same key and
+// credentials are answered with the recorded result, marked by the header
+// Idempotent-Replayed. Retries during the execution are answered with status
+// 409, reusing a key for another operation with status 422.
+func idempotent(h gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(idempotencyHeader)
+		if key == "" {
+			h(c)
+			return
+		}
+		if len(key) > 255 {
+			errStatus(c, http.StatusBadRequest, errors.New("idempotency key is too long"))
+			return
+		}
+		r := idempotencyRecord{
+			ID:      idempotencyID(c, key),
+			Method:  c.Request.Method,
+			Path:    c.Request.URL.Path,
+			Pending: true,
+			Expires: time.Now().Add(idempotencyTTL),
+		}
+		_, err := idempotencyCol.InsertOne(dbCtx, r)
+		if mongo.IsDuplicateKeyError(err) {
+			replayIdempotent(c, r)
+			return
+		}
+		if errISE(c, err) {
+			return
+		}
+		// the record is removed unless the result is recorded, so a retry is
+		// executed again
+		recorded := false
+		defer func() {
+			if !recorded {
+				_, err := idempotencyCol.DeleteOne(dbCtx, bson.M{"_id": r.ID})
+				if err != nil {
+					log.Println("[Err] Removing idempotency record:", err)
+				}
+			}
+		}()
+		w := &recordingWriter{ResponseWriter: c.Writer, limit: maxIdempotentBody}
+		c.Writer = w
+		h(c)
+		c.Writer = w.ResponseWriter
+		if w.overflow || !recordable(w.Status()) {
+			return
+		}
+		header := map[string][]string{}
+		for _, name := range idempotencyHeaders {
+			if v := w.Header().Values(name); len(v) > 0 {
+				header[name] = v
+			}
+		}
+		_, err = idempotencyCol.UpdateByID(dbCtx, r.ID, bson.M{"$set": bson.M{
+			"pending": false, "status": w.Status(), "header": header, "body": w.body.Bytes()}})
+		if err != nil {
+			log.Println("[Err] Recording idempotency result:", err)
+			return
+		}
+		recorded = true
+	}
+}
+
+// replayIdempotent answers the request with the recorded result of the
+// operation of the given record
+func replayIdempotent(c *gin.Context, r idempotencyRecord) {
+	var recorded idempotencyRecord
+	err := idempotencyCol.FindOne(dbCtx, bson.M{"_id": r.ID}).Decode(&recorded)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// the operation failed or the record expired in the meantime
+		err = errors.New("operation with this idempotency key did not complete; retry")
+		errStatus(c, http.StatusConflict, err)
+		return
+	}
+	if errISE(c, err) {
+		return
+	}
+	switch {
+	case recorded.Method != r.Method || recorded.Path != r.Path:
+		errStatus(c, http.StatusUnprocessableEntity, errors.New("idempotency key was used for another operation"))
+	case recorded.Pending:
+		errStatus(c, http.StatusConflict, errors.New("operation with this idempotency key is in progress"))
+	default:
+		log.Println("Replaying operation:", r.Method, r.Path)
+		for name, values := range recorded.Header {
+			for _, v := range values {
+				c.Writer.Header().Add(name, v)
+			}
+		}
+		c.Header("Idempotent-Replayed", "true")
+		c.Status(recorded.Status)
+		_, _ = c.Writer.Write(recorded.Body)
+		c.Abort()
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"github.com/duevil/Go_Portfolio/content"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// imageVariant is a format raster images are converted to, so clients
+// accepting the format can be served a smaller file
+type imageVariant struct {
+	mime string
+	ext  string
+	// env is the environment variable configuring the conversion command
+	env string
+	// fallback is the default conversion command; used if its executable exists
+	fallback string
+}
+
+// imageVariants are the supported variant formats ordered by preference
+var imageVariants = []imageVariant{
+	{mime: "image/avif", ext: ".avif", env: "IMAGE_AVIF_CMD", fallback: "avifenc {in} {out}"},
+	{mime: "image/webp", ext: ".webp", env: "IMAGE_WEBP_CMD", fallback: "cwebp -quiet -q 80 {in} -o {out}"},
+}
+
+// isRasterImage returns whether the given mime type is a raster image format
+// variants are created for
+func isRasterImage(mime string) bool {
+	return mime == "image/jpeg" || mime == "image/png"
+}
+
+// command returns the conversion command of the variant; returns an empty
+// string if conversion to the variant is disabled or not available
+func (v imageVariant) command() string {
+	cmd, ok := os.LookupEnv(v.env)
+	if ok {
+		return cmd
+	}
+	if _, err := exec.LookPath(strings.Fields(v.fallback)[0]); err != nil {
+		return ""
+	}
+	return v.fallback
+}
+
+// convert converts the image file at the given path into the variant format;
+// returns the path of the converted file or an empty string if the conversion
+// is not available or did not reduce the file size
+func (v imageVariant) convert(in string) (string, error) {
+	cmd := v.command()
+	if cmd == "" {
+		return "", nil
+	}
+	out := strings.TrimSuffix(in, filepath.Ext(in)) + v.ext
+	args := strings.Fields(cmd)
+	for i, a := range args {
+		args[i] = strings.NewReplacer("{in}", in, "{out}", out).Replace(a)
+	}
+	output, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		log.Println("[Err] Image conversion failed:", strings.TrimSpace(string(output)))
+		return "", err
+	}
+	inInfo, err := os.Stat(in)
+	if err != nil {
+		return "", err
+	}
+	outInfo, err := os.Stat(out)
+	if err != nil {
+		return "", err
+	}
+	if outInfo.Size() >= inInfo.Size() {
+		log.Println("Variant is not smaller than original, skipping:", v.mime)
+		return "", nil
+	}
+	return out, nil
+}
+
+// variantsAvailable returns whether conversion to any variant format is
+// available
+func variantsAvailable() bool {
+	for _, v := range imageVariants {
+		if v.command() != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// storeImage stores the given raster image together with its variants; the
+// image is written to a temporary file the converters read from
+func storeImage(f content.MongoFile, r io.Reader) error {
+	if !variantsAvailable() {
+		return f.Store(r)
+	}
+	// the image and up to one converted variant per format are written
+	dir, err := makeScratchDir("img", int64(len(imageVariants)+1)*f.Filesize)
+	if errors.Is(err, errNoSpace) {
+		return &uploadError{status: http.StatusInsufficientStorage, msg: err.Error()}
+	}
+	if err != nil {
+		return err
+	}
+	defer func(path string) { _ = os.RemoveAll(path) }(dir)
+	in := filepath.Join(dir, "image"+path.Ext(f.URI))
+	tmp, err := os.Create(in)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tmp, r)
+	cls(tmp)
+	if err != nil {
+		return err
+	}
+	err = storeImageVariants(&f, in)
+	if err != nil {
+		return err
+	}
+	return storeLocalFile(f, in)
+}
+
+// storeImageVariants converts the image at the given path into all available
+// variant formats and stores the variants next to the given file; the mime
+// types of the stored variants are recorded in the file's Variants field
+func storeImageVariants(f *content.MongoFile, in string) error {
+	f.Variants = nil
+	for _, v := range imageVariants {
+		out, err := v.convert(in)
+		if err != nil {
+			return err
+		}
+		if out == "" {
+			continue
+		}
+		err = storeLocalFile(content.MongoFile{URI: f.URI + v.ext, LastMod: f.LastMod, Mime: v.mime, Staging: f.Staging, Visibility: f.Visibility}, out)
+		if err != nil {
+			return err
+		}
+		f.Variants = append(f.Variants, v.mime)
+	}
+	return nil
+}
+
+// storeLocalFile stores the file at the given path with the given metadata
+func storeLocalFile(f content.MongoFile, p string) error {
+	r, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer cls(r)
+	fi, err := r.Stat()
+	if err != nil {
+		return err
+	}
+	f.Filesize = fi.Size()
+	return f.Store(r)
+}
+
+// negotiateVariant returns the uri of the variant of the given file that is
+// preferred by the given Accept header; returns an empty string if the
+// original file should be served
+func negotiateVariant(f content.MongoFile, accept string) string {
+	for _, v := range imageVariants {
+		if !strings.Contains(accept, v.mime) {
+			continue
+		}
+		for _, m := range f.Variants {
+			if m == v.mime {
+				return f.URI + v.ext
+			}
+		}
+	}
+	return ""
+}
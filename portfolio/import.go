@@ -0,0 +1,232 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"github.com/duevil/Go_Portfolio/content"
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/gin-gonic/gin"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// notionIDRegexp matches the id Notion appends to exported file names
+	notionIDRegexp = regexp.MustCompile(`\s+[0-9a-f]{32}$`)
+	slugRegexp     = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// handleImport handles requests for importing HTML documents exported from
+// Google Docs or Notion; the uploaded file is either a single HTML file or a
+// zip file containing HTML files and their images; each HTML file is converted
+// to markdown and its images are stored as assets next to the page; the
+// optional form value 'dir' is used as directory for the imported files
+//
+// Like handleUpload, the auth middleware is called manually after the uploaded
+// file has been saved
+func handleImport(c *gin.Context, auth gin.HandlerFunc) {
+	log.Println("Import requested")
+	ff, ok := formFile(c)
+	if !ok {
+		return
+	}
+
+	// create tmp dir and save file
+	dir, err := makeScratchDir("import", ff.Size)
+	if errStorage(c, err) || errISE(c, err) {
+		return
+	}
+	defer func(path string) { _ = os.RemoveAll(path) }(dir)
+	fPath := path.Join(dir, path.Base(ff.Filename))
+	err = c.SaveUploadedFile(ff, fPath)
+	if errISE(c, err) {
+		return
+	}
+
+	// check credentials
+	auth(c)
+	if c.IsAborted() {
+		return
+	}
+
+	// collect documents and their resources
+	files := map[string][]byte{}
+	switch strings.ToLower(path.Ext(ff.Filename)) {
+	case ".zip":
+		zr, err := zip.OpenReader(fPath)
+		if errStatus(c, http.StatusBadRequest, err) {
+			return
+		}
+		defer cls(zr)
+		// the entries are read into memory, so their number and sizes are
+		// checked before and while reading them
+		entries := 0
+		for _, zf := range zr.File {
+			if !zf.FileInfo().IsDir() {
+				entries++
+			}
+		}
+		if errUpload(c, checkArchiveEntries(entries)) {
+			return
+		}
+		var total int64
+		for _, zf := range zr.File {
+			if zf.FileInfo().IsDir() {
+				continue
+			}
+			err = checkUploadSize(zf.Name, int64(zf.UncompressedSize64))
+			if errUpload(c, err) {
+				return
+			}
+			data, err := readZipFile(zf)
+			if errUpload(c, err) || errStatus(c, http.StatusBadRequest, err) {
+				return
+			}
+			total += int64(len(data))
+			if errUpload(c, checkUploadSize(ff.Filename, total)) {
+				return
+			}
+			files[path.Clean("/"+zf.Name)] = data
+		}
+	case ".html", ".htm":
+		data, err := os.ReadFile(fPath)
+		if errISE(c, err) {
+			return
+		}
+		files["/"+path.Base(ff.Filename)] = data
+	default:
+		c.AbortWithStatusJSON(http.StatusUnsupportedMediaType, gin.H{"error": "expected an HTML or zip file"})
+		return
+	}
+
+	// convert documents
+	u := newUploader(c)
+	target := path.Clean("/" + c.PostForm("dir"))
+	var stored []string
+	for name, data := range files {
+		if ext := path.Ext(name); ext != ".html" && ext != ".htm" {
+			continue
+		}
+		uris, err := importDocument(u, target, name, data, files)
+		stored = append(stored, uris...)
+		if errUpload(c, err) || errISE(c, err) {
+			return
+		}
+	}
+	if len(stored) == 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "no HTML documents found"})
+		return
+	}
+	c.JSON(u.status(), gin.H{"files": stored})
+}
+
+// importDocument converts the HTML document with the given name to markdown
+// and stores it in the given directory; images are stored in a directory named
+// after the page; relative image sources are looked up in the given files;
+// returns the uris of all stored files
+func importDocument(u uploader, dir string, name string, doc []byte, files map[string][]byte) ([]string, error) {
+	slug := importSlug(name)
+	var stored []string
+	images := 0
+	md, err := convertHTML(string(doc), func(src string) (string, error) {
+		var data []byte
+		var ext string
+		if strings.HasPrefix(src, "data:") {
+			var err error
+			data, ext, err = decodeDataURI(src)
+			if err != nil {
+				return "", err
+			}
+		} else if u, err := url.Parse(src); err == nil && u.Scheme == "" && u.Host == "" {
+			p, err := url.PathUnescape(u.Path)
+			if err != nil {
+				return "", err
+			}
+			var ok bool
+			data, ok = files[path.Join(path.Dir(name), p)]
+			if !ok {
+				log.Println("Image not found in import, keeping source:", src)
+				return src, nil
+			}
+			ext = path.Ext(p)
+		} else {
+			// external images are kept as they are
+			return src, nil
+		}
+		images++
+		uri := path.Join(dir, slug, "image-"+strconv.Itoa(images)+strings.ToLower(ext))
+		err := importStore(u, uri, data, false)
+		if err != nil {
+			return "", err
+		}
+		stored = append(stored, uri)
+		// links are relative to the content root
+		return strings.TrimPrefix(uri, "/"), nil
+	})
+	if err != nil {
+		return stored, err
+	}
+	uri := path.Join(dir, slug+".md")
+	err = importStore(u, uri, []byte(md), true)
+	if err != nil {
+		return stored, err
+	}
+	return append(stored, uri), nil
+}
+
+// importStore stores the given data as file with the given uri using the given
+// uploader
+func importStore(u uploader, uri string, data []byte, isMD bool) error {
+	ok, mime := checkMimeType(path.Ext(uri))
+	if !ok {
+		mime = mimetype.Detect(data).String()
+	}
+	f := content.MongoFile{
+		URI:      uri,
+		Filesize: int64(len(data)),
+		LastMod:  time.Now(),
+		Mime:     mime,
+		IsMD:     isMD,
+	}
+	return u.store(f, bytes.NewReader(data))
+}
+
+// importSlug returns a URL-safe page name for the document with the given
+// file name, stripping the id Notion appends to exported file names
+func importSlug(name string) string {
+	base := path.Base(name)
+	base = notionIDRegexp.ReplaceAllString(strings.TrimSuffix(base, path.Ext(base)), "")
+	base = strings.NewReplacer("ä", "ae", "ö", "oe", "ü", "ue", "ß", "ss").Replace(strings.ToLower(base))
+	base = strings.Trim(slugRegexp.ReplaceAllString(base, "-"), "-")
+	if base == "" {
+		return "import"
+	}
+	return base
+}
+
+// readZipFile reads the content of the given zip file entry; returns an
+// uploadError if the content exceeds maxUploadSize, whatever size the entry
+// states
+func readZipFile(zf *zip.File) ([]byte, error) {
+	rc, err := zf.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer cls(rc)
+	data, err := io.ReadAll(io.LimitReader(rc, maxUploadSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxUploadSize {
+		return nil, checkUploadSize(zf.Name, int64(len(data)))
+	}
+	return data, nil
+}
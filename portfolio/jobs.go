@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// job is a function that is run periodically in the background; jobs may
+// record a report of their last run, like statistics, which is listed by the
+// jobs API
+type job struct {
+	Name     string        `json:"name"`
+	Interval time.Duration `json:"interval"`
+	LastRun  time.Time     `json:"last_run,omitempty"`
+	LastErr  string        `json:"last_error,omitempty"`
+	Running  bool          `json:"running"`
+	Report   any           `json:"report,omitempty"`
+	run      func() error
+}
+
+var (
+	jobs   = map[string]*job{}
+	jobsMu sync.Mutex
+)
+
+// scheduleJob registers the given function as job with the given name and runs
+// it in the background, first right away and then every interval; a
+// non-positive interval disables the job
+func scheduleJob(name string, interval time.Duration, run func() error) {
+	if interval <= 0 {
+		log.Println("Job disabled:", name)
+		return
+	}
+	j := &job{Name: name, Interval: interval, run: run}
+	jobsMu.Lock()
+	jobs[name] = j
+	jobsMu.Unlock()
+	log.Println("Scheduling job", name, "every", interval)
+	go func() {
+		for {
+			runJob(j)
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// runJob runs the given job and records the time and the error of the run;
+// admins are notified of failed runs. Returns false without running the job
+// if it is already running.
+func runJob(j *job) bool {
+	jobsMu.Lock()
+	if j.Running {
+		jobsMu.Unlock()
+		log.Println("Job already running:", j.Name)
+		return false
+	}
+	j.Running = true
+	jobsMu.Unlock()
+	log.Println("Running job:", j.Name)
+	err := j.run()
+	jobsMu.Lock()
+	j.Running = false
+	j.LastRun = time.Now()
+	j.LastErr = ""
+	if err != nil {
+		log.Println("[Err] Job", j.Name, "failed:", err)
+		j.LastErr = err.Error()
+	}
+	jobsMu.Unlock()
+	if err != nil {
+		notify(notifyJob+":"+j.Name, notifyJob, "Job "+j.Name+" failed: "+err.Error())
+	} else {
+		resolve(notifyJob + ":" + j.Name)
+	}
+	return true
+}
+
+// setJobReport records the given report of the last run of the job with the
+// given name; called by the job while it runs
+func setJobReport(name string, report any) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	if j, ok := jobs[name]; ok {
+		j.Report = report
+	}
+}
+
+// handleJobs handles requests for the scheduled jobs including the time, the
+// error and the report of their last runs, sorted by name
+func handleJobs(c *gin.Context) {
+	log.Println("Jobs requested")
+	jobsMu.Lock()
+	list := make([]job, 0, len(jobs))
+	for _, j := range jobs {
+		list = append(list, *j)
+	}
+	jobsMu.Unlock()
+	sort.Slice(list, func(i, k int) bool { return list[i].Name < list[k].Name })
+	c.JSON(http.StatusOK, list)
+}
+
+// handleJobRun handles requests to run the job with the given name right
+// away; the job runs in the background, so the request is answered with
+// status 202, or with status 409 if the job is already running
+func handleJobRun(c *gin.Context) {
+	name := c.Param("name")
+	log.Println("Job run requested:", name)
+	jobsMu.Lock()
+	j, ok := jobs[name]
+	running := ok && j.Running
+	jobsMu.Unlock()
+	if !ok {
+		errStatus(c, http.StatusNotFound, errors.New("job not found: "+name))
+		return
+	}
+	if running {
+		errStatus(c, http.StatusConflict, errors.New("job is already running: "+name))
+		return
+	}
+	go runJob(j)
+	c.JSON(http.StatusAccepted, gin.H{"name": name})
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/duevil/Go_Portfolio/content"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/language"
+	"html/template"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// messages are the texts of the public templates by language; texts missing
+// in a language are taken from the default language or else from German
+var messages = map[string]map[string]string{
+	"de": {
+		"date":            "02.01.2006",
+		"home":            "Startseite",
+		"languages":       "Sprachen",
+		"last_mod":        "Diese Seite wurde zuletzt am %s geändert.",
+		"not_found":       "Die angefragte Seite konnte leider nicht gefunden werden.",
+		"as_of":           "Stand: %s",
+		"blog":            "Blog",
+		"page":            "Seite %d",
+		"newer":           "Neuere Beiträge",
+		"older":           "Ältere Beiträge",
+		"archive":         "Archiv",
+		"posts_of":        "Beiträge aus %d",
+		"comments":        "Kommentare",
+		"comment_author":  "Name",
+		"comment_email":   "E-Mail (optional, wird nicht veröffentlicht)",
+		"comment_text":    "Kommentar",
+		"comment_submit":  "Kommentar absenden",
+		"comment_pending": "Vielen Dank! Der Kommentar wird nach einer Prüfung veröffentlicht.",
+		"back":            "Zurück zur Seite",
+	},
+	"en": {
+		"date":            "January 2, 2006",
+		"home":            "Home",
+		"languages":       "Languages",
+		"last_mod":        "This page was last modified on %s.",
+		"not_found":       "Sorry, the requested page could not be found.",
+		"as_of":           "As of %s",
+		"blog":            "Blog",
+		"page":            "Page %d",
+		"newer":           "Newer posts",
+		"older":           "Older posts",
+		"archive":         "Archive",
+		"posts_of":        "Posts from %d",
+		"comments":        "Comments",
+		"comment_author":  "Name",
+		"comment_email":   "Email (optional, not published)",
+		"comment_text":    "Comment",
+		"comment_submit":  "Post comment",
+		"comment_pending": "Thank you! The comment will be published after moderation.",
+		"back":            "Back to the page",
+	},
+}
+
+// templateFuncs are the functions available in the templates
+var templateFuncs = template.FuncMap{"t": translate}
+
+// translate returns the text of the public templates with the given key in the
+// given language formatted with the given arguments; unknown keys are returned
+// as is
+func translate(lang string, key string, args ...any) string {
+	base, _, _ := strings.Cut(lang, "-")
+	for _, l := range []string{lang, base, content.DefaultLanguage(), "de"} {
+		if msg, ok := messages[l][key]; ok {
+			if len(args) > 0 {
+				return fmt.Sprintf(msg, args...)
+			}
+			return msg
+		}
+	}
+	return key
+}
+
+// parseLanguages returns the languages of the given comma separated list in
+// their order
+func parseLanguages(list string) []string {
+	var langs []string
+	for _, l := range strings.Split(list, ",") {
+		if l = strings.ToLower(strings.TrimSpace(l)); l != "" {
+			langs = append(langs, l)
+		}
+	}
+	return langs
+}
+
+// handleLocalizedFile handles requests for pages below a language prefix like
+// '/en/content/about.html'; the page is served in the language of the prefix
+// like handleFile serves it
+func handleLocalizedFile(c *gin.Context) {
+	lang := c.Param("lang")
+	if !content.ValidLanguage(lang) {
+		handleNotFound(c)
+		return
+	}
+	c.Set("lang", lang)
+	handleFile(c)
+}
+
+// getLocalized returns the file requested at the given uri in the given
+// language, which is the default language if empty; files in the default
+// language are looked up by their uri first, pages are looked up by their uri
+// without language suffix and then by their slug
+func getLocalized(file string, lang string) (content.MongoFile, error) {
+	if lang == "" {
+		lang = content.DefaultLanguage()
+	}
+	var f content.MongoFile
+	err := content.ErrNotFound
+	if lang == content.DefaultLanguage() {
+		f, err = getFile(file)
+	}
+	ext := path.Ext(file)
+	if errors.Is(content.ErrNotFound, err) && (ext == ".html" || ext == ".md") {
+		err = dbBreaker.do(func() (err error) {
+			f, err = content.GetInLanguage(file, lang)
+			return err
+		})
+	}
+	if errors.Is(content.ErrNotFound, err) && ext == ".html" {
+		err = dbBreaker.do(func() (err error) {
+			f, _, err = content.GetBySlug(strings.TrimSuffix(file, ".html"), lang)
+			return err
+		})
+	}
+	return f, err
+}
+
+// negotiateLanguage redirects the request for the given page to its
+// translation preferred by the Accept-Language header of the request; returns
+// whether the request was redirected. Pages without translations are not
+// redirected.
+func negotiateLanguage(c *gin.Context, f content.MongoFile) bool {
+	if len(content.Languages()) < 2 {
+		return false
+	}
+	var translations []content.Translation
+	err := dbBreaker.do(func() (err error) {
+		translations, err = f.Translations()
+		return err
+	})
+	if err != nil {
+		log.Println("[Err] Listing translations:", f.URI, err)
+		return false
+	}
+	if translations == nil {
+		return false
+	}
+	c.Header("Vary", "Accept-Language")
+	accepted, _, err := language.ParseAcceptLanguage(c.GetHeader("Accept-Language"))
+	if err != nil || len(accepted) == 0 {
+		return false
+	}
+	tags := make([]language.Tag, len(translations))
+	for i, t := range translations {
+		tags[i] = language.Make(t.Lang)
+	}
+	_, i, confidence := language.NewMatcher(tags).Match(accepted...)
+	if confidence == language.No || translations[i].Current {
+		return false
+	}
+	link := translations[i].Link
+	if q := c.Request.URL.RawQuery; q != "" {
+		link += "?" + q
+	}
+	log.Println("Redirecting to preferred language:", link)
+	c.Redirect(http.StatusFound, link)
+	return true
+}
+
+// absoluteTranslations makes the links of the translations of the given page
+// absolute using the given base URL, as search engines expect absolute
+// alternate links; the links are kept if the base URL is empty
+func absoluteTranslations(page *content.Page, base string) {
+	if base == "" {
+		return
+	}
+	for i, t := range page.Translations {
+		page.Translations[i].Link = base + t.Link
+		if t.DefaultLink != "" {
+			page.Translations[i].DefaultLink = base + t.DefaultLink
+		}
+	}
+}
+
+// exportTranslations changes the links of the translations of the given page
+// to the paths of the variants within an export, where the variant in the
+// default language is only written without language prefix
+func exportTranslations(page *content.Page) {
+	for i, t := range page.Translations {
+		if t.DefaultLink != "" {
+			page.Translations[i].Link = t.DefaultLink
+		}
+	}
+}
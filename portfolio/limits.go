@@ -0,0 +1,92 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// defaultAllowedUploadTypes are the mime types and extensions uploads are
+// restricted to by default
+const defaultAllowedUploadTypes = "text/*,image/*,font/*,audio/*,video/*,application/pdf," +
+	"application/json,application/xml,application/zip,application/javascript,.woff,.woff2,.ttf,.otf"
+
+var (
+	// maxUploadSize is the maximum size of an uploaded file in bytes set by
+	// MAX_UPLOAD_SIZE; it also applies to each file of an uploaded zip file
+	maxUploadSize = int64(getEnvIntOrElse("MAX_UPLOAD_SIZE", 512<<20))
+	// maxDirectUploadSize is the maximum size of a file uploaded directly to
+	// the blob store in bytes set by MAX_DIRECT_UPLOAD_SIZE; by default the
+	// maximum size of an object of a single upload to S3
+	maxDirectUploadSize = int64(getEnvIntOrElse("MAX_DIRECT_UPLOAD_SIZE", 5<<30))
+	// directUploadExpiry is the duration upload URLs of direct uploads are
+	// valid set by DIRECT_UPLOAD_EXPIRY
+	directUploadExpiry = getEnvDurationOrElse("DIRECT_UPLOAD_EXPIRY", time.Hour)
+	// maxArchiveEntries is the maximum number of files in an uploaded zip file
+	// set by MAX_ARCHIVE_ENTRIES
+	maxArchiveEntries = getEnvIntOrElse("MAX_ARCHIVE_ENTRIES", 1000)
+	// allowedUploadTypes are the mime types and extensions set by
+	// ALLOWED_UPLOAD_TYPES as comma separated list; mime types may end with
+	// '/*' to allow all subtypes and extensions start with a dot; '*' allows
+	// all files
+	allowedUploadTypes = parseList(getEnvOrElse("ALLOWED_UPLOAD_TYPES", defaultAllowedUploadTypes))
+)
+
+// isAllowedUpload returns whether a file with the given uri and mime type may
+// be uploaded; the file is allowed if either its mime type or its extension is
+// allowed
+func isAllowedUpload(uri string, mimeType string) bool {
+	if allowedUploadTypes["*"] || allowedUploadTypes[strings.ToLower(path.Ext(uri))] {
+		return true
+	}
+	t, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return false
+	}
+	major, _, _ := strings.Cut(t, "/")
+	return allowedUploadTypes[t] || allowedUploadTypes[major+"/*"]
+}
+
+// checkUploadSize returns an uploadError with status 413 if the given size of
+// the file with the given uri exceeds maxUploadSize
+func checkUploadSize(uri string, size int64) error {
+	if size <= maxUploadSize {
+		return nil
+	}
+	return &uploadError{status: http.StatusRequestEntityTooLarge, code: "too_large", file: uri,
+		msg: "file is too large: " + uri, details: map[string]any{"size": size, "limit": maxUploadSize}}
+}
+
+// checkDirectUploadSize returns an uploadError with status 413 if the given
+// size of the file with the given uri uploaded directly to the blob store
+// exceeds maxDirectUploadSize
+func checkDirectUploadSize(uri string, size int64) error {
+	if size <= maxDirectUploadSize {
+		return nil
+	}
+	return &uploadError{status: http.StatusRequestEntityTooLarge, code: "too_large", file: uri,
+		msg: "file is too large: " + uri, details: map[string]any{"size": size, "limit": maxDirectUploadSize}}
+}
+
+// checkArchiveEntries returns an uploadError with status 413 if the given
+// number of files of an uploaded zip file exceeds maxArchiveEntries
+func checkArchiveEntries(entries int) error {
+	if entries <= maxArchiveEntries {
+		return nil
+	}
+	return &uploadError{status: http.StatusRequestEntityTooLarge, code: "too_many_entries",
+		msg:     "zip file contains too many files",
+		details: map[string]any{"entries": entries, "limit": maxArchiveEntries}}
+}
+
+// checkUploadType returns an uploadError with status 415 if the file with the
+// given uri and mime type is not allowed, see isAllowedUpload
+func checkUploadType(uri string, mimeType string) error {
+	if isAllowedUpload(uri, mimeType) {
+		return nil
+	}
+	return &uploadError{status: http.StatusUnsupportedMediaType, code: "unsupported_type", file: uri,
+		msg: "file type is not allowed: " + uri, details: map[string]any{"mimetype": mimeType}}
+}
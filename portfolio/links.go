@@ -0,0 +1,287 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/duevil/Go_Portfolio/content"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// linkRegexp matches the targets of markdown links and images as well as the
+// href and src attributes of inline HTML
+var linkRegexp = regexp.MustCompile(`\]\(\s*<?([^)\s>]+)|(?:href|src)\s*=\s*["']([^"']+)["']`)
+
+// linkUserAgent is the user agent external URLs are requested with
+const linkUserAgent = "Mozilla/5.0 (compatible; Go_Portfolio link checker)"
+
+// deadLink is a link of a page whose target could not be found; the status is
+// the response status of dead external URLs, the error is set if the URL could
+// not be requested at all
+type deadLink struct {
+	Page     string `json:"page"`
+	URL      string `json:"url"`
+	External bool   `json:"external"`
+	Status   int    `json:"status,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// linkReport lists the dead links of all published pages; external URLs are
+// only checked if External is set
+type linkReport struct {
+	Generated time.Time  `json:"generated"`
+	External  bool       `json:"external"`
+	Pages     int        `json:"pages"`
+	Links     int        `json:"links"`
+	Dead      []deadLink `json:"dead"`
+}
+
+// linkResult is the result of requesting an external URL
+type linkResult struct {
+	status  int
+	err     string
+	checked time.Time
+}
+
+// dead returns whether the URL of the result is dead; rate limited requests
+// are not considered dead
+func (r linkResult) dead() bool {
+	return r.err != "" || (r.status >= 400 && r.status != http.StatusTooManyRequests)
+}
+
+var (
+	// lastLinkReport is the report generated by the last run of the links job
+	lastLinkReport *linkReport
+	linkMu         sync.Mutex
+	// linkResults are the results of external URLs by URL, which are kept for
+	// LINK_CHECK_CACHE_TTL, so URLs are not requested by every run
+	linkResults   = map[string]linkResult{}
+	linkResultsMu sync.Mutex
+	linkClient    = &http.Client{Timeout: 10 * time.Second}
+)
+
+// linkChecker checks the links of pages; requests to the same host are
+// delayed by the given delay to not overload it
+type linkChecker struct {
+	external bool
+	delay    time.Duration
+	ttl      time.Duration
+	// hosts are the times of the last requests by host
+	hosts map[string]time.Time
+}
+
+// newLinkChecker returns a link checker configured by LINK_CHECK_DELAY and
+// LINK_CHECK_CACHE_TTL, which checks external URLs if the given flag is set
+func newLinkChecker(external bool) *linkChecker {
+	return &linkChecker{
+		external: external,
+		delay:    getEnvDurationOrElse("LINK_CHECK_DELAY", time.Second),
+		ttl:      getEnvDurationOrElse("LINK_CHECK_CACHE_TTL", 24*time.Hour),
+		hosts:    map[string]time.Time{},
+	}
+}
+
+// extractLinks returns the distinct link targets of the given markdown in
+// their order
+func extractLinks(md []byte) []string {
+	var links []string
+	seen := map[string]bool{}
+	for _, m := range linkRegexp.FindAllSubmatch(md, -1) {
+		l := string(m[1])
+		if l == "" {
+			l = string(m[2])
+		}
+		if l = strings.TrimSpace(l); l != "" && !seen[l] {
+			seen[l] = true
+			links = append(links, l)
+		}
+	}
+	return links
+}
+
+// checkLink checks the given link target; returns nil if the target is found
+// or not checked, which is the case for fragments, other schemes and routes
+// other than the content, as well as for external URLs if they are not
+// checked
+func (lc *linkChecker) checkLink(page string, link string) (*deadLink, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return &deadLink{Page: page, URL: link, Error: err.Error()}, nil
+	}
+	if u.Scheme == "http" || u.Scheme == "https" || (u.Scheme == "" && u.Host != "") {
+		if !lc.external {
+			return nil, nil
+		}
+		if u.Scheme == "" {
+			u.Scheme = "https"
+		}
+		r := lc.checkExternal(u)
+		if !r.dead() {
+			return nil, nil
+		}
+		return &deadLink{Page: page, URL: link, External: true, Status: r.status, Error: r.err}, nil
+	}
+	if u.Scheme != "" || u.Path == "" {
+		return nil, nil
+	}
+	uri, lang, ok := contentURI(u.Path)
+	if !ok {
+		return nil, nil
+	}
+	f, err := getLocalized(uri, lang)
+	if err == nil && (!f.Public() || !f.Published()) {
+		err = content.ErrNotFound
+	}
+	if errors.Is(content.ErrNotFound, err) {
+		return &deadLink{Page: page, URL: link, Status: http.StatusNotFound}, nil
+	}
+	return nil, err
+}
+
+// contentURI returns the uri and language of the file the given link path
+// refers to; relative paths are relative to the content root, which is the
+// base of all pages. Returns false if the path refers to another route.
+func contentURI(p string) (string, string, bool) {
+	lang := ""
+	if strings.HasPrefix(p, "/") {
+		root := "/" + content.URIRoot + "/"
+		if l, rest, ok := strings.Cut(strings.TrimPrefix(p, "/"), "/"); ok && content.ValidLanguage(l) &&
+			strings.HasPrefix("/"+rest, root) {
+			lang, p = l, "/"+rest
+		}
+		if !strings.HasPrefix(p, root) {
+			return "", "", false
+		}
+		p = strings.TrimPrefix(p, root)
+	}
+	if p == "" || strings.HasSuffix(p, "/") {
+		p += "index.html"
+	}
+	return path.Join("/", p), lang, true
+}
+
+// checkExternal requests the given URL unless its result is cached; requests
+// are sent with HEAD first and with GET if the server does not support HEAD
+func (lc *linkChecker) checkExternal(u *url.URL) linkResult {
+	link := u.String()
+	linkResultsMu.Lock()
+	r, ok := linkResults[link]
+	linkResultsMu.Unlock()
+	if ok && time.Since(r.checked) < lc.ttl {
+		return r
+	}
+	r = linkResult{checked: time.Now()}
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		if wait := lc.delay - time.Since(lc.hosts[u.Host]); wait > 0 {
+			time.Sleep(wait)
+		}
+		lc.hosts[u.Host] = time.Now()
+		r.status, r.err = requestLink(method, link)
+		if r.err != "" || (r.status != http.StatusMethodNotAllowed && r.status != http.StatusNotImplemented &&
+			r.status != http.StatusForbidden) {
+			break
+		}
+	}
+	if r.status != http.StatusTooManyRequests {
+		linkResultsMu.Lock()
+		linkResults[link] = r
+		linkResultsMu.Unlock()
+	}
+	return r
+}
+
+// requestLink requests the given URL with the given method and returns the
+// response status or the error of the request
+func requestLink(method string, link string) (int, string) {
+	log.Println("Checking external link:", method, link)
+	req, err := http.NewRequest(method, link, nil)
+	if err != nil {
+		return 0, err.Error()
+	}
+	req.Header.Set("User-Agent", linkUserAgent)
+	res, err := linkClient.Do(req)
+	if err != nil {
+		return 0, err.Error()
+	}
+	cls(res.Body)
+	return res.StatusCode, ""
+}
+
+// newLinkReport checks the links of all published pages and reports the dead
+// ones; external URLs are checked if the given flag is set
+func newLinkReport(external bool) (*linkReport, error) {
+	files, err := content.ListMarkdown()
+	if err != nil {
+		return nil, err
+	}
+	lc := newLinkChecker(external)
+	r := &linkReport{Generated: time.Now(), External: external, Dead: []deadLink{}}
+	for _, f := range files {
+		if !f.Published() {
+			continue
+		}
+		md, err := f.Markdown()
+		if err != nil {
+			return nil, err
+		}
+		r.Pages++
+		for _, l := range extractLinks(md) {
+			r.Links++
+			dead, err := lc.checkLink(f.URI, l)
+			if err != nil {
+				return nil, err
+			}
+			if dead != nil {
+				r.Dead = append(r.Dead, *dead)
+			}
+		}
+	}
+	return r, nil
+}
+
+// runLinksJob generates the link report, checking external URLs if
+// LINK_CHECK_EXTERNAL is 'true', and logs the dead links; admins are notified
+// of dead links
+func runLinksJob() error {
+	r, err := newLinkReport(getEnvOrElse("LINK_CHECK_EXTERNAL", "false") == "true")
+	if err != nil {
+		return err
+	}
+	for _, d := range r.Dead {
+		log.Println("Dead link on page", d.Page+":", d.URL, d.Status, d.Error)
+	}
+	if len(r.Dead) > 0 {
+		notify(notifyLinks, notifyLinks, fmt.Sprintf("%d dead links found on %d pages", len(r.Dead), r.Pages))
+	} else {
+		resolve(notifyLinks)
+	}
+	linkMu.Lock()
+	lastLinkReport = r
+	linkMu.Unlock()
+	return nil
+}
+
+// handleLinks handles requests for the link report; returns the report of the
+// last job run or, if the job did not run yet, generates a new report without
+// checking external URLs
+func handleLinks(c *gin.Context) {
+	log.Println("Link report requested")
+	linkMu.Lock()
+	r := lastLinkReport
+	linkMu.Unlock()
+	if r == nil {
+		var err error
+		r, err = newLinkReport(false)
+		if errISE(c, err) {
+			return
+		}
+	}
+	c.JSON(http.StatusOK, r)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lockoutEntry records the failed authentication attempts of a client IP or
+// an account
+type lockoutEntry struct {
+	Key         string    `json:"key"`
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+	LockedUntil time.Time `json:"locked_until,omitempty"`
+}
+
+// lockoutTracker tracks failed authentication attempts; after threshold
+// failures a key is locked out, starting with the base duration, which doubles
+// with every further failure up to the max duration. Entries are forgotten
+// once the last failure is older than the reset duration.
+type lockoutTracker struct {
+	threshold int
+	base      time.Duration
+	max       time.Duration
+	reset     time.Duration
+	mu        sync.Mutex
+	entries   map[string]*lockoutEntry
+}
+
+// lockouts tracks the failed logins per client IP and per account; configured
+// by LOCKOUT_THRESHOLD, LOCKOUT_DURATION, LOCKOUT_MAX_DURATION and
+// LOCKOUT_RESET, a threshold of zero disables lockouts
+var lockouts = &lockoutTracker{
+	threshold: getEnvIntOrElse("LOCKOUT_THRESHOLD", 5),
+	base:      getEnvDurationOrElse("LOCKOUT_DURATION", time.Minute),
+	max:       getEnvDurationOrElse("LOCKOUT_MAX_DURATION", time.Hour),
+	reset:     getEnvDurationOrElse("LOCKOUT_RESET", 24*time.Hour),
+	entries:   map[string]*lockoutEntry{},
+}
+
+// loginKeys returns the lockout keys of a login of the given user from the
+// client of the given context
+func loginKeys(c *gin.Context, user string) []string {
+	keys := []string{"ip:" + c.ClientIP()}
+	if user != "" {
+		keys = append(keys, "user:"+strings.ToLower(user))
+	}
+	return keys
+}
+
+// lockedFor returns the remaining duration of the longest lockout of the given
+// keys; returns zero if none of the keys is locked out
+func (t *lockoutTracker) lockedFor(now time.Time, keys ...string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var wait time.Duration
+	for _, k := range keys {
+		if e, ok := t.entries[k]; ok && e.LockedUntil.Sub(now) > wait {
+			wait = e.LockedUntil.Sub(now)
+		}
+	}
+	return wait
+}
+
+// fail records a failed attempt for each of the given keys and locks out the
+// keys which reached the threshold
+func (t *lockoutTracker) fail(now time.Time, keys ...string) {
+	if t.threshold <= 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sweep(now)
+	for _, k := range keys {
+		e, ok := t.entries[k]
+		if !ok {
+			e = &lockoutEntry{Key: k}
+			t.entries[k] = e
+		}
+		e.Failures++
+		e.LastFailure = now
+		if e.Failures >= t.threshold {
+			d := time.Duration(float64(t.base) * math.Pow(2, float64(e.Failures-t.threshold)))
+			if d > t.max || d <= 0 {
+				d = t.max
+			}
+			e.LockedUntil = now.Add(d)
+			log.Println("[Err] Locked out after", e.Failures, "failed logins:", k, "for", d)
+		}
+	}
+}
+
+// succeed forgets the failed attempts of the given keys
+func (t *lockoutTracker) succeed(keys ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, k := range keys {
+		delete(t.entries, k)
+	}
+}
+
+// clear forgets the failed attempts of the given key; returns false if there
+// are none
+func (t *lockoutTracker) clear(key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, ok := t.entries[key]
+	delete(t.entries, key)
+	return ok
+}
+
+// list returns all entries ordered by key
+func (t *lockoutTracker) list(now time.Time) []lockoutEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sweep(now)
+	list := []lockoutEntry{}
+	for _, e := range t.entries {
+		list = append(list, *e)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+	return list
+}
+
+// sweep removes the entries whose last failure is older than the reset
+// duration and which are not locked out anymore; must be called with the lock
+// held
+func (t *lockoutTracker) sweep(now time.Time) {
+	for k, e := range t.entries {
+		if now.Sub(e.LastFailure) > t.reset && now.After(e.LockedUntil) {
+			delete(t.entries, k)
+		}
+	}
+}
+
+// abortLockedOut answers the request with the login page and status 429 if
+// one of the given keys is locked out; returns whether the request was aborted
+func abortLockedOut(c *gin.Context, next string, totp bool, keys ...string) bool {
+	wait := lockouts.lockedFor(time.Now(), keys...)
+	if wait <= 0 {
+		return false
+	}
+	log.Println("[Err] Login rejected due to lockout:", keys)
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	page := newLoginPage(next)
+	page.Error = "Zu viele fehlgeschlagene Anmeldeversuche. Bitte versuche es später erneut."
+	page.TOTP = totp
+	c.HTML(http.StatusTooManyRequests, "login", page)
+	c.Abort()
+	return true
+}
+
+// handleLockouts handles requests to list the tracked failed logins and
+// lockouts
+func handleLockouts(c *gin.Context) {
+	log.Println("Lockouts requested")
+	c.JSON(http.StatusOK, lockouts.list(time.Now()))
+}
+
+// handleLockoutDelete handles requests to clear the failed logins and the
+// lockout of a client IP or an account
+func handleLockoutDelete(c *gin.Context) {
+	key := c.Param("key")
+	log.Println("Lockout deletion requested:", key)
+	if !lockouts.clear(key) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "no lockout for " + key})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
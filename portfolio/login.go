@@ -0,0 +1,289 @@
+package main
+
+import (
+	"auth"
+	"errors"
+	"github.com/duevil/Go_Portfolio/content"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// sessionCookie is the name of the cookie holding the session token
+const sessionCookie = "session"
+
+// loginPage is the page rendered by the 'login' template
+type loginPage struct {
+	content.Page
+	Error   string
+	Message string
+	Next    string
+	// TOTP is set if the second factor has to be entered
+	TOTP bool
+	// OAuth is the configured OAuth provider, if any
+	OAuth *oauthProvider
+}
+
+// newLoginPage returns the login page redirecting to the given path after
+// logging in
+func newLoginPage(next string) loginPage {
+	p, _ := oauthConfig()
+	return loginPage{Page: newPage("Anmelden", "admin/login"), Next: next, OAuth: p}
+}
+
+// handleLoginForm handles requests for the login page; the optional query
+// parameter 'next' is the path to redirect to after logging in, the optional
+// query parameter 'reset' shows that the password was reset
+func handleLoginForm(c *gin.Context) {
+	log.Println("Login form requested")
+	page := newLoginPage(c.Query("next"))
+	if c.Query("reset") != "" {
+		page.Message = "Das Passwort wurde geändert. Bitte melde dich neu an."
+	}
+	c.HTML(http.StatusOK, "login", page)
+}
+
+// handleLogin handles login requests; checks the submitted credentials and on
+// success creates a session, sets the session cookie and redirects to the
+// submitted 'next' path or the admin page; if the user has a second factor
+// enabled, a pending session is created and the TOTP form is shown instead
+func handleLogin(c *gin.Context) {
+	log.Println("Login requested")
+	user, pass := c.PostForm("username"), c.PostForm("password")
+	next := c.PostForm("next")
+	keys := loginKeys(c, user)
+	if abortLockedOut(c, next, false, keys...) {
+		return
+	}
+	u, err := auth.Authenticate(user, pass)
+	if errors.Is(err, auth.ErrInvalidCredentials) {
+		log.Println("[Err] Login failed for user:", user)
+		lockouts.fail(time.Now(), keys...)
+		page := newLoginPage(next)
+		page.Error = "Benutzername oder Passwort falsch."
+		c.HTML(http.StatusUnauthorized, "login", page)
+		return
+	}
+	if errISE(c, err) {
+		return
+	}
+	// failed attempts are only forgotten once the second factor is verified
+	if u.TOTPEnabled() {
+		startTOTPLogin(c, u.Name, next)
+		return
+	}
+	lockouts.succeed(keys...)
+	startSession(c, u.Name, next)
+}
+
+// startTOTPLogin starts a pending session of the user with the given name,
+// which has verified the first factor, and renders the form for the second
+// factor; the session is started by handleLoginTOTP once it is verified
+func startTOTPLogin(c *gin.Context, user string, next string) {
+	ttl := 5 * time.Minute
+	_, token, err := auth.NewPendingSession(user, ttl, c.Request.UserAgent(), c.ClientIP())
+	if errISE(c, err) {
+		return
+	}
+	setSessionCookie(c, token, int(ttl.Seconds()))
+	page := newLoginPage(next)
+	page.TOTP = true
+	c.HTML(http.StatusOK, "login", page)
+}
+
+// handleLoginTOTP handles the second step of logins of users with a second
+// factor; checks the submitted TOTP or recovery code against the user of the
+// pending session and on success replaces it by a session
+func handleLoginTOTP(c *gin.Context) {
+	log.Println("Login second factor requested")
+	next := c.PostForm("next")
+	token, err := c.Cookie(sessionCookie)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/admin/login")
+		return
+	}
+	s, err := auth.GetPendingSession(token)
+	if errors.Is(err, auth.ErrNoSession) {
+		c.Redirect(http.StatusSeeOther, "/admin/login")
+		return
+	}
+	if errISE(c, err) {
+		return
+	}
+	keys := loginKeys(c, s.User)
+	if abortLockedOut(c, next, true, keys...) {
+		return
+	}
+	u, err := auth.GetUser(s.User)
+	if err == nil {
+		err = auth.VerifyTOTP(u, c.PostForm("code"))
+	}
+	if errors.Is(err, auth.ErrInvalidCode) {
+		log.Println("[Err] Second factor failed for user:", s.User)
+		lockouts.fail(time.Now(), keys...)
+		page := newLoginPage(next)
+		page.Error, page.TOTP = "Der Code ist ungültig.", true
+		c.HTML(http.StatusUnauthorized, "login", page)
+		return
+	}
+	if errISE(c, err) {
+		return
+	}
+	lockouts.succeed(keys...)
+	err = auth.DeleteSession(token)
+	if errISE(c, err) {
+		return
+	}
+	startSession(c, u.Name, next)
+}
+
+// startSession creates a session for the given user, sets the session cookie
+// and redirects to the given path or, if it is not a local path, the admin page
+func startSession(c *gin.Context, user string, next string) {
+	ttl := getEnvDurationOrElse("SESSION_TTL", 12*time.Hour)
+	_, token, err := auth.NewSession(user, ttl, c.Request.UserAgent(), c.ClientIP())
+	if errISE(c, err) {
+		return
+	}
+	setSessionCookie(c, token, int(ttl.Seconds()))
+	if !localPath(next) {
+		next = "/admin/"
+	}
+	c.Redirect(http.StatusSeeOther, next)
+}
+
+// localPath returns whether the given path to redirect to after logging in is
+// a path of this site; browsers treat backslashes like slashes, so paths like
+// '/\example.com' would lead to another host
+func localPath(next string) bool {
+	if strings.Contains(next, "\\") || !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") {
+		return false
+	}
+	u, err := url.Parse(next)
+	return err == nil && u.Scheme == "" && u.Host == ""
+}
+
+// handleLogout handles logout requests; deletes the current session and the
+// session cookie and redirects to the login page
+func handleLogout(c *gin.Context) {
+	log.Println("Logout requested")
+	if token, err := c.Cookie(sessionCookie); err == nil {
+		err = auth.DeleteSession(token)
+		if errISE(c, err) {
+			return
+		}
+	}
+	setSessionCookie(c, "", -1)
+	c.Redirect(http.StatusSeeOther, "/admin/login")
+}
+
+// sessionAuth is the middleware checking whether the request belongs to a
+// valid session; the session's user and the user's role are stored in the
+// context under the keys 'user' and 'role'; requests with a bearer token are
+// authenticated by tokenAuth instead; unauthenticated page requests are
+// redirected to the login page, other requests are aborted with status 401
+func sessionAuth(c *gin.Context) {
+	if secret, ok := bearerToken(c); ok {
+		tokenAuth(c, secret)
+		return
+	}
+	token, err := c.Cookie(sessionCookie)
+	if err == nil {
+		var s auth.Session
+		s, err = auth.GetSession(token)
+		if err == nil {
+			err = setUser(c, s.User)
+			if err == nil {
+				c.Set("session", s)
+				return
+			}
+		}
+	}
+	if !errors.Is(err, http.ErrNoCookie) && !errors.Is(err, auth.ErrNoSession) &&
+		!errors.Is(err, auth.ErrUserNotFound) && errISE(c, err) {
+		return
+	}
+	if c.Request.Method == http.MethodGet && strings.Contains(c.GetHeader("Accept"), "text/html") {
+		c.Redirect(http.StatusSeeOther, "/admin/login?next="+url.QueryEscape(c.Request.URL.RequestURI()))
+		c.Abort()
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "login required"})
+}
+
+// requirePermission returns a middleware aborting requests with status 403 if
+// the role of the authenticated user lacks the given permission
+func requirePermission(p auth.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role, _ := c.Get("role")
+		if r, ok := role.(auth.Role); !ok || !r.Can(p) {
+			log.Println("[Err] Permission denied for user:", c.GetString("user"))
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "permission denied"})
+		}
+	}
+}
+
+// allowURI checks whether the user authenticated in the given context may
+// change the content at the given uri; if not, the request is aborted with
+// status 403
+func allowURI(c *gin.Context, uri string) bool {
+	if uriAllowed(c, uri) {
+		return true
+	}
+	log.Println("[Err] Uri", uri, "denied for user:", c.GetString("user"))
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "permission denied", "uri": uri})
+	return false
+}
+
+// uriAllowed returns whether the user authenticated in the given context may
+// change the content at the given uri
+func uriAllowed(c *gin.Context, uri string) bool {
+	u, _ := c.Get("account")
+	user, ok := u.(auth.User)
+	return ok && user.AllowsURI(uri)
+}
+
+// requireAuth returns a middleware combining sessionAuth, csrfProtect and
+// requirePermission with the given permission; it may also be called manually
+// inside handlers
+func requireAuth(p auth.Permission) gin.HandlerFunc {
+	permission := requirePermission(p)
+	return chain(sessionAuth, csrfProtect, permission)
+}
+
+// setSessionCookie sets the session cookie to the given token; the cookie is
+// marked secure if the request was made over TLS or SESSION_SECURE is set
+func setSessionCookie(c *gin.Context, token string, maxAge int) {
+	secure := c.Request.TLS != nil || getEnvOrElse("SESSION_SECURE", "false") == "true"
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(sessionCookie, token, maxAge, "/", "", secure, true)
+}
+
+// setUser sets the user with the given name as the authenticated user of the
+// request; the user's name and role and the user itself are set in the
+// context
+func setUser(c *gin.Context, name string) error {
+	u, err := auth.GetUser(name)
+	if err != nil {
+		return err
+	}
+	c.Set("user", u.Name)
+	c.Set("role", u.Role)
+	c.Set("account", u)
+	return nil
+}
+
+// requireAction returns a middleware aborting requests with status 403 if the
+// authenticated user may not perform the given action
+func requireAction(a auth.Action) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, _ := c.Get("account")
+		if user, ok := u.(auth.User); !ok || !user.Allows(a) {
+			log.Println("[Err] Action", a, "denied for user:", c.GetString("user"))
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "permission denied"})
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// errMailDisabled is returned if no SMTP server is configured
+var errMailDisabled = errors.New("mail is disabled; SMTP_HOST is not set")
+
+// mailEnabled returns whether an SMTP server is configured
+func mailEnabled() bool {
+	return getEnvOrElse("SMTP_HOST", "") != ""
+}
+
+// sendMail sends a plain text mail with the given subject and body to the
+// given address using the SMTP server set by SMTP_HOST and SMTP_PORT; the
+// server is authenticated with SMTP_USERNAME and SMTP_PASSWORD if set and the
+// sender is set by SMTP_FROM
+func sendMail(to string, subject string, body string) error {
+	host := getEnvOrElse("SMTP_HOST", "")
+	if host == "" {
+		return errMailDisabled
+	}
+	from := getEnvOrElse("SMTP_FROM", "portfolio@"+host)
+	var a smtp.Auth
+	if user := getEnvOrElse("SMTP_USERNAME", ""); user != "" {
+		a = smtp.PlainAuth("", user, getEnvOrElse("SMTP_PASSWORD", ""), host)
+	}
+	msg := strings.Join([]string{
+		"From: " + from,
+		"To: " + to,
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"Content-Transfer-Encoding: 8bit",
+		"",
+		strings.ReplaceAll(body, "\n", "\r\n"),
+	}, "\r\n")
+	log.Println("Sending mail:", subject)
+	addr := net.JoinHostPort(host, getEnvOrElse("SMTP_PORT", "587"))
+	return smtp.SendMail(addr, a, from, []string{to}, []byte(msg))
+}
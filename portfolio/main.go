@@ -1,80 +1,249 @@
 package main
 
 import (
-	"content"
+	"auth"
 	"context"
+	"github.com/duevil/Go_Portfolio/content"
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
-	"html/template"
 	"log"
+	"net/http"
 	"os"
 	"path"
+	"time"
 )
 
-var templates = template.Must(template.ParseGlob("templates/*.*"))
+var (
+	// dbClient is the client of the database connection
+	dbClient *mongo.Client
+	// contentEngine is the content engine storing the files in the database;
+	// it is the default engine of the content package
+	contentEngine *content.Engine
+	// dbCtx is the context of all database operations
+	dbCtx = context.Background()
+)
 
 func main() {
+	initScratchDir()
+	// the embedded templates can be overridden per file by setting TEMPLATES_DIR
+	t, err := loadTemplates()
+	checkErr(err)
+	activeTemplates.Store(t)
+	if len(os.Args) > 1 && os.Args[1] == "doctor" {
+		os.Exit(runDoctorCommand())
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrateCommand(os.Args[2:]))
+	}
 	// database initialization
 	{
-		log.Println("Connecting to database")
-		// open database connection
-		content.Context = context.Background()
-		auth := options.Credential{
-			Username: os.Getenv("MDB_ROOT_USERNAME"),
-			Password: os.Getenv("MDB_ROOT_PASSWORD"),
-		}
-		opt := options.Client().ApplyURI("mongodb://mdb:27017")
-		opt.SetAuth(auth)
-		client, err := mongo.Connect(content.Context, opt)
+		client, err := connectDB()
 		checkErr(err)
 		// close database connection on exit
-		defer func(c *mongo.Client) { checkErr(c.Disconnect(content.Context)) }(client)
-		// check whether the database is reachable
-		err = client.Ping(content.Context, readpref.Primary())
+		defer func(c *mongo.Client) { checkErr(c.Disconnect(dbCtx)) }(client)
+		initDB(client)
+		// accounts configured in the accounts file set by ACCOUNTS_FILE are
+		// created or updated on every start
+		checkErr(syncAccountsFile())
+		// seed the admin account on the first run; the password is only read from
+		// the environment or its secret file until then and stored as bcrypt hash
+		_, err = auth.SeedUser(getEnvOrElse("ADMIN_USERNAME", "admin"), getEnvOrElse("ADMIN_PASSWORD", "admin"),
+			getEnvOrElse("ADMIN_PASSWORD_HASH", ""), auth.RoleAdmin)
 		checkErr(err)
-		log.Println("Database connection established, initializing database")
-		// create database and collection
-		db := client.Database(getEnvOrElse("DB_NAME", "portfolio"))
-		content.SetCollection(db.Collection(getEnvOrElse("DB_FILE_COL", content.URIRoot)))
 		log.Println("Database initialized")
 	}
+	// export hooks
+	if getEnvOrElse("EXPORT_SEARCH_INDEX", "true") == "true" {
+		registerExportHook(searchIndexHook{})
+	}
+	registerExportHook(feedsHook{})
+	registerExportHook(blogHook{})
+	registerExportHook(ogHook{})
+	registerExportHook(redirectsHook{})
+	registerCommandHooks()
+	// background jobs
+	{
+		scheduleJob("stale", getEnvDurationOrElse("STALE_REPORT_INTERVAL", 24*time.Hour), runStaleJob)
+		scheduleJob("links", getEnvDurationOrElse("LINK_CHECK_INTERVAL", 24*time.Hour), runLinksJob)
+		scheduleJob("schedule", getEnvDurationOrElse("SCHEDULE_INTERVAL", time.Minute), runScheduleJob)
+		scheduleJob("notifications", getEnvDurationOrElse("NOTIFY_INTERVAL", 5*time.Minute), runNotifyJob)
+		scheduleJob("usage", getEnvDurationOrElse("USAGE_CHECK_INTERVAL", 15*time.Minute), runUsageJob)
+		// database maintenance
+		scheduleJob("stats", getEnvDurationOrElse("STATS_INTERVAL", 24*time.Hour), runStatsJob)
+		scheduleJob("cleanup", getEnvDurationOrElse("CLEANUP_INTERVAL", time.Hour), runCleanupJob)
+		scheduleJob("compact", getEnvDurationOrElse("COMPACT_INTERVAL", 0), runCompactJob)
+		scheduleJob("bandwidth", getEnvDurationOrElse("BANDWIDTH_FLUSH_INTERVAL", time.Minute), flushBandwidth)
+		startReplication()
+		startWatching()
+		scheduleCDNPurge()
+	}
 	// gin initialization
 	{
 		log.Println("Initializing server")
 		// bind gin routes
 		router := gin.Default()
-		router.SetHTMLTemplate(templates)
+		router.HTMLRender = templateRender{}
+		router.Use(securityHeaders())
 		router.NoRoute(handleNotFound)
+		// client IPs are used for rate limiting and sessions, so forwarded IPs
+		// are only accepted from the proxies set by TRUSTED_PROXIES
+		var proxies []string
+		for p := range parseList(getEnvOrElse("TRUSTED_PROXIES", "")) {
+			proxies = append(proxies, p)
+		}
+		checkErr(router.SetTrustedProxies(proxies))
 		indexRedirect := func(c *gin.Context) {
 			// handle index redirect
 			c.Request.URL.Path = path.Join("/", content.URIRoot, "index.html")
 			router.HandleContext(c)
 		}
+		router.GET("/healthz", handleHealth)
 		router.GET("/", indexRedirect)
 		router.GET("index", indexRedirect)
 		router.GET("index.html", indexRedirect)
-		router.GET(path.Join(content.URIRoot, "*uri"), handleFile)
-		// add auth routes
-		adminUser := getEnvOrElse("ADMIN_USERNAME", "admin")
-		adminPass := getEnvOrElse("ADMIN_PASSWORD", "admin")
+		router.GET(path.Join(content.URIRoot, "*uri"), countBandwidth, cdnCache(), keepStale, handleFile)
+		router.GET(path.Join("/:lang", content.URIRoot, "*uri"), countBandwidth, cdnCache(), keepStale, handleLocalizedFile)
+		// visitors comment on pages by posting to the page
+		commentLimit := rateLimit("comment", 5, 3)
+		router.POST(path.Join(content.URIRoot, "*uri"), commentLimit, handleCommentPost)
+		router.POST(path.Join("/:lang", content.URIRoot, "*uri"), commentLimit, handleCommentPost)
+		// responses are tagged with surrogate keys, so a CDN can purge them
+		// selectively when content or settings change
+		pages := cdnCache(keyPages)
+		router.GET("/search", pages, handleSearch)
+		router.GET("/tags", pages, handleTags)
+		router.GET("/tags/:tag", pages, handleTag)
+		router.GET("/blog", pages, handleBlog)
+		router.GET("/blog/page/:page", pages, handleBlogPage)
+		router.GET("/blog/:year", pages, handleBlogYear)
+		router.GET("/og/*page", countBandwidth, cdnCache(), keepStale, handleOGImage)
+		router.GET("/sitemap.xml", pages, feedHandler("application/xml; charset=utf-8", buildSitemap))
+		router.GET("/feed.xml", pages, feedHandler("application/atom+xml; charset=utf-8", buildAtom))
+		router.GET("/rss.xml", pages, feedHandler("application/rss+xml; charset=utf-8", buildRSS))
+		router.Group("/static", cdnCache(keyStatic)).StaticFS("", http.FS(assetFS("STATIC_DIR", "static")))
+		// rate limits per client IP
+		loginLimit := rateLimit("login", 10, 5)
+		uploadLimit := rateLimit("upload", 30, 10)
+		deleteLimit := rateLimit("delete", 60, 20)
+		// add auth routes; all admin routes are restricted to the networks set by
+		// ADMIN_ALLOWED_CIDRS and ADMIN_DENIED_CIDRS
+		public := router.Group("/admin", adminAllowed)
+		public.GET("/login", handleLoginForm)
+		public.POST("/login", loginLimit, handleLogin)
+		public.POST("/login/totp", loginLimit, handleLoginTOTP)
+		public.GET("/login/oauth", handleOAuthLogin)
+		public.GET("/login/oauth/callback", handleOAuthCallback)
+		public.POST("/logout", handleLogout)
+		public.GET("/password-reset", handleResetForm)
+		public.POST("/password-reset", loginLimit, handleResetRequest)
+		public.POST("/password-reset/confirm", loginLimit, handleResetConfirm)
+		canRead := requirePermission(auth.PermRead)
+		canWrite := requirePermission(auth.PermWrite)
+		canManage := requirePermission(auth.PermAdmin)
 		// due to unknown reasons it is not possible to perform an upload of larger files when using
-		// any middleware, so we must use the raw router instead and call the basic auth function
+		// any middleware, so we must use the raw router instead and call the auth function
 		// manually inside the handler function
-		router.POST("/admin/upload", func(c *gin.Context) {
-			// we pass the basic auth middleware as a handler function to the raw router
-			handleUpload(c, gin.BasicAuth(gin.Accounts{adminUser: adminPass}))
-		})
-		auth := router.Group("/admin", gin.BasicAuth(gin.Accounts{adminUser: adminPass}))
-		auth.GET("/", handleAdmin)
-		auth.GET("/download", handleDownload)
-		auth.GET("/list", handleList)
-		auth.DELETE("*uri", handleDelete)
+		// uploads and deletions accept an Idempotency-Key header, so clients can
+		// retry them safely
+		canUpload := chain(requireAuth(auth.PermWrite), requireAction(auth.ActionUpload))
+		router.POST("/admin/upload", chain(adminAllowed, uploadLimit, idempotent(func(c *gin.Context) {
+			// we pass the auth middleware as a handler function to the raw router
+			handleUpload(c, canUpload)
+		})))
+		router.POST("/admin/import", chain(adminAllowed, uploadLimit, idempotent(func(c *gin.Context) { handleImport(c, canUpload) })))
+		router.POST("/admin/bundle", chain(adminAllowed, uploadLimit, idempotent(func(c *gin.Context) { handleBundleImport(c, canUpload) })))
+		router.POST("/admin/paste", chain(adminAllowed, uploadLimit, idempotent(func(c *gin.Context) { handlePaste(c, canUpload) })))
+		router.PUT("/admin/replica/*uri", chain(adminAllowed, uploadLimit, func(c *gin.Context) { handleReplicaPut(c, canUpload) }))
+		router.GET("/staging/:name/*uri", adminAllowed, sessionAuth, canRead, handleStagingPreview)
+		admin := router.Group("/admin", adminAllowed, sessionAuth, csrfProtect)
+		admin.GET("/", canRead, handleAdmin)
+		admin.GET("/ui/*filepath", canRead, handleUI)
+		admin.GET("/download", canRead, requireAction(auth.ActionDownload), handleDownload)
+		admin.GET("/bundle/*uri", canRead, requireAction(auth.ActionDownload), handleBundleExport)
+		admin.GET("/list", canRead, handleList)
+		admin.GET("/settings", canRead, handleSettings)
+		admin.GET("/stale", canRead, handleStale)
+		admin.GET("/links", canRead, handleLinks)
+		admin.GET("/jobs", canManage, handleJobs)
+		admin.POST("/jobs/:name/run", canManage, handleJobRun)
+		admin.GET("/usage", canManage, handleUsage)
+		admin.GET("/notifications", canManage, handleNotifications)
+		admin.POST("/notifications/read", canManage, handleNotificationsRead)
+		admin.POST("/notifications/read/:id", canManage, handleNotificationRead)
+		admin.GET("/stats", canRead, handleStats)
+		admin.GET("/doctor", canManage, handleDoctor)
+		admin.GET("/diff", canManage, handleDiff)
+		admin.POST("/templates/reload", canManage, handleTemplatesReload)
+		admin.PUT("/templates/:name", canManage, handleTemplateUpload)
+		admin.PUT("/visibility/*uri", canWrite, handleVisibility)
+		admin.PUT("/status/*uri", canWrite, handleStatus)
+		admin.PUT("/caption/*uri", canWrite, handleCaption)
+		admin.PUT("/schedule/*uri", canWrite, handleSchedule)
+		admin.POST("/move/*uri", canManage, idempotent(handleMove))
+		admin.POST("/copy/*uri", canWrite, handleCopy)
+		admin.POST("/bulk", canManage, idempotent(handleBulk))
+		// large assets may be uploaded to the blob store directly instead
+		admin.POST("/uploads", canWrite, requireAction(auth.ActionUpload), handleDirectUpload)
+		admin.POST("/uploads/register", canWrite, requireAction(auth.ActionUpload), idempotent(handleDirectUploadRegister))
+		admin.GET("/revisions/*uri", canRead, handleRevisions)
+		admin.GET("/asof/:date/*uri", canRead, handleAsOf)
+		admin.GET("/redirects", canRead, handleRedirects)
+		admin.PUT("/redirects", canWrite, handleRedirectUpdate)
+		admin.GET("/menu", canRead, handleMenu)
+		admin.PUT("/menu/*uri", canWrite, handleMenuUpdate)
+		admin.PUT("/settings", canManage, handleSettingsUpdate)
+		admin.GET("/quarantine", canManage, handleQuarantine)
+		admin.POST("/quarantine/approve/*uri", canManage, handleQuarantineApprove)
+		admin.POST("/quarantine/reject/*uri", canManage, handleQuarantineReject)
+		admin.GET("/comments", canManage, handleComments)
+		admin.POST("/comments/approve/:id", canManage, handleCommentApprove)
+		admin.POST("/comments/spam/:id", canManage, handleCommentSpam)
+		admin.GET("/review", canRead, handleReview)
+		admin.POST("/review/comment/*uri", canWrite, handleReviewComment)
+		admin.POST("/review/approve/*uri", canManage, handleReviewApprove)
+		admin.POST("/review/reject/*uri", canManage, handleReviewReject)
+		admin.GET("/staging", canRead, handleStagingSets)
+		admin.GET("/staging/:name", canRead, handleStaging)
+		admin.POST("/staging/:name/promote", canManage, handleStagingPromote)
+		admin.POST("/rollback", canManage, handleRollback)
+		admin.POST("/replicate", canManage, handleReplicate)
+		admin.GET("/lockouts", canManage, handleLockouts)
+		admin.POST("/password", canRead, handlePasswordChange)
+		admin.GET("/sessions", canRead, handleSessions)
+		admin.GET("/totp", canRead, handleTOTP)
+		admin.POST("/totp", canRead, handleTOTPEnroll)
+		admin.POST("/totp/confirm", canRead, handleTOTPConfirm)
+		admin.POST("/totp/disable", canRead, handleTOTPDisable)
+		admin.POST("/preview", canWrite, handleMarkdownPreview)
+		admin.GET("/previews", canRead, handlePreviews)
+		admin.POST("/previews", canWrite, handlePreviewCreate)
+		admin.GET("/tokens", canRead, handleTokens)
+		admin.POST("/tokens", canRead, handleTokenCreate)
+		admin.POST("/signed", canWrite, handleSignedCreate)
+		admin.GET("/users", canManage, handleUsers)
+		admin.POST("/users", canManage, handleUserCreate)
+		admin.PUT("/users/:name", canManage, handleUserUpdate)
+		admin.DELETE("*uri", deleteLimit, idempotent(adminDeleteHandler([]deleteRoute{
+			{prefix: "/users/", param: "name", handlers: []gin.HandlerFunc{canManage, handleUserDelete}},
+			{prefix: "/sessions/", param: "id", handlers: []gin.HandlerFunc{canRead, handleSessionDelete}},
+			{prefix: "/tokens/", param: "id", handlers: []gin.HandlerFunc{canRead, handleTokenDelete}},
+			{prefix: "/previews/", param: "id", handlers: []gin.HandlerFunc{canRead, handlePreviewDelete}},
+			{prefix: "/lockouts/", param: "key", handlers: []gin.HandlerFunc{canManage, handleLockoutDelete}},
+			{prefix: "/comments/", param: "id", handlers: []gin.HandlerFunc{canManage, handleCommentDelete}},
+			{prefix: "/redirects/", param: "id", handlers: []gin.HandlerFunc{canWrite, handleRedirectDelete}},
+		}, canWrite, requireAction(auth.ActionDelete), handleDelete)))
+		// pages can be edited one by one without uploading a zip file
+		api := router.Group("/api", adminAllowed, sessionAuth, csrfProtect)
+		api.GET("/pages", canRead, handlePages)
+		api.GET("/pages/*uri", canRead, handlePage)
+		api.POST("/pages", canWrite, requireAction(auth.ActionUpload), uploadLimit, idempotent(handlePageCreate))
+		api.PUT("/pages/*uri", canWrite, requireAction(auth.ActionUpload), uploadLimit, idempotent(handlePageUpdate))
+		api.PATCH("/pages/*uri", canWrite, requireAction(auth.ActionUpload), uploadLimit, idempotent(handlePagePatch))
+		api.DELETE("/pages/*uri", canWrite, requireAction(auth.ActionDelete), deleteLimit, idempotent(handlePageDelete))
 		// run server
-		addr := ":" + getEnvOrElse("GIN_PORT", "9000")
-		log.Println("Starting server on", addr)
-		err := router.Run(addr)
+		err := runServer(router)
 		if err != nil {
 			// call panic instead of fatal to allow for deferred functions to run
 			log.Panicln("Error:", err)
@@ -82,3 +251,82 @@ func main() {
 	}
 	log.Println("Server stopped")
 }
+
+// connectDB opens the database connection and checks whether the database is
+// reachable
+func connectDB() (*mongo.Client, error) {
+	log.Println("Connecting to database")
+	credential := options.Credential{
+		Username: getEnvOrElse("MDB_ROOT_USERNAME", ""),
+		Password: getEnvOrElse("MDB_ROOT_PASSWORD", ""),
+	}
+	opt := options.Client().ApplyURI("mongodb://mdb:27017")
+	opt.SetAuth(credential)
+	client, err := mongo.Connect(dbCtx, opt)
+	if err != nil {
+		return nil, err
+	}
+	// check whether the database is reachable
+	err = client.Ping(dbCtx, readpref.Primary())
+	if err != nil {
+		_ = client.Disconnect(dbCtx)
+		return nil, err
+	}
+	return client, nil
+}
+
+// initDB sets the collections of the content and auth packages and the keys
+// depending on the database
+func initDB(client *mongo.Client) {
+	log.Println("Database connection established, initializing database")
+	dbClient = client
+	// create database and collection
+	db := client.Database(getEnvOrElse("DB_NAME", "portfolio"))
+	// file contents are stored in GridFS or on disk
+	blobs, err := newBlobStore(db)
+	checkErr(err)
+	// markdown files are versioned if REVISION_HISTORY is 'true'
+	revisions := ""
+	if getEnvOrElse("REVISION_HISTORY", "false") == "true" {
+		revisions = getEnvOrElse("DB_REVISION_COL", "revisions")
+	}
+	contentEngine, err = content.New(dbCtx, db,
+		content.WithCollections(getEnvOrElse("DB_FILE_COL", content.URIRoot),
+			getEnvOrElse("DB_SETTINGS_COL", "settings"), getEnvOrElse("DB_BANDWIDTH_COL", "bandwidth")),
+		content.WithCommentCollection(getEnvOrElse("DB_COMMENT_COL", "comments")),
+		content.WithRedirectCollection(getEnvOrElse("DB_REDIRECT_COL", "redirects")),
+		content.WithRevisions(revisions),
+		content.WithBlobStore(blobs),
+		// reads and idempotent writes are retried on transient errors like an
+		// election of a new primary
+		content.WithRetryPolicy(getEnvIntOrElse("DB_RETRY_ATTEMPTS", 3),
+			getEnvDurationOrElse("DB_RETRY_BACKOFF", 100*time.Millisecond)),
+		// new pages are published unless DEFAULT_PAGE_STATUS is 'draft'
+		content.WithDefaultPageStatus(getEnvOrElse("DEFAULT_PAGE_STATUS", "")),
+		// slow operations are logged to find pathological pages
+		content.WithSlowThresholds(getEnvDurationOrElse("SLOW_QUERY_THRESHOLD", 200*time.Millisecond),
+			getEnvDurationOrElse("SLOW_RENDER_THRESHOLD", 100*time.Millisecond)),
+		// file contents are encrypted at rest if a key is set
+		content.WithEncryptionKey([]byte(getEnvOrElse("CONTENT_ENCRYPTION_KEY", ""))),
+		// rendered markdown is sanitized with the policy set by SANITIZE_POLICY
+		content.WithSanitizePolicy(getEnvOrElse("SANITIZE_POLICY", "relaxed")),
+		// pages are written in the languages set by LANGUAGES, the default
+		// language first
+		content.WithLanguages(parseLanguages(getEnvOrElse("LANGUAGES", "de,en"))...),
+	)
+	checkErr(err)
+	content.SetDefault(contentEngine)
+	// the settings may replace the default language, so they are loaded before
+	// the first request is routed
+	if _, err = contentEngine.LoadSettings(); err != nil {
+		log.Println("[Err] Loading settings:", err)
+	}
+	setIdempotencyCollection(db.Collection(getEnvOrElse("DB_IDEMPOTENCY_COL", "idempotency")))
+	setNotificationCollection(db.Collection(getEnvOrElse("DB_NOTIFICATION_COL", "notifications")))
+	auth.Context = dbCtx
+	auth.SetSessionCollection(db.Collection(getEnvOrElse("DB_SESSION_COL", "sessions")))
+	auth.SetUserCollection(db.Collection(getEnvOrElse("DB_USER_COL", "users")))
+	auth.SetTokenCollection(db.Collection(getEnvOrElse("DB_TOKEN_COL", "tokens")))
+	auth.SetPreviewCollection(db.Collection(getEnvOrElse("DB_PREVIEW_COL", "previews")))
+	auth.SetResetKey(secretKey("PASSWORD_RESET_KEY"))
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"auth"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"log"
+	"sort"
+	"time"
+)
+
+// collectionStats are the statistics of a collection of the database
+type collectionStats struct {
+	Name        string `json:"name"`
+	Count       int64  `json:"count"`
+	Size        int64  `json:"size"`
+	StorageSize int64  `json:"storage_size"`
+	IndexSize   int64  `json:"index_size"`
+	Indexes     int    `json:"indexes"`
+}
+
+// cleanupReport is the number of expired records deleted by a cleanup run
+type cleanupReport struct {
+	Sessions      int64 `json:"sessions"`
+	Previews      int64 `json:"previews"`
+	Tokens        int64 `json:"tokens"`
+	Idempotency   int64 `json:"idempotency"`
+	Notifications int64 `json:"notifications"`
+}
+
+// maintenanceDB returns the database of the site
+func maintenanceDB() *mongo.Database {
+	return dbClient.Database(getEnvOrElse("DB_NAME", "portfolio"))
+}
+
+// collectionNames returns the names of the collections of the given database
+// without views and system collections, sorted by name
+func collectionNames(db *mongo.Database) ([]string, error) {
+	filter := bson.M{"type": "collection", "name": bson.M{"$not": bson.M{"$regex": `^system\.`}}}
+	names, err := db.ListCollectionNames(dbCtx, filter)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// runStatsJob computes the statistics of all collections of the database,
+// which are reported by the jobs API
+func runStatsJob() error {
+	db := maintenanceDB()
+	names, err := collectionNames(db)
+	if err != nil {
+		return err
+	}
+	stats := make([]collectionStats, 0, len(names))
+	for _, name := range names {
+		cursor, err := db.Collection(name).Aggregate(dbCtx, mongo.Pipeline{
+			{{Key: "$collStats", Value: bson.M{"storageStats": bson.M{}}}},
+		})
+		if err != nil {
+			return err
+		}
+		var res []struct {
+			StorageStats struct {
+				Count          int64          `bson:"count"`
+				Size           int64          `bson:"size"`
+				StorageSize    int64          `bson:"storageSize"`
+				TotalIndexSize int64          `bson:"totalIndexSize"`
+				IndexSizes     map[string]any `bson:"indexSizes"`
+			} `bson:"storageStats"`
+		}
+		err = cursor.All(dbCtx, &res)
+		if err != nil {
+			return err
+		}
+		s := collectionStats{Name: name}
+		// sharded collections report the statistics per shard
+		for _, r := range res {
+			s.Count += r.StorageStats.Count
+			s.Size += r.StorageStats.Size
+			s.StorageSize += r.StorageStats.StorageSize
+			s.IndexSize += r.StorageStats.TotalIndexSize
+			s.Indexes = max(s.Indexes, len(r.StorageStats.IndexSizes))
+		}
+		stats = append(stats, s)
+	}
+	setJobReport("stats", stats)
+	return nil
+}
+
+// runCleanupJob deletes expired sessions, previews and idempotency records,
+// tokens idle for TOKEN_MAX_IDLE and notifications resolved
+// NOTIFY_RETENTION ago; the database removes expired records by itself, but
+// only once a minute and not at all if an expiry index is missing. Idle tokens
+// are kept if TOKEN_MAX_IDLE is not set.
+func runCleanupJob() error {
+	var r cleanupReport
+	var err error
+	r.Sessions, err = auth.DeleteExpiredSessions()
+	if err != nil {
+		return err
+	}
+	r.Previews, err = auth.DeleteExpiredPreviews()
+	if err != nil {
+		return err
+	}
+	if idle := getEnvDurationOrElse("TOKEN_MAX_IDLE", 0); idle > 0 {
+		r.Tokens, err = auth.DeleteIdleTokens(time.Now().Add(-idle))
+		if err != nil {
+			return err
+		}
+	}
+	res, err := idempotencyCol.DeleteMany(dbCtx, bson.M{"expires": bson.M{"$lte": time.Now()}})
+	if err != nil {
+		return err
+	}
+	r.Idempotency = res.DeletedCount
+	retention := getEnvDurationOrElse("NOTIFY_RETENTION", 30*24*time.Hour)
+	res, err = notificationCol.DeleteMany(dbCtx,
+		bson.M{"resolved": true, "updated": bson.M{"$lt": time.Now().Add(-retention)}})
+	if err != nil {
+		return err
+	}
+	r.Notifications = res.DeletedCount
+	log.Println("Deleted expired records:", r.Sessions, "sessions,", r.Previews, "previews,", r.Tokens, "tokens,",
+		r.Idempotency, "idempotency records,", r.Notifications, "notifications")
+	setJobReport("cleanup", r)
+	return nil
+}
+
+// runCompactJob compacts all collections of the database, which defragments
+// their data and rebuilds their indexes, releasing unused space; compaction may
+// block other operations on the collection, so the job is disabled unless
+// COMPACT_INTERVAL is set
+func runCompactJob() error {
+	db := maintenanceDB()
+	names, err := collectionNames(db)
+	if err != nil {
+		return err
+	}
+	released := map[string]int64{}
+	for _, name := range names {
+		log.Println("Compacting collection:", name)
+		var res struct {
+			BytesFreed int64 `bson:"bytesFreed"`
+		}
+		err = db.RunCommand(dbCtx, bson.D{{Key: "compact", Value: name}}).Decode(&res)
+		if err != nil {
+			return err
+		}
+		released[name] = res.BytesFreed
+	}
+	setJobReport("compact", released)
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"github.com/duevil/Go_Portfolio/content"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+)
+
+// handleMenu handles requests for the navigation menu of all pages including
+// hidden and unpublished pages
+func handleMenu(c *gin.Context) {
+	log.Println("Menu requested")
+	menu, err := content.ListMenu()
+	if errISE(c, err) {
+		return
+	}
+	if menu == nil {
+		menu = []content.MenuItem{}
+	}
+	c.JSON(http.StatusOK, menu)
+}
+
+// handleMenuUpdate handles requests to set the menu entry of a page; the
+// request body contains the weight, whether the page is hidden and the uri of
+// the parent page, which must be a top-level page
+func handleMenuUpdate(c *gin.Context) {
+	uri := c.Param("uri")
+	log.Println("Menu update requested:", uri)
+	var req content.MenuEntry
+	err := c.ShouldBindJSON(&req)
+	if errBind(c, err) {
+		return
+	}
+	f, err := content.GetFromDB(uri)
+	if err == nil && (!f.Public() || !f.IsMD) {
+		err = content.ErrNotFound
+	}
+	if errNotFound(c, err) || errISE(c, err) || !allowURI(c, f.URI) {
+		return
+	}
+	err = content.SetMenu(f.URI, req)
+	if errors.Is(err, content.ErrInvalidMenu) {
+		errStatus(c, http.StatusBadRequest, err)
+		return
+	}
+	if errISE(c, err) {
+		return
+	}
+	purgeCDNKeys(keyMenu)
+	c.Status(http.StatusNoContent)
+}
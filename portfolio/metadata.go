@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net/http"
+)
+
+var (
+	errInvalidImage = errors.New("malformed image")
+	pngSignature    = []byte("\x89PNG\r\n\x1a\n")
+	exifHeader      = []byte("Exif\x00\x00")
+)
+
+// pngMetadataChunks are the chunks removed from PNG images
+var pngMetadataChunks = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"tIME": true,
+}
+
+// stripMetadataEnabled returns whether metadata is stripped from uploaded
+// images, which is disabled by setting STRIP_IMAGE_METADATA to 'false'
+func stripMetadataEnabled() bool {
+	return getEnvOrElse("STRIP_IMAGE_METADATA", "true") != "false"
+}
+
+// hasStrippableMetadata returns whether metadata can be stripped from images
+// of the given mime type
+func hasStrippableMetadata(mime string) bool {
+	return mime == "image/jpeg" || mime == "image/png" || mime == "image/webp"
+}
+
+// stripMetadata removes EXIF including GPS data, XMP, IPTC, comments and text
+// chunks from the given JPEG, PNG or WebP image of the given mime type; color
+// profiles are kept, as is the orientation of JPEG images; returns an
+// uploadError if the image is malformed
+func stripMetadata(uri string, mime string, data []byte) ([]byte, error) {
+	var out []byte
+	var err error
+	switch mime {
+	case "image/jpeg":
+		out, err = stripJPEG(data)
+	case "image/png":
+		out, err = stripPNG(data)
+	case "image/webp":
+		out, err = stripWebP(data)
+	default:
+		return data, nil
+	}
+	if err != nil {
+		return nil, &uploadError{status: http.StatusUnprocessableEntity, code: "invalid_image", file: uri,
+			msg: "stripping image metadata failed: " + uri + ": " + err.Error()}
+	}
+	return out, nil
+}
+
+// stripJPEG removes all APP1 (EXIF and XMP), APP13 (IPTC) and comment
+// segments; the orientation is kept in a minimal EXIF segment, so images are
+// still displayed upright
+func stripJPEG(data []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, errInvalidImage
+	}
+	out := bytes.Buffer{}
+	out.Write(data[:2])
+	orientation := uint16(0)
+	exifAt := -1
+	i := 2
+	for {
+		if i+2 > len(data) || data[i] != 0xff {
+			return nil, errInvalidImage
+		}
+		marker := data[i+1]
+		// fill bytes
+		if marker == 0xff {
+			i++
+			continue
+		}
+		// the entropy coded data following the start of scan is copied as-is
+		if marker == 0xda || marker == 0xd9 {
+			out.Write(data[i:])
+			break
+		}
+		if i+4 > len(data) {
+			return nil, errInvalidImage
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, errInvalidImage
+		}
+		segment := data[i:end]
+		payload := segment[4:]
+		switch marker {
+		case 0xe1: // APP1
+			if bytes.HasPrefix(payload, exifHeader) {
+				if o := exifOrientation(payload[len(exifHeader):]); o > 1 {
+					orientation = o
+				}
+				if exifAt < 0 {
+					exifAt = out.Len()
+				}
+			}
+		case 0xed, 0xfe: // APP13, COM
+		default:
+			out.Write(segment)
+		}
+		i = end
+	}
+	if orientation == 0 {
+		return out.Bytes(), nil
+	}
+	// the minimal EXIF segment replaces the first removed one
+	stripped := out.Bytes()
+	result := make([]byte, 0, len(stripped)+36)
+	result = append(result, stripped[:exifAt]...)
+	result = append(result, orientationSegment(orientation)...)
+	return append(result, stripped[exifAt:]...), nil
+}
+
+// exifOrientation returns the orientation tag of the first image file
+// directory of the given TIFF structure of an EXIF segment; returns zero if
+// the tag is missing
+func exifOrientation(tiff []byte) uint16 {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0
+	}
+	n := int(order.Uint16(tiff[ifd:]))
+	for e := 0; e < n; e++ {
+		entry := ifd + 2 + 12*e
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		// the orientation is a single SHORT stored in the value field
+		if order.Uint16(tiff[entry:]) == 0x0112 && order.Uint16(tiff[entry+2:]) == 3 {
+			return order.Uint16(tiff[entry+8:])
+		}
+	}
+	return 0
+}
+
+// orientationSegment returns an APP1 segment containing only the given
+// orientation
+func orientationSegment(orientation uint16) []byte {
+	tiff := []byte{'M', 'M', 0, 42, 0, 0, 0, 8, 0, 1, 0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(tiff[18:], orientation)
+	payload := append(append([]byte{}, exifHeader...), tiff...)
+	segment := []byte{0xff, 0xe1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// stripPNG removes the EXIF, text and time chunks
+func stripPNG(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, errInvalidImage
+	}
+	out := bytes.Buffer{}
+	out.Write(pngSignature)
+	for i := len(pngSignature); i < len(data); {
+		if i+12 > len(data) {
+			return nil, errInvalidImage
+		}
+		// length, type, data and CRC
+		end := i + 12 + int(binary.BigEndian.Uint32(data[i:]))
+		if end > len(data) || end < i {
+			return nil, errInvalidImage
+		}
+		if !pngMetadataChunks[string(data[i+4:i+8])] {
+			out.Write(data[i:end])
+		}
+		i = end
+	}
+	return out.Bytes(), nil
+}
+
+// stripWebP removes the EXIF and XMP chunks and clears their flags in the
+// extended format header
+func stripWebP(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errInvalidImage
+	}
+	out := bytes.Buffer{}
+	out.Write(data[:12])
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return nil, errInvalidImage
+		}
+		// chunks are padded to an even size
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + size + size%2
+		if end > len(data) || end < i {
+			return nil, errInvalidImage
+		}
+		switch string(data[i : i+4]) {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			chunk := append([]byte{}, data[i:end]...)
+			if len(chunk) > 8 {
+				chunk[8] &^= 0x08 | 0x04
+			}
+			out.Write(chunk)
+		default:
+			out.Write(data[i:end])
+		}
+		i = end
+	}
+	stripped := out.Bytes()
+	binary.LittleEndian.PutUint32(stripped[4:], uint32(len(stripped)-8))
+	return stripped, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"github.com/duevil/Go_Portfolio/content"
+	"log"
+	"sort"
+	"strings"
+)
+
+// migrations are the migrations of stored content run by the 'migrate'
+// command; each returns the uris of the migrated files
+var migrations = map[string]func() ([]string, error){
+	// content of files stored inline in the database is moved to the blob
+	// store
+	"inline": content.MoveInline,
+	// content of large files stored on disk is copied to GridFS
+	"disk": copyFromDisk,
+}
+
+// runMigrateCommand runs the migrations with the given names from the command
+// line and prints the migrated files; returns the exit code, which is 1 if a
+// migration failed
+func runMigrateCommand(names []string) int {
+	var known []string
+	for name := range migrations {
+		known = append(known, name)
+	}
+	sort.Strings(known)
+	if len(names) == 0 {
+		fmt.Println("usage: portfolio migrate <" + strings.Join(known, "|") + ">...")
+		return 2
+	}
+	for _, name := range names {
+		if migrations[name] == nil {
+			fmt.Println("unknown migration:", name)
+			return 2
+		}
+	}
+	client, err := connectDB()
+	if err != nil {
+		log.Println("[Err] Connecting to database:", err)
+		return 1
+	}
+	defer func() { _ = client.Disconnect(dbCtx) }()
+	initDB(client)
+	for _, name := range names {
+		migrated, err := migrations[name]()
+		for _, uri := range migrated {
+			fmt.Printf("[%s] migrated %s\n", name, uri)
+		}
+		if err != nil {
+			fmt.Printf("[%s] failed: %v\n", name, err)
+			return 1
+		}
+		fmt.Printf("[%s] %d files migrated\n", name, len(migrated))
+	}
+	return 0
+}
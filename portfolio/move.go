@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"github.com/duevil/Go_Portfolio/content"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// linkChange is a link of a page rewritten when the file it refers to is moved
+type linkChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// pageLinkChanges are the links of a page rewritten by a move
+type pageLinkChanges struct {
+	Page  string       `json:"page"`
+	Links []linkChange `json:"links"`
+}
+
+// moveReport is the response to a move request; if DryRun is set, nothing was
+// moved or rewritten, and the report shows what a move would change
+type moveReport struct {
+	From   string            `json:"from"`
+	To     string            `json:"to"`
+	DryRun bool              `json:"dry_run"`
+	Files  []string          `json:"files"`
+	Pages  []pageLinkChanges `json:"pages"`
+}
+
+// movedLink returns the given link with its target changed from the file with
+// the given uri to the given uri; the second return value is false if the link
+// does not refer to the file. The form of the link is kept: relative links
+// stay relative, absolute links keep their language prefix, and the query and
+// fragment are kept.
+func movedLink(link string, uri string, dest string) (string, bool) {
+	u, err := url.Parse(strings.TrimSpace(link))
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" {
+		return "", false
+	}
+	target, _, ok := contentURI(u.Path)
+	if !ok || target != uri {
+		return "", false
+	}
+	if strings.HasPrefix(u.Path, "/") {
+		root := "/" + content.URIRoot + "/"
+		i := strings.Index(u.Path, root)
+		u.Path = u.Path[:i+len(root)] + strings.TrimPrefix(dest, "/")
+	} else {
+		u.Path = strings.TrimPrefix(dest, "/")
+	}
+	u.RawPath = ""
+	return u.String(), true
+}
+
+// rewriteLinks changes the targets of all links of the given markdown which
+// refer to the file with the given uri to the given uri; returns the rewritten
+// markdown and the changed links
+func rewriteLinks(md []byte, uri string, dest string) ([]byte, []linkChange) {
+	var changes []linkChange
+	out := make([]byte, 0, len(md))
+	last := 0
+	for _, m := range linkRegexp.FindAllSubmatchIndex(md, -1) {
+		// the target is either the first or the second group
+		start, end := m[2], m[3]
+		if start < 0 {
+			start, end = m[4], m[5]
+		}
+		link := string(md[start:end])
+		moved, ok := movedLink(link, uri, dest)
+		if !ok {
+			continue
+		}
+		out = append(append(out, md[last:start]...), moved...)
+		last = end
+		changes = append(changes, linkChange{From: link, To: moved})
+	}
+	return append(out, md[last:]...), changes
+}
+
+// handleMove handles requests to move the asset with the given uri to the uri
+// given by the query parameter 'dest', see moveAsset. If the query parameter
+// 'dry_run' is 'true', nothing is changed and the response shows the pages
+// whose links would be rewritten.
+func handleMove(c *gin.Context) {
+	uri := c.Param("uri")
+	dest, err := sanitizePath(strings.TrimPrefix(c.Query("dest"), "/"))
+	if errUpload(c, err) {
+		return
+	}
+	dryRun := c.Query("dry_run") == "true"
+	log.Println("Move requested:", uri, dest, dryRun)
+	f, err := content.GetFromDB(uri)
+	if errNotFound(c, err) || errISE(c, err) {
+		return
+	}
+	r, err := moveAsset(f, dest, dryRun)
+	switch {
+	case errors.Is(err, content.ErrNotAnAsset):
+		errStatus(c, http.StatusBadRequest, err)
+	case errors.Is(err, content.ErrExists):
+		errStatus(c, http.StatusConflict, err)
+	case !errISE(c, err):
+		if !dryRun {
+			c.Header("Location", "/"+content.URIRoot+dest)
+		}
+		c.JSON(http.StatusOK, r)
+	}
+}
+
+// moveAsset moves the given asset to the given uri and rewrites the links of
+// all pages referring to it, as well as those to its variants, which are moved
+// along; if dryRun is set, nothing is changed. Returns the report of the move,
+// content.ErrNotAnAsset if the file is not a public asset or content.ErrExists
+// if a file is stored at the uri.
+func moveAsset(f content.MongoFile, dest string, dryRun bool) (moveReport, error) {
+	if f.IsMD || !f.Public() {
+		return moveReport{}, content.ErrNotAnAsset
+	}
+	_, err := content.GetFromDB(dest)
+	if err == nil {
+		return moveReport{}, content.ErrExists
+	}
+	if !errors.Is(content.ErrNotFound, err) {
+		return moveReport{}, err
+	}
+
+	// find the links to rewrite; the links are rewritten with the markdown
+	// including the front matter
+	pages, err := content.ListMarkdown()
+	if err != nil {
+		return moveReport{}, err
+	}
+	uri := f.URI
+	r := moveReport{From: uri, To: dest, DryRun: dryRun, Files: []string{uri}, Pages: []pageLinkChanges{}}
+	for _, m := range f.Variants {
+		r.Files = append(r.Files, uri+extensionByType(m))
+	}
+	rewritten := map[string][]byte{}
+	for _, p := range pages {
+		md, err := readFile(p)
+		if err != nil {
+			return moveReport{}, err
+		}
+		var changes []linkChange
+		for _, moved := range r.Files {
+			var ch []linkChange
+			md, ch = rewriteLinks(md, moved, dest+strings.TrimPrefix(moved, uri))
+			changes = append(changes, ch...)
+		}
+		if len(changes) > 0 {
+			r.Pages = append(r.Pages, pageLinkChanges{Page: p.URI, Links: changes})
+			rewritten[p.URI] = md
+		}
+	}
+	if dryRun {
+		return r, nil
+	}
+
+	// move the asset and its variants, then rewrite the pages
+	for _, moved := range r.Files {
+		_, err = content.MoveAsset(moved, dest+strings.TrimPrefix(moved, uri))
+		if err != nil {
+			return moveReport{}, err
+		}
+	}
+	contentChanged(r.Files...)
+	for _, p := range r.Pages {
+		md := rewritten[p.Page]
+		log.Println("Rewriting links of page:", p.Page)
+		page := content.NewMarkdownFile(p.Page, int64(len(md)), time.Now())
+		err = page.Store(bytes.NewReader(md))
+		if err != nil {
+			return moveReport{}, err
+		}
+		contentChanged(p.Page)
+	}
+	return r, nil
+}
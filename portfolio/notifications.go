@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/duevil/Go_Portfolio/content"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"net/http"
+	"time"
+)
+
+// kinds of notifications
+const (
+	notifyJob      = "job"
+	notifyLinks    = "links"
+	notifyReview   = "review"
+	notifyComments = "comments"
+	notifyDisk     = "disk"
+)
+
+var (
+	// notificationCol is the collection the notifications are stored in;
+	// shared by all instances, so notifications are read only once
+	notificationCol *mongo.Collection
+	notifyClient    = &http.Client{Timeout: 10 * time.Second}
+)
+
+// notification is a system event admins are notified of; the id identifies
+// the event, like 'job:links', so an event persisting over several checks
+// is reported once. Notifications are unread until an admin marks them as
+// read and are resolved once the event is over.
+type notification struct {
+	ID       string    `bson:"_id" json:"id"`
+	Kind     string    `bson:"kind" json:"kind"`
+	Message  string    `bson:"message" json:"message"`
+	Created  time.Time `bson:"created" json:"created"`
+	Updated  time.Time `bson:"updated" json:"updated"`
+	Read     bool      `bson:"read" json:"read"`
+	Resolved bool      `bson:"resolved" json:"resolved"`
+}
+
+// setNotificationCollection sets the collection the notifications are stored
+// in and creates an index for listing them
+func setNotificationCollection(c *mongo.Collection) {
+	notificationCol = c
+	index := mongo.IndexModel{Keys: bson.D{{Key: "updated", Value: -1}}}
+	_, err := c.Indexes().CreateOne(dbCtx, index)
+	if err != nil {
+		log.Println("[Err] Creating notification index:", err)
+	}
+}
+
+// notify records the event with the given id, kind and message; the event is
+// recorded as new unread notification unless it is already recorded with the
+// same message and not resolved, in which case only its update time is set.
+// New notifications are mirrored by mail and webhook.
+func notify(id string, kind string, msg string) {
+	if notificationCol == nil {
+		return
+	}
+	now := time.Now()
+	var prev notification
+	err := notificationCol.FindOne(dbCtx, bson.M{"_id": id}).Decode(&prev)
+	if err == nil && !prev.Resolved && prev.Message == msg {
+		_, err = notificationCol.UpdateByID(dbCtx, id, bson.M{"$set": bson.M{"updated": now}})
+		if err != nil {
+			log.Println("[Err] Updating notification:", id, err)
+		}
+		return
+	}
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		log.Println("[Err] Getting notification:", id, err)
+		return
+	}
+	n := notification{ID: id, Kind: kind, Message: msg, Created: now, Updated: now}
+	_, err = notificationCol.ReplaceOne(dbCtx, bson.M{"_id": id}, n, options.Replace().SetUpsert(true))
+	if err != nil {
+		log.Println("[Err] Storing notification:", id, err)
+		return
+	}
+	log.Println("Notification:", msg)
+	go mirrorNotification(n)
+}
+
+// resolve marks the notification of the event with the given id as resolved,
+// so the event is notified again if it recurs
+func resolve(id string) {
+	if notificationCol == nil {
+		return
+	}
+	_, err := notificationCol.UpdateOne(dbCtx, bson.M{"_id": id, "resolved": false},
+		bson.M{"$set": bson.M{"resolved": true, "updated": time.Now()}})
+	if err != nil {
+		log.Println("[Err] Resolving notification:", id, err)
+	}
+}
+
+// mirrorNotification sends the given notification by mail to NOTIFY_EMAIL and
+// as JSON to the webhook NOTIFY_WEBHOOK_URL if set
+func mirrorNotification(n notification) {
+	if to := getEnvOrElse("NOTIFY_EMAIL", ""); to != "" && mailEnabled() {
+		err := sendMail(to, "["+siteTitle()+"] "+n.Message, n.Message+"\n\n"+n.Created.Format(time.RFC1123Z))
+		if err != nil {
+			log.Println("[Err] Mailing notification:", n.ID, err)
+		}
+	}
+	if u := getEnvOrElse("NOTIFY_WEBHOOK_URL", ""); u != "" {
+		err := postWebhook(u, n)
+		if err != nil {
+			log.Println("[Err] Posting notification:", n.ID, err)
+		}
+	}
+}
+
+// postWebhook posts the given notification as JSON to the given URL
+func postWebhook(u string, n notification) error {
+	b, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	res, err := notifyClient.Post(u, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer cls(res.Body)
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("request to %s failed: %s", u, res.Status)
+	}
+	return nil
+}
+
+// runNotifyJob checks for pages waiting for review and comments waiting for
+// moderation; storage is checked by the usage job
+func runNotifyJob() error {
+	files, err := content.ListInReview()
+	if err != nil {
+		return err
+	}
+	if len(files) > 0 {
+		notify(notifyReview, notifyReview, fmt.Sprintf("%d pages are waiting for review", len(files)))
+	} else {
+		resolve(notifyReview)
+	}
+	if commentsEnabled() {
+		n, err := content.CountComments(content.CommentPending)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			notify(notifyComments, notifyComments, fmt.Sprintf("%d comments are waiting for moderation", n))
+		} else {
+			resolve(notifyComments)
+		}
+	}
+	return nil
+}
+
+// handleNotifications handles requests for the notifications, newest first;
+// only unread notifications are listed if the query parameter 'unread' is
+// 'true'. The response contains the number of unread notifications.
+func handleNotifications(c *gin.Context) {
+	log.Println("Notifications requested")
+	filter := bson.M{}
+	if c.Query("unread") == "true" {
+		filter["read"] = false
+	}
+	opts := options.Find().SetSort(bson.M{"updated": -1}).SetLimit(int64(getEnvIntOrElse("NOTIFY_LIST_LIMIT", 100)))
+	cursor, err := notificationCol.Find(dbCtx, filter, opts)
+	if errISE(c, err) {
+		return
+	}
+	list := []notification{}
+	err = cursor.All(dbCtx, &list)
+	if errISE(c, err) {
+		return
+	}
+	unread, err := notificationCol.CountDocuments(dbCtx, bson.M{"read": false})
+	if errISE(c, err) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"unread": unread, "notifications": list})
+}
+
+// handleNotificationRead handles requests to mark the notification with the
+// given id as read
+func handleNotificationRead(c *gin.Context) {
+	id := c.Param("id")
+	log.Println("Notification read requested:", id)
+	res, err := notificationCol.UpdateByID(dbCtx, id, bson.M{"$set": bson.M{"read": true}})
+	if errISE(c, err) {
+		return
+	}
+	if res.MatchedCount == 0 {
+		errNotFound(c, content.ErrNotFound)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// handleNotificationsRead handles requests to mark all notifications as read
+func handleNotificationsRead(c *gin.Context) {
+	log.Println("All notifications read requested")
+	_, err := notificationCol.UpdateMany(dbCtx, bson.M{"read": false}, bson.M{"$set": bson.M{"read": true}})
+	if errISE(c, err) {
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"auth"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// oauthStateCookie is the name of the cookie holding the state and the PKCE
+// verifier of a running OAuth login
+const oauthStateCookie = "oauth_state"
+
+// oauthUserRegexp matches characters that are not allowed in user names
+var oauthUserRegexp = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// oauthClient is the client used for requests to the OAuth provider
+var oauthClient = &http.Client{Timeout: 10 * time.Second}
+
+// oauthProvider holds the endpoints of an OAuth provider
+type oauthProvider struct {
+	// Label is shown on the login page
+	Label       string
+	authURL     string
+	tokenURL    string
+	userinfoURL string
+	scope       string
+	// github is set for GitHub, whose user API differs from OIDC
+	github bool
+}
+
+var (
+	// oidcProvider caches the discovered OIDC provider
+	oidcProvider    *oauthProvider
+	oidcProviderErr error
+	oidcOnce        sync.Once
+)
+
+// oauthConfig returns the OAuth provider set by OAUTH_PROVIDER, which is either
+// 'github' or 'oidc'; OIDC providers are discovered using OIDC_ISSUER; returns
+// false if OAuth login is not configured
+func oauthConfig() (*oauthProvider, bool) {
+	if getEnvOrElse("OAUTH_CLIENT_ID", "") == "" || getEnvOrElse("SITE_URL", "") == "" {
+		return nil, false
+	}
+	switch getEnvOrElse("OAUTH_PROVIDER", "") {
+	case "github":
+		return &oauthProvider{
+			Label:       "GitHub",
+			authURL:     "https://github.com/login/oauth/authorize",
+			tokenURL:    "https://github.com/login/oauth/access_token",
+			userinfoURL: "https://api.github.com/user/emails",
+			scope:       "user:email",
+			github:      true,
+		}, true
+	case "oidc":
+		oidcOnce.Do(func() { oidcProvider, oidcProviderErr = discoverOIDC(getEnvOrElse("OIDC_ISSUER", "")) })
+		if oidcProviderErr != nil {
+			log.Println("[Err] OIDC discovery:", oidcProviderErr)
+			return nil, false
+		}
+		return oidcProvider, true
+	default:
+		return nil, false
+	}
+}
+
+// discoverOIDC reads the endpoints of the OIDC provider with the given issuer
+// from its discovery document
+func discoverOIDC(issuer string) (*oauthProvider, error) {
+	issuer = strings.TrimSuffix(issuer, "/")
+	if issuer == "" {
+		return nil, errors.New("OIDC_ISSUER is not set")
+	}
+	var doc struct {
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	}
+	err := oauthGetJSON(issuer+"/.well-known/openid-configuration", "", &doc)
+	if err != nil {
+		return nil, err
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.UserinfoEndpoint == "" {
+		return nil, errors.New("incomplete discovery document of " + issuer)
+	}
+	return &oauthProvider{
+		Label:       getEnvOrElse("OIDC_LABEL", "OpenID Connect"),
+		authURL:     doc.AuthorizationEndpoint,
+		tokenURL:    doc.TokenEndpoint,
+		userinfoURL: doc.UserinfoEndpoint,
+		scope:       "openid email",
+	}, nil
+}
+
+// handleOAuthLogin handles requests to log in with the OAuth provider;
+// redirects to the provider's authorization endpoint
+func handleOAuthLogin(c *gin.Context) {
+	log.Println("OAuth login requested")
+	p, ok := oauthConfig()
+	if !ok {
+		handleNotFound(c)
+		return
+	}
+	state, err := randomHex(16)
+	if errISE(c, err) {
+		return
+	}
+	verifier, err := randomHex(32)
+	if errISE(c, err) {
+		return
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+	secure := c.Request.TLS != nil || getEnvOrElse("SESSION_SECURE", "false") == "true"
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oauthStateCookie, state+"."+verifier, 600, "/admin/login/oauth", "", secure, true)
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", getEnvOrElse("OAUTH_CLIENT_ID", ""))
+	q.Set("redirect_uri", oauthRedirectURL())
+	q.Set("scope", p.scope)
+	q.Set("state", state)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+	c.Redirect(http.StatusFound, p.authURL+"?"+q.Encode())
+}
+
+// handleOAuthCallback handles the redirect back from the OAuth provider;
+// validates the state, exchanges the code for an access token, reads the
+// user's verified emails and logs in the user if one of them is allowed by
+// OAUTH_ADMIN_EMAILS. Users with a second factor must enter it like after a
+// password login.
+func handleOAuthCallback(c *gin.Context) {
+	log.Println("OAuth callback requested")
+	p, ok := oauthConfig()
+	if !ok {
+		handleNotFound(c)
+		return
+	}
+	cookie, _ := c.Cookie(oauthStateCookie)
+	c.SetCookie(oauthStateCookie, "", -1, "/admin/login/oauth", "", false, true)
+	state, verifier, _ := strings.Cut(cookie, ".")
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(c.Query("state"))) != 1 {
+		oauthFailed(c, http.StatusBadRequest, "invalid state")
+		return
+	}
+	if e := c.Query("error"); e != "" {
+		oauthFailed(c, http.StatusUnauthorized, "provider returned error: "+e)
+		return
+	}
+	token, err := p.exchange(c.Query("code"), verifier)
+	if err != nil {
+		oauthFailed(c, http.StatusBadGateway, err.Error())
+		return
+	}
+	emails, err := p.emails(token)
+	if err != nil {
+		oauthFailed(c, http.StatusBadGateway, err.Error())
+		return
+	}
+	allowed := parseList(getEnvOrElse("OAUTH_ADMIN_EMAILS", ""))
+	for _, email := range emails {
+		if !allowed[strings.ToLower(email)] {
+			continue
+		}
+		u, err := oauthUser(email)
+		if errors.Is(err, auth.ErrUserNotFound) {
+			oauthFailed(c, http.StatusForbidden, "no user with email: "+email)
+			return
+		}
+		if errISE(c, err) {
+			return
+		}
+		log.Println("OAuth login of user:", u.Name)
+		if u.TOTPEnabled() {
+			startTOTPLogin(c, u.Name, "/admin/")
+			return
+		}
+		startSession(c, u.Name, "/admin/")
+		return
+	}
+	oauthFailed(c, http.StatusForbidden, "no allowed email: "+strings.Join(emails, ", "))
+}
+
+// exchange exchanges the given authorization code for an access token
+func (p *oauthProvider) exchange(code string, verifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", oauthRedirectURL())
+	form.Set("client_id", getEnvOrElse("OAUTH_CLIENT_ID", ""))
+	form.Set("client_secret", getEnvOrElse("OAUTH_CLIENT_SECRET", ""))
+	form.Set("code_verifier", verifier)
+	req, err := http.NewRequest(http.MethodPost, p.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	res, err := oauthClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer cls(res.Body)
+	var body struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+	}
+	err = json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&body)
+	if err != nil {
+		return "", err
+	}
+	if body.AccessToken == "" {
+		return "", fmt.Errorf("token exchange failed: %s %s", res.Status, body.Error)
+	}
+	return body.AccessToken, nil
+}
+
+// emails returns the verified emails of the user the given access token
+// belongs to
+func (p *oauthProvider) emails(token string) ([]string, error) {
+	var emails []string
+	if p.github {
+		var list []struct {
+			Email    string `json:"email"`
+			Verified bool   `json:"verified"`
+		}
+		err := oauthGetJSON(p.userinfoURL, token, &list)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range list {
+			if e.Verified {
+				emails = append(emails, e.Email)
+			}
+		}
+		return emails, nil
+	}
+	var info struct {
+		Email    string `json:"email"`
+		Verified bool   `json:"email_verified"`
+	}
+	err := oauthGetJSON(p.userinfoURL, token, &info)
+	if err != nil {
+		return nil, err
+	}
+	if info.Verified && info.Email != "" {
+		emails = append(emails, info.Email)
+	}
+	return emails, nil
+}
+
+// oauthGetJSON requests the given URL, authorized with the given access token
+// if not empty, and decodes the JSON response into the given value
+func oauthGetJSON(u string, token string, v any) error {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := oauthClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer cls(res.Body)
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("request to %s failed: %s", u, res.Status)
+	}
+	return json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(v)
+}
+
+// oauthUser returns the user with the given email; if there is no such user
+// and OAUTH_CREATE_USERS is 'true', an admin user is created, since only admin
+// emails are allowed, else auth.ErrUserNotFound is returned
+func oauthUser(email string) (auth.User, error) {
+	u, err := auth.GetUserByEmail(email)
+	if !errors.Is(err, auth.ErrUserNotFound) || getEnvOrElse("OAUTH_CREATE_USERS", "false") != "true" {
+		return u, err
+	}
+	// the user can only log in using OAuth until a password is set
+	password, err := randomHex(32)
+	if err != nil {
+		return auth.User{}, err
+	}
+	name := strings.Trim(oauthUserRegexp.ReplaceAllString(strings.ReplaceAll(email, "@", "_at_"), "-"), "-")
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	u, err = auth.CreateUser(name, email, password, auth.RoleAdmin)
+	if err != nil {
+		return auth.User{}, err
+	}
+	log.Println("Created admin user for OAuth login:", u.Name, email)
+	return u, nil
+}
+
+// oauthFailed logs the failed OAuth login and renders the login page with an
+// error
+func oauthFailed(c *gin.Context, status int, reason string) {
+	log.Println("[Err] OAuth login failed:", reason)
+	page := newLoginPage("")
+	page.Error = "Die Anmeldung ist fehlgeschlagen."
+	c.HTML(status, "login", page)
+}
+
+// oauthRedirectURL returns the URL the OAuth provider redirects back to
+func oauthRedirectURL() string {
+	return strings.TrimSuffix(getEnvOrElse("SITE_URL", ""), "/") + "/admin/login/oauth/callback"
+}
+
+// randomHex returns the given number of random bytes hex encoded
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
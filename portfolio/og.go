@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/duevil/Go_Portfolio/content"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	"image/png"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// size of the social preview images in pixels as recommended for OpenGraph
+const (
+	ogWidth  = 1200
+	ogHeight = 630
+	// ogPadding is the distance of the text to the edges of the image
+	ogPadding = 80
+	// ogTitleLines is the maximum number of lines of the title; longer titles
+	// are truncated
+	ogTitleLines = 4
+)
+
+// ogCard is the data a social preview image is rendered from
+type ogCard struct {
+	Title string
+	Tags  []string
+	// Site and Host are the branding shown at the bottom of the image
+	Site string
+	Host string
+}
+
+// ogTemplate is the style social preview images are rendered with, set by the
+// OG_* variables; the background image is the uri of a PNG or JPEG file in the
+// database covering the background, empty if the background is plain
+type ogTemplate struct {
+	background color.Color
+	foreground color.Color
+	accent     color.Color
+	image      string
+}
+
+var (
+	// ogStyle is the template all social preview images are rendered with
+	ogStyle = ogTemplate{
+		background: parseColor(getEnvOrElse("OG_BACKGROUND", "#1d2330"), color.RGBA{R: 0x1d, G: 0x23, B: 0x30, A: 0xff}),
+		foreground: parseColor(getEnvOrElse("OG_FOREGROUND", "#ffffff"), color.White),
+		accent:     parseColor(getEnvOrElse("OG_ACCENT", "#e8a33d"), color.RGBA{R: 0xe8, G: 0xa3, B: 0x3d, A: 0xff}),
+		image:      getEnvOrElse("OG_BACKGROUND_IMAGE", ""),
+	}
+	// ogImages keeps rendered images by the version of their page; its size in
+	// bytes is set by OG_CACHE_SIZE
+	ogImages = &staleCache{
+		max:     getEnvIntOrElse("OG_CACHE_SIZE", 16<<20),
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+	}
+	// ogFonts are the parsed bold and regular fonts; parsed once when the first
+	// image is rendered
+	ogFonts struct {
+		once    sync.Once
+		bold    *opentype.Font
+		regular *opentype.Font
+		err     error
+	}
+)
+
+// parseColor parses the given color in the hexadecimal form '#rrggbb';
+// returns the given default if the color is invalid
+func parseColor(s string, def color.Color) color.Color {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) != 6 {
+		log.Println("[Err] Invalid color, using default:", s)
+		return def
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		log.Println("[Err] Invalid color, using default:", s)
+		return def
+	}
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xff}
+}
+
+// ogImageURL returns the URL of the social preview image of the given page;
+// the URL is absolute if SITE_URL is set
+func ogImageURL(f content.MongoFile) string {
+	return siteURL(nil) + path.Join("/og", strings.TrimSuffix(f.URI, path.Ext(f.URI))+".png")
+}
+
+// ogFace returns a face of the given font with the given size in points
+func ogFace(f *opentype.Font, size float64) (font.Face, error) {
+	return opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+}
+
+// wrapText breaks the given text into lines not wider than the given width
+// measured with the given face; words wider than a line are not broken. If
+// there are more than the given number of lines, the last line is truncated
+// with an ellipsis.
+func wrapText(face font.Face, text string, width int, maxLines int) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		next := strings.TrimSpace(line + " " + word)
+		if line != "" && font.MeasureString(face, next).Ceil() > width {
+			lines = append(lines, line)
+			next = word
+		}
+		line = next
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	if len(lines) > maxLines {
+		lines = lines[:maxLines]
+		words := strings.Fields(lines[maxLines-1])
+		for len(words) > 1 && font.MeasureString(face, strings.Join(words, " ")+" …").Ceil() > width {
+			words = words[:len(words)-1]
+		}
+		lines[maxLines-1] = strings.Join(words, " ") + " …"
+	}
+	return lines
+}
+
+// drawText draws the given text with the given face and color with its
+// baseline starting at the given point
+func drawText(img draw.Image, face font.Face, c color.Color, x int, y int, text string) {
+	d := font.Drawer{Dst: img, Src: image.NewUniform(c), Face: face, Dot: fixed.P(x, y)}
+	d.DrawString(text)
+}
+
+// backgroundImage returns the background image of the template; returns nil if
+// the template has none or it cannot be read
+func (t ogTemplate) backgroundImage() image.Image {
+	if t.image == "" {
+		return nil
+	}
+	f, err := getFile(t.image)
+	if err != nil {
+		log.Println("[Err] Reading social preview background:", t.image, err)
+		return nil
+	}
+	rc, err := f.Open()
+	if err != nil {
+		log.Println("[Err] Reading social preview background:", t.image, err)
+		return nil
+	}
+	defer cls(rc)
+	img, _, err := image.Decode(rc)
+	if err != nil {
+		log.Println("[Err] Decoding social preview background:", t.image, err)
+		return nil
+	}
+	return img
+}
+
+// render renders the given card as PNG image; the title is drawn in bold and
+// wrapped, the tags are drawn above it and the branding below it
+func (t ogTemplate) render(card ogCard) ([]byte, error) {
+	ogFonts.once.Do(func() {
+		ogFonts.bold, ogFonts.err = opentype.Parse(gobold.TTF)
+		if ogFonts.err == nil {
+			ogFonts.regular, ogFonts.err = opentype.Parse(goregular.TTF)
+		}
+	})
+	if ogFonts.err != nil {
+		return nil, ogFonts.err
+	}
+	title, err := ogFace(ogFonts.bold, 64)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = title.Close() }()
+	brand, err := ogFace(ogFonts.bold, 36)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = brand.Close() }()
+	small, err := ogFace(ogFonts.regular, 30)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = small.Close() }()
+	img := image.NewRGBA(image.Rect(0, 0, ogWidth, ogHeight))
+	draw.Draw(img, img.Bounds(), image.NewUniform(t.background), image.Point{}, draw.Src)
+	if bg := t.backgroundImage(); bg != nil {
+		draw.CatmullRom.Scale(img, img.Bounds(), bg, bg.Bounds(), draw.Src, nil)
+	}
+	draw.Draw(img, image.Rect(0, 0, 24, ogHeight), image.NewUniform(t.accent), image.Point{}, draw.Src)
+	width := ogWidth - 2*ogPadding
+	if len(card.Tags) > 0 {
+		tags := "#" + strings.Join(card.Tags, "  #")
+		drawText(img, small, t.accent, ogPadding, ogPadding+30, wrapText(small, tags, width, 1)[0])
+	}
+	lineHeight := title.Metrics().Height.Ceil()
+	y := ogPadding + 60 + lineHeight
+	for _, line := range wrapText(title, card.Title, width, ogTitleLines) {
+		drawText(img, title, t.foreground, ogPadding, y, line)
+		y += lineHeight
+	}
+	drawText(img, brand, t.foreground, ogPadding, ogHeight-ogPadding, card.Site)
+	if card.Host != "" {
+		x := ogWidth - ogPadding - font.MeasureString(small, card.Host).Ceil()
+		drawText(img, small, t.accent, x, ogHeight-ogPadding, card.Host)
+	}
+	buf := bytes.Buffer{}
+	err = png.Encode(&buf, img)
+	return buf.Bytes(), err
+}
+
+// newOGCard returns the card of the given page on the site with the given
+// base URL
+func newOGCard(f content.MongoFile, base string) ogCard {
+	card := ogCard{Title: f.Title(), Tags: f.Tags(), Site: siteTitle()}
+	if u, err := url.Parse(base); err == nil {
+		card.Host = u.Host
+	}
+	return card
+}
+
+// handleOGImage handles requests for the social preview image of a page; the
+// image of the page '/a/b.md' is served at '/og/a/b.png'. Images are rendered
+// once per version of a page and cached; private and unpublished pages have no
+// image.
+func handleOGImage(c *gin.Context) {
+	p := c.Param("page")
+	log.Println("Social preview requested:", p)
+	if path.Ext(p) != ".png" {
+		errNotFound(c, content.ErrNotFound)
+		return
+	}
+	uri := strings.TrimSuffix(path.Clean(p), ".png") + ".md"
+	f, err := getFile(uri)
+	if err == nil && (!f.IsMD || !f.Public() || !f.Published() || f.Private()) {
+		err = content.ErrNotFound
+	}
+	if errNotFound(c, err) || errUnavailable(c, err) || errISE(c, err) {
+		return
+	}
+	surrogateKeys(c, fileKey(uri))
+	card := newOGCard(f, siteURL(c))
+	sum := sha256.Sum256([]byte(strings.Join([]string{uri, strconv.FormatInt(f.LastMod.UnixNano(), 10), f.Hash,
+		card.Site, card.Host}, "\n")))
+	etag := hex.EncodeToString(sum[:])
+	c.Header("ETag", `"`+etag+`"`)
+	if matchETag(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	if e, ok := ogImages.get(etag); ok {
+		c.Data(http.StatusOK, "image/png", e.body)
+		return
+	}
+	v, err, _ := pageGroup.Do("og:"+etag, func() (any, error) {
+		return ogStyle.render(card)
+	})
+	if errISE(c, err) {
+		return
+	}
+	data := v.([]byte)
+	ogImages.put(staleEntry{key: etag, body: data})
+	c.Data(http.StatusOK, "image/png", data)
+}
+
+// ogHook is an export hook writing the social preview images of the exported
+// pages into the export
+type ogHook struct{}
+
+func (ogHook) Name() string { return "social preview images" }
+
+func (ogHook) Run(dir string, base string, files []content.MongoFile) error {
+	for _, f := range filterFiles(files, func(f *content.MongoFile) bool { return f.IsMD && !f.Private() }) {
+		data, err := ogStyle.render(newOGCard(f, base))
+		if err != nil {
+			return err
+		}
+		name := filepath.Join(dir, "og", filepath.FromSlash(strings.TrimSuffix(f.URI, path.Ext(f.URI))+".png"))
+		err = os.MkdirAll(filepath.Dir(name), 0o755)
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(name, data, 0o644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"auth"
+	"errors"
+	"github.com/duevil/Go_Portfolio/content"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// maxPageSize is the maximum size of a page sent to the pages API set by
+// MAX_PAGE_SIZE
+var maxPageSize = int64(getEnvIntOrElse("MAX_PAGE_SIZE", 1<<20))
+
+// pageRequest is the JSON request body for creating and editing pages; the uri
+// is only read when creating a page
+type pageRequest struct {
+	URI        string  `json:"uri"`
+	Content    *string `json:"content"`
+	Visibility string  `json:"visibility" binding:"omitempty,oneof=public unlisted private"`
+	Status     string  `json:"status" binding:"omitempty,oneof=draft review published archived"`
+}
+
+// pageResponse is the response of the pages API; the content is only included
+// when a single page is requested
+type pageResponse struct {
+	File    content.MongoFile `json:"file"`
+	URL     string            `json:"url"`
+	Content *string           `json:"content,omitempty"`
+}
+
+// pageURI returns the uri of the page at the given path; the extension '.md'
+// is added to paths without extension, paths of other files are rejected
+func pageURI(p string) (string, error) {
+	uri, err := sanitizePath(strings.TrimPrefix(p, "/"))
+	if err != nil {
+		return "", err
+	}
+	switch path.Ext(uri) {
+	case "":
+		uri += ".md"
+	case ".md":
+	default:
+		return "", &uploadError{status: http.StatusBadRequest, code: "not_a_page", file: uri,
+			msg: "pages must be markdown files: " + uri}
+	}
+	return uri, nil
+}
+
+// bindPage reads the page of the request body, which is either a JSON
+// pageRequest or the raw markdown; the uri, visibility and status of raw
+// markdown are given by the query parameters 'uri', 'visibility' and 'status'
+func bindPage(c *gin.Context) (pageRequest, bool) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxPageSize)
+	var req pageRequest
+	var err error
+	if c.ContentType() == binding.MIMEJSON {
+		err = c.ShouldBindJSON(&req)
+	} else {
+		var data []byte
+		data, err = io.ReadAll(c.Request.Body)
+		markdown := string(data)
+		req = pageRequest{URI: c.Query("uri"), Content: &markdown, Visibility: c.Query("visibility"), Status: c.Query("status")}
+	}
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		errUpload(c, &uploadError{status: http.StatusRequestEntityTooLarge, code: "too_large",
+			msg: "page is too large", details: map[string]any{"limit": maxPageSize}})
+		return req, false
+	}
+	return req, !errBind(c, err)
+}
+
+// findPage returns the published page with the given uri; the second return
+// value is false if there is no such page
+func findPage(uri string) (content.MongoFile, bool, error) {
+	f, err := content.GetFromDB(uri)
+	if errors.Is(content.ErrNotFound, err) || (err == nil && !f.Public()) {
+		return content.MongoFile{}, false, nil
+	}
+	return f, err == nil, err
+}
+
+// checkIfMatch checks whether the request's If-Match header matches the
+// content hash of the given page; if not, the request is aborted with status
+// 412. Requests with If-Match header for a missing page are aborted as well.
+func checkIfMatch(c *gin.Context, f content.MongoFile, exists bool) bool {
+	ifMatch := c.GetHeader("If-Match")
+	if ifMatch == "" {
+		return true
+	}
+	var hash string
+	if exists {
+		var err error
+		hash, err = f.ContentHash()
+		if errISE(c, err) {
+			return false
+		}
+		if matchETag(ifMatch, hash) {
+			return true
+		}
+	}
+	log.Println("[Err] Precondition failed for page:", c.Param("uri"))
+	c.AbortWithStatusJSON(http.StatusPreconditionFailed, gin.H{"error": content.ErrHashMismatch.Error(), "sha256": hash})
+	return false
+}
+
+// storePage stores the markdown of the given request as page with the given
+// uri and responds with the stored metadata and the url of the page; responds
+// with status 200 if an existing page was replaced. Like uploads, pages of
+// editors are submitted for review if the review workflow is enabled, see
+// uploader.
+func storePage(c *gin.Context, uri string, req pageRequest, replaced bool) {
+	u := newUploader(c)
+	if req.Visibility != "" {
+		u.visibility = req.Visibility
+	}
+	if req.Status != "" {
+		u.pageStatus = req.Status
+	}
+	markdown := *req.Content
+	f := content.NewMarkdownFile(uri, int64(len(markdown)), time.Now())
+	err := u.store(f, strings.NewReader(markdown))
+	if errUpload(c, err) || errISE(c, err) {
+		return
+	}
+	stored, err := content.GetFromDB(u.storedURI(uri))
+	if errISE(c, err) {
+		return
+	}
+	status := u.status()
+	if replaced && status == http.StatusCreated {
+		status = http.StatusOK
+	}
+	location := u.location(uri)
+	c.Header("Location", location)
+	c.JSON(status, pageResponse{File: stored, URL: location})
+}
+
+// handlePages handles requests to list all published markdown pages; private
+// pages are only listed for admins
+func handlePages(c *gin.Context) {
+	log.Println("Pages requested")
+	list, err := content.ListAll()
+	if errISE(c, err) {
+		return
+	}
+	role, _ := c.Get("role")
+	admin := false
+	if r, ok := role.(auth.Role); ok {
+		admin = r.Can(auth.PermAdmin)
+	}
+	pages := []pageResponse{}
+	for _, f := range list {
+		if path.Ext(f.URI) != ".md" || (f.Private() && !admin) {
+			continue
+		}
+		pages = append(pages, pageResponse{File: f, URL: f.Link()})
+	}
+	c.JSON(http.StatusOK, pages)
+}
+
+// handlePage handles requests for a single page; responds with the page's
+// metadata, url and markdown
+func handlePage(c *gin.Context) {
+	uri, err := pageURI(c.Param("uri"))
+	if errUpload(c, err) {
+		return
+	}
+	log.Println("Page requested:", uri)
+	f, ok, err := findPage(uri)
+	if errISE(c, err) {
+		return
+	}
+	if !ok {
+		errNotFound(c, content.ErrNotFound)
+		return
+	}
+	rc, err := f.Open()
+	if errISE(c, err) {
+		return
+	}
+	defer cls(rc)
+	data, err := io.ReadAll(rc)
+	if errISE(c, err) {
+		return
+	}
+	markdown := string(data)
+	c.JSON(http.StatusOK, pageResponse{File: f, URL: f.Link(), Content: &markdown})
+}
+
+// handlePageCreate handles requests to create a page; responds with status 409
+// if the page already exists
+func handlePageCreate(c *gin.Context) {
+	log.Println("Page creation requested")
+	req, ok := bindPage(c)
+	if !ok {
+		return
+	}
+	fields := map[string]string{}
+	if req.URI == "" {
+		fields["uri"] = "is required"
+	}
+	if req.Content == nil {
+		fields["content"] = "is required"
+	}
+	if len(fields) > 0 {
+		abortFields(c, fields)
+		return
+	}
+	uri, err := pageURI(req.URI)
+	if errUpload(c, err) {
+		return
+	}
+	_, exists, err := findPage(uri)
+	if errISE(c, err) {
+		return
+	}
+	if exists {
+		log.Println("[Err] Page already exists:", uri)
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "page already exists", "uri": uri})
+		return
+	}
+	storePage(c, uri, req, false)
+}
+
+// handlePageUpdate handles requests to create or replace the page with the
+// given uri; if the request has an If-Match header, the page is only replaced
+// if its content hash matches, else the request is answered with status 412
+func handlePageUpdate(c *gin.Context) {
+	uri, err := pageURI(c.Param("uri"))
+	if errUpload(c, err) {
+		return
+	}
+	log.Println("Page update requested:", uri)
+	req, ok := bindPage(c)
+	if !ok {
+		return
+	}
+	if req.Content == nil {
+		abortFields(c, map[string]string{"content": "is required"})
+		return
+	}
+	f, exists, err := findPage(uri)
+	if errISE(c, err) || !checkIfMatch(c, f, exists) {
+		return
+	}
+	storePage(c, uri, req, exists)
+}
+
+// handlePagePatch handles requests to change the content, the visibility or
+// the status of an existing page; the If-Match header is checked like by
+// handlePageUpdate
+func handlePagePatch(c *gin.Context) {
+	uri, err := pageURI(c.Param("uri"))
+	if errUpload(c, err) {
+		return
+	}
+	log.Println("Page patch requested:", uri)
+	req, ok := bindPage(c)
+	if !ok {
+		return
+	}
+	if req.Content == nil && req.Visibility == "" && req.Status == "" {
+		abortFields(c, map[string]string{"content": "is required unless the visibility or status is changed"})
+		return
+	}
+	f, exists, err := findPage(uri)
+	if errISE(c, err) {
+		return
+	}
+	if !exists {
+		errNotFound(c, content.ErrNotFound)
+		return
+	}
+	if !checkIfMatch(c, f, exists) || !allowURI(c, f.URI) ||
+		(req.Content == nil && req.Status == content.StatusPublished && !allowPublish(c, f.URI)) {
+		return
+	}
+	if req.Content != nil {
+		storePage(c, uri, req, true)
+		return
+	}
+	if req.Visibility != "" {
+		err = content.SetVisibility(f.URI, req.Visibility)
+		if errISE(c, err) {
+			return
+		}
+		f.Visibility = req.Visibility
+	}
+	if req.Status != "" {
+		err = content.SetStatus(f.URI, req.Status)
+		if errISE(c, err) {
+			return
+		}
+		f.Status = req.Status
+	}
+	contentChanged(f.URI)
+	c.JSON(http.StatusOK, pageResponse{File: f, URL: f.Link()})
+}
+
+// handlePageDelete handles requests to delete a page; the If-Match header is
+// checked like by handleDelete
+func handlePageDelete(c *gin.Context) {
+	uri, err := pageURI(c.Param("uri"))
+	if errUpload(c, err) {
+		return
+	}
+	log.Println("Page deletion requested:", uri)
+	f, exists, err := findPage(uri)
+	if errISE(c, err) {
+		return
+	}
+	if !exists {
+		errNotFound(c, content.ErrNotFound)
+		return
+	}
+	deleteFile(c, f)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/duevil/Go_Portfolio/content"
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/gin-gonic/gin"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// handlePaste handles requests for uploading pasted images; the request body
+// contains the raw image bytes, which are stored under an auto-generated uri
+// inside PASTE_DIR; responds with the uri and the markdown snippet to insert;
+// the optional query parameter 'alt' is used as alt text of the snippet
+//
+// Like handleUpload, the auth middleware is called manually after the request
+// body has been read
+func handlePaste(c *gin.Context, auth gin.HandlerFunc) {
+	log.Println("Paste requested")
+	limit := int64(getEnvIntOrElse("MAX_PASTE_SIZE", 15<<20))
+	data, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, limit))
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "pasted image is too large"})
+		return
+	}
+	if errStatus(c, http.StatusBadRequest, err) {
+		return
+	}
+
+	// check credentials
+	auth(c)
+	if c.IsAborted() {
+		return
+	}
+
+	// check that the data is an image
+	mt := mimetype.Detect(data)
+	ext := extensionByType(mt.String())
+	if !strings.HasPrefix(mt.String(), "image/") || ext == "" {
+		c.AbortWithStatusJSON(http.StatusUnsupportedMediaType, gin.H{"error": "pasted data is not an image: " + mt.String()})
+		return
+	}
+
+	// store image
+	id := make([]byte, 4)
+	_, err = rand.Read(id)
+	if errISE(c, err) {
+		return
+	}
+	now := time.Now()
+	uri := path.Join("/", getEnvOrElse("PASTE_DIR", "pasted"), now.Format("20060102-150405")+"-"+hex.EncodeToString(id)+ext)
+	f := content.NewAsset(uri, mt.String(), int64(len(data)), now)
+	u := newUploader(c)
+	err = u.store(f, bytes.NewReader(data))
+	if errUpload(c, err) || errISE(c, err) {
+		return
+	}
+
+	// finish; links are relative to the content root
+	location := u.location(uri)
+	c.Header("Location", location)
+	c.JSON(u.status(), gin.H{
+		"uri":      uri,
+		"url":      location,
+		"markdown": "![" + escapeMarkdown(c.Query("alt")) + "](" + strings.TrimPrefix(uri, "/") + ")",
+	})
+}
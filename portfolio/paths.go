@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"github.com/duevil/Go_Portfolio/content"
+	"net/http"
+	"path"
+	"strings"
+	"unicode"
+)
+
+// reservedPaths are the uri prefixes uploaded files must not be stored at;
+// they are used by routes or for files which are not uploaded directly
+var reservedPaths = []string{"/admin", "/static", content.QuarantineRoot, content.StagingRoot, content.PreviousRoot}
+
+// sanitizePath returns the uri for the given relative path of an uploaded
+// file, like the name of a zip entry; backslashes are treated as separators.
+// Returns an uploadError with status 400 if the path is absolute or escapes
+// the content root; the uri is checked as described by checkURI and returned
+// along with its error.
+func sanitizePath(p string) (string, error) {
+	p = strings.ReplaceAll(p, "\\", "/")
+	if strings.HasPrefix(p, "/") || (len(p) > 1 && p[1] == ':') {
+		return "", &uploadError{status: http.StatusBadRequest, msg: "absolute path not allowed: " + p}
+	}
+	clean := path.Clean(p)
+	if clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", &uploadError{status: http.StatusBadRequest, msg: "path outside of the content root: " + p}
+	}
+	uri := "/" + clean
+	return uri, checkURI(uri)
+}
+
+// pathConflict is an uploaded file whose uri collides with a reserved path
+type pathConflict struct {
+	File  string `json:"file"`
+	URI   string `json:"uri"`
+	Route string `json:"route"`
+}
+
+// reservedPath returns the reserved path the given uri is below; returns an
+// empty string if the uri is not reserved
+func reservedPath(uri string) string {
+	lower := strings.ToLower(uri)
+	for _, r := range reservedPaths {
+		if lower == r || strings.HasPrefix(lower, r+"/") {
+			return r
+		}
+	}
+	return ""
+}
+
+// conflictError returns an uploadError with status 409 listing the given
+// conflicts
+func conflictError(conflicts []pathConflict) error {
+	e := &uploadError{status: http.StatusConflict, code: "reserved_path",
+		msg: fmt.Sprintf("%d files have reserved paths", len(conflicts)), details: map[string]any{"conflicts": conflicts}}
+	if len(conflicts) == 1 {
+		e.msg = "reserved path: " + conflicts[0].URI
+		e.file = conflicts[0].File
+	}
+	return e
+}
+
+// checkURI returns an uploadError with status 400 if the given uri is not an
+// absolute, clean path without control characters and with status 409 if it
+// is below a reserved path
+func checkURI(uri string) error {
+	if !strings.HasPrefix(uri, "/") || path.Clean(uri) != uri || uri == "/" {
+		return &uploadError{status: http.StatusBadRequest, msg: "invalid path: " + uri}
+	}
+	if strings.IndexFunc(uri, unicode.IsControl) >= 0 {
+		return &uploadError{status: http.StatusBadRequest, msg: "path contains control characters: " + uri}
+	}
+	if r := reservedPath(uri); r != "" {
+		return conflictError([]pathConflict{{File: uri, URI: uri, Route: r}})
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"auth"
+	"errors"
+	"github.com/duevil/Go_Portfolio/content"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+)
+
+// previewRequest is the request body for creating a preview link; the
+// lifetime is a duration like '72h' and defaults to PREVIEW_TTL, reusable
+// links may be opened until they expire instead of only once
+type previewRequest struct {
+	URI      string `json:"uri" binding:"required"`
+	TTL      string `json:"ttl"`
+	Reusable bool   `json:"reusable"`
+}
+
+// handlePreviews handles requests to list preview links; admins see the links
+// of all users, other users only their own links
+func handlePreviews(c *gin.Context) {
+	log.Println("Previews requested")
+	previews, err := auth.ListPreviews(sessionUserFilter(c))
+	if errISE(c, err) {
+		return
+	}
+	c.JSON(http.StatusOK, previews)
+}
+
+// handlePreviewCreate handles requests to create a preview link for a file,
+// which may be unpublished, like a staged or quarantined file; the link
+// expires after the requested lifetime, which is limited by PREVIEW_MAX_TTL.
+// The response contains the link, which is not shown again.
+func handlePreviewCreate(c *gin.Context) {
+	log.Println("Preview creation requested")
+	var req previewRequest
+	err := c.ShouldBindJSON(&req)
+	if errBind(c, err) {
+		return
+	}
+	ttl := getEnvDurationOrElse("PREVIEW_TTL", 72*time.Hour)
+	if req.TTL != "" {
+		ttl, err = time.ParseDuration(req.TTL)
+		if err == nil && ttl <= 0 {
+			err = errors.New("ttl must be positive")
+		}
+		if errStatus(c, http.StatusBadRequest, err) {
+			return
+		}
+	}
+	ttl = min(ttl, getEnvDurationOrElse("PREVIEW_MAX_TTL", 30*24*time.Hour))
+	f, err := content.GetFromDB(req.URI)
+	if errNotFound(c, err) || errISE(c, err) {
+		return
+	}
+	p, secret, err := auth.CreatePreview(f.URI, c.GetString("user"), ttl, !req.Reusable)
+	if errISE(c, err) {
+		return
+	}
+	link := siteURL(c) + path.Join("/", content.URIRoot, f.URI) + "?preview=" + url.QueryEscape(secret)
+	c.JSON(http.StatusCreated, gin.H{"preview": p, "url": link})
+}
+
+// handlePreviewDelete handles requests to revoke a preview link; admins may
+// revoke the links of all users, other users only their own links
+func handlePreviewDelete(c *gin.Context) {
+	id := c.Param("id")
+	log.Println("Preview revocation requested:", id)
+	err := auth.RevokePreview(id, sessionUserFilter(c))
+	if errors.Is(err, auth.ErrNoPreview) {
+		errStatus(c, http.StatusNotFound, err)
+		return
+	}
+	if errISE(c, err) {
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// servePreview serves the given file, which may be unpublished, if the given
+// preview token grants access to it; responds with status 404 otherwise, so
+// invalid tokens do not reveal whether a file exists
+func servePreview(c *gin.Context, f content.MongoFile, secret string) {
+	_, err := auth.UsePreview(secret, f.URI)
+	if errors.Is(err, auth.ErrNoPreview) {
+		errNotFound(c, content.ErrNotFound)
+		return
+	}
+	if errISE(c, err) {
+		return
+	}
+	log.Println("Serving preview:", f.URI)
+	// the token is part of the URL, so it must neither be cached, indexed nor
+	// leaked to linked sites
+	noCDNCache(c)
+	c.Header("X-Robots-Tag", "noindex")
+	c.Header("Referrer-Policy", "no-referrer")
+	serveFile(c, f, nil)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"auth"
+	"github.com/duevil/Go_Portfolio/content"
+	"github.com/gin-gonic/gin"
+	"io"
+	"log"
+	"net/http"
+	"path"
+)
+
+// uploader stores uploaded files on behalf of the authenticated user; if
+// QUARANTINE_UPLOADS is set, uploads of users without admin permission are
+// quarantined until an admin approves them. Uploads into a staging content set
+// are not quarantined, as they are only published when an admin promotes the
+// set. The visibility and the status are set for all uploaded files unless
+// empty; uploads to uris the account is not permitted to change are rejected,
+// as are uploads published by users who may not publish due to the review
+// workflow, whose pages are submitted for review instead.
+type uploader struct {
+	user       string
+	account    auth.User
+	quarantine bool
+	review     bool
+	staging    string
+	visibility string
+	pageStatus string
+}
+
+// newUploader returns the uploader for the user authenticated in the given
+// context
+func newUploader(c *gin.Context) uploader {
+	role, _ := c.Get("role")
+	r, _ := role.(auth.Role)
+	account, _ := c.Get("account")
+	a, _ := account.(auth.User)
+	return uploader{
+		user:       c.GetString("user"),
+		account:    a,
+		quarantine: getEnvOrElse("QUARANTINE_UPLOADS", "false") == "true" && !r.Can(auth.PermAdmin),
+		review:     reviewWorkflow() && !r.Can(auth.PermAdmin),
+		staging:    c.Query("staging"),
+		visibility: c.Query("visibility"),
+		pageStatus: c.Query("status"),
+	}
+}
+
+// store stores the given file read from the given reader; staged files are
+// stored below content.StagingRoot and quarantined files below
+// content.QuarantineRoot
+func (u uploader) store(f content.MongoFile, r io.Reader) error {
+	f, err := u.prepare(f)
+	if err != nil {
+		return err
+	}
+	err = storeUpload(f, r)
+	if err == nil && f.Public() {
+		contentChanged(f.URI)
+	}
+	return err
+}
+
+// prepare checks whether the uploader may store the given file and returns it
+// with the fields set by the uploader and the uri it is stored at
+func (u uploader) prepare(f content.MongoFile) (content.MongoFile, error) {
+	err := checkURI(f.URI)
+	if err != nil {
+		return f, err
+	}
+	if !u.account.AllowsURI(f.URI) {
+		return f, &uploadError{status: http.StatusForbidden, code: "uri_forbidden", file: f.URI,
+			msg: "uploading to this uri is not permitted: " + f.URI}
+	}
+	f.UploadedBy = u.user
+	if !content.ValidVisibility(u.visibility) {
+		return f, &uploadError{status: http.StatusBadRequest, msg: content.ErrInvalidVisibility.Error()}
+	}
+	if u.visibility != "" {
+		f.Visibility = u.visibility
+	}
+	if !content.ValidStatus(u.pageStatus) {
+		return f, &uploadError{status: http.StatusBadRequest, msg: content.ErrInvalidStatus.Error()}
+	}
+	if u.review && u.pageStatus == content.StatusPublished {
+		return f, &uploadError{status: http.StatusForbidden, code: "approval_required", file: f.URI,
+			msg: "publishing requires the approval of an admin: " + f.URI}
+	}
+	if u.pageStatus != "" {
+		f.Status = u.pageStatus
+	} else if u.review && f.IsMD && u.staging == "" {
+		// pages of editors are submitted for review unless another status is
+		// given, as they would be published by default or keep the status of
+		// the replaced page; staged pages are published by promoting the set
+		f.Status = content.StatusReview
+	}
+	if u.staging != "" {
+		if !content.ValidStagingName(u.staging) {
+			return f, &uploadError{status: http.StatusBadRequest, msg: "invalid staging name: " + u.staging}
+		}
+		log.Println("Staging upload:", u.staging, f.URI)
+		f.Staging = u.staging
+	} else if u.quarantine {
+		log.Println("Quarantining upload:", f.URI)
+		f.Quarantined = true
+	}
+	f.URI = u.storedURI(f.URI)
+	return f, nil
+}
+
+// storedURI returns the uri the file with the given uri is stored at; staged
+// and quarantined files are stored below their roots
+func (u uploader) storedURI(uri string) string {
+	if u.staging != "" {
+		return content.StagingURI(u.staging, uri)
+	}
+	if u.quarantine {
+		return content.QuarantineURI(uri)
+	}
+	return uri
+}
+
+// location returns the path the file with the given uri is served at once it
+// is uploaded; staged files are served as preview
+func (u uploader) location(uri string) string {
+	if u.staging != "" {
+		return path.Join("/staging", u.staging, uri)
+	}
+	return path.Join("/", content.URIRoot, uri)
+}
+
+// status returns the response status for a successful upload
+func (u uploader) status() int {
+	if u.quarantine && u.staging == "" {
+		return http.StatusAccepted
+	}
+	return http.StatusCreated
+}
+
+// handleQuarantine handles requests to list all quarantined uploads
+func handleQuarantine(c *gin.Context) {
+	log.Println("Quarantine requested")
+	files, err := content.ListQuarantined()
+	if errISE(c, err) {
+		return
+	}
+	c.JSON(http.StatusOK, files)
+}
+
+// handleQuarantineApprove handles requests to approve a quarantined upload; the
+// upload is published at its target uri and removed from the quarantine
+func handleQuarantineApprove(c *gin.Context) {
+	uri := content.QuarantineURI(c.Param("uri"))
+	log.Println("Quarantine approval requested:", uri)
+	f, err := content.GetFromDB(uri)
+	if errNotFound(c, err) || errISE(c, err) {
+		return
+	}
+	if !f.Quarantined {
+		errNotFound(c, content.ErrNotFound)
+		return
+	}
+	rc, err := f.Open()
+	if errISE(c, err) {
+		return
+	}
+	defer cls(rc)
+	target := content.MongoFile{
+		URI:        content.QuarantineTarget(f.URI),
+		Filesize:   f.Filesize,
+		LastMod:    f.LastMod,
+		Mime:       f.Mime,
+		IsMD:       f.IsMD,
+		UploadedBy: f.UploadedBy,
+		Visibility: f.Visibility,
+	}
+	err = storeUpload(target, rc)
+	if errUpload(c, err) || errISE(c, err) {
+		return
+	}
+	contentChanged(target.URI)
+	err = f.Delete()
+	if errISE(c, err) {
+		return
+	}
+	c.JSON(http.StatusOK, target)
+}
+
+// handleQuarantineReject handles requests to reject a quarantined upload; the
+// upload is deleted
+func handleQuarantineReject(c *gin.Context) {
+	uri := content.QuarantineURI(c.Param("uri"))
+	log.Println("Quarantine rejection requested:", uri)
+	f, err := content.GetFromDB(uri)
+	if errNotFound(c, err) || errISE(c, err) {
+		return
+	}
+	if !f.Quarantined {
+		errNotFound(c, content.ErrNotFound)
+		return
+	}
+	err = f.Delete()
+	if errISE(c, err) {
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimiter limits the requests per client IP using a token bucket per IP
+type rateLimiter struct {
+	name string
+	// rate is the number of tokens added per second
+	rate  float64
+	burst float64
+	mu    sync.Mutex
+	// buckets maps client IPs to their buckets
+	buckets map[string]*rateBucket
+	swept   time.Time
+}
+
+// rateBucket is the token bucket of a client IP
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimit returns a middleware limiting the requests per client IP to the
+// number of requests per minute set by RATE_LIMIT_<NAME> with a burst set by
+// RATE_LIMIT_<NAME>_BURST; the given defaults are used if the variables are
+// not set, a rate of zero disables the limit. Limited requests are answered
+// with status 429.
+func rateLimit(name string, perMinute int, burst int) gin.HandlerFunc {
+	key := "RATE_LIMIT_" + strings.ToUpper(name)
+	perMinute = getEnvIntOrElse(key, perMinute)
+	burst = getEnvIntOrElse(key+"_BURST", burst)
+	if perMinute <= 0 {
+		log.Println("Rate limit disabled:", name)
+		return func(c *gin.Context) {}
+	}
+	l := &rateLimiter{
+		name:    name,
+		rate:    float64(perMinute) / 60,
+		burst:   math.Max(float64(burst), 1),
+		buckets: map[string]*rateBucket{},
+		swept:   time.Now(),
+	}
+	return l.handle
+}
+
+// handle aborts the request with status 429 if the client's bucket is empty
+func (l *rateLimiter) handle(c *gin.Context) {
+	ip := c.ClientIP()
+	ok, wait := l.take(ip, time.Now())
+	if ok {
+		return
+	}
+	log.Println("[Err] Rate limit", l.name, "exceeded by:", ip)
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "too many requests"})
+}
+
+// take takes a token from the bucket of the given IP; returns false and the
+// duration until the next token is available if the bucket is empty
+func (l *rateLimiter) take(ip string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+	b, ok := l.buckets[ip]
+	if !ok {
+		b = &rateBucket{tokens: l.burst, last: now}
+		l.buckets[ip] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep removes the buckets that are full again at most once a minute, so the
+// buckets of past clients do not accumulate
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < time.Minute {
+		return
+	}
+	l.swept = now
+	for ip, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, ip)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"github.com/duevil/Go_Portfolio/content"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// redirectRequest is the request body for setting the redirect of a path
+type redirectRequest struct {
+	From   string `json:"from" binding:"required,max=2048"`
+	To     string `json:"to" binding:"required,max=2048"`
+	Status int    `json:"status" binding:"omitempty,oneof=301 302 307 308"`
+}
+
+// applyRedirect redirects the request to the target of the redirect of the
+// requested path keeping the query; returns whether the request was
+// redirected. Only GET and HEAD requests outside the admin routes are
+// redirected.
+func applyRedirect(c *gin.Context) bool {
+	p := c.Request.URL.Path
+	if (c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead) ||
+		p == "/admin" || strings.HasPrefix(p, "/admin/") || strings.HasPrefix(p, "/api/") {
+		return false
+	}
+	var r content.Redirect
+	err := dbBreaker.do(func() (err error) {
+		r, err = content.GetRedirect(p)
+		return err
+	})
+	if err != nil {
+		if !errors.Is(content.ErrNotFound, err) {
+			log.Println("[Err] Getting redirect:", p, err)
+		}
+		return false
+	}
+	to := r.To
+	if q := c.Request.URL.RawQuery; q != "" && !strings.Contains(to, "?") {
+		to += "?" + q
+	}
+	log.Println("Redirecting", p, "to:", to)
+	c.Redirect(r.Status, to)
+	c.Abort()
+	return true
+}
+
+// purgeRedirect purges the responses for the given path from the CDN, so
+// changed redirects take effect
+func purgeRedirect(from string) {
+	if uri, ok := strings.CutPrefix(from, "/"+content.URIRoot); ok {
+		purgeCDN(uri)
+	}
+}
+
+// handleRedirects handles requests for all redirects sorted by their path
+func handleRedirects(c *gin.Context) {
+	log.Println("Redirects requested")
+	redirects, err := content.ListRedirects()
+	if errISE(c, err) {
+		return
+	}
+	c.JSON(http.StatusOK, redirects)
+}
+
+// handleRedirectUpdate handles requests to set the redirect of a path; the
+// request body contains the path, the target and the status, which defaults
+// to 301
+func handleRedirectUpdate(c *gin.Context) {
+	var req redirectRequest
+	err := c.ShouldBindJSON(&req)
+	if errBind(c, err) {
+		return
+	}
+	log.Println("Redirect update requested:", req.From)
+	r, err := content.SetRedirect(content.Redirect{From: req.From, To: req.To, Status: req.Status})
+	if errors.Is(err, content.ErrInvalidRedirect) {
+		errStatus(c, http.StatusBadRequest, err)
+		return
+	}
+	if errISE(c, err) {
+		return
+	}
+	purgeRedirect(r.From)
+	c.JSON(http.StatusOK, r)
+}
+
+// handleRedirectDelete handles requests to delete the redirect with the given
+// id
+func handleRedirectDelete(c *gin.Context) {
+	id := c.Param("id")
+	log.Println("Redirect deletion requested:", id)
+	r, err := content.DeleteRedirect(id)
+	if errNotFound(c, err) || errISE(c, err) {
+		return
+	}
+	purgeRedirect(r.From)
+	c.Status(http.StatusNoContent)
+}
+
+// redirectsHook is an export hook writing the redirects into the file
+// '_redirects' of the export, which static hosts like Netlify and Cloudflare
+// Pages apply; every line contains the path, the target and the status. As
+// pages in the default language are exported without language prefix, paths
+// with the prefix of the default language are redirected to them.
+type redirectsHook struct{}
+
+func (redirectsHook) Name() string { return "redirects" }
+
+func (redirectsHook) Run(dir string, _ string, _ []content.MongoFile) error {
+	redirects, err := content.ListRedirects()
+	if err != nil {
+		return err
+	}
+	prefixed := len(content.Languages()) > 1
+	if len(redirects) == 0 && !prefixed {
+		return nil
+	}
+	b := strings.Builder{}
+	for _, r := range redirects {
+		// paths with spaces cannot be written to the file
+		if strings.ContainsAny(r.From+r.To, " \t\n") {
+			log.Println("[Err] Skipping redirect with spaces in export:", r.From)
+			continue
+		}
+		b.WriteString(r.From + " " + r.To + " " + strconv.Itoa(r.Status) + "\n")
+	}
+	// hosts apply the first matching line, so the redirects set take precedence
+	if prefixed {
+		b.WriteString("/" + content.DefaultLanguage() + "/* /:splat 301\n")
+	}
+	return os.WriteFile(filepath.Join(dir, "_redirects"), []byte(b.String()), 0o644)
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/duevil/Go_Portfolio/content"
+	"github.com/gin-gonic/gin"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// replicaURL is the base URL of the mirror instance content changes are pushed
+// to set by REPLICA_URL; replication is disabled if not set
+var replicaURL = strings.TrimRight(getEnvOrElse("REPLICA_URL", ""), "/")
+
+// replicaClient is the client content changes are pushed with
+var replicaClient = &http.Client{Timeout: 5 * time.Minute}
+
+// replicaQueue holds the uris of changed files until they are pushed
+var replicaQueue = make(chan string, getEnvIntOrElse("REPLICA_QUEUE_SIZE", 1000))
+
+// startReplication starts pushing changed files to the mirror instance if
+// REPLICA_URL is set; the mirror is authorized with the API token set by
+// REPLICA_TOKEN, which needs write permission and, if the mirror uses the
+// review workflow, must be allowed to publish
+func startReplication() {
+	if replicaURL == "" {
+		return
+	}
+	log.Println("Replicating content to:", replicaURL)
+	go func() {
+		for uri := range replicaQueue {
+			pushReplica(uri)
+		}
+	}()
+}
+
+// replicate queues the files with the given uris to be pushed to the mirror
+// instance; a file is uploaded with its status and schedule if it is public,
+// else it is deleted on the mirror. If the queue is full, the change is dropped and must be pushed by a
+// full resync.
+func replicate(uris ...string) {
+	if replicaURL == "" {
+		return
+	}
+	for _, uri := range uris {
+		select {
+		case replicaQueue <- uri:
+		default:
+			log.Println("[Err] Replication queue is full, dropping change:", uri)
+		}
+	}
+}
+
+// pushReplica pushes the file with the given uri to the mirror instance,
+// retrying failed pushes with increasing delays
+func pushReplica(uri string) {
+	delay := time.Second
+	for attempt := 1; ; attempt++ {
+		err := syncReplica(uri)
+		if err == nil {
+			return
+		}
+		if attempt == 5 {
+			log.Println("[Err] Replicating file failed, giving up:", uri, err)
+			return
+		}
+		log.Println("[Err] Replicating file failed, retrying:", uri, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// syncReplica uploads the file with the given uri to the mirror instance if it
+// is public or deletes it on the mirror otherwise; the status and the schedule
+// are pushed along, so drafts are not published on the mirror and pages are
+// archived there as well
+func syncReplica(uri string) error {
+	escaped := (&url.URL{Path: uri}).EscapedPath()
+	f, err := content.GetFromDB(uri)
+	if errors.Is(content.ErrNotFound, err) || (err == nil && !f.Public()) {
+		log.Println("Deleting file on replica:", uri)
+		return replicaRequest(http.MethodDelete, replicaURL+"/admin"+escaped, nil, nil)
+	}
+	if err != nil {
+		return err
+	}
+	log.Println("Pushing file to replica:", uri)
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer cls(rc)
+	return replicaRequest(http.MethodPut, replicaURL+"/admin/replica"+escaped, rc, func(req *http.Request) {
+		req.ContentLength = f.Filesize
+		req.Header.Set("Content-Type", f.Mime)
+		req.Header.Set("Last-Modified", f.LastMod.UTC().Format(http.TimeFormat))
+		req.Header.Set("X-Visibility", f.Visibility)
+		// files without status are published, while the mirror may store new
+		// pages as drafts
+		status := f.Status
+		if status == "" {
+			status = content.StatusPublished
+		}
+		req.Header.Set("X-Status", status)
+		if !f.PublishAt.IsZero() {
+			req.Header.Set("X-Publish-At", f.PublishAt.UTC().Format(time.RFC3339))
+		}
+		if !f.UnpublishAt.IsZero() {
+			req.Header.Set("X-Unpublish-At", f.UnpublishAt.UTC().Format(time.RFC3339))
+		}
+	})
+}
+
+// replicaRequest sends a request with the given method and body to the given
+// URL of the mirror instance; the given function may modify the request
+func replicaRequest(method string, u string, body io.Reader, modify func(req *http.Request)) error {
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return err
+	}
+	if token := getEnvOrElse("REPLICA_TOKEN", ""); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if modify != nil {
+		modify(req)
+	}
+	res, err := replicaClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer cls(res.Body)
+	if res.StatusCode >= 300 && !(method == http.MethodDelete && res.StatusCode == http.StatusNotFound) {
+		return fmt.Errorf("request to %s failed: %s", u, res.Status)
+	}
+	return nil
+}
+
+// handleReplicate handles requests to push all public files to the mirror
+// instance, which brings a new or diverged mirror in sync
+func handleReplicate(c *gin.Context) {
+	log.Println("Replication requested")
+	if replicaURL == "" {
+		errStatus(c, http.StatusConflict, errors.New("REPLICA_URL is not set"))
+		return
+	}
+	files, err := content.ListAll()
+	if errISE(c, err) {
+		return
+	}
+	for _, f := range files {
+		replicate(f.URI)
+	}
+	c.JSON(http.StatusAccepted, gin.H{"queued": len(files)})
+}
+
+// handleReplicaPut handles requests of a primary instance to store a file
+// pushed to this instance as its mirror; the request body is the file's
+// content, its type and modification time are read from the Content-Type and
+// Last-Modified headers, its visibility and status from the X-Visibility and
+// X-Status headers and its schedule from the X-Publish-At and X-Unpublish-At
+// headers, which are missing for files without schedule
+//
+// Like handleUpload, the auth middleware is called manually after the request
+// body has been saved
+func handleReplicaPut(c *gin.Context, auth gin.HandlerFunc) {
+	uri := c.Param("uri")
+	log.Println("Replica file pushed:", uri)
+	err := checkURI(uri)
+	if err == nil {
+		err = checkUploadSize(uri, c.Request.ContentLength)
+	}
+	if errUpload(c, err) {
+		return
+	}
+	dir, err := makeScratchDir("replica", c.Request.ContentLength)
+	if errStorage(c, err) || errISE(c, err) {
+		return
+	}
+	defer func(path string) { _ = os.RemoveAll(path) }(dir)
+	tmp, err := os.Create(path.Join(dir, "file"))
+	if errISE(c, err) {
+		return
+	}
+	defer cls(tmp)
+	size, err := io.Copy(tmp, http.MaxBytesReader(c.Writer, c.Request.Body, maxUploadSize))
+	if errStatus(c, http.StatusBadRequest, err) {
+		return
+	}
+	_, err = tmp.Seek(0, io.SeekStart)
+	if errISE(c, err) {
+		return
+	}
+
+	// check credentials
+	auth(c)
+	if c.IsAborted() {
+		return
+	}
+
+	mimeType := c.GetHeader("Content-Type")
+	if _, _, err := mime.ParseMediaType(mimeType); err != nil {
+		mimeType = "application/octet-stream"
+	}
+	lastMod, err := http.ParseTime(c.GetHeader("Last-Modified"))
+	if err != nil {
+		lastMod = time.Now()
+	}
+	f := content.MongoFile{
+		URI:      uri,
+		Filesize: size,
+		LastMod:  lastMod,
+		Mime:     mimeType,
+		IsMD:     path.Ext(uri) == ".md",
+	}
+	publishAt, unpublishAt, err := replicaSchedule(c)
+	if errStatus(c, http.StatusBadRequest, err) {
+		return
+	}
+	u := newUploader(c)
+	u.visibility = c.GetHeader("X-Visibility")
+	u.pageStatus = c.GetHeader("X-Status")
+	err = u.store(f, tmp)
+	if errUpload(c, err) || errISE(c, err) {
+		return
+	}
+	// the schedule is set even if missing, so a removed schedule is removed on
+	// the mirror as well
+	err = content.SetSchedule(u.storedURI(uri), publishAt, unpublishAt)
+	if errors.Is(err, content.ErrInvalidSchedule) {
+		errStatus(c, http.StatusBadRequest, err)
+		return
+	}
+	if errISE(c, err) {
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// replicaSchedule returns the times the pushed file is published and
+// unpublished at, which are given by the X-Publish-At and X-Unpublish-At
+// headers; missing headers are returned as zero times
+func replicaSchedule(c *gin.Context) (time.Time, time.Time, error) {
+	var times [2]time.Time
+	for i, h := range []string{"X-Publish-At", "X-Unpublish-At"} {
+		v := c.GetHeader(h)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid %s header: %w", h, err)
+		}
+		times[i] = t
+	}
+	return times[0], times[1], nil
+}
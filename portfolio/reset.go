@@ -0,0 +1,95 @@
+package main
+
+import (
+	"auth"
+	"errors"
+	"github.com/duevil/Go_Portfolio/content"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// resetPage is the page rendered by the 'reset' template; if the token is set,
+// the form for setting a new password is shown, else the form for requesting a
+// reset link
+type resetPage struct {
+	content.Page
+	Token   string
+	Message string
+	Error   string
+}
+
+// handleResetForm handles requests for the password reset page; the optional
+// query parameter 'token' is the token from the emailed reset link
+func handleResetForm(c *gin.Context) {
+	log.Println("Password reset form requested")
+	c.HTML(http.StatusOK, "reset", resetPage{Page: newPage("Passwort zurücksetzen", "admin/password-reset"), Token: c.Query("token")})
+}
+
+// handleResetRequest handles requests for password reset links; the form value
+// 'user' is the name or email of the user; the link is sent to the user's
+// email; the response does not reveal whether the user exists
+func handleResetRequest(c *gin.Context) {
+	log.Println("Password reset requested")
+	page := resetPage{
+		Page:    newPage("Passwort zurücksetzen", "admin/password-reset"),
+		Message: "Falls das Konto existiert und eine E-Mail-Adresse hinterlegt ist, wurde ein Link zum Zurücksetzen versendet.",
+	}
+	base := getEnvOrElse("SITE_URL", "")
+	if !mailEnabled() || base == "" {
+		// links must not be derived from the request's host header
+		log.Println("[Err] Password reset requires SMTP_HOST and SITE_URL")
+		page.Message, page.Error = "", "Das Zurücksetzen per E-Mail ist nicht eingerichtet."
+		c.HTML(http.StatusServiceUnavailable, "reset", page)
+		return
+	}
+	name := c.PostForm("user")
+	u, err := auth.GetUser(name)
+	if errors.Is(err, auth.ErrUserNotFound) {
+		u, err = auth.GetUserByEmail(name)
+	}
+	if err == nil && u.Email != "" {
+		ttl := getEnvDurationOrElse("PASSWORD_RESET_TTL", time.Hour)
+		link := base + "/admin/password-reset?token=" + url.QueryEscape(auth.ResetToken(u, ttl))
+		err = sendMail(u.Email, "Passwort zurücksetzen",
+			"Hallo "+u.Name+",\n\nüber folgenden Link kann das Passwort für die Admin-Seite zurückgesetzt werden:\n\n"+
+				link+"\n\nDer Link ist "+ttl.String()+" gültig. Falls du das Zurücksetzen nicht angefordert hast, "+
+				"kannst du diese E-Mail ignorieren.\n")
+	}
+	if err != nil && !errors.Is(err, auth.ErrUserNotFound) {
+		log.Println("[Err] Sending password reset:", err)
+	}
+	c.HTML(http.StatusOK, "reset", page)
+}
+
+// handleResetConfirm handles requests to set a new password; the form values
+// are the reset token and the new password; on success all sessions of the
+// user are deleted and the user is redirected to the login page
+func handleResetConfirm(c *gin.Context) {
+	log.Println("Password reset confirmation requested")
+	token := c.PostForm("token")
+	page := resetPage{Page: newPage("Passwort zurücksetzen", "admin/password-reset"), Token: token}
+	u, err := auth.VerifyResetToken(token)
+	if err == nil {
+		err = auth.SetPassword(u.Name, c.PostForm("password"))
+	}
+	switch {
+	case errors.Is(err, auth.ErrInvalidResetToken):
+		page.Token, page.Error = "", "Der Link ist ungültig oder abgelaufen."
+		c.HTML(http.StatusBadRequest, "reset", page)
+		return
+	case errors.Is(err, auth.ErrInvalidUser):
+		page.Error = "Das Passwort muss zwischen 8 und 72 Zeichen lang sein."
+		c.HTML(http.StatusBadRequest, "reset", page)
+		return
+	case errors.Is(err, auth.ErrManagedUser):
+		page.Token, page.Error = "", "Das Passwort dieses Kontos wird über die Kontendatei verwaltet."
+		c.HTML(http.StatusConflict, "reset", page)
+		return
+	case errISE(c, err):
+		return
+	}
+	c.Redirect(http.StatusSeeOther, "/admin/login?reset=1")
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"auth"
+	"github.com/duevil/Go_Portfolio/content"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"time"
+)
+
+// reviewWorkflow returns whether the review workflow is enabled by
+// REVIEW_WORKFLOW; if so, editors submit pages for review by setting their
+// status to 'review' and only admins may publish them
+func reviewWorkflow() bool {
+	return getEnvOrElse("REVIEW_WORKFLOW", "false") == "true"
+}
+
+// mayPublish returns whether the user authenticated in the given context may
+// publish files, which only admins may do if the review workflow is enabled
+func mayPublish(c *gin.Context) bool {
+	role, _ := c.Get("role")
+	r, _ := role.(auth.Role)
+	return !reviewWorkflow() || r.Can(auth.PermAdmin)
+}
+
+// allowPublish checks whether the user authenticated in the given context may
+// publish the file with the given uri, see mayPublish; if not, the request is
+// aborted with status 403
+func allowPublish(c *gin.Context, uri string) bool {
+	if mayPublish(c) {
+		return true
+	}
+	log.Println("[Err] Publishing", uri, "denied for user:", c.GetString("user"))
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "publishing requires the approval of an admin", "uri": uri})
+	return false
+}
+
+// reviewComment is a comment of a review request; comments with a line refer
+// to the line of the page's markdown
+type reviewComment struct {
+	Line int    `json:"line" binding:"min=0"`
+	Text string `json:"text" binding:"required,max=4096"`
+}
+
+// reviewRequest is the request body for rejecting a page submitted for review
+// or commenting on it
+type reviewRequest struct {
+	Comments []reviewComment `json:"comments" binding:"required,min=1,dive"`
+}
+
+// comments returns the comments of the request written by the user
+// authenticated in the given context
+func (r reviewRequest) comments(c *gin.Context) []content.ReviewComment {
+	now := time.Now()
+	comments := make([]content.ReviewComment, 0, len(r.Comments))
+	for _, rc := range r.Comments {
+		comments = append(comments, content.ReviewComment{Author: c.GetString("user"), Line: rc.Line, Text: rc.Text,
+			Created: now})
+	}
+	return comments
+}
+
+// reviewFile returns the file in review with the given uri; if there is no
+// such file, the request is aborted with status 404
+func reviewFile(c *gin.Context, uri string) (content.MongoFile, bool) {
+	f, err := content.GetFromDB(uri)
+	if err == nil && (!f.Public() || f.Status != content.StatusReview) {
+		err = content.ErrNotFound
+	}
+	if errNotFound(c, err) || errISE(c, err) {
+		return f, false
+	}
+	return f, true
+}
+
+// handleReview handles requests for the pages submitted for review including
+// their review comments, oldest first
+func handleReview(c *gin.Context) {
+	log.Println("Review requested")
+	files, err := content.ListInReview()
+	if errISE(c, err) {
+		return
+	}
+	c.JSON(http.StatusOK, files)
+}
+
+// handleReviewComment handles requests to comment on a page submitted for
+// review without changing its status, so editors can answer the comments of
+// reviewers
+func handleReviewComment(c *gin.Context) {
+	uri := c.Param("uri")
+	log.Println("Review comment requested:", uri)
+	var req reviewRequest
+	err := c.ShouldBindJSON(&req)
+	if errBind(c, err) {
+		return
+	}
+	f, ok := reviewFile(c, uri)
+	if !ok || !allowURI(c, f.URI) {
+		return
+	}
+	err = content.AddReviewComments(f.URI, req.comments(c))
+	if errNotFound(c, err) || errISE(c, err) {
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// handleReviewApprove handles requests to approve a page submitted for review;
+// the page and its variants are published and the review comments are
+// removed
+func handleReviewApprove(c *gin.Context) {
+	uri := c.Param("uri")
+	log.Println("Review approval requested:", uri)
+	f, ok := reviewFile(c, uri)
+	if !ok {
+		return
+	}
+	err := setFileStatus(f, content.StatusPublished)
+	if errISE(c, err) {
+		return
+	}
+	err = content.ClearReviewComments(f.URI)
+	if errISE(c, err) {
+		return
+	}
+	contentChanged(f.URI)
+	c.JSON(http.StatusOK, gin.H{"uri": f.URI, "status": content.StatusPublished})
+}
+
+// handleReviewReject handles requests to reject a page submitted for review;
+// the request body contains the comments explaining the requested changes,
+// which are added to the page. The page and its variants are set back to
+// draft, so the editor can revise and submit them again.
+func handleReviewReject(c *gin.Context) {
+	uri := c.Param("uri")
+	log.Println("Review rejection requested:", uri)
+	var req reviewRequest
+	err := c.ShouldBindJSON(&req)
+	if errBind(c, err) {
+		return
+	}
+	f, ok := reviewFile(c, uri)
+	if !ok {
+		return
+	}
+	err = content.AddReviewComments(f.URI, req.comments(c))
+	if errISE(c, err) {
+		return
+	}
+	err = setFileStatus(f, content.StatusDraft)
+	if errISE(c, err) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"uri": f.URI, "status": content.StatusDraft})
+}
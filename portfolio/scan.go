@@ -0,0 +1,222 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// clamdChunkSize is the size of the chunks a stream is sent to clamd in
+const clamdChunkSize = 64 << 10
+
+var (
+	// clamdAddr is the TCP address of the clamd daemon uploads are scanned by
+	// set by CLAMD_ADDR; uploads are not scanned if it is empty
+	clamdAddr = getEnvOrElse("CLAMD_ADDR", "")
+	// clamdTimeout is the timeout of a single scan set by CLAMD_TIMEOUT
+	clamdTimeout = getEnvDurationOrElse("CLAMD_TIMEOUT", time.Minute)
+)
+
+// scanFinding is a file flagged by the scanner
+type scanFinding struct {
+	File      string `json:"file"`
+	Signature string `json:"signature"`
+}
+
+// scanningEnabled returns whether uploads are scanned
+func scanningEnabled() bool {
+	return clamdAddr != ""
+}
+
+// clamdCommand connects to clamd and sends the given command; the connection
+// must be closed by the caller
+func clamdCommand(command string) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", clamdAddr, clamdTimeout)
+	if err != nil {
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Now().Add(clamdTimeout))
+	// the 'z' prefix selects null terminated commands and replies
+	_, err = conn.Write([]byte("z" + command + "\x00"))
+	if err != nil {
+		cls(conn)
+		return nil, err
+	}
+	return conn, nil
+}
+
+// clamdReply reads the null terminated reply of clamd from the given
+// connection
+func clamdReply(conn net.Conn) (string, error) {
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && (!errors.Is(err, io.EOF) || reply == "") {
+		return "", err
+	}
+	return strings.TrimSuffix(reply, "\x00"), nil
+}
+
+// pingClamd checks whether clamd is reachable
+func pingClamd() error {
+	conn, err := clamdCommand("PING")
+	if err != nil {
+		return err
+	}
+	defer cls(conn)
+	reply, err := clamdReply(conn)
+	if err != nil {
+		return err
+	}
+	if reply != "PONG" {
+		return errors.New("unexpected reply of clamd: " + reply)
+	}
+	return nil
+}
+
+// scanReader streams the content of the given reader to clamd; returns the
+// name of the signature the content matched or an empty string if the content
+// is clean
+func scanReader(r io.Reader) (string, error) {
+	conn, err := clamdCommand("INSTREAM")
+	if err != nil {
+		return "", err
+	}
+	defer cls(conn)
+	// the stream is sent as chunks prefixed with their length and terminated
+	// by a chunk of length zero
+	buf := make([]byte, 4+clamdChunkSize)
+	for {
+		n, err := io.ReadFull(r, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf, uint32(n))
+			if _, err := conn.Write(buf[:4+n]); err != nil {
+				return "", err
+			}
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	_, err = conn.Write([]byte{0, 0, 0, 0})
+	if err != nil {
+		return "", err
+	}
+	reply, err := clamdReply(conn)
+	if err != nil {
+		return "", err
+	}
+	reply = strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case reply == "OK":
+		return "", nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return strings.TrimSuffix(reply, " FOUND"), nil
+	}
+	return "", errors.New("clamd: " + reply)
+}
+
+// scanUpload scans the given uploaded file with the given uri, or each file of
+// it if it is a zip file, unless scanning is disabled; returns an uploadError
+// with status 422 reporting all flagged files if any file is flagged and with
+// status 503 if the scan fails, so no unscanned upload is stored; the file is
+// rewound after scanning
+func scanUpload(f *os.File, uri string, size int64, isZip bool) error {
+	if !scanningEnabled() {
+		return nil
+	}
+	log.Println("Scanning upload:", uri)
+	var findings []scanFinding
+	scan := func(name string, r io.Reader) error {
+		return scanContent(name, r, &findings)
+	}
+	var err error
+	if isZip {
+		err = scanZip(f, size, scan)
+	} else {
+		err = scan(uri, f)
+	}
+	if err == nil {
+		err = flaggedError(findings)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	return err
+}
+
+// scanStream scans the content of the uploaded file with the given uri read
+// from the given reader unless scanning is disabled; returns an uploadError
+// like scanUpload
+func scanStream(uri string, r io.Reader) error {
+	if !scanningEnabled() {
+		return nil
+	}
+	log.Println("Scanning upload:", uri)
+	var findings []scanFinding
+	err := scanContent(uri, r, &findings)
+	if err != nil {
+		return err
+	}
+	return flaggedError(findings)
+}
+
+// scanContent scans the content of the file with the given name read from the
+// given reader and adds a finding to the given findings if it is flagged;
+// returns an uploadError with status 503 if the scan fails
+func scanContent(name string, r io.Reader, findings *[]scanFinding) error {
+	sig, err := scanReader(r)
+	if err != nil {
+		log.Println("[Err] Scanning", name, "failed:", err)
+		return &uploadError{status: http.StatusServiceUnavailable, code: "scan_failed", file: name,
+			msg: "scanning the upload failed: " + name}
+	}
+	if sig != "" {
+		log.Println("[Err] Scanner flagged", name, "as", sig)
+		*findings = append(*findings, scanFinding{File: name, Signature: sig})
+	}
+	return nil
+}
+
+// flaggedError returns an uploadError with status 422 reporting the given
+// findings; returns nil if there are none
+func flaggedError(findings []scanFinding) error {
+	if len(findings) == 0 {
+		return nil
+	}
+	return &uploadError{status: http.StatusUnprocessableEntity, code: "infected",
+		msg: "upload was flagged by the scanner", details: map[string]any{"findings": findings}}
+}
+
+// scanZip calls the given scan function for each file of the given zip file
+func scanZip(f *os.File, size int64, scan func(name string, r io.Reader) error) error {
+	zr, err := zip.NewReader(f, size)
+	if err != nil {
+		return err
+	}
+	for _, zf := range zr.File {
+		if zf.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			return err
+		}
+		err = scan(zf.Name, rc)
+		cls(rc)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
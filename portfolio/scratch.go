@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"os"
+)
+
+// errNoSpace is returned if the scratch directory lacks the space for an
+// operation
+var errNoSpace = errors.New("insufficient disk space")
+
+// scratchDir is the directory temporary files are created in; set by
+// SCRATCH_DIR and defaulting to the system's temporary directory
+var scratchDir = os.TempDir()
+
+// initScratchDir creates the scratch directory set by SCRATCH_DIR; the
+// directory is also used for temporary files of multipart form parsing
+func initScratchDir() {
+	dir := getEnvOrElse("SCRATCH_DIR", "")
+	if dir == "" {
+		return
+	}
+	checkErr(os.MkdirAll(dir, 0o700))
+	checkErr(os.Setenv("TMPDIR", dir))
+	scratchDir = dir
+	log.Println("Using scratch directory:", dir)
+}
+
+// makeScratchDir creates a temporary directory with the given name pattern in
+// the scratch directory after checking that the given number of bytes plus the
+// reserve set by SCRATCH_MIN_FREE_MB is available; returns an error wrapping
+// errNoSpace otherwise
+func makeScratchDir(pattern string, need int64) (string, error) {
+	err := checkScratchSpace(need)
+	if err != nil {
+		return "", err
+	}
+	return os.MkdirTemp(scratchDir, pattern)
+}
+
+// checkScratchSpace checks that the given number of bytes plus the reserve set
+// by SCRATCH_MIN_FREE_MB is available in the scratch directory; the check is
+// skipped if the free space cannot be determined
+func checkScratchSpace(need int64) error {
+	free, ok := diskFree(scratchDir)
+	if !ok {
+		return nil
+	}
+	need += int64(getEnvIntOrElse("SCRATCH_MIN_FREE_MB", 100)) << 20
+	if free < need {
+		return fmt.Errorf("%w: %d MiB required, %d MiB available in %s", errNoSpace, need>>20, free>>20, scratchDir)
+	}
+	return nil
+}
+
+// errStorage checks whether the given error wraps errNoSpace; if it does, it is
+// logged using log.Println and returned to the client with the status code
+// http.StatusInsufficientStorage
+func errStorage(c *gin.Context, err error) bool {
+	if errors.Is(err, errNoSpace) {
+		log.Println("[Err] Insufficient storage:", err)
+		c.AbortWithStatusJSON(http.StatusInsufficientStorage, gin.H{"error": err.Error()})
+		return true
+	}
+	return false
+}
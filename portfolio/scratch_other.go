@@ -0,0 +1,6 @@
+//go:build !unix
+
+package main
+
+// diskFree is not supported on this platform, so the free space is unknown
+func diskFree(string) (int64, bool) { return 0, false }
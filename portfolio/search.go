@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/duevil/Go_Portfolio/content"
+	"github.com/gin-gonic/gin"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// excerptLength is the maximum number of bytes of a page excerpt in the
+	// exported search index
+	excerptLength = 200
+	// snippetContext is the number of bytes of context around a match
+	snippetContext = 60
+	// maxSnippets is the maximum number of snippets per search result
+	maxSnippets = 3
+)
+
+var wordRegexp = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// searchResult is a page matching a search query
+type searchResult struct {
+	URI      string          `json:"uri"`
+	URL      string          `json:"url"`
+	Title    string          `json:"title"`
+	Score    int             `json:"score"`
+	Snippets []template.HTML `json:"snippets"`
+}
+
+// searchIndexEntry is a document of the exported search index; the fields can
+// be used directly by lunr or fuse
+type searchIndexEntry struct {
+	ID      string `json:"id"`
+	Title   string `json:"title"`
+	URL     string `json:"url"`
+	Excerpt string `json:"excerpt"`
+}
+
+// searchIndexHook is an export hook writing the search index of all markdown
+// pages to search-index.json, so the exported site can be searched without a
+// server
+type searchIndexHook struct{}
+
+func (searchIndexHook) Name() string { return "search index" }
+
+func (searchIndexHook) Run(dir string, _ string, files []content.MongoFile) error {
+	index := make([]searchIndexEntry, 0)
+	for _, f := range files {
+		if !f.IsMD || !f.Listed() {
+			continue
+		}
+		md, err := f.Markdown()
+		if err != nil {
+			return err
+		}
+		index = append(index, searchIndexEntry{
+			ID:      f.URI,
+			Title:   f.Title(),
+			URL:     exportName(f),
+			Excerpt: excerpt(content.PlainText(md)),
+		})
+	}
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "search-index.json"), data, 0o644)
+}
+
+// excerpt shortens the given text to at most excerptLength bytes without
+// cutting words
+func excerpt(text string) string {
+	if len(text) <= excerptLength {
+		return text
+	}
+	end := strings.LastIndexByte(text[:excerptLength], ' ')
+	if end <= 0 {
+		end = excerptLength
+		for end > 0 && !utf8.RuneStart(text[end]) {
+			end--
+		}
+	}
+	return text[:end] + "…"
+}
+
+// handleSearch handles search requests; searches all markdown pages for the
+// words of the query parameter 'q' and returns the matching pages with
+// highlighted snippets, best matches first
+func handleSearch(c *gin.Context) {
+	q := c.Query("q")
+	log.Println("Search requested:", q)
+	words := searchWords(q)
+	if len(words) == 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "query must contain at least one word"})
+		return
+	}
+	files, err := content.ListMarkdown()
+	if errISE(c, err) {
+		return
+	}
+	results := make([]searchResult, 0)
+	for _, f := range files {
+		if !f.Listed() || !f.Published() {
+			continue
+		}
+		md, err := f.Markdown()
+		if errISE(c, err) {
+			return
+		}
+		score, snippets := searchText(content.PlainText(md), words)
+		if score == 0 {
+			continue
+		}
+		results = append(results, searchResult{
+			URI:      f.URI,
+			URL:      f.Link(),
+			Title:    f.Title(),
+			Score:    score,
+			Snippets: snippets,
+		})
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Title < results[j].Title
+	})
+	c.JSON(http.StatusOK, gin.H{"query": q, "results": results})
+}
+
+// searchWords splits the given query into unique lower case words
+func searchWords(q string) []string {
+	var words []string
+	seen := map[string]bool{}
+	for _, w := range wordRegexp.FindAllString(strings.ToLower(q), -1) {
+		if !seen[w] {
+			seen[w] = true
+			words = append(words, w)
+		}
+	}
+	return words
+}
+
+// searchText searches the given text for words starting with any of the given
+// words; returns the number of matches as score and highlighted snippets, or a
+// zero score if not all words are contained in the text
+func searchText(text string, words []string) (int, []template.HTML) {
+	var hits [][]int
+	matched := map[string]bool{}
+	for _, loc := range wordRegexp.FindAllStringIndex(text, -1) {
+		w := strings.ToLower(text[loc[0]:loc[1]])
+		for _, q := range words {
+			if strings.HasPrefix(w, q) {
+				hits = append(hits, loc)
+				matched[q] = true
+				break
+			}
+		}
+	}
+	if len(matched) < len(words) {
+		return 0, nil
+	}
+	var snippets []template.HTML
+	for i := 0; i < len(hits) && len(snippets) < maxSnippets; {
+		var s template.HTML
+		s, i = snippet(text, hits, i)
+		snippets = append(snippets, s)
+	}
+	return len(hits), snippets
+}
+
+// snippet creates an HTML escaped snippet of the text around the hit with the
+// given index, highlighting all hits within the snippet; returns the snippet
+// and the index of the first hit ending after the snippet
+func snippet(text string, hits [][]int, i int) (template.HTML, int) {
+	start := hits[i][0] - snippetContext
+	if start <= 0 {
+		start = 0
+	} else if n := strings.IndexByte(text[start:hits[i][0]], ' '); n >= 0 {
+		start += n + 1
+	}
+	end := hits[i][1] + snippetContext
+	if end >= len(text) {
+		end = len(text)
+	} else if n := strings.LastIndexByte(text[hits[i][1]:end], ' '); n >= 0 {
+		end = hits[i][1] + n
+	}
+	// ensure that no runes are cut
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start--
+	}
+	for end < len(text) && !utf8.RuneStart(text[end]) {
+		end++
+	}
+	b := strings.Builder{}
+	if start > 0 {
+		b.WriteString("…")
+	}
+	pos := start
+	for _, h := range hits {
+		if h[0] < start || h[1] > end {
+			continue
+		}
+		b.WriteString(template.HTMLEscapeString(text[pos:h[0]]))
+		b.WriteString("<mark>")
+		b.WriteString(template.HTMLEscapeString(text[h[0]:h[1]]))
+		b.WriteString("</mark>")
+		pos = h[1]
+	}
+	b.WriteString(template.HTMLEscapeString(text[pos:end]))
+	if end < len(text) {
+		b.WriteString("…")
+	}
+	for i < len(hits) && hits[i][1] <= end {
+		i++
+	}
+	return template.HTML(b.String()), i
+}
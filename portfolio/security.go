@@ -0,0 +1,84 @@
+package main
+
+import (
+	"github.com/duevil/Go_Portfolio/content"
+	"github.com/gin-gonic/gin"
+	"log"
+	"os"
+	"strings"
+)
+
+// securityHeader is a header set on all responses
+type securityHeader struct {
+	name  string
+	value string
+	// tlsOnly headers are only set on responses to secure requests
+	tlsOnly bool
+	// analytics is the value used instead if the analytics script is rendered
+	analytics string
+}
+
+// analyticsSources are the sources the analytics script rendered for the
+// analytics id of the settings is loaded from and sends its data to
+const analyticsSources = "https://www.googletagmanager.com https://*.google-analytics.com"
+
+// defaultSecurityHeaders are the security headers and their default values;
+// the CSP allows the inline base script of the templates, the web fonts and
+// images from any HTTPS source, as pages may embed external images
+var defaultSecurityHeaders = []securityHeader{
+	{name: "Content-Security-Policy", value: "default-src 'self'; " +
+		"script-src 'self' 'unsafe-inline'; " +
+		"style-src 'self' 'unsafe-inline' https://fonts.googleapis.com; " +
+		"font-src 'self' https://fonts.gstatic.com; " +
+		"img-src 'self' data: https:; " +
+		"object-src 'none'; base-uri 'self'; frame-ancestors 'self'",
+		analytics: "default-src 'self'; " +
+			"script-src 'self' 'unsafe-inline' " + analyticsSources + "; " +
+			"style-src 'self' 'unsafe-inline' https://fonts.googleapis.com; " +
+			"font-src 'self' https://fonts.gstatic.com; " +
+			"img-src 'self' data: https:; " +
+			"connect-src 'self' " + analyticsSources + "; " +
+			"object-src 'none'; base-uri 'self'; frame-ancestors 'self'"},
+	{name: "Strict-Transport-Security", value: "max-age=31536000; includeSubDomains", tlsOnly: true},
+	{name: "X-Content-Type-Options", value: "nosniff"},
+	{name: "Referrer-Policy", value: "strict-origin-when-cross-origin"},
+	{name: "X-Frame-Options", value: "SAMEORIGIN"},
+}
+
+// securityHeaders returns the middleware setting the security headers on all
+// responses; each header's value may be overridden by the environment variable
+// HEADER_<NAME>, e.g. HEADER_CONTENT_SECURITY_POLICY, and is not set if the
+// variable is set but empty. If the settings have an analytics id, the default
+// CSP is replaced by one allowing the analytics script; an overridden CSP is
+// kept as it is.
+func securityHeaders() gin.HandlerFunc {
+	var headers []securityHeader
+	for _, h := range defaultSecurityHeaders {
+		key := "HEADER_" + strings.ToUpper(strings.ReplaceAll(h.name, "-", "_"))
+		if v, ok := os.LookupEnv(key); ok {
+			h.value, h.analytics = v, ""
+		}
+		if h.value == "" {
+			log.Println("Security header disabled:", h.name)
+			continue
+		}
+		headers = append(headers, h)
+	}
+	return func(c *gin.Context) {
+		secure := c.Request.TLS != nil || getEnvOrElse("SESSION_SECURE", "false") == "true"
+		analytics := false
+		if s, err := content.LoadSettings(); err == nil {
+			analytics = s.AnalyticsID != ""
+		}
+		for _, h := range headers {
+			if h.tlsOnly && !secure {
+				continue
+			}
+			if analytics && h.analytics != "" {
+				c.Header(h.name, h.analytics)
+			} else {
+				c.Header(h.name, h.value)
+			}
+		}
+	}
+}
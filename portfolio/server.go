@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/acme/autocert"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// runServer runs the given router in the mode set by TLS_MODE:
+//
+//   - 'off' (default) serves plain HTTP on GIN_PORT
+//   - 'file' serves HTTPS on TLS_PORT using the certificate and key set by
+//     TLS_CERT_FILE and TLS_KEY_FILE
+//   - 'autocert' serves HTTPS on TLS_PORT using certificates obtained from
+//     Let's Encrypt for the comma separated TLS_DOMAINS, which are cached in
+//     TLS_CACHE_DIR; HTTP-01 challenges are answered on HTTP_PORT
+//
+// In both TLS modes, plain HTTP requests on HTTP_PORT are redirected to HTTPS.
+func runServer(router *gin.Engine) error {
+	mode := getEnvOrElse("TLS_MODE", "off")
+	if mode == "off" {
+		addr := ":" + getEnvOrElse("GIN_PORT", "9000")
+		log.Println("Starting server on", addr)
+		return router.Run(addr)
+	}
+	srv := &http.Server{
+		Addr:              ":" + getEnvOrElse("TLS_PORT", "443"),
+		Handler:           router,
+		ReadHeaderTimeout: 10 * time.Second,
+		TLSConfig:         &tls.Config{MinVersion: tls.VersionTLS12},
+	}
+	redirect := http.HandlerFunc(redirectHTTPS)
+	var certFile, keyFile string
+	switch mode {
+	case "file":
+		certFile = getEnvOrElse("TLS_CERT_FILE", "")
+		keyFile = getEnvOrElse("TLS_KEY_FILE", "")
+		if certFile == "" || keyFile == "" {
+			return errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set for TLS_MODE 'file'")
+		}
+	case "autocert":
+		var domains []string
+		for d := range parseList(getEnvOrElse("TLS_DOMAINS", "")) {
+			domains = append(domains, d)
+		}
+		if len(domains) == 0 {
+			return errors.New("TLS_DOMAINS must be set for TLS_MODE 'autocert'")
+		}
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(domains...),
+			Cache:      autocert.DirCache(getEnvOrElse("TLS_CACHE_DIR", "certs")),
+			Email:      getEnvOrElse("TLS_EMAIL", ""),
+		}
+		srv.TLSConfig = m.TLSConfig()
+		srv.TLSConfig.MinVersion = tls.VersionTLS12
+		// answers HTTP-01 challenges and redirects all other requests
+		redirect = m.HTTPHandler(nil).ServeHTTP
+		log.Println("Obtaining certificates for:", strings.Join(domains, ", "))
+	default:
+		return errors.New("unknown TLS_MODE: " + mode)
+	}
+	httpAddr := ":" + getEnvOrElse("HTTP_PORT", "80")
+	go func() {
+		log.Println("Starting HTTP redirect server on", httpAddr)
+		s := &http.Server{Addr: httpAddr, Handler: redirect, ReadHeaderTimeout: 10 * time.Second}
+		if err := s.ListenAndServe(); err != nil {
+			log.Println("[Err] HTTP redirect server:", err)
+		}
+	}()
+	log.Println("Starting TLS server on", srv.Addr)
+	return srv.ListenAndServeTLS(certFile, keyFile)
+}
+
+// redirectHTTPS redirects the request to the same URL using HTTPS on TLS_PORT
+func redirectHTTPS(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if i := strings.LastIndex(host, ":"); i > strings.LastIndex(host, "]") {
+		host = host[:i]
+	}
+	if port := getEnvOrElse("TLS_PORT", "443"); port != "443" {
+		host += ":" + port
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+}
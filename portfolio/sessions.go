@@ -0,0 +1,59 @@
+package main
+
+import (
+	"auth"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+)
+
+// sessionInfo is a session as listed by handleSessions
+type sessionInfo struct {
+	auth.Session
+	// Current is set for the session the listing was requested with
+	Current bool `json:"current"`
+}
+
+// handleSessions handles requests to list the active sessions; admins see the
+// sessions of all users, other users only their own sessions
+func handleSessions(c *gin.Context) {
+	log.Println("Sessions requested")
+	sessions, err := auth.ListSessions(sessionUserFilter(c))
+	if errISE(c, err) {
+		return
+	}
+	current, _ := c.Get("session")
+	cur, _ := current.(auth.Session)
+	infos := make([]sessionInfo, len(sessions))
+	for i, s := range sessions {
+		infos[i] = sessionInfo{Session: s, Current: s.ID == cur.ID}
+	}
+	c.JSON(http.StatusOK, infos)
+}
+
+// handleSessionDelete handles requests to revoke a session; admins may revoke
+// the sessions of all users, other users only their own sessions
+func handleSessionDelete(c *gin.Context) {
+	id := c.Param("id")
+	log.Println("Session revocation requested:", id)
+	err := auth.RevokeSession(id, sessionUserFilter(c))
+	if errors.Is(err, auth.ErrNoSession) {
+		errStatus(c, http.StatusNotFound, err)
+		return
+	}
+	if errISE(c, err) {
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// sessionUserFilter returns the user whose sessions may be managed in the given
+// context; returns an empty string if the user may manage all sessions
+func sessionUserFilter(c *gin.Context) string {
+	role, _ := c.Get("role")
+	if r, _ := role.(auth.Role); r.Can(auth.PermAdmin) {
+		return ""
+	}
+	return c.GetString("user")
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"github.com/duevil/Go_Portfolio/content"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"reflect"
+)
+
+// handleSettings handles requests for the site settings
+func handleSettings(c *gin.Context) {
+	log.Println("Settings requested")
+	s, err := content.LoadSettings()
+	if errISE(c, err) {
+		return
+	}
+	c.JSON(http.StatusOK, s)
+}
+
+// handleSettingsUpdate handles requests to replace the site settings with the
+// settings given as JSON body
+func handleSettingsUpdate(c *gin.Context) {
+	log.Println("Settings update requested")
+	var s content.Settings
+	err := c.ShouldBindJSON(&s)
+	if errBind(c, err) {
+		return
+	}
+	err = s.Validate()
+	if errStatus(c, http.StatusBadRequest, err) {
+		return
+	}
+	old, err := content.LoadSettings()
+	if errISE(c, err) {
+		return
+	}
+	err = content.SaveSettings(s)
+	if errISE(c, err) {
+		return
+	}
+	// only the responses rendering the changed parts are purged; the default
+	// language changes the pages served without language prefix, and the site
+	// title is also rendered by the feeds
+	if old.DefaultLanguage != s.DefaultLanguage {
+		purgeCDNKeys(keyMenu, keySettings, keyPages)
+	} else {
+		if !reflect.DeepEqual(old.FooterColumns, s.FooterColumns) {
+			purgeCDNKeys(keyMenu)
+		}
+		if !reflect.DeepEqual(old.SocialLinks, s.SocialLinks) || old.Author != s.Author ||
+			old.FooterText != s.FooterText || old.SiteTitle != s.SiteTitle || old.AnalyticsID != s.AnalyticsID {
+			purgeCDNKeys(keySettings)
+		}
+		if old.SiteTitle != s.SiteTitle || old.Author != s.Author {
+			purgeCDNKeys(keyPages)
+		}
+	}
+	c.JSON(http.StatusOK, s)
+}
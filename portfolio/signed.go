@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/duevil/Go_Portfolio/content"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"time"
+)
+
+// downloadKey is the key download URLs are signed with
+var downloadKey = secretKey("DOWNLOAD_URL_KEY")
+
+// signedRequest is the request body for creating a signed download URL; the
+// lifetime is a duration like '24h' and defaults to DOWNLOAD_URL_TTL
+type signedRequest struct {
+	URI string `json:"uri" binding:"required"`
+	TTL string `json:"ttl"`
+}
+
+// downloadSignature returns the signature of the download URL of the file
+// with the given uri expiring at the given Unix time
+func downloadSignature(uri string, expires int64) string {
+	mac := hmac.New(sha256.New, downloadKey)
+	mac.Write([]byte(uri + "\n" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// handleSignedCreate handles requests to create a signed download URL for a
+// file, which may be private or unpublished; the URL expires after the
+// requested lifetime, which is limited by DOWNLOAD_URL_MAX_TTL. Signed URLs
+// are not stored, so they cannot be revoked before they expire except by
+// changing DOWNLOAD_URL_KEY, which invalidates all of them.
+func handleSignedCreate(c *gin.Context) {
+	log.Println("Signed download URL requested")
+	var req signedRequest
+	err := c.ShouldBindJSON(&req)
+	if errBind(c, err) {
+		return
+	}
+	ttl := getEnvDurationOrElse("DOWNLOAD_URL_TTL", 24*time.Hour)
+	if req.TTL != "" {
+		ttl, err = time.ParseDuration(req.TTL)
+		if err == nil && ttl <= 0 {
+			err = errors.New("ttl must be positive")
+		}
+		if errStatus(c, http.StatusBadRequest, err) {
+			return
+		}
+	}
+	ttl = min(ttl, getEnvDurationOrElse("DOWNLOAD_URL_MAX_TTL", 30*24*time.Hour))
+	f, err := content.GetFromDB(req.URI)
+	if err == nil && !f.Public() {
+		err = content.ErrNotFound
+	}
+	if errNotFound(c, err) || errISE(c, err) || !allowURI(c, f.URI) {
+		return
+	}
+	expires := time.Now().Add(ttl).Unix()
+	q := url.Values{}
+	q.Set("expires", strconv.FormatInt(expires, 10))
+	q.Set("signature", downloadSignature(f.URI, expires))
+	link := siteURL(c) + path.Join("/", content.URIRoot, f.URI) + "?" + q.Encode()
+	c.JSON(http.StatusCreated, gin.H{"uri": f.URI, "url": link, "expires": time.Unix(expires, 0)})
+}
+
+// serveSigned serves the given file, which may be private or unpublished, if
+// the given signature is valid for it and the given expiry; responds with
+// status 404 for invalid signatures, so they do not reveal whether a file
+// exists, and with status 410 for expired URLs
+func serveSigned(c *gin.Context, f content.MongoFile, signature string, expires string) {
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || !hmac.Equal([]byte(signature), []byte(downloadSignature(f.URI, exp))) || !f.Public() {
+		errNotFound(c, content.ErrNotFound)
+		return
+	}
+	if time.Now().Unix() > exp {
+		errStatus(c, http.StatusGone, errors.New("download URL expired"))
+		return
+	}
+	log.Println("Serving signed download:", f.URI)
+	// the signature is part of the URL, so it must neither be cached, indexed
+	// nor leaked to linked sites
+	noCDNCache(c)
+	c.Header("X-Robots-Tag", "noindex")
+	c.Header("Referrer-Policy", "no-referrer")
+	serveFile(c, f, nil)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"github.com/duevil/Go_Portfolio/content"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"path"
+)
+
+// handleStagingPreview handles requests to preview a staging content set; the
+// set's files are served like published files, files which are not part of
+// the set are served from the published content, so the preview shows the
+// site as it will be once the set is promoted
+func handleStagingPreview(c *gin.Context) {
+	name, uri := c.Param("name"), c.Param("uri")
+	log.Println("Staging preview requested:", name, uri)
+	if !content.ValidStagingName(name) {
+		errNotFound(c, content.ErrNotFound)
+		return
+	}
+	f, err := content.GetFromDB(content.StagingURI(name, uri))
+	if errors.Is(content.ErrNotFound, err) {
+		f, err = content.GetFromDB(uri)
+		if err == nil && !f.Public() {
+			err = content.ErrNotFound
+		}
+	}
+	if errNotFound(c, err) || errISE(c, err) {
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	// relative links of previewed pages resolve within the preview
+	serveFile(c, f, func(page *content.Page) {
+		page.Root = path.Join("staging", name)
+		page.Base = path.Join(page.Root, uri)
+	})
+}
+
+// handleStagingSets handles requests to list the names of all staging content
+// sets
+func handleStagingSets(c *gin.Context) {
+	log.Println("Staging sets requested")
+	names, err := content.ListStagingSets()
+	if errISE(c, err) {
+		return
+	}
+	c.JSON(http.StatusOK, names)
+}
+
+// handleStaging handles requests to list the files of a staging content set
+func handleStaging(c *gin.Context) {
+	name := c.Param("name")
+	log.Println("Staging requested:", name)
+	if !content.ValidStagingName(name) {
+		errStatus(c, http.StatusBadRequest, content.ErrInvalidStagingName)
+		return
+	}
+	files, err := content.ListStaging(name)
+	if errISE(c, err) {
+		return
+	}
+	c.JSON(http.StatusOK, files)
+}
+
+// handleStagingPromote handles requests to promote a staging content set; the
+// set's files replace the published files and the set is removed
+func handleStagingPromote(c *gin.Context) {
+	name := c.Param("name")
+	log.Println("Staging promotion requested:", name)
+	files, err := content.PromoteStaging(name)
+	if errors.Is(err, content.ErrInvalidStagingName) {
+		errStatus(c, http.StatusBadRequest, err)
+		return
+	}
+	if errNotFound(c, err) || errISE(c, err) {
+		return
+	}
+	for _, f := range files {
+		contentChanged(f.URI)
+	}
+	c.JSON(http.StatusOK, files)
+}
+
+// handleRollback handles requests to roll back the last promotion; the files
+// replaced by the promotion are swapped back in, and another rollback undoes
+// the rollback
+func handleRollback(c *gin.Context) {
+	log.Println("Rollback requested")
+	uris, err := content.Rollback()
+	if errNotFound(c, err) || errISE(c, err) {
+		return
+	}
+	contentChanged(uris...)
+	c.JSON(http.StatusOK, gin.H{"files": uris})
+}
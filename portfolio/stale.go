@@ -0,0 +1,89 @@
+package main
+
+import (
+	"github.com/duevil/Go_Portfolio/content"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// staleReport lists the pages which were not modified for a given number of
+// months
+type staleReport struct {
+	Months    int                 `json:"months"`
+	Generated time.Time           `json:"generated"`
+	Pages     []content.MongoFile `json:"pages"`
+}
+
+var (
+	// lastStaleReport is the report generated by the last run of the stale job
+	lastStaleReport *staleReport
+	staleMu         sync.Mutex
+)
+
+// staleMonths returns the number of months after which a page is considered
+// stale, configured by STALE_AFTER_MONTHS
+func staleMonths() int { return getEnvIntOrElse("STALE_AFTER_MONTHS", 12) }
+
+// newStaleReport creates a report of all pages not modified within the given
+// number of months
+func newStaleReport(months int) (*staleReport, error) {
+	now := time.Now()
+	pages, err := content.ListStale(now.AddDate(0, -months, 0))
+	if err != nil {
+		return nil, err
+	}
+	if pages == nil {
+		pages = []content.MongoFile{}
+	}
+	return &staleReport{Months: months, Generated: now, Pages: pages}, nil
+}
+
+// runStaleJob generates the stale report and logs the pages which should be
+// refreshed
+func runStaleJob() error {
+	r, err := newStaleReport(staleMonths())
+	if err != nil {
+		return err
+	}
+	for _, p := range r.Pages {
+		log.Println("Stale page, last modified", p.LastMod.Format(time.DateOnly)+":", p.URI)
+	}
+	staleMu.Lock()
+	lastStaleReport = r
+	staleMu.Unlock()
+	return nil
+}
+
+// staleQuery are the query parameters of the stale report
+type staleQuery struct {
+	Months *int `form:"months" binding:"omitempty,min=1"`
+}
+
+// handleStale handles requests for the stale report; returns the report of the
+// last job run or, if the query parameter 'months' is given or the job did not
+// run yet, generates a new report
+func handleStale(c *gin.Context) {
+	log.Println("Stale report requested")
+	var q staleQuery
+	err := c.ShouldBindQuery(&q)
+	if errBind(c, err) {
+		return
+	}
+	staleMu.Lock()
+	r := lastStaleReport
+	staleMu.Unlock()
+	if q.Months != nil || r == nil {
+		months := staleMonths()
+		if q.Months != nil {
+			months = *q.Months
+		}
+		r, err = newStaleReport(months)
+		if errISE(c, err) {
+			return
+		}
+	}
+	c.JSON(http.StatusOK, r)
+}
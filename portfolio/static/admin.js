@@ -0,0 +1,27 @@
+function csrfToken() {
+    const match = document.cookie.match(/(?:^|;\s*)csrf_token=([^;]*)/);
+    return match ? match[1] : "";
+}
+document.getElementById("upload").addEventListener("click", () => {
+    const file = document.getElementById("file").files[0];
+    const formData = new FormData();
+    formData.append("file", file);
+    fetch("/admin/upload", {method: "POST", body: formData, headers: {"X-CSRF-Token": csrfToken()}}).then(response => {
+        if (response.ok) alert("Inhalt \n'" + file.name + "'\n wurde hochgeladen.");
+        else alert("Inhalt \n'" + file.name + "'\n konnte nicht hochgeladen werden.");
+    });
+});
+document.getElementById("delete").addEventListener("click", () => {
+    const uri = document.getElementById("del_uri").value;
+    let c = confirm("Inhalt \n'" + uri + "'\n löschen?")
+    if (!c) return;
+    fetch("/admin/" + uri, {method: "DELETE", headers: {"X-CSRF-Token": csrfToken()}}).then(response => {
+        if (response.ok) alert("Inhalt \n'" + uri + "'\n wurde gelöscht.");
+        else alert("Inhalt \n'" + uri + "'\n konnte nicht gelöscht werden.");
+    });
+});
+document.getElementById("copy").addEventListener("click", () => {
+    navigator.clipboard
+        .writeText(document.getElementById("list").contentWindow.document.body.innerText)
+        .then(() => alert("JSON in Zwischenablage kopiert."));
+});
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"github.com/duevil/Go_Portfolio/content"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"time"
+)
+
+// statusRequest is the request body for setting the status of a file
+type statusRequest struct {
+	Status string `json:"status" binding:"required,oneof=draft review published archived"`
+}
+
+// handleStatus handles requests to transition a file and its variants to
+// another status; the request body contains the status, which is either
+// 'draft', 'review', 'published' or 'archived'. If the review workflow is
+// enabled, only admins may publish files. Responds with the previous and the
+// new status.
+func handleStatus(c *gin.Context) {
+	uri := c.Param("uri")
+	log.Println("Status update requested:", uri)
+	var req statusRequest
+	err := c.ShouldBindJSON(&req)
+	if errBind(c, err) {
+		return
+	}
+	f, err := content.GetFromDB(uri)
+	if err == nil && !f.Public() {
+		err = content.ErrNotFound
+	}
+	if errNotFound(c, err) || errISE(c, err) || !allowURI(c, f.URI) ||
+		(req.Status == content.StatusPublished && !allowPublish(c, f.URI)) {
+		return
+	}
+	previous := f.Status
+	if previous == "" {
+		previous = content.StatusPublished
+	}
+	err = setFileStatus(f, req.Status)
+	if errors.Is(err, content.ErrInvalidStatus) {
+		errStatus(c, http.StatusBadRequest, err)
+		return
+	}
+	if errISE(c, err) {
+		return
+	}
+	contentChanged(f.URI)
+	c.JSON(http.StatusOK, gin.H{"uri": f.URI, "previous": previous, "status": req.Status})
+}
+
+// setFileStatus sets the given status for the given file and its variants
+func setFileStatus(f content.MongoFile, s string) error {
+	err := content.SetStatus(f.URI, s)
+	if err != nil {
+		return err
+	}
+	for _, m := range f.Variants {
+		err = content.SetStatus(f.URI+extensionByType(m), s)
+		if err != nil && !errors.Is(content.ErrNotFound, err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// scheduleRequest is the request body for scheduling the publication of a
+// file; missing times remove the schedule
+type scheduleRequest struct {
+	PublishAt   *time.Time `json:"publish_at"`
+	UnpublishAt *time.Time `json:"unpublish_at"`
+}
+
+// handleSchedule handles requests to schedule the publication and the
+// unpublication of a file and its variants; the request body contains the
+// times as RFC 3339 timestamps. A file scheduled to be published in the future
+// is set to draft until then. If the review workflow is enabled, only admins
+// may schedule a publication.
+func handleSchedule(c *gin.Context) {
+	uri := c.Param("uri")
+	log.Println("Schedule update requested:", uri)
+	var req scheduleRequest
+	err := c.ShouldBindJSON(&req)
+	if errBind(c, err) {
+		return
+	}
+	var publishAt, unpublishAt time.Time
+	if req.PublishAt != nil {
+		publishAt = *req.PublishAt
+	}
+	if req.UnpublishAt != nil {
+		unpublishAt = *req.UnpublishAt
+	}
+	f, err := content.GetFromDB(uri)
+	if err == nil && !f.Public() {
+		err = content.ErrNotFound
+	}
+	if errNotFound(c, err) || errISE(c, err) || !allowURI(c, f.URI) ||
+		(req.PublishAt != nil && !allowPublish(c, f.URI)) {
+		return
+	}
+	err = content.SetSchedule(f.URI, publishAt, unpublishAt)
+	if errors.Is(err, content.ErrInvalidSchedule) {
+		errStatus(c, http.StatusBadRequest, err)
+		return
+	}
+	if errISE(c, err) {
+		return
+	}
+	for _, m := range f.Variants {
+		err = content.SetSchedule(f.URI+extensionByType(m), publishAt, unpublishAt)
+		if !errors.Is(content.ErrNotFound, err) && errISE(c, err) {
+			return
+		}
+	}
+	contentChanged(f.URI)
+	c.JSON(http.StatusOK, gin.H{"uri": f.URI, "publish_at": req.PublishAt, "unpublish_at": req.UnpublishAt})
+}
+
+// runScheduleJob publishes and unpublishes the files whose scheduled time
+// passed
+func runScheduleJob() error {
+	changed, err := content.ApplySchedule(time.Now())
+	if len(changed) > 0 {
+		contentChanged(changed...)
+	}
+	return err
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"github.com/duevil/Go_Portfolio/content"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// newBlobStore returns the store file contents are kept in set by
+// LOCAL_STORAGE; either 'gridfs', the default, which stores them in the GridFS
+// bucket DB_BLOB_BUCKET of the given database, 'disk', which stores them in
+// LOCAL_STORAGE_DIR, or 's3', which stores them in an S3 bucket, see s3Store.
+// When running multiple instances with 'disk', the directory must be a volume
+// shared by all instances, as every instance serves all files.
+func newBlobStore(db *mongo.Database) (content.BlobStore, error) {
+	switch mode := getEnvOrElse("LOCAL_STORAGE", "gridfs"); mode {
+	case "disk":
+		return diskStore(), nil
+	case "gridfs":
+		return content.NewGridFSStore(db, getEnvOrElse("DB_BLOB_BUCKET", "blobs"))
+	case "s3":
+		return s3Store()
+	default:
+		return nil, errors.New("unknown LOCAL_STORAGE: " + mode)
+	}
+}
+
+// s3Store returns the store of file contents in the bucket S3_BUCKET of the
+// S3 compatible service at S3_ENDPOINT in the region S3_REGION, 'us-east-1' by
+// default, accessed with S3_ACCESS_KEY and S3_SECRET_KEY; large assets may be
+// uploaded to the bucket directly, see handleDirectUpload
+func s3Store() (content.S3Store, error) {
+	store := content.S3Store{
+		Endpoint:  getEnvOrElse("S3_ENDPOINT", ""),
+		Region:    getEnvOrElse("S3_REGION", "us-east-1"),
+		Bucket:    getEnvOrElse("S3_BUCKET", ""),
+		AccessKey: getEnvOrElse("S3_ACCESS_KEY", ""),
+		SecretKey: getEnvOrElse("S3_SECRET_KEY", ""),
+	}
+	if store.Endpoint == "" || store.Bucket == "" {
+		return store, errors.New("S3_ENDPOINT and S3_BUCKET must be set to store file contents in S3")
+	}
+	return store, nil
+}
+
+// diskStore returns the store of file contents in LOCAL_STORAGE_DIR, where
+// large files were kept before GridFS became the default
+func diskStore() content.DiskStore {
+	return content.DiskStore{Dir: getEnvOrElse("LOCAL_STORAGE_DIR", content.URIRoot)}
+}
+
+// copyFromDisk copies file contents missing in the blob store from
+// LOCAL_STORAGE_DIR
+func copyFromDisk() ([]string, error) {
+	return content.CopyBlobs(diskStore())
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// cssURLRegexp matches url() references in style sheets and style attributes
+var cssURLRegexp = regexp.MustCompile(`(?i)url\(\s*['"]?\s*([^'")\s]*)`)
+
+// svgBlockedElements are removed from uploaded SVGs including their children
+var svgBlockedElements = map[string]bool{
+	"script":        true,
+	"foreignobject": true,
+	"iframe":        true,
+	"embed":         true,
+	"object":        true,
+	"handler":       true,
+	"listener":      true,
+}
+
+// svgAnimationElements can change attributes of other elements and are removed
+// if they target a link attribute
+var svgAnimationElements = map[string]bool{
+	"animate":          true,
+	"animatemotion":    true,
+	"animatetransform": true,
+	"set":              true,
+}
+
+// sanitizeSVG removes scripts, foreign objects, event handlers and external
+// references from the given SVG document; comments, doctypes and processing
+// instructions other than the XML declaration are dropped as well; returns an
+// uploadError if the document cannot be parsed
+func sanitizeSVG(data []byte) ([]byte, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.Strict = false
+	buf := bytes.Buffer{}
+	// skip is the depth of the currently removed element; zero if none
+	skip := 0
+	inStyle := false
+	for {
+		// raw tokens keep the namespace prefixes as written
+		t, err := d.RawToken()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, &uploadError{status: http.StatusBadRequest, msg: "invalid SVG: " + err.Error()}
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			name := strings.ToLower(t.Name.Local)
+			if skip > 0 || svgBlockedElements[name] || (svgAnimationElements[name] && svgAnimatesLink(t)) {
+				skip++
+				continue
+			}
+			inStyle = name == "style"
+			buf.WriteString("<" + xmlName(t.Name))
+			for _, a := range t.Attr {
+				if svgAllowedAttr(name, a) {
+					buf.WriteString(" " + xmlName(a.Name) + `="`)
+					_ = xml.EscapeText(&buf, []byte(a.Value))
+					buf.WriteString(`"`)
+				}
+			}
+			buf.WriteString(">")
+		case xml.EndElement:
+			if skip > 0 {
+				skip--
+				continue
+			}
+			inStyle = false
+			buf.WriteString("</" + xmlName(t.Name) + ">")
+		case xml.CharData:
+			if skip > 0 || (inStyle && cssExternal(string(t))) {
+				continue
+			}
+			_ = xml.EscapeText(&buf, t)
+		case xml.ProcInst:
+			if skip == 0 && t.Target == "xml" {
+				buf.WriteString("<?xml " + string(t.Inst) + "?>")
+			}
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// svgAllowedAttr returns whether the given attribute of an element with the
+// given lower case name is kept
+func svgAllowedAttr(element string, a xml.Attr) bool {
+	name := strings.ToLower(a.Name.Local)
+	value := strings.ToLower(strings.TrimSpace(a.Value))
+	switch {
+	case strings.HasPrefix(name, "on"):
+		return false
+	case strings.Contains(value, "javascript:"):
+		return false
+	case name == "href" || name == "src":
+		if element == "a" {
+			return strings.HasPrefix(value, "#") || strings.HasPrefix(value, "http://") ||
+				strings.HasPrefix(value, "https://") || strings.HasPrefix(value, "mailto:")
+		}
+		return !svgExternalRef(value)
+	}
+	return !cssExternal(value)
+}
+
+// svgAnimatesLink returns whether the given animation element targets a link
+// attribute
+func svgAnimatesLink(t xml.StartElement) bool {
+	for _, a := range t.Attr {
+		if strings.ToLower(a.Name.Local) == "attributename" && strings.Contains(strings.ToLower(a.Value), "href") {
+			return true
+		}
+	}
+	return false
+}
+
+// svgExternalRef returns whether the given reference points outside the
+// document; fragments and embedded raster images are allowed
+func svgExternalRef(ref string) bool {
+	ref = strings.ToLower(strings.TrimSpace(ref))
+	if ref == "" || strings.HasPrefix(ref, "#") {
+		return false
+	}
+	for _, prefix := range []string{"data:image/png", "data:image/jpeg", "data:image/gif", "data:image/webp"} {
+		if strings.HasPrefix(ref, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// cssExternal returns whether the given CSS imports style sheets or references
+// external resources
+func cssExternal(css string) bool {
+	if strings.Contains(strings.ToLower(css), "@import") {
+		return true
+	}
+	for _, m := range cssURLRegexp.FindAllStringSubmatch(css, -1) {
+		if svgExternalRef(m[1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// xmlName returns the given name as written in the document
+func xmlName(n xml.Name) string {
+	if n.Space == "" {
+		return n.Local
+	}
+	return n.Space + ":" + n.Local
+}
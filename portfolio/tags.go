@@ -0,0 +1,132 @@
+package main
+
+import (
+	"github.com/duevil/Go_Portfolio/content"
+	"github.com/gin-gonic/gin"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tag is a tag of the pages listed on the generated tag pages
+type tag struct {
+	Label string
+	Pages []content.MongoFile
+}
+
+// tagKey returns the key tags are matched by, so tags differing in case or
+// surrounding whitespace are the same tag
+func tagKey(t string) string {
+	return strings.ToLower(strings.TrimSpace(t))
+}
+
+// tagURL returns the url of the listing page of the given tag
+func tagURL(t string) string {
+	return "/tags/" + url.PathEscape(tagKey(t))
+}
+
+// listTags returns the tags of all listed and published pages by their keys;
+// the label of a tag is the first one found, the pages of a tag are sorted
+// by date, newest first
+func listTags() (map[string]*tag, error) {
+	files, err := content.ListTagged()
+	if err != nil {
+		return nil, err
+	}
+	tags := map[string]*tag{}
+	for _, f := range files {
+		if !f.Listed() || !f.Published() {
+			continue
+		}
+		for _, t := range f.Tags() {
+			key := tagKey(t)
+			if key == "" {
+				continue
+			}
+			if tags[key] == nil {
+				tags[key] = &tag{Label: strings.TrimSpace(t)}
+			}
+			tags[key].Pages = append(tags[key].Pages, f)
+		}
+	}
+	for _, t := range tags {
+		sort.SliceStable(t.Pages, func(i, j int) bool {
+			return pageDate(t.Pages[i]).After(pageDate(t.Pages[j]))
+		})
+	}
+	return tags, nil
+}
+
+// pageDate returns the date of the given page's front matter or else the time
+// it was last modified
+func pageDate(f content.MongoFile) time.Time {
+	if f.Meta != nil && !f.Meta.Date.IsZero() {
+		return f.Meta.Date
+	}
+	return f.LastMod
+}
+
+// handleTags handles requests for the generated page listing all tags with
+// the number of their pages
+func handleTags(c *gin.Context) {
+	log.Println("Tags requested")
+	tags, err := listTags()
+	if errISE(c, err) {
+		return
+	}
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	b := strings.Builder{}
+	b.WriteString("<h1>Tags</h1>\n<ul class=\"tags\">\n")
+	for _, key := range keys {
+		t := tags[key]
+		b.WriteString(`<li><a href="` + template.HTMLEscapeString(tagURL(key)) + `">` +
+			template.HTMLEscapeString(t.Label) + "</a> (" + strconv.Itoa(len(t.Pages)) + ")</li>\n")
+	}
+	b.WriteString("</ul>\n")
+	renderGenerated(c, "Tags", "tags", b.String())
+}
+
+// handleTag handles requests for the generated page listing the pages with
+// the given tag; responds with status 404 if no page has the tag
+func handleTag(c *gin.Context) {
+	key := tagKey(c.Param("tag"))
+	log.Println("Tag requested:", key)
+	tags, err := listTags()
+	if errISE(c, err) {
+		return
+	}
+	t, ok := tags[key]
+	if !ok {
+		errNotFound(c, content.ErrNotFound)
+		return
+	}
+	b := strings.Builder{}
+	b.WriteString("<h1>" + template.HTMLEscapeString(t.Label) + "</h1>\n<ul>\n")
+	for _, f := range t.Pages {
+		b.WriteString(`<li><a href="` + template.HTMLEscapeString(f.Link()) + `">` + template.HTMLEscapeString(f.Title()) + "</a>")
+		if f.Meta != nil && f.Meta.Description != "" {
+			b.WriteString(" – " + template.HTMLEscapeString(f.Meta.Description))
+		}
+		b.WriteString("</li>\n")
+	}
+	b.WriteString("</ul>\n")
+	renderGenerated(c, t.Label, strings.TrimPrefix(tagURL(key), "/"), b.String())
+}
+
+// renderGenerated responds with a generated page with the given title, base
+// and HTML content rendered by the 'page' template
+func renderGenerated(c *gin.Context, title string, base string, html string) {
+	page := newPage(title, base)
+	page.Content = template.HTML(html)
+	surrogateKeys(c, keyMenu, keySettings)
+	c.HTML(http.StatusOK, "page", page)
+}
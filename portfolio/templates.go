@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/duevil/Go_Portfolio/content"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
+	"html/template"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync/atomic"
+	"testing/fstest"
+	"time"
+)
+
+// templateNameRegexp matches the names of template files that may be uploaded
+var templateNameRegexp = regexp.MustCompile(`^[a-z0-9_-]+\.gohtml$`)
+
+// activeTemplates are the parsed HTML templates in use; they are swapped when
+// templates are uploaded or reloaded
+var activeTemplates atomic.Pointer[template.Template]
+
+// currentTemplates returns the parsed HTML templates in use
+func currentTemplates() *template.Template { return activeTemplates.Load() }
+
+// templateRender renders the templates in use, so swapped templates are used
+// by all following requests
+type templateRender struct{}
+
+func (templateRender) Instance(name string, data any) render.Render {
+	return render.HTML{Template: currentTemplates(), Name: name, Data: data}
+}
+
+// parseTemplates parses all templates of the given file system and validates
+// them by executing every template rendered by the handlers with sample data
+func parseTemplates(fsys fs.FS) (*template.Template, error) {
+	t, err := template.New("").Funcs(templateFuncs).ParseFS(fsys, "*.*")
+	if err != nil {
+		return nil, err
+	}
+	settings := content.Settings{
+		SiteTitle:       "Title",
+		Author:          "Author",
+		FooterText:      "Text",
+		AnalyticsID:     "G-XXXXXXXX",
+		DefaultLanguage: "de",
+		FooterColumns:   []content.FooterColumn{{Title: "Title", Links: []content.Link{{Label: "Label", URL: "/"}}}},
+		SocialLinks:     []content.Link{{Label: "Label", URL: "https://example.com"}},
+	}
+	page := content.Page{
+		Title:    "Title",
+		Content:  "<p>Content</p>",
+		LastMod:  time.Now(),
+		Year:     time.Now().Year(),
+		Base:     "index.html",
+		Root:     content.URIRoot,
+		Settings: settings,
+		Tags:     []string{"Tag"},
+		Menu:     []content.MenuItem{{Title: "Title", Link: "/", Children: []content.MenuItem{{Title: "Title", Link: "/"}}}},
+		Lang:     "de",
+		Translations: []content.Translation{{Lang: "de", Title: "Titel", Link: "/de/", Current: true, DefaultLink: "/"},
+			{Lang: "en", Title: "Title", Link: "/en/"}},
+		Comments:     []content.Comment{{Author: "Author", Text: "Text", Created: time.Now()}},
+		CommentsOpen: true,
+	}
+	samples := []struct {
+		name string
+		data any
+	}{
+		{"page", page},
+		{"print", page},
+		{"minimal", page},
+		{"admin", page},
+		{"404", page},
+		{"login", loginPage{Page: page, Error: "Error", Message: "Message", Next: "/admin/"}},
+		{"login", loginPage{Page: page, TOTP: true, OAuth: &oauthProvider{Label: "Provider"}}},
+		{"reset", resetPage{Page: page, Message: "Message", Error: "Error"}},
+		{"reset", resetPage{Page: page, Token: "token"}},
+	}
+	for _, s := range samples {
+		if t.Lookup(s.name) == nil {
+			return nil, fmt.Errorf("template %q is not defined", s.name)
+		}
+		// execute a clone, so executing does not prevent later parsing
+		c, err := t.Clone()
+		if err != nil {
+			return nil, err
+		}
+		err = c.ExecuteTemplate(io.Discard, s.name, s.data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// loadTemplates parses and validates the templates including the overrides
+// of TEMPLATES_DIR
+func loadTemplates() (*template.Template, error) {
+	return parseTemplates(assetFS("TEMPLATES_DIR", "templates"))
+}
+
+// handleTemplatesReload handles requests to reload the templates from
+// TEMPLATES_DIR; the templates are only swapped if they are valid, else the
+// request is answered with status 422
+func handleTemplatesReload(c *gin.Context) {
+	log.Println("Templates reload requested")
+	t, err := loadTemplates()
+	if errStatus(c, http.StatusUnprocessableEntity, err) {
+		return
+	}
+	activeTemplates.Store(t)
+	c.Status(http.StatusNoContent)
+}
+
+// handleTemplateUpload handles requests to upload a template file to
+// TEMPLATES_DIR; the request body is the template, which is validated together
+// with all other templates before it is written and the templates are swapped;
+// invalid templates are rejected with status 422
+func handleTemplateUpload(c *gin.Context) {
+	name := c.Param("name")
+	log.Println("Template upload requested:", name)
+	dir := getEnvOrElse("TEMPLATES_DIR", "")
+	if dir == "" {
+		errStatus(c, http.StatusConflict, errors.New("TEMPLATES_DIR is not set"))
+		return
+	}
+	if !templateNameRegexp.MatchString(name) {
+		errStatus(c, http.StatusBadRequest, errors.New("invalid template name: "+name))
+		return
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, 1<<20))
+	if errStatus(c, http.StatusBadRequest, err) {
+		return
+	}
+	upper := fstest.MapFS{name: &fstest.MapFile{Data: data, Mode: 0o644}}
+	t, err := parseTemplates(overlayFS{upper: upper, lower: assetFS("TEMPLATES_DIR", "templates")})
+	if errStatus(c, http.StatusUnprocessableEntity, err) {
+		return
+	}
+	err = writeFileAtomic(filepath.Join(dir, name), data)
+	if errISE(c, err) {
+		return
+	}
+	activeTemplates.Store(t)
+	c.Status(http.StatusNoContent)
+}
+
+// writeFileAtomic writes the given data to a temporary file next to the given
+// path and renames it, so readers never see a partially written file
+func writeFileAtomic(p string, data []byte) error {
+	err := os.MkdirAll(filepath.Dir(p), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(p), ".upload")
+	if err != nil {
+		return err
+	}
+	defer func(name string) { _ = os.Remove(name) }(f.Name())
+	_, err = io.Copy(f, bytes.NewReader(data))
+	cls(f)
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), p)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"auth"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// tokenRequest is the request body for creating API tokens
+type tokenRequest struct {
+	Name string `json:"name" binding:"required,max=100"`
+}
+
+// handleTokens handles requests to list API tokens; admins see the tokens of
+// all users, other users only their own tokens
+func handleTokens(c *gin.Context) {
+	log.Println("Tokens requested")
+	tokens, err := auth.ListTokens(sessionUserFilter(c))
+	if errISE(c, err) {
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// handleTokenCreate handles requests to create an API token for the current
+// user; the response contains the token's secret, which is not shown again
+func handleTokenCreate(c *gin.Context) {
+	log.Println("Token creation requested")
+	var req tokenRequest
+	err := c.ShouldBindJSON(&req)
+	if errBind(c, err) {
+		return
+	}
+	t, secret, err := auth.CreateToken(req.Name, c.GetString("user"))
+	if errISE(c, err) {
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"token": t, "secret": secret})
+}
+
+// handleTokenDelete handles requests to revoke an API token; admins may revoke
+// the tokens of all users, other users only their own tokens
+func handleTokenDelete(c *gin.Context) {
+	id := c.Param("id")
+	log.Println("Token revocation requested:", id)
+	err := auth.RevokeToken(id, sessionUserFilter(c))
+	if errors.Is(err, auth.ErrNoToken) {
+		errStatus(c, http.StatusNotFound, err)
+		return
+	}
+	if errISE(c, err) {
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// bearerToken returns the token of the request's 'Authorization: Bearer'
+// header; returns false if there is no such header
+func bearerToken(c *gin.Context) (string, bool) {
+	scheme, token, ok := strings.Cut(c.GetHeader("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// tokenAuth authenticates the request with the given API token; the token's
+// user and the user's role are stored in the context under the keys 'user'
+// and 'role'; requests with invalid tokens are aborted with status 401
+func tokenAuth(c *gin.Context, secret string) {
+	t, err := auth.GetToken(secret)
+	if err == nil {
+		err = setUser(c, t.User)
+		if err == nil {
+			c.Set("token", t)
+			return
+		}
+	}
+	if !errors.Is(err, auth.ErrNoToken) && !errors.Is(err, auth.ErrUserNotFound) && errISE(c, err) {
+		return
+	}
+	log.Println("[Err] Invalid API token")
+	c.Header("WWW-Authenticate", `Bearer realm="admin"`)
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"auth"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+)
+
+// totpRequest is the request body for confirming and disabling the second
+// factor
+type totpRequest struct {
+	Code string `json:"code" binding:"required,max=32"`
+}
+
+// handleTOTP handles requests for the second factor state of the current user
+func handleTOTP(c *gin.Context) {
+	log.Println("Second factor state requested")
+	u, err := auth.GetUser(c.GetString("user"))
+	if errISE(c, err) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"enabled": u.TOTPEnabled(), "recovery_codes": len(u.RecoveryCodes)})
+}
+
+// handleTOTPEnroll handles requests to start the enrollment of a second factor
+// for the current user; responds with the secret and the otpauth URI to add to
+// an authenticator app
+func handleTOTPEnroll(c *gin.Context) {
+	log.Println("Second factor enrollment requested")
+	secret, uri, err := auth.EnrollTOTP(c.GetString("user"), getEnvOrElse("TOTP_ISSUER", siteTitle()))
+	if errTOTP(c, err) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"secret": secret, "uri": uri})
+}
+
+// handleTOTPConfirm handles requests to complete the enrollment with a code
+// from the authenticator app; responds with the recovery codes, which are not
+// shown again
+func handleTOTPConfirm(c *gin.Context) {
+	log.Println("Second factor confirmation requested")
+	var req totpRequest
+	err := c.ShouldBindJSON(&req)
+	if errBind(c, err) {
+		return
+	}
+	codes, err := auth.ConfirmTOTP(c.GetString("user"), req.Code)
+	if errTOTP(c, err) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// handleTOTPDisable handles requests to disable the second factor of the
+// current user; requires a valid TOTP or recovery code
+func handleTOTPDisable(c *gin.Context) {
+	log.Println("Second factor deactivation requested")
+	var req totpRequest
+	err := c.ShouldBindJSON(&req)
+	if errBind(c, err) {
+		return
+	}
+	err = auth.DisableTOTP(c.GetString("user"), req.Code)
+	if errTOTP(c, err) {
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// errTOTP checks whether the given error is not nil and responds with the
+// status matching the error returned by the second factor management
+func errTOTP(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, auth.ErrInvalidCode):
+		return errStatus(c, http.StatusBadRequest, err)
+	case errors.Is(err, auth.ErrTOTPEnabled), errors.Is(err, auth.ErrTOTPNotEnabled):
+		return errStatus(c, http.StatusConflict, err)
+	}
+	return errISE(c, err)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/gin-gonic/gin"
+	"html/template"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// uiAsset is a fingerprinted asset of the admin frontend
+type uiAsset struct {
+	data []byte
+	mime string
+}
+
+var (
+	// uiNames maps the original asset names to their fingerprinted names
+	uiNames = map[string]string{}
+	// uiAssets maps the fingerprinted asset names to their contents
+	uiAssets = map[string]uiAsset{}
+	// uiIndex is the rendered entry page of the admin frontend
+	uiIndex []byte
+)
+
+// init fingerprints the embedded admin frontend assets and renders the entry
+// page referencing the fingerprinted filenames; as the file names change
+// whenever their content changes, the assets can be cached indefinitely
+func init() {
+	ui, err := fs.Sub(embedded, "ui")
+	checkErr(err)
+	entries, err := fs.ReadDir(ui, ".")
+	checkErr(err)
+	for _, e := range entries {
+		if e.IsDir() || e.Name() == "index.html" {
+			continue
+		}
+		data, err := fs.ReadFile(ui, e.Name())
+		checkErr(err)
+		sum := sha256.Sum256(data)
+		ext := path.Ext(e.Name())
+		name := strings.TrimSuffix(e.Name(), ext) + "." + hex.EncodeToString(sum[:])[:12] + ext
+		uiNames[e.Name()] = name
+		uiAssets[name] = uiAsset{data: data, mime: mime.TypeByExtension(ext)}
+	}
+	tmpl := template.Must(template.New("index.html").Funcs(template.FuncMap{
+		"asset": func(name string) (string, error) {
+			if n, ok := uiNames[name]; ok {
+				return "/admin/ui/" + n, nil
+			}
+			return "", fs.ErrNotExist
+		},
+	}).ParseFS(ui, "index.html"))
+	buf := bytes.Buffer{}
+	checkErr(tmpl.Execute(&buf, nil))
+	uiIndex = buf.Bytes()
+}
+
+// handleUI handles requests for the admin frontend; serves the entry page for
+// the root and fingerprinted assets with an immutable cache policy
+func handleUI(c *gin.Context) {
+	name := strings.TrimPrefix(c.Param("filepath"), "/")
+	if name == "" || name == "index.html" {
+		log.Println("Admin UI requested")
+		setCSRFCookie(c)
+		c.Header("Cache-Control", "no-cache")
+		c.Data(http.StatusOK, "text/html; charset=utf-8", uiIndex)
+		return
+	}
+	a, ok := uiAssets[name]
+	if !ok {
+		handleNotFound(c)
+		return
+	}
+	c.Header("Cache-Control", "public, max-age=31536000, immutable")
+	c.Data(http.StatusOK, a.mime, a.data)
+}
@@ -0,0 +1,670 @@
+"use strict";
+
+const view = document.getElementById("view");
+
+// el creates an element with the given attributes and children
+function el(tag, attrs = {}, ...children) {
+    const e = document.createElement(tag);
+    for (const [k, v] of Object.entries(attrs)) {
+        if (k.startsWith("on")) e.addEventListener(k.substring(2), v);
+        else e.setAttribute(k, v);
+    }
+    for (const c of children) e.append(c);
+    return e;
+}
+
+// csrfToken returns the CSRF token issued with the entry page, which must be
+// sent with every state-changing request
+function csrfToken() {
+    const match = document.cookie.match(/(?:^|;\s*)csrf_token=([^;]*)/);
+    return match ? match[1] : "";
+}
+
+// api performs a request against the admin API and throws on error responses
+async function api(method, url, body, headers = {}) {
+    const init = {method: method, body: body, headers: {...headers, "X-CSRF-Token": csrfToken()}};
+    if (body !== undefined && !(body instanceof FormData) && typeof body !== "string") {
+        init.body = JSON.stringify(body);
+        init.headers["Content-Type"] = "application/json";
+    }
+    const response = await fetch(url, init);
+    if (response.status === 401) {
+        location.href = "/admin/login?next=" + encodeURIComponent("/admin/ui/" + location.hash);
+    }
+    if (!response.ok) throw new Error(method + " " + url + ": " + response.status);
+    return response;
+}
+
+function showError(err) {
+    view.append(el("p", {class: "error"}, err.message));
+}
+
+// commentStatus is the status of the comments listed by the comments view
+let commentStatus = "pending";
+
+const views = {
+    async list() {
+        const files = await (await api("GET", "/admin/list")).json() || [];
+        files.sort((a, b) => a.uri.localeCompare(b.uri));
+        const selected = new Set();
+        const action = el("select", {},
+            el("option", {value: "delete"}, "Löschen"),
+            el("option", {value: "retag"}, "Tags ändern"),
+            el("option", {value: "set-status"}, "Status setzen"),
+            el("option", {value: "move-prefix"}, "Präfix verschieben"));
+        const bulkStatus = el("p");
+        // bulk asks for the values of the selected action and performs it on
+        // the selected files
+        const bulk = async () => {
+            if (selected.size === 0) return;
+            const body = {action: action.value, uris: [...selected]};
+            const list = s => (s || "").split(",").map(t => t.trim()).filter(t => t);
+            if (body.action === "delete") {
+                if (!confirm(selected.size + " Inhalte löschen?")) return;
+            } else if (body.action === "retag") {
+                body.add_tags = list(prompt("Hinzuzufügende Tags (kommagetrennt)"));
+                body.remove_tags = list(prompt("Zu entfernende Tags (kommagetrennt)"));
+            } else if (body.action === "set-status") {
+                body.status = prompt("Status (draft, review, published, archived)", "published");
+                if (!body.status) return;
+            } else {
+                body.from = prompt("Bisheriges Präfix", "/");
+                body.to = prompt("Neues Präfix", "/");
+                if (!body.from || !body.to) return;
+            }
+            try {
+                const result = await (await api("POST", "/admin/bulk", body)).json();
+                await render();
+                view.append(el("p", {}, result.succeeded + " erfolgreich, " + result.failed + " fehlgeschlagen"),
+                    ...result.results.filter(r => r.error).map(r => el("p", {class: "error"}, r.uri + ": " + r.error)));
+            } catch (err) {
+                bulkStatus.textContent = "Aktion fehlgeschlagen: " + err.message;
+            }
+        };
+        const rows = files.map(f => el("tr", {},
+            el("td", {}, el("input", {
+                type: "checkbox",
+                onchange: e => e.target.checked ? selected.add(f.uri) : selected.delete(f.uri)
+            })),
+            el("td", {}, el("a", {href: "/content" + f.uri, target: "_blank"}, f.uri)),
+            el("td", {}, f.mimetype || ""),
+            el("td", {}, f.status || "published"),
+            el("td", {}, String(f.size || 0)),
+            el("td", {}, f.last_mod ? new Date(f.last_mod).toLocaleString() : ""),
+            el("td", {}, f.uri.endsWith(".md") ? el("a", {href: "/admin/bundle" + f.uri}, "Bundle") : el("button", {
+                onclick: async () => {
+                    const dest = prompt("Neuer Pfad für '" + f.uri + "'", f.uri);
+                    if (!dest || dest === f.uri) return;
+                    const url = "/admin/move" + f.uri + "?dest=" + encodeURIComponent(dest);
+                    try {
+                        const preview = await (await api("POST", url + "&dry_run=true")).json();
+                        const pages = preview.pages.map(p => p.page + " (" + p.links.length + ")").join("\n");
+                        if (!confirm("'" + f.uri + "' nach '" + dest + "' verschieben?" +
+                            (pages ? "\nLinks werden angepasst in:\n" + pages : ""))) return;
+                        await api("POST", url);
+                    } catch (err) {
+                        showError(err);
+                        return;
+                    }
+                    render();
+                }
+            }, "Verschieben")),
+            el("td", {}, el("button", {
+                onclick: async () => {
+                    const dest = prompt("Pfad der Kopie von '" + f.uri + "'", f.uri);
+                    if (!dest || dest === f.uri) return;
+                    await api("POST", "/admin/copy" + f.uri + "?dest=" + encodeURIComponent(dest)).catch(showError);
+                    render();
+                }
+            }, "Kopieren")),
+            el("td", {}, el("button", {
+                onclick: async () => {
+                    if (!confirm("Inhalt \n'" + f.uri + "'\n löschen?")) return;
+                    await api("DELETE", "/admin" + f.uri).catch(showError);
+                    render();
+                }
+            }, "Löschen")),
+        ));
+        view.append(
+            el("h1", {}, "Inhalte"),
+            el("p", {}, el("a", {href: "/admin/download", target: "_blank"}, "Als ZIP herunterladen")),
+            el("p", {}, "Auswahl: ", action, el("button", {onclick: bulk}, "Ausführen")),
+            bulkStatus,
+            el("table", {},
+                el("thead", {}, el("tr", {},
+                    el("th", {}), el("th", {}, "URI"), el("th", {}, "Typ"), el("th", {}, "Status"), el("th", {}, "Größe"),
+                    el("th", {}, "Geändert"), el("th", {}), el("th", {}), el("th", {}))),
+                el("tbody", {}, ...rows)),
+        );
+    },
+    async upload() {
+        const input = el("input", {type: "file", name: "file"});
+        const status = el("p");
+        const importInput = el("input", {type: "file", accept: ".html,.htm,.zip"});
+        const importStatus = el("p");
+        const bundleInput = el("input", {type: "file", accept: ".zip"});
+        const bundleOverwrite = el("input", {type: "checkbox"});
+        const bundleStatus = el("p");
+        view.append(
+            el("h1", {}, "Hochladen"),
+            el("p", {}, "ZIP-Archive mit mehreren Dateien oder einzelne Datei"),
+            input,
+            el("button", {
+                onclick: async () => {
+                    const file = input.files[0];
+                    if (!file) return;
+                    const formData = new FormData();
+                    formData.append("file", file);
+                    try {
+                        await api("POST", "/admin/upload", formData);
+                        status.textContent = "'" + file.name + "' wurde hochgeladen.";
+                    } catch (err) {
+                        status.textContent = "'" + file.name + "' konnte nicht hochgeladen werden.";
+                    }
+                }
+            }, "Hochladen"),
+            status,
+            el("h2", {}, "Google Docs / Notion importieren"),
+            el("p", {}, "HTML-Export oder ZIP-Archiv, wird in Markdown umgewandelt"),
+            importInput,
+            el("button", {
+                onclick: async () => {
+                    const file = importInput.files[0];
+                    if (!file) return;
+                    const formData = new FormData();
+                    formData.append("file", file);
+                    try {
+                        const result = await (await api("POST", "/admin/import", formData)).json();
+                        importStatus.textContent = "Importiert: " + result.files.join(", ");
+                    } catch (err) {
+                        importStatus.textContent = "'" + file.name + "' konnte nicht importiert werden.";
+                    }
+                }
+            }, "Importieren"),
+            importStatus,
+            el("h2", {}, "Seiten-Bundle importieren"),
+            el("p", {}, "Von einer anderen Instanz exportierte Seite mit ihren Bildern"),
+            bundleInput,
+            el("label", {}, bundleOverwrite, " Vorhandene Dateien ersetzen"),
+            el("button", {
+                onclick: async () => {
+                    const file = bundleInput.files[0];
+                    if (!file) return;
+                    const formData = new FormData();
+                    formData.append("file", file);
+                    try {
+                        const url = "/admin/bundle" + (bundleOverwrite.checked ? "?overwrite=true" : "");
+                        const result = await (await api("POST", url, formData)).json();
+                        bundleStatus.textContent = "Importiert: " + result.page + " (" + result.files.length +
+                            " Dateien, " + result.skipped.length + " unverändert)";
+                    } catch (err) {
+                        bundleStatus.textContent = "'" + file.name + "' konnte nicht importiert werden: " + err.message;
+                    }
+                }
+            }, "Importieren"),
+            bundleStatus,
+        );
+    },
+    async edit() {
+        const pages = await (await api("GET", "/api/pages")).json();
+        const uri = el("input", {type: "text", placeholder: "/pfad/seite.md", list: "pages", size: "40"});
+        const text = el("textarea", {rows: "30", spellcheck: "true"});
+        const frame = el("iframe", {sandbox: "", title: "Vorschau"});
+        const status = el("p");
+        const page = () => "/" + uri.value.replace(/^\/+/, "");
+        // the hash of the loaded page, so saving does not overwrite changes
+        // made by others in the meantime
+        let hash = "";
+        let timer;
+        // preview shows the page as it will be served; the scripts of the page
+        // template do not run in the sandbox, so links are resolved against
+        // the content root instead
+        const preview = async () => {
+            try {
+                const html = await (await api("POST", "/admin/preview", {uri: page(), content: text.value})).text();
+                const doc = new DOMParser().parseFromString(html, "text/html");
+                let base = doc.querySelector("base");
+                if (!base) {
+                    base = doc.createElement("base");
+                    doc.head.prepend(base);
+                }
+                base.href = location.origin + "/content/";
+                frame.srcdoc = "<!DOCTYPE html>" + doc.documentElement.outerHTML;
+            } catch (err) {
+                status.textContent = "Vorschau fehlgeschlagen: " + err.message;
+            }
+        };
+        text.addEventListener("input", () => {
+            clearTimeout(timer);
+            timer = setTimeout(preview, 300);
+        });
+        view.append(
+            el("h1", {}, "Bearbeiten"),
+            el("datalist", {id: "pages"}, ...pages.map(p => el("option", {value: p.file.uri}))),
+            uri,
+            el("button", {
+                onclick: async () => {
+                    try {
+                        const result = await (await api("GET", "/api/pages" + page())).json();
+                        text.value = result.content;
+                        hash = result.file.sha256 || "";
+                        status.textContent = "";
+                        await preview();
+                    } catch (err) {
+                        status.textContent = "'" + page() + "' konnte nicht geladen werden: " + err.message;
+                    }
+                }
+            }, "Laden"),
+            el("button", {
+                onclick: async () => {
+                    try {
+                        const result = await (await api("PUT", "/api/pages" + page(), {content: text.value},
+                            hash ? {"If-Match": '"' + hash + '"'} : {})).json();
+                        hash = result.file.sha256 || "";
+                        status.textContent = "Gespeichert: " + result.url;
+                    } catch (err) {
+                        status.textContent = "'" + page() + "' konnte nicht gespeichert werden: " + err.message;
+                    }
+                }
+            }, "Speichern"),
+            status,
+            el("div", {class: "editor"}, text, frame),
+        );
+    },
+    async settings() {
+        const settings = await (await api("GET", "/admin/settings")).json();
+        const text = el("textarea", {rows: "24", cols: "80"});
+        text.value = JSON.stringify(settings, null, 2);
+        const status = el("p");
+        view.append(
+            el("h1", {}, "Einstellungen"),
+            el("p", {}, "Fußzeilen-Spalten und Social-Links als JSON"),
+            text,
+            el("br"),
+            el("button", {
+                onclick: async () => {
+                    try {
+                        await api("PUT", "/admin/settings", JSON.parse(text.value));
+                        status.textContent = "Einstellungen gespeichert.";
+                    } catch (err) {
+                        status.textContent = "Einstellungen konnten nicht gespeichert werden: " + err.message;
+                    }
+                }
+            }, "Speichern"),
+            status,
+        );
+    },
+    async quarantine() {
+        const files = await (await api("GET", "/admin/quarantine")).json();
+        const action = (name, uri) => async () => {
+            await api("POST", "/admin/quarantine/" + name + uri).catch(showError);
+            render();
+        };
+        const rows = files.map(f => {
+            const uri = f.uri.replace(/^\/\.quarantine/, "");
+            return el("tr", {},
+                el("td", {}, uri),
+                el("td", {}, f.uploaded_by || ""),
+                el("td", {}, f.last_mod ? new Date(f.last_mod).toLocaleString() : ""),
+                el("td", {},
+                    el("button", {onclick: action("approve", uri)}, "Freigeben"),
+                    el("button", {onclick: action("reject", uri)}, "Ablehnen")),
+            );
+        });
+        view.append(
+            el("h1", {}, "Freigaben"),
+            el("table", {},
+                el("thead", {}, el("tr", {},
+                    el("th", {}, "URI"), el("th", {}, "Hochgeladen von"), el("th", {}, "Geändert"), el("th", {}))),
+                el("tbody", {}, ...rows)),
+        );
+    },
+    async comments() {
+        const status = commentStatus;
+        const comments = await (await api("GET", "/admin/comments?status=" + status)).json();
+        const action = (c, name) => async () => {
+            await api("POST", "/admin/comments/" + name + "/" + c.id).catch(showError);
+            render();
+        };
+        const select = el("select", {
+            onchange: () => {
+                commentStatus = select.value;
+                render();
+            }
+        }, ...[["pending", "Ausstehend"], ["approved", "Freigegeben"], ["spam", "Spam"]].map(([v, label]) =>
+            el("option", v === status ? {value: v, selected: ""} : {value: v}, label)));
+        const rows = comments.map(c => el("tr", {},
+            el("td", {}, new Date(c.created).toLocaleString()),
+            el("td", {}, el("a", {href: "/content" + c.uri, target: "_blank"}, c.uri)),
+            el("td", {}, c.author + (c.email ? " <" + c.email + ">" : "")),
+            el("td", {}, c.text),
+            el("td", {},
+                status === "approved" ? "" : el("button", {onclick: action(c, "approve")}, "Freigeben"),
+                status === "spam" ? "" : el("button", {onclick: action(c, "spam")}, "Spam"),
+                el("button", {
+                    onclick: async () => {
+                        if (!confirm("Kommentar von '" + c.author + "' löschen?")) return;
+                        await api("DELETE", "/admin/comments/" + c.id).catch(showError);
+                        render();
+                    }
+                }, "Löschen")),
+        ));
+        view.append(
+            el("h1", {}, "Kommentare"),
+            select,
+            el("table", {},
+                el("thead", {}, el("tr", {},
+                    el("th", {}, "Zeit"), el("th", {}, "Seite"), el("th", {}, "Autor"), el("th", {}, "Text"),
+                    el("th", {}))),
+                el("tbody", {}, ...rows)),
+        );
+    },
+    async review() {
+        const files = await (await api("GET", "/admin/review")).json();
+        const sections = files.map(f => {
+            const line = el("input", {type: "number", min: "0", placeholder: "Zeile", size: "5"});
+            const text = el("input", {type: "text", placeholder: "Kommentar", size: "60"});
+            const comments = () => text.value ? {comments: [{line: Number(line.value) || 0, text: text.value}]} : undefined;
+            const action = name => async () => {
+                await api("POST", "/admin/review/" + name + f.uri, comments()).catch(showError);
+                render();
+            };
+            return el("section", {},
+                el("h2", {}, f.uri),
+                el("p", {}, (f.uploaded_by || "") + " · " + new Date(f.last_mod).toLocaleString()),
+                el("ul", {}, ...(f.review || []).map(r => el("li", {},
+                    r.author + (r.line ? " (Zeile " + r.line + ")" : "") + ": " + r.text))),
+                line, text,
+                el("button", {onclick: action("comment")}, "Kommentieren"),
+                el("button", {onclick: action("approve")}, "Veröffentlichen"),
+                el("button", {onclick: action("reject")}, "Zurückweisen"),
+            );
+        });
+        view.append(el("h1", {}, "Prüfung"), ...sections);
+    },
+    async links() {
+        const report = await (await api("GET", "/admin/links")).json();
+        const rows = report.dead.map(d => el("tr", {},
+            el("td", {}, el("a", {href: "/content" + d.page, target: "_blank"}, d.page)),
+            el("td", {}, d.url),
+            el("td", {}, d.external ? "extern" : "intern"),
+            el("td", {}, d.error || String(d.status || "")),
+        ));
+        view.append(
+            el("h1", {}, "Defekte Links"),
+            el("p", {}, report.pages + " Seiten mit " + report.links + " Links geprüft am " +
+                new Date(report.generated).toLocaleString() + (report.external ? "" : ", externe Links nicht geprüft")),
+            el("table", {},
+                el("thead", {}, el("tr", {},
+                    el("th", {}, "Seite"), el("th", {}, "Link"), el("th", {}, "Art"), el("th", {}, "Fehler"))),
+                el("tbody", {}, ...rows)),
+        );
+    },
+    async redirects() {
+        const redirects = await (await api("GET", "/admin/redirects")).json();
+        const rows = redirects.map(r => el("tr", {},
+            el("td", {}, r.from),
+            el("td", {}, r.to),
+            el("td", {}, String(r.status)),
+            el("td", {}, el("button", {
+                onclick: async () => {
+                    if (!confirm("Weiterleitung \n'" + r.from + "'\n löschen?")) return;
+                    await api("DELETE", "/admin/redirects/" + r.id).catch(showError);
+                    render();
+                }
+            }, "Löschen")),
+        ));
+        const from = el("input", {type: "text", placeholder: "/content/alt.html"});
+        const to = el("input", {type: "text", placeholder: "/content/neu.html"});
+        const status = el("select", {},
+            el("option", {value: "301"}, "301 dauerhaft"),
+            el("option", {value: "302"}, "302 vorübergehend"));
+        view.append(
+            el("h1", {}, "Weiterleitungen"),
+            el("table", {},
+                el("thead", {}, el("tr", {},
+                    el("th", {}, "Pfad"), el("th", {}, "Ziel"), el("th", {}, "Status"), el("th", {}))),
+                el("tbody", {}, ...rows)),
+            el("h2", {}, "Weiterleitung setzen"),
+            from, to, status,
+            el("button", {
+                onclick: async () => {
+                    await api("PUT", "/admin/redirects", {from: from.value, to: to.value, status: Number(status.value)})
+                        .catch(showError);
+                    render();
+                }
+            }, "Speichern"),
+        );
+    },
+    async jobs() {
+        const jobs = await (await api("GET", "/admin/jobs")).json();
+        const rows = jobs.map(j => el("tr", {},
+            el("td", {}, j.name),
+            el("td", {}, j.last_run ? new Date(j.last_run).toLocaleString() : ""),
+            el("td", {}, j.running ? "läuft" : j.last_error || "ok"),
+            el("td", {}, j.report ? el("pre", {}, JSON.stringify(j.report, null, 2)) : ""),
+            el("td", {}, el("button", {
+                onclick: async () => {
+                    await api("POST", "/admin/jobs/" + j.name + "/run").catch(showError);
+                    render();
+                }
+            }, "Ausführen")),
+        ));
+        view.append(
+            el("h1", {}, "Aufgaben"),
+            el("table", {},
+                el("thead", {}, el("tr", {},
+                    el("th", {}, "Name"), el("th", {}, "Zuletzt ausgeführt"), el("th", {}, "Status"),
+                    el("th", {}, "Bericht"), el("th", {}))),
+                el("tbody", {}, ...rows)),
+        );
+    },
+    async notifications() {
+        const result = await (await api("GET", "/admin/notifications")).json();
+        const rows = result.notifications.map(n => el("tr", {class: n.read ? "" : "unread"},
+            el("td", {}, new Date(n.updated).toLocaleString()),
+            el("td", {}, n.kind),
+            el("td", {}, n.message + (n.resolved ? " (erledigt)" : "")),
+            el("td", {}, n.read ? "" : el("button", {
+                onclick: async () => {
+                    await api("POST", "/admin/notifications/read/" + encodeURIComponent(n.id)).catch(showError);
+                    render();
+                }
+            }, "Gelesen")),
+        ));
+        view.append(
+            el("h1", {}, "Benachrichtigungen"),
+            el("p", {}, result.unread + " ungelesen"),
+            el("button", {
+                onclick: async () => {
+                    await api("POST", "/admin/notifications/read").catch(showError);
+                    render();
+                }
+            }, "Alle als gelesen markieren"),
+            el("table", {},
+                el("thead", {}, el("tr", {},
+                    el("th", {}, "Zeit"), el("th", {}, "Art"), el("th", {}, "Meldung"), el("th", {}))),
+                el("tbody", {}, ...rows)),
+        );
+    },
+    async sessions() {
+        const sessions = await (await api("GET", "/admin/sessions")).json();
+        const rows = sessions.map(s => el("tr", {},
+            el("td", {}, s.user + (s.current ? " (aktuell)" : "")),
+            el("td", {}, s.user_agent || ""),
+            el("td", {}, s.ip || ""),
+            el("td", {}, new Date(s.last_seen).toLocaleString()),
+            el("td", {}, el("button", {
+                onclick: async () => {
+                    await api("DELETE", "/admin/sessions/" + s.id).catch(showError);
+                    render();
+                }
+            }, "Beenden")),
+        ));
+        view.append(
+            el("h1", {}, "Sitzungen"),
+            el("table", {},
+                el("thead", {}, el("tr", {},
+                    el("th", {}, "Benutzer"), el("th", {}, "Gerät"), el("th", {}, "IP"),
+                    el("th", {}, "Zuletzt aktiv"), el("th", {}))),
+                el("tbody", {}, ...rows)),
+        );
+    },
+    async tokens() {
+        const tokens = await (await api("GET", "/admin/tokens")).json();
+        const rows = tokens.map(t => el("tr", {},
+            el("td", {}, t.name),
+            el("td", {}, t.user),
+            el("td", {}, t.hint + "…"),
+            el("td", {}, t.last_used ? new Date(t.last_used).toLocaleString() : ""),
+            el("td", {}, el("button", {
+                onclick: async () => {
+                    if (!confirm("Token \n'" + t.name + "'\n widerrufen?")) return;
+                    await api("DELETE", "/admin/tokens/" + t.id).catch(showError);
+                    render();
+                }
+            }, "Widerrufen")),
+        ));
+        const name = el("input", {type: "text", placeholder: "Name, z.B. CI"});
+        const secret = el("pre");
+        view.append(
+            el("h1", {}, "API-Tokens"),
+            el("table", {},
+                el("thead", {}, el("tr", {},
+                    el("th", {}, "Name"), el("th", {}, "Benutzer"), el("th", {}, "Token"),
+                    el("th", {}, "Zuletzt benutzt"), el("th", {}))),
+                el("tbody", {}, ...rows)),
+            el("h2", {}, "Token erstellen"),
+            name,
+            el("button", {
+                onclick: async () => {
+                    try {
+                        const result = await (await api("POST", "/admin/tokens", {name: name.value})).json();
+                        secret.textContent = "Authorization: Bearer " + result.secret +
+                            "\n\nDas Token wird nur einmal angezeigt.";
+                    } catch (err) {
+                        showError(err);
+                    }
+                }
+            }, "Erstellen"),
+            secret,
+        );
+    },
+    async totp() {
+        const state = await (await api("GET", "/admin/totp")).json();
+        const code = el("input", {type: "text", placeholder: "Code", autocomplete: "one-time-code"});
+        const output = el("pre");
+        view.append(el("h1", {}, "Zwei-Faktor-Authentifizierung"));
+        if (state.enabled) {
+            view.append(
+                el("p", {}, "Aktiviert, " + state.recovery_codes + " Wiederherstellungscodes übrig."),
+                code,
+                el("button", {
+                    onclick: async () => {
+                        await api("POST", "/admin/totp/disable", {code: code.value}).catch(showError);
+                        render();
+                    }
+                }, "Deaktivieren"),
+            );
+            return;
+        }
+        const confirmButton = el("button", {
+            onclick: async () => {
+                try {
+                    const result = await (await api("POST", "/admin/totp/confirm", {code: code.value})).json();
+                    output.textContent = "Aktiviert. Wiederherstellungscodes (werden nur einmal angezeigt):\n\n" +
+                        result.recovery_codes.join("\n");
+                } catch (err) {
+                    showError(err);
+                }
+            }
+        }, "Bestätigen");
+        view.append(
+            el("p", {}, "Nicht aktiviert."),
+            el("button", {
+                onclick: async () => {
+                    try {
+                        const result = await (await api("POST", "/admin/totp")).json();
+                        output.textContent = "In der Authenticator-App hinzufügen:\n\n" + result.uri +
+                            "\n\nSchlüssel: " + result.secret;
+                        view.append(code, confirmButton);
+                    } catch (err) {
+                        showError(err);
+                    }
+                }
+            }, "Einrichten"),
+            output,
+        );
+    },
+    async users() {
+        const users = await (await api("GET", "/admin/users")).json();
+        const roles = ["viewer", "editor", "admin"];
+        const roleSelect = value => {
+            const select = el("select", {}, ...roles.map(r => el("option", {value: r}, r)));
+            select.value = value;
+            return select;
+        };
+        const rows = users.map(u => {
+            const role = roleSelect(u.role);
+            const email = el("input", {type: "email", placeholder: "E-Mail"});
+            email.value = u.email || "";
+            const password = el("input", {type: "password", placeholder: "Neues Passwort", autocomplete: "new-password"});
+            return el("tr", {},
+                el("td", {}, u.name),
+                el("td", {}, email),
+                el("td", {}, role),
+                el("td", {}, password),
+                el("td", {},
+                    el("button", {
+                        onclick: async () => {
+                            await api("PUT", "/admin/users/" + encodeURIComponent(u.name),
+                                {email: email.value, role: role.value, password: password.value}).catch(showError);
+                            render();
+                        }
+                    }, "Speichern"),
+                    el("button", {
+                        onclick: async () => {
+                            if (!confirm("Benutzer \n'" + u.name + "'\n löschen?")) return;
+                            await api("DELETE", "/admin/users/" + encodeURIComponent(u.name)).catch(showError);
+                            render();
+                        }
+                    }, "Löschen")),
+            );
+        });
+        const name = el("input", {type: "text", placeholder: "Name"});
+        const email = el("input", {type: "email", placeholder: "E-Mail"});
+        const password = el("input", {type: "password", placeholder: "Passwort", autocomplete: "new-password"});
+        const role = roleSelect("editor");
+        view.append(
+            el("h1", {}, "Benutzer"),
+            el("table", {},
+                el("thead", {}, el("tr", {},
+                    el("th", {}, "Name"), el("th", {}, "E-Mail"), el("th", {}, "Rolle"), el("th", {}, "Passwort"), el("th", {}))),
+                el("tbody", {}, ...rows)),
+            el("h2", {}, "Benutzer anlegen"),
+            name, email, password, role,
+            el("button", {
+                onclick: async () => {
+                    await api("POST", "/admin/users",
+                        {name: name.value, email: email.value, password: password.value, role: role.value}).catch(showError);
+                    render();
+                }
+            }, "Anlegen"),
+        );
+    },
+};
+
+// render renders the view selected by the location hash
+async function render() {
+    const name = location.hash.replace(/^#\//, "") || "list";
+    document.querySelectorAll("#nav a").forEach(a => a.classList.toggle("active", a.hash === "#/" + name));
+    view.innerHTML = "";
+    try {
+        await (views[name] || views.list)();
+    } catch (err) {
+        showError(err);
+    }
+}
+
+window.addEventListener("hashchange", render);
+render();
@@ -2,17 +2,52 @@ package main
 
 import (
 	"archive/zip"
-	"content"
+	"bytes"
+	"errors"
+	"github.com/duevil/Go_Portfolio/content"
 	"github.com/gabriel-vasile/mimetype"
 	"github.com/gin-gonic/gin"
+	"io"
 	"log"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path"
-	"path/filepath"
 	"strings"
 )
 
+// formFile returns the file of the multipart form field 'file'; the request is
+// limited to maxUploadSize, so anonymous clients cannot make the server buffer
+// more than that before the credentials are checked. Aborts the request with
+// status 413 if it is too large and returns false if the file cannot be read.
+func formFile(c *gin.Context) (*multipart.FileHeader, bool) {
+	// the multipart form adds some overhead to the file's size
+	limit := maxUploadSize + 1<<20
+	tooLarge := &uploadError{status: http.StatusRequestEntityTooLarge, code: "too_large",
+		msg: "request is too large", details: map[string]any{"limit": maxUploadSize}}
+	if c.Request.ContentLength > limit {
+		errUpload(c, tooLarge)
+		return nil, false
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+	// the form is buffered on disk, and the file is saved once more
+	err := checkScratchSpace(2 * c.Request.ContentLength)
+	if errStorage(c, err) || errISE(c, err) {
+		return nil, false
+	}
+	ff, err := c.FormFile("file")
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		errUpload(c, tooLarge)
+		return nil, false
+	}
+	if errStatus(c, http.StatusBadRequest, err) {
+		return nil, false
+	}
+	return ff, true
+}
+
 // handleUpload handles requests for uploading files; if the uploaded file is a
 // zip file, it is extracted and all files in the zip file are iterated over and
 // stored in the database using the zip directory structure; else the file is
@@ -24,19 +59,26 @@ import (
 // uploaded file has been saved
 func handleUpload(c *gin.Context, auth gin.HandlerFunc) {
 	log.Println("Upload requested")
-	ff, err := c.FormFile("file")
-	if errStatus(c, http.StatusBadRequest, err) {
+	ff, ok := formFile(c)
+	if !ok {
+		return
+	}
+	uri, err := sanitizePath(ff.Filename)
+	if err == nil {
+		err = checkUploadSize(uri, ff.Size)
+	}
+	if errUpload(c, err) {
 		return
 	}
 
 	// create tmp dir and save file
 	log.Println("Saving file:", ff.Filename)
-	dir, err := os.MkdirTemp("", "tmp")
-	if errISE(c, err) {
+	dir, err := makeScratchDir("upload", ff.Size)
+	if errStorage(c, err) || errISE(c, err) {
 		return
 	}
 	defer func(path string) { _ = os.RemoveAll(path) }(dir)
-	fPath := path.Join(dir, ff.Filename)
+	fPath := path.Join(dir, path.Base(uri))
 	err = c.SaveUploadedFile(ff, fPath)
 	if errISE(c, err) {
 		return
@@ -49,18 +91,28 @@ func handleUpload(c *gin.Context, auth gin.HandlerFunc) {
 	}
 
 	// open file
+	u := newUploader(c)
 	f, err := os.Open(fPath)
 	if errISE(c, err) {
 		return
 	}
 	defer cls(f)
 
+	// scan the file before anything of it is stored
+	ext := path.Ext(ff.Filename)
+	err = scanUpload(f, uri, ff.Size, ext == ".zip")
+	if errUpload(c, err) || errISE(c, err) {
+		return
+	}
+
 	// handle file according to its extension
 	var location string
-	ext := path.Ext(ff.Filename)
 	if ext == ".zip" {
 		location = "/admin/list"
-		err = handleUploadZip(ff.Size, f)
+		if u.staging != "" {
+			location = "/admin/staging/" + u.staging
+		}
+		err = handleUploadZip(ff.Size, f, u)
 	} else {
 		fi, err := f.Stat()
 		if errISE(c, err) {
@@ -74,39 +126,73 @@ func handleUpload(c *gin.Context, auth gin.HandlerFunc) {
 				return
 			}
 		}
-		location = path.Join(content.URIRoot, ff.Filename)
+		location = u.location(uri)
 		p := content.MongoFile{
-			URI:      "/" + ff.Filename, // add leading slash
+			URI:      uri,
 			Filesize: fi.Size(),
 			LastMod:  fi.ModTime(),
 			Mime:     mime,
 			IsMD:     ext == ".md",
 		}
-		err = p.Store(f)
+		err = u.store(p, f)
 	}
-	if errISE(c, err) {
+	if errUpload(c, err) || errISE(c, err) {
 		return
 	}
 
 	// finish
-	c.Status(http.StatusCreated)
+	if u.quarantine && u.staging == "" {
+		location = "/admin/quarantine"
+	}
+	c.Status(u.status())
 	c.Header("Location", location)
 }
 
 // handleUploadZip handles the upload of a zip file; iterates over the files in
-// the zip file and stores them in the database
-func handleUploadZip(size int64, f *os.File) error {
+// the zip file and stores them in the database using the given uploader
+func handleUploadZip(size int64, f *os.File, u uploader) error {
 	log.Println("Handling upload of zip file:", f.Name())
 	zr, err := zip.NewReader(f, size)
 	if err != nil {
 		return err
 	}
-	// iterate over files in zip file
+	// check the limits and paths before any file is stored; all files with
+	// reserved paths are reported at once
+	entries := 0
+	var conflicts []pathConflict
 	for _, zf := range zr.File {
 		if zf.FileInfo().IsDir() {
 			continue
 		}
-		err = handleUploadZipIterateFunc(f.Name(), zf)
+		entries++
+		err = checkUploadSize(zf.Name, int64(zf.UncompressedSize64))
+		if err != nil {
+			return err
+		}
+		uri, err := zipEntryURI(f.Name(), zf)
+		if r := reservedPath(uri); r != "" {
+			conflicts = append(conflicts, pathConflict{File: zf.Name, URI: uri, Route: r})
+		} else if err != nil {
+			return err
+		}
+	}
+	err = checkArchiveEntries(entries)
+	if err != nil {
+		return err
+	}
+	if len(conflicts) > 0 {
+		return conflictError(conflicts)
+	}
+	captions, sidecars, err := zipCaptions(zr.File)
+	if err != nil {
+		return err
+	}
+	// iterate over files in zip file
+	for _, zf := range zr.File {
+		if zf.FileInfo().IsDir() || sidecars[zf.Name] {
+			continue
+		}
+		err = handleUploadZipIterateFunc(f.Name(), zf, u, captions[zf.Name])
 		if err != nil {
 			return err
 		}
@@ -115,8 +201,9 @@ func handleUploadZip(size int64, f *os.File) error {
 }
 
 // handleUploadZipIterateFunc is the function that is called for each file in
-// the zip file
-func handleUploadZipIterateFunc(fName string, zf *zip.File) error {
+// the zip file; the given caption read from the sidecar file of an image is
+// stored with it
+func handleUploadZipIterateFunc(fName string, zf *zip.File, u uploader, caption imageCaption) error {
 	// set mime type
 	ext := path.Ext(zf.FileInfo().Name())
 	ok, mime := checkMimeType(ext)
@@ -134,20 +221,7 @@ func handleUploadZipIterateFunc(fName string, zf *zip.File) error {
 		}
 		rc.Close()
 	}
-	// get file uri
-	fPath := path.Base(fName)
-	fPath = fPath[:len(fPath)-len(path.Ext(fPath))]
-	fPath, err := filepath.Rel(fPath, zf.Name)
-	if err != nil {
-		return err
-	}
-	// remove ../ from path
-	if strings.HasPrefix(fPath, "..") {
-		fPath, err = filepath.Rel("..", fPath)
-		if err != nil {
-			return err
-		}
-	}
+	uri, err := zipEntryURI(fName, zf)
 	if err != nil {
 		return err
 	}
@@ -158,13 +232,113 @@ func handleUploadZipIterateFunc(fName string, zf *zip.File) error {
 	}
 	defer cls(rc)
 	p := content.MongoFile{
-		URI:      "/" + fPath, // add leading slash
+		URI:      uri,
 		Filesize: int64(zf.UncompressedSize64),
 		LastMod:  zf.Modified,
 		Mime:     mime,
 		IsMD:     ext == ".md",
+		Alt:      caption.Alt,
+		Caption:  caption.Caption,
+	}
+	return u.store(p, rc)
+}
+
+// zipEntryURI returns the uri of the given file of the zip file with the given
+// name; files in a directory named after the zip file are stored relative to
+// that directory. The uri is returned along with the error if it is reserved.
+func zipEntryURI(fName string, zf *zip.File) (string, error) {
+	dir := path.Base(fName)
+	dir = dir[:len(dir)-len(path.Ext(dir))]
+	return sanitizePath(strings.TrimPrefix(strings.ReplaceAll(zf.Name, "\\", "/"), dir+"/"))
+}
+
+// uploadError is returned when storing an upload if the uploaded file is
+// rejected; the status is used as response status, the optional code, file
+// and details are added to the response
+type uploadError struct {
+	status  int
+	msg     string
+	code    string
+	file    string
+	details map[string]any
+}
+
+func (e *uploadError) Error() string { return e.msg }
+
+// storeUpload stores an uploaded file read from the given reader; all uploads
+// pass through here, so processing of uploaded files is done in one place
+func storeUpload(f content.MongoFile, r io.Reader) error {
+	err := checkUploadMime(f)
+	if err != nil {
+		return err
+	}
+	err = checkUploadSize(f.URI, f.Filesize)
+	if err != nil {
+		return err
+	}
+	// personal photos must not leak locations and other metadata
+	if stripMetadataEnabled() && hasStrippableMetadata(f.Mime) {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		data, err = stripMetadata(f.URI, f.Mime, data)
+		if err != nil {
+			return err
+		}
+		f.Filesize = int64(len(data))
+		r = bytes.NewReader(data)
+	}
+	switch {
+	case strings.HasPrefix(f.Mime, "image/svg+xml"):
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		data, err = sanitizeSVG(data)
+		if err != nil {
+			return err
+		}
+		f.Filesize = int64(len(data))
+		return f.Store(bytes.NewReader(data))
+	case isRasterImage(f.Mime) && !f.Quarantined:
+		// variants of quarantined images are created on approval
+		return storeImage(f, r)
+	}
+	return f.Store(r)
+}
+
+// checkUploadMime returns an uploadError with status 415 if the mime type of
+// the given uploaded file is blocked or not allowed
+func checkUploadMime(f content.MongoFile) error {
+	if rejectBlockedMime() && isBlockedMime(f.Mime) {
+		return &uploadError{status: http.StatusUnsupportedMediaType, code: "blocked_type", file: f.URI,
+			msg: "file type is not allowed: " + f.URI}
+	}
+	return checkUploadType(f.URI, f.Mime)
+}
+
+// extensionByType returns the file extension for the given mime type; returns
+// an empty string if the mime type is unknown
+func extensionByType(mimeType string) string {
+	switch strings.TrimSpace(strings.Split(mimeType, ";")[0]) {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	case "image/webp":
+		return ".webp"
+	case "image/avif":
+		return ".avif"
+	case "image/svg+xml":
+		return ".svg"
+	}
+	if exts, err := mime.ExtensionsByType(mimeType); err == nil && len(exts) > 0 {
+		return exts[0]
 	}
-	return p.Store(rc)
+	return ""
 }
 
 // checkMimeType checks if the given extension is a valid extension and returns
@@ -172,7 +346,7 @@ func handleUploadZipIterateFunc(fName string, zf *zip.File) error {
 func checkMimeType(ext string) (bool, string) {
 	switch ext {
 	case ".md":
-		return true, "text/markdown; charset=utf-8"
+		return true, content.MarkdownMime
 	case ".html":
 		return true, "text/html; charset=utf-8"
 	case ".css":
@@ -191,6 +365,8 @@ func checkMimeType(ext string) (bool, string) {
 		return true, "image/vnd.microsoft.icon"
 	case ".webp":
 		return true, "image/webp"
+	case ".avif":
+		return true, "image/avif"
 	case ".pdf":
 		return true, "application/pdf"
 	case ".zip":
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/duevil/Go_Portfolio/content"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"io/fs"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// usage is the storage used by a store in bytes; the limit is the number of
+// bytes from which on admins are warned, zero if there is no limit
+type usage struct {
+	Name  string `json:"name"`
+	Bytes int64  `json:"bytes"`
+	Limit int64  `json:"limit,omitempty"`
+}
+
+// exceeded returns whether the store uses more than its limit
+func (u usage) exceeded() bool { return u.Limit > 0 && u.Bytes > u.Limit }
+
+// warning returns the warning about the store exceeding its limit; the
+// warning does not change with the used storage, so admins are notified once
+func (u usage) warning() string { return fmt.Sprintf("Storage %s exceeds %d MiB", u.Name, u.Limit>>20) }
+
+// usageReport lists the storage used by the database, the GridFS bucket, the
+// local files directory and the scratch directory as well as the free space
+// in the scratch directory, if known, which is low if less than
+// NOTIFY_MIN_FREE_MB are available; warnings are the messages of stores
+// exceeding their limits and of low free space
+type usageReport struct {
+	Generated   time.Time `json:"generated"`
+	Stores      []usage   `json:"stores"`
+	ScratchFree int64     `json:"scratch_free,omitempty"`
+	LowSpace    bool      `json:"low_space"`
+	Warnings    []string  `json:"warnings"`
+}
+
+var (
+	// lastUsageReport is the report generated by the last run of the usage job
+	lastUsageReport *usageReport
+	usageMu         sync.Mutex
+)
+
+// usageLimit returns the limit in bytes set by the given variable in MiB;
+// zero disables the limit
+func usageLimit(name string) int64 { return int64(getEnvIntOrElse(name, 0)) << 20 }
+
+// dirSize returns the total size of the files below the given directory; a
+// missing directory is empty and files removed while walking are skipped
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
+
+// databaseSize returns the storage size of the database including indexes
+func databaseSize() (int64, error) {
+	var stats struct {
+		StorageSize float64 `bson:"storageSize"`
+		IndexSize   float64 `bson:"indexSize"`
+	}
+	db := dbClient.Database(getEnvOrElse("DB_NAME", "portfolio"))
+	err := db.RunCommand(dbCtx, bson.D{{Key: "dbStats", Value: 1}}).Decode(&stats)
+	return int64(stats.StorageSize + stats.IndexSize), err
+}
+
+// newUsageReport measures the storage used by all stores and compares it to
+// the limits set by USAGE_MAX_DB_MB, USAGE_MAX_GRIDFS_MB, USAGE_MAX_LOCAL_MB and
+// USAGE_MAX_SCRATCH_MB; the GridFS bucket is only measured if file contents
+// are stored in GridFS
+func newUsageReport() (*usageReport, error) {
+	r := &usageReport{Generated: time.Now(), Warnings: []string{}}
+	size, err := databaseSize()
+	if err != nil {
+		return nil, err
+	}
+	r.Stores = append(r.Stores, usage{Name: "database", Bytes: size, Limit: usageLimit("USAGE_MAX_DB_MB")})
+	if getEnvOrElse("LOCAL_STORAGE", "gridfs") == "gridfs" {
+		db := dbClient.Database(getEnvOrElse("DB_NAME", "portfolio"))
+		store, err := content.NewGridFSStore(db, getEnvOrElse("DB_BLOB_BUCKET", "blobs"))
+		if err != nil {
+			return nil, err
+		}
+		size, err = store.Size(dbCtx)
+		if err != nil {
+			return nil, err
+		}
+		r.Stores = append(r.Stores, usage{Name: "gridfs", Bytes: size, Limit: usageLimit("USAGE_MAX_GRIDFS_MB")})
+	}
+	size, err = dirSize(diskStore().Dir)
+	if err != nil {
+		return nil, err
+	}
+	r.Stores = append(r.Stores, usage{Name: "local", Bytes: size, Limit: usageLimit("USAGE_MAX_LOCAL_MB")})
+	size, err = dirSize(scratchDir)
+	if err != nil {
+		return nil, err
+	}
+	r.Stores = append(r.Stores, usage{Name: "scratch", Bytes: size, Limit: usageLimit("USAGE_MAX_SCRATCH_MB")})
+	for _, u := range r.Stores {
+		if u.exceeded() {
+			r.Warnings = append(r.Warnings, u.warning())
+		}
+	}
+	if free, ok := diskFree(scratchDir); ok {
+		r.ScratchFree = free
+		if minFree := int64(getEnvIntOrElse("NOTIFY_MIN_FREE_MB", 1024)) << 20; free < minFree {
+			r.LowSpace = true
+			r.Warnings = append(r.Warnings, fmt.Sprintf("Less than %d MiB available in %s", minFree>>20, scratchDir))
+		}
+	}
+	return r, nil
+}
+
+// runUsageJob generates the usage report and logs its warnings; admins are
+// notified of stores exceeding their limits and of low free space
+func runUsageJob() error {
+	r, err := newUsageReport()
+	if err != nil {
+		return err
+	}
+	for _, w := range r.Warnings {
+		log.Println("[Err] Storage warning:", w)
+	}
+	for _, u := range r.Stores {
+		if id := notifyDisk + ":" + u.Name; u.exceeded() {
+			notify(id, notifyDisk, u.warning())
+		} else {
+			resolve(id)
+		}
+	}
+	if r.LowSpace {
+		notify(notifyDisk, notifyDisk, r.Warnings[len(r.Warnings)-1])
+	} else {
+		resolve(notifyDisk)
+	}
+	usageMu.Lock()
+	lastUsageReport = r
+	usageMu.Unlock()
+	return nil
+}
+
+// handleUsage handles requests for the usage report; returns the report of the
+// last job run or, if the job did not run yet, generates a new report
+func handleUsage(c *gin.Context) {
+	log.Println("Usage report requested")
+	usageMu.Lock()
+	r := lastUsageReport
+	usageMu.Unlock()
+	if r == nil {
+		var err error
+		r, err = newUsageReport()
+		if errISE(c, err) {
+			return
+		}
+	}
+	c.JSON(http.StatusOK, r)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"auth"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// userCreateRequest is the request body for creating users
+type userCreateRequest struct {
+	Name     string    `json:"name" binding:"required,max=64"`
+	Email    string    `json:"email" binding:"omitempty,email"`
+	Password string    `json:"password" binding:"required,min=8,max=72"`
+	Role     auth.Role `json:"role" binding:"required,oneof=admin editor viewer"`
+}
+
+// userRequest is the request body for updating users; the password is only
+// changed if given
+type userRequest struct {
+	Email    string    `json:"email" binding:"omitempty,email"`
+	Password string    `json:"password" binding:"omitempty,min=8,max=72"`
+	Role     auth.Role `json:"role" binding:"required,oneof=admin editor viewer"`
+}
+
+// handleUsers handles requests to list all users
+func handleUsers(c *gin.Context) {
+	log.Println("Users requested")
+	users, err := auth.ListUsers()
+	if errISE(c, err) {
+		return
+	}
+	c.JSON(http.StatusOK, users)
+}
+
+// handleUserCreate handles requests to create a user; the request body contains
+// the user's name, optional email, password and role
+func handleUserCreate(c *gin.Context) {
+	log.Println("User creation requested")
+	var req userCreateRequest
+	err := c.ShouldBindJSON(&req)
+	if errBind(c, err) {
+		return
+	}
+	u, err := auth.CreateUser(req.Name, req.Email, req.Password, req.Role)
+	if errUser(c, err) {
+		return
+	}
+	c.Header("Location", "/admin/users/"+u.Name)
+	c.JSON(http.StatusCreated, u)
+}
+
+// handleUserUpdate handles requests to change the email, the role and, if
+// given, the password of a user
+func handleUserUpdate(c *gin.Context) {
+	name := c.Param("name")
+	log.Println("User update requested:", name)
+	var req userRequest
+	err := c.ShouldBindJSON(&req)
+	if errBind(c, err) {
+		return
+	}
+	err = auth.UpdateUser(name, req.Email, req.Password, req.Role)
+	if errUser(c, err) {
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// handleUserDelete handles requests to delete a user and the user's sessions
+func handleUserDelete(c *gin.Context) {
+	name := c.Param("name")
+	log.Println("User deletion requested:", name)
+	if name == c.GetString("user") {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "cannot delete the current user"})
+		return
+	}
+	err := auth.DeleteUser(name)
+	if errUser(c, err) {
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// errUser checks whether the given error is not nil and responds with the
+// status matching the error returned by the user management
+func errUser(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, auth.ErrInvalidUser):
+		return errStatus(c, http.StatusBadRequest, err)
+	case errors.Is(err, auth.ErrUserNotFound):
+		return errStatus(c, http.StatusNotFound, err)
+	case errors.Is(err, auth.ErrUserExists), errors.Is(err, auth.ErrManagedUser):
+		return errStatus(c, http.StatusConflict, err)
+	}
+	return errISE(c, err)
+}
+
+// passwordRequest is the request body for changing the current user's
+// password; the user's API tokens are revoked unless KeepTokens is set
+type passwordRequest struct {
+	Current    string `json:"current" binding:"required"`
+	Password   string `json:"password" binding:"required,min=8,max=72"`
+	KeepTokens bool   `json:"keep_tokens"`
+}
+
+// handlePasswordChange handles requests to change the current user's password;
+// the current password must be given. All of the user's sessions and, unless
+// keeping them is requested, API tokens are invalidated; if the request is
+// authenticated by a session, a new session is started for it. Wrong current
+// passwords count as failed logins.
+func handlePasswordChange(c *gin.Context) {
+	user := c.GetString("user")
+	log.Println("Password change requested:", user)
+	var req passwordRequest
+	err := c.ShouldBindJSON(&req)
+	if errBind(c, err) {
+		return
+	}
+	keys := loginKeys(c, user)
+	if wait := lockouts.lockedFor(time.Now(), keys...); wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "too many failed attempts"})
+		return
+	}
+	err = auth.ChangePassword(user, req.Current, req.Password, !req.KeepTokens)
+	if errors.Is(err, auth.ErrInvalidCredentials) {
+		lockouts.fail(time.Now(), keys...)
+		errStatus(c, http.StatusForbidden, errors.New("current password is wrong"))
+		return
+	}
+	if errUser(c, err) {
+		return
+	}
+	lockouts.succeed(keys...)
+	if _, ok := c.Get("session"); ok {
+		ttl := getEnvDurationOrElse("SESSION_TTL", 12*time.Hour)
+		s, token, err := auth.NewSession(user, ttl, c.Request.UserAgent(), c.ClientIP())
+		if errISE(c, err) {
+			return
+		}
+		setSessionCookie(c, token, int(ttl.Seconds()))
+		c.Set("session", s)
+		setCSRFCookie(c)
+	}
+	c.Status(http.StatusNoContent)
+}
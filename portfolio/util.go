@@ -1,24 +1,106 @@
 package main
 
 import (
-	"content"
+	"crypto/rand"
 	"errors"
+	"github.com/duevil/Go_Portfolio/content"
 	"github.com/gin-gonic/gin"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
+// envFiles caches the values read from the files set by the variables with
+// the suffix '_FILE', keyed by the names of the variables without suffix;
+// every file is read once, as many variables are looked up for every request
+var envFiles sync.Map
+
 // getEnvOrElse returns the value for the given key if os.LookupEnv was successful
-// or else returns the alternative value
+// or else returns the alternative value; if the variable with the suffix
+// '_FILE' is set, the value is read from the file it points to instead, so
+// credentials and other settings can be mounted as Docker or Kubernetes
+// secrets instead of being passed as environment variables
 func getEnvOrElse(key string, sElse string) string {
+	if f, ok := os.LookupEnv(key + "_FILE"); ok && f != "" {
+		v, ok := envFiles.Load(key)
+		if !ok {
+			b, err := os.ReadFile(f)
+			checkErr(err)
+			v, _ = envFiles.LoadOrStore(key, strings.TrimRight(string(b), "\r\n"))
+		}
+		if s := v.(string); s != "" {
+			return s
+		}
+		return sElse
+	}
 	if s, ok := os.LookupEnv(key); ok && s != "" {
 		return s
 	}
 	return sElse
 }
 
+// getEnvIntOrElse returns the value for the given key parsed as integer or else
+// returns the alternative value if the value is not set or cannot be parsed
+func getEnvIntOrElse(key string, iElse int) int {
+	s := getEnvOrElse(key, "")
+	if s == "" {
+		return iElse
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		log.Println("[Err] Invalid integer for", key+":", err)
+		return iElse
+	}
+	return i
+}
+
+// getEnvDurationOrElse returns the value for the given key parsed as duration
+// or else returns the alternative value if the value is not set or cannot be
+// parsed
+func getEnvDurationOrElse(key string, dElse time.Duration) time.Duration {
+	s := getEnvOrElse(key, "")
+	if s == "" {
+		return dElse
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		log.Println("[Err] Invalid duration for", key+":", err)
+		return dElse
+	}
+	return d
+}
+
+// secretKey returns the key set by the given environment variable or secret
+// file; if the key is not set, a random key is generated, which invalidates
+// everything signed with it on restart
+func secretKey(key string) []byte {
+	if s := getEnvOrElse(key, ""); s != "" {
+		return []byte(s)
+	}
+	log.Println(key, "is not set; using a random key")
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	checkErr(err)
+	return b
+}
+
+// parseList parses the given comma separated list into a set of its lower case
+// entries
+func parseList(list string) map[string]bool {
+	set := map[string]bool{}
+	for _, e := range strings.Split(list, ",") {
+		if e = strings.ToLower(strings.TrimSpace(e)); e != "" {
+			set[e] = true
+		}
+	}
+	return set
+}
+
 // checkErr checks whether the given error is not nil; if the error is not nil,
 // it is logged using log.Fatalln
 func checkErr(err error) {
@@ -29,6 +111,16 @@ func checkErr(err error) {
 
 func cls(c io.Closer) { _ = c.Close() }
 
+// readFile reads the whole content of the given file
+func readFile(f content.MongoFile) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer cls(rc)
+	return io.ReadAll(rc)
+}
+
 // errStatus checks whether the given error is not nil; if the error is not nil,
 // it is logged using log.Println and the error is returned to the client using
 // c.AbortWithError with the given status code
@@ -63,3 +155,37 @@ func errNotFound(c *gin.Context, err error) bool {
 	}
 	return false
 }
+
+// errUpload checks whether the given error is an uploadError; if the error is
+// an uploadError, it is logged using log.Println and returned to the client
+// with the error's status code
+func errUpload(c *gin.Context, err error) bool {
+	var ue *uploadError
+	if errors.As(err, &ue) {
+		log.Println("[Err] Upload rejected [", ue.status, "]:", ue.msg)
+		h := gin.H{"error": ue.msg}
+		if ue.code != "" {
+			h["code"] = ue.code
+		}
+		if ue.file != "" {
+			h["file"] = ue.file
+		}
+		for k, v := range ue.details {
+			h[k] = v
+		}
+		c.AbortWithStatusJSON(ue.status, h)
+		return true
+	}
+	return false
+}
+
+// filterFiles returns the files for which the given function returns true
+func filterFiles(files []content.MongoFile, keep func(f *content.MongoFile) bool) []content.MongoFile {
+	filtered := make([]content.MongoFile, 0, len(files))
+	for i := range files {
+		if keep(&files[i]) {
+			filtered = append(filtered, files[i])
+		}
+	}
+	return filtered
+}
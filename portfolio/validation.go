@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+func init() {
+	// field errors are reported with the names used in requests
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(func(f reflect.StructField) string {
+			for _, tag := range []string{"json", "form"} {
+				name, _, _ := strings.Cut(f.Tag.Get(tag), ",")
+				if name == "-" {
+					return ""
+				}
+				if name != "" {
+					return name
+				}
+			}
+			return f.Name
+		})
+	}
+}
+
+// errBind checks whether the given error returned by binding a request is not
+// nil; if so, the request is aborted with status 400 and a response listing
+// the invalid fields with a message per field
+func errBind(c *gin.Context, err error) bool {
+	if err == nil {
+		return false
+	}
+	log.Println("[Err] Invalid request:", err)
+	fields := map[string]string{}
+	var verrs validator.ValidationErrors
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &verrs):
+		for _, e := range verrs {
+			fields[fieldPath(e)] = fieldMessage(e)
+		}
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		fields[typeErr.Field] = "must be of type " + typeErr.Type.String()
+	default:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+		return true
+	}
+	abortFields(c, fields)
+	return true
+}
+
+// abortFields aborts the request with status 400 and a response listing the
+// given invalid fields with a message per field
+func abortFields(c *gin.Context, fields map[string]string) {
+	c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid request", "fields": fields})
+}
+
+// fieldPath returns the path of the field of the given error without the name
+// of the request struct, like 'social_links[0].url'
+func fieldPath(e validator.FieldError) string {
+	_, p, _ := strings.Cut(e.Namespace(), ".")
+	if p == "" {
+		return e.Field()
+	}
+	return p
+}
+
+// fieldMessage returns a message describing the given field error
+func fieldMessage(e validator.FieldError) string {
+	switch e.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	case "oneof":
+		return "must be one of: " + strings.ReplaceAll(e.Param(), " ", ", ")
+	case "min":
+		if e.Kind() == reflect.String {
+			return "must be at least " + e.Param() + " characters long"
+		}
+		return "must be at least " + e.Param()
+	case "max":
+		if e.Kind() == reflect.String {
+			return "must be at most " + e.Param() + " characters long"
+		}
+		return "must be at most " + e.Param()
+	case "url", "http_url":
+		return "must be a valid URL"
+	case "datetime":
+		return "must have the format " + e.Param()
+	case "printascii":
+		return "must only contain printable ASCII characters"
+	}
+	return fmt.Sprintf("failed the %q validation", e.Tag())
+}
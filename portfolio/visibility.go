@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"github.com/duevil/Go_Portfolio/content"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+)
+
+// visibilityRequest is the request body for setting the visibility of a file
+type visibilityRequest struct {
+	Visibility string `json:"visibility" binding:"required,oneof=public unlisted private"`
+}
+
+// handleVisibility handles requests to set the visibility of a file and its
+// variants; the request body contains the visibility, which is either
+// 'public', 'unlisted' or 'private'
+func handleVisibility(c *gin.Context) {
+	uri := c.Param("uri")
+	log.Println("Visibility update requested:", uri)
+	var req visibilityRequest
+	err := c.ShouldBindJSON(&req)
+	if errBind(c, err) {
+		return
+	}
+	f, err := content.GetFromDB(uri)
+	if err == nil && !f.Public() {
+		err = content.ErrNotFound
+	}
+	if errNotFound(c, err) || errISE(c, err) || !allowURI(c, f.URI) {
+		return
+	}
+	err = content.SetVisibility(f.URI, req.Visibility)
+	if errors.Is(err, content.ErrInvalidVisibility) {
+		errStatus(c, http.StatusBadRequest, err)
+		return
+	}
+	if errISE(c, err) {
+		return
+	}
+	for _, m := range f.Variants {
+		err = content.SetVisibility(f.URI+extensionByType(m), req.Visibility)
+		if !errors.Is(content.ErrNotFound, err) && errISE(c, err) {
+			return
+		}
+	}
+	contentChanged(f.URI)
+	c.Status(http.StatusNoContent)
+}
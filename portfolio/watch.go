@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"github.com/duevil/Go_Portfolio/content"
+	"log"
+	"time"
+)
+
+// startWatching watches the settings for changes made by other instances
+// using a change stream unless WATCH_CHANGES is 'false', so the settings
+// cached by every instance are dropped within seconds of a write. Nothing else
+// needs to be watched: the menu is read from the database whenever a page is
+// rendered, and rendered pages are cached in the database and thus shared by
+// all instances. If the stream fails, it is reopened after
+// WATCH_RETRY_INTERVAL; watching stops once the database context is done or if
+// the database does not support change streams.
+func startWatching() {
+	if getEnvOrElse("WATCH_CHANGES", "true") == "false" {
+		return
+	}
+	retry := getEnvDurationOrElse("WATCH_RETRY_INTERVAL", 5*time.Second)
+	go func() {
+		for {
+			err := contentEngine.WatchSettings(dbCtx)
+			if dbCtx.Err() != nil {
+				return
+			}
+			if errors.Is(err, content.ErrWatchUnsupported) {
+				log.Println("[Err] Watching settings disabled:", err)
+				return
+			}
+			log.Println("[Err] Watching settings failed, retrying in", retry, ":", err)
+			time.Sleep(retry)
+		}
+	}()
+}